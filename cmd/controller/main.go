@@ -17,14 +17,22 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	goflag "flag"
+	"fmt"
+	"net/http"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	flag "github.com/spf13/pflag"
 	k8sinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/klog/v2"
 
 	// Uncomment the following line to load the gcp plugin (only required to authenticate against GKE clusters).
@@ -41,16 +49,39 @@ import (
 	"github.com/yunify/hostnic-cni/pkg/simple/client/network/ippool"
 )
 
-var qps, burst int
+var qps, burst, metricsPort int
+
+var metricsInterval time.Duration
+
+var (
+	leaderElect       bool
+	leaseDuration     time.Duration
+	renewDeadline     time.Duration
+	retryPeriod       time.Duration
+	resourceName      string
+	resourceNamespace string
+)
 
 func main() {
 	klog.InitFlags(goflag.CommandLine)
 	flag.IntVar(&qps, "k8s-api-qps", 80, "maximum QPS to k8s apiserver from this client.")
 	flag.IntVar(&burst, "k8s-api-burst", 100, "maximum burst for throttle from this client.")
+	flag.IntVar(&metricsPort, "metrics-port", 9192, "metrics port")
+	flag.DurationVar(&metricsInterval, "metrics-interval", 30*time.Second, "The interval to compute cluster ipam metrics.")
+	flag.BoolVar(&leaderElect, "leader-elect", true, "Start a leader election client and gain leadership before executing the main loop. Enable this when running replicated controllers for high availability.")
+	flag.DurationVar(&leaseDuration, "leader-elect-lease-duration", 15*time.Second, "The duration that non-leader candidates will wait after observing a leadership renewal until attempting to acquire leadership.")
+	flag.DurationVar(&renewDeadline, "leader-elect-renew-deadline", 10*time.Second, "The interval between attempts by the acting leader to renew a leadership slot before it stops leading.")
+	flag.DurationVar(&retryPeriod, "leader-elect-retry-period", 2*time.Second, "The duration the clients should wait between attempting acquisition and renewal of a leadership.")
+	flag.StringVar(&resourceName, "leader-elect-resource-name", "hostnic-controller", "The name of the lease object that is used for locking during leader election.")
+	flag.StringVar(&resourceNamespace, "leader-elect-resource-namespace", constants.IPAMConfigNamespace, "The namespace of the lease object that is used for locking during leader election.")
+	qcOpts := qcclient.NewMiddlewareOptions()
+	qcOpts.AddFlags()
 	flag.CommandLine.AddGoFlagSet(goflag.CommandLine)
 	flag.Parse()
 
-	qcclient.SetupQingCloudClient(qcclient.Options{})
+	qcclient.SetupQingCloudClient(qcclient.Options{
+		Middleware: qcOpts,
+	})
 
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
@@ -77,35 +108,187 @@ func main() {
 		klog.Fatalf("Error building example clientset: %s", err.Error())
 	}
 
-	k8sInformerFactory := k8sinformers.NewSharedInformerFactory(k8sClient, time.Second*30)
-	informerFactory := informers.NewSharedInformerFactory(client, time.Second*30)
+	reg := prometheus.NewPedanticRegistry()
+	startMetricsServer(reg)
+
+	run := func(stopCh <-chan struct{}) error {
+		k8sInformerFactory := k8sinformers.NewSharedInformerFactory(k8sClient, time.Second*30)
+		informerFactory := informers.NewSharedInformerFactory(client, time.Second*30)
+
+		c1 := controller.NewVxNetPoolController(clusterConfig, k8sClient, client, informerFactory, k8sInformerFactory)
+
+		c2 := controller.NewIPPoolController(k8sClient, client,
+			k8sInformerFactory, informerFactory, ippool.NewProvider(client, networkv1alpha1.IPPoolTypeLocal, informerFactory, k8sInformerFactory))
+
+		// cluster ipam metrics are only exported by the leader
+		ipamMetrics := controller.NewIPAMMetrics(k8sClient, informerFactory)
+		if err := reg.Register(ipamMetrics); err != nil {
+			return err
+		}
+		defer reg.Unregister(ipamMetrics)
 
-	c1 := controller.NewVxNetPoolController(clusterConfig, k8sClient, client, informerFactory, k8sInformerFactory)
+		// notice that there is no need to run Start methods in a separate goroutine. (i.e. go kubeInformerFactory.Start(stopCh)
+		// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
+		k8sInformerFactory.Start(stopCh)
+		informerFactory.Start(stopCh)
 
-	c2 := controller.NewIPPoolController(k8sClient, client,
-		k8sInformerFactory, informerFactory, ippool.NewProvider(client, networkv1alpha1.IPPoolTypeLocal, informerFactory, k8sInformerFactory))
+		// stop the other controller when one of them fails
+		runCh := make(chan struct{})
+		var once sync.Once
+		stopAll := func() { once.Do(func() { close(runCh) }) }
+		go func() {
+			<-stopCh
+			stopAll()
+		}()
+
+		errCh := make(chan error, 3)
+		wg := sync.WaitGroup{}
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if err := c1.Run(2, runCh); err != nil {
+				klog.Errorf("Error running controller: %s", err.Error())
+				errCh <- err
+				stopAll()
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			if err := c2.Run(2, runCh); err != nil {
+				klog.Errorf("Error running controller: %s", err.Error())
+				errCh <- err
+				stopAll()
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			if err := ipamMetrics.Run(metricsInterval, runCh); err != nil {
+				klog.Errorf("Error running ipam metrics: %s", err.Error())
+				errCh <- err
+				stopAll()
+			}
+		}()
+
+		wg.Wait()
+		close(errCh)
+		return <-errCh
+	}
+
+	if !leaderElect {
+		if err := run(stopCh); err != nil {
+			klog.Fatalf("Error running controller: %v", err)
+		}
+		klog.Info("controller exited")
+		return
+	}
 
-	// notice that there is no need to run Start methods in a separate goroutine. (i.e. go kubeInformerFactory.Start(stopCh)
-	// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
-	k8sInformerFactory.Start(stopCh)
-	informerFactory.Start(stopCh)
+	runWithLeaderElection(k8sClient, stopCh, run)
+}
 
-	wg := sync.WaitGroup{}
-	wg.Add(2)
+func startMetricsServer(reg *prometheus.Registry) {
+	h := promhttp.HandlerFor(reg,
+		promhttp.HandlerOpts{
+			ErrorHandling: promhttp.ContinueOnError,
+		})
+	http.Handle("/metrics", h)
 	go func() {
-		if err = c1.Run(2, stopCh); err != nil {
-			klog.Errorf("Error running controller: %s", err.Error())
-			wg.Done()
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", metricsPort), nil); err != nil {
+			klog.Errorf("Error serving metrics: %s", err.Error())
 		}
 	}()
+}
+
+// runWithLeaderElection runs the controllers only while holding the lease. The controllers
+// are drained before the lease is released on shutdown, and the process exits as soon as the
+// lease is lost, without waiting for the controllers, because a new leader may be active already.
+func runWithLeaderElection(k8sClient kubernetes.Interface, stopCh <-chan struct{}, run func(stopCh <-chan struct{}) error) {
+	id := os.Getenv("MY_POD_NAME")
+	if id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			klog.Fatalf("Error getting hostname: %s", err.Error())
+		}
+		id = hostname
+	}
+
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock,
+		resourceNamespace,
+		resourceName,
+		k8sClient.CoreV1(),
+		k8sClient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{
+			Identity: id,
+		})
+	if err != nil {
+		klog.Fatalf("Error creating resource lock: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	done := make(chan struct{})
+	var runErr error
 
 	go func() {
-		if err = c2.Run(2, stopCh); err != nil {
-			klog.Errorf("Error running controller: %s", err.Error())
-			wg.Done()
+		<-stopCh
+		select {
+		case <-started:
+			// release the lease after the controllers are drained
+			<-done
+		default:
 		}
+		cancel()
 	}()
 
-	wg.Wait()
-	klog.Fatalf("Error running controller")
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		Name:            resourceName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.Infof("%s started leading", id)
+				close(started)
+				defer close(done)
+
+				runCh := make(chan struct{})
+				go func() {
+					select {
+					case <-ctx.Done():
+					case <-stopCh:
+					}
+					close(runCh)
+				}()
+				runErr = run(runCh)
+			},
+			OnStoppedLeading: func() {
+				select {
+				case <-stopCh:
+					klog.Infof("%s stopped leading", id)
+				default:
+					klog.Infof("leader lost: %s", id)
+					os.Exit(0)
+				}
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					klog.Infof("new leader elected: %s", identity)
+				}
+			},
+		},
+	})
+
+	select {
+	case <-started:
+		<-done
+	default:
+	}
+
+	if runErr != nil {
+		klog.Fatalf("Error running controller: %v", runErr)
+	}
+	klog.Info("controller exited")
 }
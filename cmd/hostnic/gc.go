@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
+	klog "k8s.io/klog/v2"
+
+	ipam2 "github.com/yunify/hostnic-cni/cmd/hostnic/ipam"
+	constants "github.com/yunify/hostnic-cni/pkg/constants"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+)
+
+const (
+	// error codes of STATUS in CNI spec 1.1
+	errPluginNotAvailable uint = 50
+
+	// the time allowed to connect hostnic-node for STATUS
+	statusTimeout = 3 * time.Second
+)
+
+// gcNetConf is the netconf of GC, which carries the attachments still in use on this node
+type gcNetConf struct {
+	constants.NetConf
+	ValidAttachments []gcAttachment `json:"cni.dev/valid-attachments,omitempty"`
+}
+
+type gcAttachment struct {
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifname"`
+}
+
+// pluginMain runs GC or STATUS, which get nothing but CNI_COMMAND and CNI_PATH besides the netconf.
+// Errors are printed as JSON to stdout like skel.PluginMain.
+func pluginMain(cmd func(stdin []byte) error) {
+	stdin, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		err = types.NewError(types.ErrIOFailure, fmt.Sprintf("error reading from stdin: %v", err), "")
+	} else {
+		err = cmd(stdin)
+	}
+	if err == nil {
+		return
+	}
+
+	e, ok := err.(*types.Error)
+	if !ok {
+		e = types.NewError(types.ErrInternal, err.Error(), "")
+	}
+	if err := e.Print(); err != nil {
+		klog.Errorf("Error writing error JSON to stdout: %v", err)
+	}
+	os.Exit(1)
+}
+
+// loadNetConf decodes the netconf of GC or STATUS, which are only allowed since CNI spec 1.1
+func loadNetConf(stdin []byte, conf interface{}, cmd string) error {
+	if err := json.Unmarshal(stdin, conf); err != nil {
+		return types.NewError(types.ErrDecodingFailure, fmt.Sprintf("failed to load netconf: %v", err), "")
+	}
+	cniVersion, err := (&version.ConfigDecoder{}).Decode(stdin)
+	if err != nil {
+		return types.NewError(types.ErrDecodingFailure, err.Error(), "")
+	}
+	if !versionAtLeast(cniVersion, "1.1.0") {
+		return types.NewError(types.ErrIncompatibleCNIVersion, fmt.Sprintf("config version does not allow %s", cmd), "")
+	}
+	return nil
+}
+
+// cmdGC releases the pod records, rules, arpreply and ips of sandboxes which are not in the valid attachments
+func cmdGC(stdin []byte) (err error) {
+	conf := gcNetConf{}
+	if err = loadNetConf(stdin, &conf, "GC"); err != nil {
+		return err
+	}
+	setupLog(&conf.NetConf)
+
+	klog.Infof("cmdGC of network %s with %d valid attachments", conf.Name, len(conf.ValidAttachments))
+	defer func() {
+		klog.Infof("cmdGC rst: %v", err)
+	}()
+
+	// ips are allocated with the hostname as node by cmdAdd
+	nodeName, err := os.Hostname()
+	if err != nil || nodeName == "" {
+		return fmt.Errorf("failed to get hostname: %v", err)
+	}
+
+	var valid []*rpc.Attachment
+	for _, attachment := range conf.ValidAttachments {
+		valid = append(valid, &rpc.Attachment{
+			ContainerID: attachment.ContainerID,
+			IfName:      attachment.IfName,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.NicAttachTimeout)
+	defer cancel()
+	// valid attachments are those of this network only, others are collected by their own GC
+	reply, err := ipam2.GarbageCollect(ctx, valid, nodeName, conf.VxNet)
+	if err != nil {
+		return fmt.Errorf("failed to collect garbage: %v", err)
+	}
+	for _, pod := range reply.Pods {
+		klog.Infof("cmdGC released pod %s ip %s", getPodKey(pod), pod.PodIP)
+	}
+	for _, handle := range reply.Handles {
+		klog.Infof("cmdGC released handle %s", handle)
+	}
+	return nil
+}
+
+// cmdStatus reports whether hostnic is ready for ADD, hostnic-node must be reachable and able to provide a nic
+func cmdStatus(stdin []byte) error {
+	conf := constants.NetConf{}
+	if err := loadNetConf(stdin, &conf, "STATUS"); err != nil {
+		return err
+	}
+
+	reply, err := ipam2.Status(context.Background(), statusTimeout)
+	if err != nil {
+		return types.NewError(errPluginNotAvailable, "hostnic-node is unreachable", err.Error())
+	}
+	if !reply.Ready {
+		return types.NewError(errPluginNotAvailable, "no usable hostnic on this node", reply.Reason)
+	}
+	return nil
+}
@@ -19,6 +19,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
@@ -29,11 +30,11 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/types/current"
-	"github.com/containernetworking/cni/pkg/version"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
 	"github.com/containernetworking/plugins/pkg/ns"
@@ -43,6 +44,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
+	"go.opentelemetry.io/otel/attribute"
 	klog "k8s.io/klog/v2"
 
 	ipam2 "github.com/yunify/hostnic-cni/cmd/hostnic/ipam"
@@ -50,6 +52,7 @@ import (
 	"github.com/yunify/hostnic-cni/pkg/log"
 	"github.com/yunify/hostnic-cni/pkg/networkutils"
 	"github.com/yunify/hostnic-cni/pkg/rpc"
+	"github.com/yunify/hostnic-cni/pkg/tracing"
 	"golang.org/x/sys/unix"
 )
 
@@ -62,9 +65,14 @@ func init() {
 
 const (
 	defaultIfName = "veth1"
+
+	// the time allowed to flush spans before the plugin exits
+	tracingFlushTimeout = 3 * time.Second
 )
 
-func setupContainerVeth(netns ns.NetNS, hostIfName, contIfName string, conf constants.NetConf, pr *current.Result) (*current.Interface, *current.Interface, error) {
+// setupContainerVeth routes the traffic of pod via 169.254.1.1, which is the default route of pod,
+// or only the route to dst for secondary interfaces.
+func setupContainerVeth(netns ns.NetNS, hostIfName, contIfName string, conf constants.NetConf, pr *current.Result, dst *net.IPNet) (*current.Interface, *current.Interface, error) {
 	_, _, err := net.ParseCIDR(conf.Service)
 	if conf.HostNicType == constants.HostNicPassThrough && err != nil {
 		return nil, nil, fmt.Errorf("should config valid service: %v", err)
@@ -114,15 +122,27 @@ func setupContainerVeth(netns ns.NetNS, hostIfName, contIfName string, conf cons
 		var (
 			routes []netlink.Route
 		)
-		routes = append(routes, netlink.Route{
-			LinkIndex: contVeth.Index,
-			Dst: &net.IPNet{
-				IP:   net.ParseIP("169.254.1.1"),
-				Mask: net.CIDRMask(32, 32),
-			},
-			Scope: netlink.SCOPE_LINK,
-		})
-		if conf.HostNicType == constants.HostNicPassThrough {
+		if dst == nil {
+			routes = append(routes, netlink.Route{
+				LinkIndex: contVeth.Index,
+				Dst: &net.IPNet{
+					IP:   net.ParseIP("169.254.1.1"),
+					Mask: net.CIDRMask(32, 32),
+				},
+				Scope: netlink.SCOPE_LINK,
+			})
+		}
+		if dst != nil {
+			// the link route to 169.254.1.1 belongs to the primary interface, so the route is onlink
+			routes = append(routes, netlink.Route{
+				LinkIndex: contVeth.Index,
+				Dst:       dst,
+				Scope:     netlink.SCOPE_UNIVERSE,
+				Gw:        net.ParseIP("169.254.1.1"),
+				Src:       pr.IPs[0].Address.IP,
+				Flags:     int(netlink.FLAG_ONLINK),
+			})
+		} else if conf.HostNicType == constants.HostNicPassThrough {
 			_, serviceNet, _ := net.ParseCIDR(conf.Service)
 			routes = append(routes, netlink.Route{
 				LinkIndex: contVeth.Index,
@@ -156,7 +176,7 @@ func setupContainerVeth(netns ns.NetNS, hostIfName, contIfName string, conf cons
 	return hostInterface, containerInterface, err
 }
 
-func setupHostVeth(conf constants.NetConf, vethName string, msg *rpc.IPAMMessage, result *current.Result) error {
+func setupHostVeth(ctx context.Context, conf constants.NetConf, vethName string, msg *rpc.IPAMMessage, result *current.Result) error {
 	// hostVeth moved namespaces and may have a new ifindex
 	hostVeth, err := netlink.LinkByName(vethName)
 	if err != nil {
@@ -182,10 +202,18 @@ func setupHostVeth(conf constants.NetConf, vethName string, msg *rpc.IPAMMessage
 		return fmt.Errorf("failed to add route %s to pod, err=%+v", spew.Sdump(route), err)
 	}
 
-	return networkutils.NetworkHelper.SetupPodNetwork(msg.Nic, msg.IP)
+	_, span := tracing.Start(ctx, "netlink.SetupPodNetwork", attribute.String("pod.ip", msg.IP))
+	err = networkutils.NetworkHelper.SetupPodNetwork(msg.Nic, msg.IP)
+	tracing.End(span, err)
+	return err
 }
 
-func cmdAddVeth(conf constants.NetConf, hostIfName, contIfName string, msg *rpc.IPAMMessage, result *current.Result, netns ns.NetNS) error {
+func cmdAddVeth(ctx context.Context, conf constants.NetConf, hostIfName, contIfName string, msg *rpc.IPAMMessage, result *current.Result, netns ns.NetNS) error {
+	dst, err := podRouteDst(contIfName, msg.Nic)
+	if err != nil {
+		return err
+	}
+
 	link, err := netlink.LinkByName(hostIfName)
 	if link != nil {
 		logrus.Infof("cmdAddVeth LinkByName found link %s, link type=%s, link attr=%s,err=%v; going to delete this link", hostIfName, link.Type(), spew.Sdump(link.Attrs()), err)
@@ -203,19 +231,24 @@ func cmdAddVeth(conf constants.NetConf, hostIfName, contIfName string, msg *rpc.
 		logrus.Infof("delete link %s success!", hostIfName)
 	}
 
-	hostInterface, _, err := setupContainerVeth(netns, hostIfName, contIfName, conf, result)
+	_, span := tracing.Start(ctx, "netlink.SetupContainerVeth", attribute.String("veth", hostIfName))
+	hostInterface, _, err := setupContainerVeth(netns, hostIfName, contIfName, conf, result, dst)
+	tracing.End(span, err)
 	if err != nil {
 		logrus.Errorf("setupContainerVeth(hostIfName:%s,contIfName:%s) error:%v", hostIfName, contIfName, err)
 		return err
 	}
 	logrus.Infof("setupContainerVeth(hostIfName:%s,contIfName:%s) success, hostInterface=%s", hostIfName, contIfName, spew.Sdump(hostInterface))
 
-	if err = setupHostVeth(conf, hostInterface.Name, msg, result); err != nil {
+	if err = setupHostVeth(ctx, conf, hostInterface.Name, msg, result); err != nil {
 		logrus.Errorf("setupHostVeth %s error:%v", hostInterface.Name, err)
 		return err
 	}
 	logrus.Infof("setupHostVeth %s success!", hostInterface.Name)
 
+	if dst != nil {
+		result.Routes = append(result.Routes, &types.Route{Dst: *dst, GW: net.ParseIP("169.254.1.1")})
+	}
 	return err
 }
 
@@ -269,7 +302,7 @@ func moveLinkIn(hostDev netlink.Link, containerNs ns.NetNS, ifName string, pr *c
 	return contDev, nil
 }
 
-func cmdAddPassThrough(conf constants.NetConf, hostIfName, contIfName string, msg *rpc.IPAMMessage, result *current.Result, netns ns.NetNS) error {
+func cmdAddPassThrough(ctx context.Context, conf constants.NetConf, hostIfName, contIfName string, msg *rpc.IPAMMessage, result *current.Result, netns ns.NetNS) error {
 	if len(result.Interfaces) == 0 {
 		logrus.Errorf("IPAM plugin returned missing Interface config")
 		return errors.New("IPAM plugin returned missing Interface config")
@@ -290,20 +323,24 @@ func cmdAddPassThrough(conf constants.NetConf, hostIfName, contIfName string, ms
 		return fmt.Errorf("failed to find host device: %v", err)
 	}
 
+	_, span := tracing.Start(ctx, "netlink.MoveLinkIn", attribute.String("mac", result.Interfaces[0].Mac))
 	_, err = moveLinkIn(hostDev, netns, contIfName, result)
+	tracing.End(span, err)
 	if err != nil {
 		logrus.Errorf("failed to move link %v", err)
 		return fmt.Errorf("failed to move link %v", err)
 	}
 
-	hostInterface, _, err := setupContainerVeth(netns, hostIfName, defaultIfName, conf, result)
+	_, span = tracing.Start(ctx, "netlink.SetupContainerVeth", attribute.String("veth", hostIfName))
+	hostInterface, _, err := setupContainerVeth(netns, hostIfName, defaultIfName, conf, result, nil)
+	tracing.End(span, err)
 	if err != nil {
 		logrus.Errorf("setupContainerVeth(hostIfName:%s,defaultIfName:%s) error:%v", hostIfName, defaultIfName, err)
 		return err
 	}
 	logrus.Infof("setupContainerVeth(hostIfName:%s,defaultIfName:%s) success, hostInterface=%s", hostIfName, defaultIfName, spew.Sdump(hostInterface))
 
-	if err = setupHostVeth(conf, hostInterface.Name, msg, result); err != nil {
+	if err = setupHostVeth(ctx, conf, hostInterface.Name, msg, result); err != nil {
 		logrus.Errorf("setupHostVeth %s error:%v", hostInterface.Name, err)
 		return err
 	}
@@ -312,7 +349,7 @@ func cmdAddPassThrough(conf constants.NetConf, hostIfName, contIfName string, ms
 	return err
 }
 
-func checkConf(conf *constants.NetConf) error {
+func setupLog(conf *constants.NetConf) {
 	if conf.LogLevel == 0 {
 		conf.LogLevel = int(logrus.InfoLevel)
 	}
@@ -320,6 +357,10 @@ func checkConf(conf *constants.NetConf) error {
 		Level: conf.LogLevel,
 		File:  conf.LogFile,
 	})
+}
+
+func checkConf(conf *constants.NetConf) error {
+	setupLog(conf)
 
 	if conf.HostVethPrefix == "" {
 		conf.HostVethPrefix = constants.HostNicPrefix
@@ -426,9 +467,7 @@ func checkIptables(conf *constants.NetConf) error {
 	return nil
 }
 
-func cmdAdd(args *skel.CmdArgs) error {
-	var err error
-
+func cmdAdd(args *skel.CmdArgs) (err error) {
 	klog.Infof("cmdAdd args %+v", args)
 	defer func() {
 		klog.Infof("cmdAdd for %s rst: %v", args.ContainerID, err)
@@ -441,8 +480,16 @@ func cmdAdd(args *skel.CmdArgs) error {
 	if err = checkConf(&conf); err != nil {
 		return fmt.Errorf("failed to checkConf: %v", err)
 	}
+	if err = checkSecondary(conf, args.IfName); err != nil {
+		return err
+	}
 	klog.Infof("cmdAdd for %s load and check netconf success, conf=%+v", args.ContainerID, conf)
 
+	ctx, endTrace := startTrace(conf, "cni.ADD", args)
+	defer func() {
+		endTrace(err)
+	}()
+
 	//get nodename
 	nodeName, err := os.Hostname()
 	if err != nil {
@@ -450,7 +497,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 	}
 
 	// run the IPAM plugin and get back the config to apply
-	ipamMsg, result, err := ipam2.AddrAlloc(args, nodeName)
+	ipamMsg, result, err := ipam2.AddrAlloc(ctx, args, nodeName, conf.VxNet)
 	if err != nil {
 		return fmt.Errorf("failed to alloc addr: %v", err)
 	}
@@ -470,24 +517,24 @@ func cmdAdd(args *skel.CmdArgs) error {
 	}
 	defer netns.Close()
 
-	hostIfName := generateHostVethName(conf.HostVethPrefix, podInfo.Namespace, podInfo.Name)
+	hostIfName := generateHostVethName(conf.HostVethPrefix, podInfo.Namespace, podInfo.Name, args.IfName)
 	contIfName := args.IfName
 	klog.Infof("HostNicType=%s,hostIfName=%s,contIfName=%s", conf.HostNicType, hostIfName, contIfName)
 
 	switch conf.HostNicType {
 	case constants.HostNicPassThrough:
 		klog.Infof("go to excute cmdAddPassThrough")
-		err = cmdAddPassThrough(conf, hostIfName, contIfName, ipamMsg, result, netns)
+		err = cmdAddPassThrough(ctx, conf, hostIfName, contIfName, ipamMsg, result, netns)
 	default:
 		klog.Infof("go to excute cmdAddVeth")
-		err = cmdAddVeth(conf, hostIfName, contIfName, ipamMsg, result, netns)
+		err = cmdAddVeth(ctx, conf, hostIfName, contIfName, ipamMsg, result, netns)
 	}
 
 	if err != nil {
 		klog.Errorf("add veth error:%v", err)
 		return err
 	} else {
-		return types.PrintResult(result, conf.CNIVersion)
+		return printResult(result, conf.CNIVersion)
 	}
 }
 
@@ -561,9 +608,7 @@ func cmdDelPassThrough(svcIfName, contIfName string, netns ns.NetNS) error {
 	return err
 }
 
-func cmdDel(args *skel.CmdArgs) error {
-	var err error
-
+func cmdDel(args *skel.CmdArgs) (err error) {
 	klog.Infof("cmdDel args %+v", args)
 	defer func() {
 		klog.Infof("cmdDel for %s rst: %v", args.ContainerID, err)
@@ -577,12 +622,17 @@ func cmdDel(args *skel.CmdArgs) error {
 		return fmt.Errorf("failed to checkConf: %v", err)
 	}
 
+	ctx, endTrace := startTrace(conf, "cni.DEL", args)
+	defer func() {
+		endTrace(err)
+	}()
+
 	//only get pod ip info here to delete ip rule and ebtable rule
-	ipamMsg, err := ipam2.AddrUnalloc(args, true)
+	ipamMsg, err := ipam2.AddrUnalloc(ctx, args, true)
 	podInfo := ipamMsg.Args
 	conf.HostNicType = podInfo.NicType
 	contIfName := args.IfName
-	svcIfName := generateHostVethName(conf.HostVethPrefix, podInfo.Namespace, podInfo.Name)
+	svcIfName := generateHostVethName(conf.HostVethPrefix, podInfo.Namespace, podInfo.Name, args.IfName)
 	podKey := getPodKey(podInfo)
 
 	if err != nil {
@@ -596,14 +646,14 @@ func cmdDel(args *skel.CmdArgs) error {
 	if ipamMsg.Nic == nil {
 		// not found db record and ip record, skip cleanup
 		klog.Infof("not found nic record for pod %s, try to clean up rules and release ip", podKey)
-		return cleanupRulesAndIP(args, ipamMsg)
+		return cleanupRulesAndIP(ctx, args, ipamMsg)
 	}
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
 		klog.Errorf("getns %s for pod %s error: %v", args.Netns, podKey, err)
 		if strings.Contains(err.Error(), "no such file or directory") {
-			return cleanupRulesAndIP(args, ipamMsg)
+			return cleanupRulesAndIP(ctx, args, ipamMsg)
 		}
 		return fmt.Errorf("getns %s for pod %s error: %v", args.Netns, podKey, err)
 	}
@@ -619,16 +669,16 @@ func cmdDel(args *skel.CmdArgs) error {
 	if err != nil {
 		klog.Errorf("del nic for pod %s error: %v", podKey, err)
 		if strings.Contains(err.Error(), "no such file or directory") {
-			return cleanupRulesAndIP(args, ipamMsg)
+			return cleanupRulesAndIP(ctx, args, ipamMsg)
 		}
 		return fmt.Errorf("del nic for pod %s error: %v", podKey, err)
 	}
 	klog.Infof("del nic for pod %s success", podKey)
 
-	return cleanupRulesAndIP(args, ipamMsg)
+	return cleanupRulesAndIP(ctx, args, ipamMsg)
 }
 
-func cleanupRulesAndIP(args *skel.CmdArgs, ipamMsg *rpc.IPAMMessage) error {
+func cleanupRulesAndIP(ctx context.Context, args *skel.CmdArgs, ipamMsg *rpc.IPAMMessage) error {
 	// clean up ip rule and ebtables
 	// delete rule and ebtables rule before delete db record, after delete db record, can not get pod ip and nic;
 	podInfo := ipamMsg.Args
@@ -636,7 +686,9 @@ func cleanupRulesAndIP(args *skel.CmdArgs, ipamMsg *rpc.IPAMMessage) error {
 	klog.Infof("going to clean network rules and ip for pod %s", podKey)
 
 	if ipamMsg.Nic != nil {
+		_, span := tracing.Start(ctx, "netlink.CleanupPodNetwork", attribute.String("pod.ip", ipamMsg.IP))
 		err := networkutils.NetworkHelper.CleanupPodNetwork(ipamMsg.Nic, ipamMsg.IP)
+		tracing.End(span, err)
 		if err != nil {
 			return fmt.Errorf("clean network rule for pod %s error: %v", podKey, err)
 		}
@@ -644,7 +696,7 @@ func cleanupRulesAndIP(args *skel.CmdArgs, ipamMsg *rpc.IPAMMessage) error {
 	}
 
 	// release ip and delet db record
-	_, err := ipam2.AddrUnalloc(args, false)
+	_, err := ipam2.AddrUnalloc(ctx, args, false)
 	if err != nil {
 		return fmt.Errorf("ipam addr unalloc for pod %s error: %v", podKey, err)
 	}
@@ -652,18 +704,190 @@ func cleanupRulesAndIP(args *skel.CmdArgs, ipamMsg *rpc.IPAMMessage) error {
 	return nil
 }
 
+func cmdCheck(args *skel.CmdArgs) (err error) {
+	klog.Infof("cmdCheck args %+v", args)
+	defer func() {
+		klog.Infof("cmdCheck for %s rst: %v", args.ContainerID, err)
+	}()
+
+	conf := constants.NetConf{}
+	if err = json.Unmarshal(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("failed to load netconf: %v", err)
+	}
+	if err = checkConf(&conf); err != nil {
+		return fmt.Errorf("failed to checkConf: %v", err)
+	}
+
+	// constants.NetConf doesn't carry prevResult, parse it with the netconf of cni
+	result, err := parsePrevResult(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	ctx, endTrace := startTrace(conf, "cni.CHECK", args)
+	defer func() {
+		endTrace(err)
+	}()
+
+	ipamMsg, err := ipam2.AddrUnalloc(ctx, args, true)
+	if err != nil {
+		return fmt.Errorf("failed to get nic and ip info: %v", err)
+	}
+	podInfo := ipamMsg.Args
+	podKey := getPodKey(podInfo)
+	if ipamMsg.Nic == nil {
+		return fmt.Errorf("not found nic record for pod %s", podKey)
+	}
+	if len(result.IPs) == 0 || result.IPs[0].Address.IP.String() != ipamMsg.IP {
+		return fmt.Errorf("ip of pod %s is %s, but prevResult has %v", podKey, ipamMsg.IP, result.IPs)
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	dst, err := podRouteDst(args.IfName, ipamMsg.Nic)
+	if err != nil {
+		return err
+	}
+	if err = netns.Do(func(_ ns.NetNS) error {
+		return checkContainerNetwork(args.IfName, result, dst)
+	}); err != nil {
+		return fmt.Errorf("check container network for pod %s error: %v", podKey, err)
+	}
+
+	hostIfName := generateHostVethName(conf.HostVethPrefix, podInfo.Namespace, podInfo.Name, args.IfName)
+	if err = checkHostVeth(hostIfName, ipamMsg.IP); err != nil {
+		return fmt.Errorf("check host veth for pod %s error: %v", podKey, err)
+	}
+
+	if err = networkutils.NetworkHelper.CheckPodNetwork(ipamMsg.Nic, ipamMsg.IP); err != nil {
+		return fmt.Errorf("check network rule for pod %s error: %v", podKey, err)
+	}
+	return nil
+}
+
+// checkContainerNetwork checks the ip of pod and the route via 169.254.1.1, which is the route to dst
+// for secondary interfaces. It runs in the netns of pod.
+func checkContainerNetwork(contIfName string, result *current.Result, dst *net.IPNet) error {
+	if err := ip.ValidateExpectedInterfaceIPs(contIfName, result.IPs); err != nil {
+		return err
+	}
+
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return fmt.Errorf("failed to list routes: %v", err)
+	}
+	for _, route := range routes {
+		if route.Gw == nil || !route.Gw.Equal(net.ParseIP("169.254.1.1")) {
+			continue
+		}
+		if dst == nil || (route.Dst != nil && route.Dst.String() == dst.String()) {
+			return nil
+		}
+	}
+	if dst != nil {
+		return fmt.Errorf("route to %s via 169.254.1.1 not found", dst)
+	}
+	return fmt.Errorf("route via 169.254.1.1 not found")
+}
+
+// checkHostVeth checks the host side veth of pod and the route to pod through it
+func checkHostVeth(hostIfName, podIP string) error {
+	hostVeth, err := netlink.LinkByName(hostIfName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup link by name %q: %v", hostIfName, err)
+	}
+
+	routes, err := netlink.RouteList(hostVeth, netlink.FAMILY_V4)
+	if err != nil {
+		return fmt.Errorf("failed to list routes of %s: %v", hostIfName, err)
+	}
+	for _, route := range routes {
+		if route.Dst != nil && route.Dst.IP.Equal(net.ParseIP(podIP)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("route to %s via %s not found", podIP, hostIfName)
+}
+
+// startTrace starts the root span of a CNI invocation, the returned function ends
+// the span and flushes it before the plugin exits.
+func startTrace(conf constants.NetConf, name string, args *skel.CmdArgs) (context.Context, func(error)) {
+	shutdown, err := tracing.Setup("hostnic-cni", conf.Tracing)
+	if err != nil {
+		klog.Errorf("failed to setup tracing, ignore: %v", err)
+		shutdown = func(context.Context) error { return nil }
+	}
+
+	ctx, span := tracing.Start(context.Background(), name,
+		attribute.String("container.id", args.ContainerID),
+		attribute.String("netns", args.Netns))
+	return ctx, func(err error) {
+		tracing.End(span, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			klog.Errorf("failed to flush spans: %v", err)
+		}
+	}
+}
+
 // generateHostVethName returns a name to be used on the host-side veth device.
-func generateHostVethName(prefix, namespace, podname string) string {
+// The name of primary interface doesn't include ifName, so it is unchanged for existing pods.
+func generateHostVethName(prefix, namespace, podname, ifName string) string {
+	key := fmt.Sprintf("%s.%s", namespace, podname)
+	if constants.IsSecondaryIfName(ifName) {
+		key += "." + ifName
+	}
 	h := sha1.New()
-	h.Write([]byte(fmt.Sprintf("%s.%s", namespace, podname)))
+	h.Write([]byte(key))
 	return fmt.Sprintf("%s%s", prefix, hex.EncodeToString(h.Sum(nil))[:11])
 }
 
+// checkSecondary checks the vxnet of netconf, which is only set when hostnic is a secondary network of multus
+func checkSecondary(conf constants.NetConf, ifName string) error {
+	secondary := constants.IsSecondaryIfName(ifName)
+	if secondary && conf.VxNet == "" {
+		return fmt.Errorf("vxnet should be set for secondary interface %s", ifName)
+	}
+	if !secondary && conf.VxNet != "" {
+		return fmt.Errorf("vxnet %s is only for secondary interfaces, but interface is %s", conf.VxNet, constants.DefaultPodIfName)
+	}
+	return nil
+}
+
+// podRouteDst returns the network of vxnet routed by the secondary interface ifName, nil for the primary interface
+func podRouteDst(ifName string, nic *rpc.HostNic) (*net.IPNet, error) {
+	if !constants.IsSecondaryIfName(ifName) {
+		return nil, nil
+	}
+	if nic == nil || nic.VxNet == nil {
+		return nil, fmt.Errorf("vxnet of secondary interface %s not found", ifName)
+	}
+	_, dst, err := net.ParseCIDR(nic.VxNet.Network)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q of vxnet %s: %v", nic.VxNet.Network, nic.VxNet.ID, err)
+	}
+	return dst, nil
+}
+
 func getPodKey(info *rpc.PodInfo) string {
 	return info.Namespace + "/" + info.Name + "/" + info.Containter
 }
 
 func main() {
 	networkutils.SetupNetworkHelper()
-	skel.PluginMain(cmdAdd, nil, cmdDel, version.All, bv.BuildString("hostnic"))
+	// GC and STATUS of CNI spec 1.1 are unknown to the vendored skel
+	switch os.Getenv("CNI_COMMAND") {
+	case "GC":
+		pluginMain(cmdGC)
+	case "STATUS":
+		pluginMain(cmdStatus)
+	default:
+		skel.PluginMain(cmdAdd, cmdCheck, cmdDel, supportedVersions, bv.BuildString("hostnic"))
+	}
 }
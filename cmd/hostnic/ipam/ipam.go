@@ -28,15 +28,18 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/types/current"
 	"github.com/davecgh/go-spew/spew"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
 
 	. "github.com/yunify/hostnic-cni/pkg/constants"
 	"github.com/yunify/hostnic-cni/pkg/networkutils"
 	"github.com/yunify/hostnic-cni/pkg/rpc"
+	"github.com/yunify/hostnic-cni/pkg/tracing"
 )
 
-func AddrAlloc(args *skel.CmdArgs, nodeName string) (*rpc.IPAMMessage, *current.Result, error) {
+// AddrAlloc allocates ip and nic for pod, vxnet is the vxnet of secondary interfaces
+func AddrAlloc(ctx context.Context, args *skel.CmdArgs, nodeName, vxnet string) (*rpc.IPAMMessage, *current.Result, error) {
 	// conf := NetConf{}
 	// if err := json.Unmarshal(args.StdinData, &conf); err != nil {
 	// 	return nil, nil, fmt.Errorf("failed to unmarshal netconf %s", spew.Sdump(args))
@@ -47,15 +50,19 @@ func AddrAlloc(args *skel.CmdArgs, nodeName string) (*rpc.IPAMMessage, *current.
 		return nil, nil, fmt.Errorf("failed to load k8s args  %s", spew.Sdump(args))
 	}
 
+	// the deadline is passed to hostnic-node by grpc, so that neither side waits for the nic forever
+	ctx, cancel := context.WithTimeout(ctx, NicAttachTimeout)
+	defer cancel()
+
 	// Set up a connection to the ipamD server.
-	conn, err := grpc.Dial(DefaultUnixSocketPath, grpc.WithInsecure())
+	conn, err := grpc.Dial(DefaultUnixSocketPath, grpc.WithInsecure(), grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor()))
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to connect server, err=%v", err)
 	}
 	defer conn.Close()
 
 	c := rpc.NewCNIBackendClient(conn)
-	r, err := c.AddNetwork(context.Background(),
+	r, err := c.AddNetwork(ctx,
 		&rpc.IPAMMessage{
 			Args: &rpc.PodInfo{
 				Name:       string(k8sArgs.K8S_POD_NAME),
@@ -64,6 +71,7 @@ func AddrAlloc(args *skel.CmdArgs, nodeName string) (*rpc.IPAMMessage, *current.
 				Netns:      args.Netns,
 				IfName:     args.IfName,
 				NodeName:   nodeName,
+				VxNet:      vxnet,
 			},
 		})
 	if err != nil {
@@ -73,15 +81,11 @@ func AddrAlloc(args *skel.CmdArgs, nodeName string) (*rpc.IPAMMessage, *current.
 	nic := r.Nic
 
 	//wait for nic attach
-	for {
-		link, err := networkutils.NetworkHelper.LinkByMacAddr(nic.HardwareAddr)
-		if err != nil && err != ErrNicNotFound {
-			return nil, nil, err
-		}
-		if link != nil {
-			break
-		}
-		time.Sleep(1 * time.Second)
+	_, span := tracing.Start(ctx, "link.Wait", attribute.String("mac", nic.HardwareAddr))
+	_, err = networkutils.NetworkHelper.WaitLinkByMacAddr(ctx, nic.HardwareAddr)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	index := 0
@@ -102,7 +106,7 @@ func AddrAlloc(args *skel.CmdArgs, nodeName string) (*rpc.IPAMMessage, *current.
 	return r, result, nil
 }
 
-func AddrUnalloc(args *skel.CmdArgs, peek bool) (*rpc.IPAMMessage, error) {
+func AddrUnalloc(ctx context.Context, args *skel.CmdArgs, peek bool) (*rpc.IPAMMessage, error) {
 	// conf := NetConf{}
 	// if err := json.Unmarshal(args.StdinData, &conf); err != nil {
 	// 	return nil, fmt.Errorf("failed to unmarshal netconf %s", spew.Sdump(args))
@@ -129,14 +133,14 @@ func AddrUnalloc(args *skel.CmdArgs, peek bool) (*rpc.IPAMMessage, error) {
 	conn, err := grpc.Dial(DefaultUnixSocketPath, grpc.WithInsecure(), grpc.WithConnectParams(grpc.ConnectParams{
 		Backoff:           backoff.DefaultConfig,
 		MinConnectTimeout: 30 * time.Second,
-	}))
+	}), grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect server")
 	}
 	defer conn.Close()
 
 	c := rpc.NewCNIBackendClient(conn)
-	reply, err := c.DelNetwork(context.Background(), info)
+	reply, err := c.DelNetwork(ctx, info)
 	if err != nil {
 		return nil, fmt.Errorf("failed to call DelNetwork: %v", err)
 	}
@@ -153,3 +157,35 @@ func AddrUnalloc(args *skel.CmdArgs, peek bool) (*rpc.IPAMMessage, error) {
 
 	return reply, nil
 }
+
+// GarbageCollect asks hostnic-node to release the pods and handles of the network on nodeName, which are
+// not in valid. vxnet is the vxnet of secondary networks, and empty for the primary network.
+func GarbageCollect(ctx context.Context, valid []*rpc.Attachment, nodeName, vxnet string) (*rpc.GCReply, error) {
+	conn, err := grpc.Dial(DefaultUnixSocketPath, grpc.WithInsecure(), grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect server, err=%v", err)
+	}
+	defer conn.Close()
+
+	c := rpc.NewCNIBackendClient(conn)
+	return c.GCNetwork(ctx, &rpc.GCRequest{
+		ValidAttachments: valid,
+		NodeName:         nodeName,
+		VxNet:            vxnet,
+	})
+}
+
+// Status returns the status of hostnic-node, it fails if the server could not be connected within timeout
+func Status(ctx context.Context, timeout time.Duration) (*rpc.StatusReply, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, DefaultUnixSocketPath, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect server, err=%v", err)
+	}
+	defer conn.Close()
+
+	c := rpc.NewCNIBackendClient(conn)
+	return c.Status(ctx, &rpc.Nothing{})
+}
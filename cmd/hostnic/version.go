@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/version"
+)
+
+// supportedVersions adds the 1.x versions of CNI spec to the versions known by the vendored libcni,
+// results of 1.x are converted by hostnic itself.
+var supportedVersions = version.PluginSupports("0.1.0", "0.2.0", "0.3.0", "0.3.1", "0.4.0", "1.0.0", "1.1.0")
+
+// versionAtLeast reports whether cniVersion is min or later
+func versionAtLeast(cniVersion, min string) bool {
+	ok, err := version.GreaterThanOrEqualTo(cniVersion, min)
+	return err == nil && ok
+}
+
+// resultV1 is the result of CNI spec 1.x, which drops the version of ips
+type resultV1 struct {
+	CNIVersion string               `json:"cniVersion,omitempty"`
+	Interfaces []*current.Interface `json:"interfaces,omitempty"`
+	IPs        []*ipConfigV1        `json:"ips,omitempty"`
+	Routes     []*types.Route       `json:"routes,omitempty"`
+	DNS        types.DNS            `json:"dns,omitempty"`
+}
+
+type ipConfigV1 struct {
+	Interface *int        `json:"interface,omitempty"`
+	Address   types.IPNet `json:"address"`
+	Gateway   net.IP      `json:"gateway,omitempty"`
+}
+
+func newResultV1(result *current.Result, cniVersion string) *resultV1 {
+	r := &resultV1{
+		CNIVersion: cniVersion,
+		Interfaces: result.Interfaces,
+		Routes:     result.Routes,
+		DNS:        result.DNS,
+	}
+	for _, ipc := range result.IPs {
+		r.IPs = append(r.IPs, &ipConfigV1{
+			Interface: ipc.Interface,
+			Address:   types.IPNet(ipc.Address),
+			Gateway:   ipc.Gateway,
+		})
+	}
+	return r
+}
+
+// printResult prints result in the format of cniVersion
+func printResult(result *current.Result, cniVersion string) error {
+	if !versionAtLeast(cniVersion, "1.0.0") {
+		return types.PrintResult(result, cniVersion)
+	}
+
+	data, err := json.MarshalIndent(newResultV1(result, cniVersion), "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// parsePrevResult parses the prevResult in netconf, which is required by CHECK
+func parsePrevResult(stdin []byte) (*current.Result, error) {
+	netConf := types.NetConf{}
+	if err := json.Unmarshal(stdin, &netConf); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+	if netConf.RawPrevResult == nil {
+		return nil, fmt.Errorf("required prevResult missing")
+	}
+
+	if !versionAtLeast(netConf.CNIVersion, "1.0.0") {
+		if err := version.ParsePrevResult(&netConf); err != nil {
+			return nil, fmt.Errorf("failed to parse prevResult: %v", err)
+		}
+		result, err := current.NewResultFromResult(netConf.PrevResult)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert prevResult: %v", err)
+		}
+		return result, nil
+	}
+
+	// the result of 1.x is the result of 0.4.0 without the version of ips
+	data, err := json.Marshal(netConf.RawPrevResult)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prevResult: %v", err)
+	}
+	result := &current.Result{}
+	if err = json.Unmarshal(data, result); err != nil {
+		return nil, fmt.Errorf("failed to parse prevResult: %v", err)
+	}
+	for _, ipc := range result.IPs {
+		if ipc.Address.IP.To4() != nil {
+			ipc.Version = "4"
+		} else {
+			ipc.Version = "6"
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+func TestPrevResultRoundTrip(t *testing.T) {
+	index := 0
+	result := &current.Result{
+		IPs: []*current.IPConfig{
+			{
+				Version: "4",
+				Address: net.IPNet{
+					IP:   net.ParseIP("172.22.0.10"),
+					Mask: net.CIDRMask(32, 32),
+				},
+				Interface: &index,
+				Gateway:   net.ParseIP("169.254.1.1"),
+			},
+		},
+	}
+
+	for _, cniVersion := range []string{"0.3.1", "0.4.0", "1.0.0", "1.1.0"} {
+		var prevResult interface{} = newResultV1(result, cniVersion)
+		if !versionAtLeast(cniVersion, "1.0.0") {
+			r, err := result.GetAsVersion(cniVersion)
+			if err != nil {
+				t.Fatalf("%s: %v", cniVersion, err)
+			}
+			prevResult = r
+		}
+		data, err := json.Marshal(prevResult)
+		if err != nil {
+			t.Fatalf("%s: %v", cniVersion, err)
+		}
+		if versionAtLeast(cniVersion, "1.0.0") && strings.Contains(string(data), `"version"`) {
+			t.Errorf("%s: ips of result should not have version: %s", cniVersion, data)
+		}
+
+		stdin := fmt.Sprintf(`{"cniVersion": %q, "name": "hostnic", "type": "hostnic", "prevResult": %s}`, cniVersion, data)
+		parsed, err := parsePrevResult([]byte(stdin))
+		if err != nil {
+			t.Fatalf("%s: %v", cniVersion, err)
+		}
+		if len(parsed.IPs) != 1 {
+			t.Fatalf("%s: got ips %v", cniVersion, parsed.IPs)
+		}
+		ipc := parsed.IPs[0]
+		if ipc.Version != "4" || ipc.Address.String() != "172.22.0.10/32" || !ipc.Gateway.Equal(net.ParseIP("169.254.1.1")) {
+			t.Errorf("%s: got ip %v", cniVersion, ipc)
+		}
+	}
+
+	if _, err := parsePrevResult([]byte(`{"cniVersion": "1.1.0", "name": "hostnic", "type": "hostnic"}`)); err == nil {
+		t.Errorf("missing prevResult should fail")
+	}
+}
@@ -19,9 +19,13 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"os"
 	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	k8sinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -35,14 +39,24 @@ import (
 	"github.com/yunify/hostnic-cni/pkg/config"
 	"github.com/yunify/hostnic-cni/pkg/constants"
 	"github.com/yunify/hostnic-cni/pkg/db"
+	"github.com/yunify/hostnic-cni/pkg/deviceplugin"
+	"github.com/yunify/hostnic-cni/pkg/egress"
+	"github.com/yunify/hostnic-cni/pkg/flowlog"
 	"github.com/yunify/hostnic-cni/pkg/networkutils"
 	"github.com/yunify/hostnic-cni/pkg/qcclient"
 	"github.com/yunify/hostnic-cni/pkg/server"
 	"github.com/yunify/hostnic-cni/pkg/signals"
 	"github.com/yunify/hostnic-cni/pkg/simple/client/network/ippool/ipam"
+	"github.com/yunify/hostnic-cni/pkg/tracing"
 )
 
-var qps, burst, metricsPort int
+var (
+	qps, burst, metricsPort int
+	enableDevicePlugin      bool
+	enableBlockAffinity     bool
+	enableEgress            bool
+	egressMark              int
+)
 
 func main() {
 	//parse flag and setup klog
@@ -50,8 +64,16 @@ func main() {
 	flag.IntVar(&qps, "k8s-api-qps", 80, "maximum QPS to k8s apiserver from this client.")
 	flag.IntVar(&burst, "k8s-api-burst", 100, "maximum burst for throttle from this client.")
 	flag.IntVar(&metricsPort, "metrics-port", 9191, "metrics port")
+	flag.BoolVar(&enableDevicePlugin, "enable-device-plugin", false, "advertise the nic slots of vxnets as extended resources to kubelet")
+	flag.BoolVar(&enableBlockAffinity, "enable-block-affinity", false, "prefer the ipamblocks claimed by this node, to cut write conflicts between nodes")
+	flag.BoolVar(&enableEgress, "enable-egress", false, "program the SNAT and policy routing of egresspolicies")
+	flag.IntVar(&egressMark, "egress-mark", constants.DefaultEgressMark, "single bit mark of the connections initiated by pods, routed to egress ips")
 	dbOpts := db.NewLevelDBOptions()
 	dbOpts.AddFlags()
+	qcOpts := qcclient.NewMiddlewareOptions()
+	qcOpts.AddFlags()
+	flowOpts := flowlog.NewOptions()
+	flowOpts.AddFlags()
 	flag.Parse()
 	db.SetupLevelDB(dbOpts)
 	defer func() {
@@ -68,9 +90,22 @@ func main() {
 	}
 	log.Infof("hostnic config is %v", conf)
 
+	shutdownTracing, err := tracing.Setup("hostnic-node", conf.Tracing)
+	if err != nil {
+		log.Fatalf("failed to setup tracing: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Errorf("failed to flush spans: %v", err)
+		}
+	}()
+
 	// setup qcclient, k8s
 	qcclient.SetupQingCloudClient(qcclient.Options{
-		Tag: conf.Pool.Tag,
+		Tag:        conf.Pool.Tag,
+		Middleware: qcOpts,
 	})
 
 	cfg, err := clientcmd.BuildConfigFromFlags("", "")
@@ -93,16 +128,42 @@ func main() {
 	k8sInformerFactory := k8sinformers.NewSharedInformerFactory(k8sClient, time.Second*30)
 	informerFactory := informers.NewSharedInformerFactory(client, time.Second*30)
 
+	// only watch the node this daemon runs on
+	nodeInformerFactory := k8sinformers.NewSharedInformerFactoryWithOptions(k8sClient, time.Second*30,
+		k8sinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", os.Getenv("MY_NODE_NAME")).String()
+		}))
+
 	clusterConfig := config.NewClusterConfig(k8sInformerFactory.Core().V1().ConfigMaps())
+	poolSelector := config.NewPoolSelector(nodeInformerFactory.Core().V1().Nodes(), informerFactory.Network().V1alpha1().VxNetPools())
 	ipamClient := ipam.NewIPAMClient(client, networkv1alpha1.IPPoolTypeLocal, informerFactory, k8sInformerFactory)
+	if enableBlockAffinity {
+		ipamClient = ipamClient.WithBlockAffinity(os.Getenv("MY_NODE_NAME"))
+	}
+	var devicePlugin *deviceplugin.Manager
+	if enableDevicePlugin {
+		devicePlugin = deviceplugin.NewManager(os.Getenv("MY_NODE_NAME"), informerFactory.Network().V1alpha1().IPPools().Lister(), poolSelector)
+	}
+	var egressManager *egress.Manager
+	if enableEgress {
+		if err := egress.ValidateMark(egressMark); err != nil {
+			log.Fatalf("invalid egress mark: %v", err)
+		}
+		egressManager = egress.NewManager(os.Getenv("MY_NODE_NAME"), egressMark, client, ipamClient, informerFactory, k8sInformerFactory)
+	}
 
 	k8sInformerFactory.Start(stopCh)
 	informerFactory.Start(stopCh)
+	nodeInformerFactory.Start(stopCh)
 
 	if err = clusterConfig.Sync(stopCh); err != nil {
 		log.Fatalf("clusterConfig sync error: %v", err)
 	}
 
+	if err = poolSelector.Sync(stopCh); err != nil {
+		log.Fatalf("poolSelector sync error: %v", err)
+	}
+
 	if err = ipamClient.Sync(stopCh); err != nil {
 		log.Fatalf("ipamclient sync error: %v", err)
 	}
@@ -111,8 +172,23 @@ func main() {
 	allocator.SetupAllocator(conf.Pool)
 
 	log.Info("all setup done, startup daemon")
-	allocator.Alloc.Start(stopCh)
-	server.NewIPAMServer(conf.Server, clusterConfig, k8sClient, ipamClient, metricsPort).Start(stopCh)
+	if err = allocator.Alloc.Start(stopCh); err != nil {
+		log.Fatalf("allocator start error: %v", err)
+	}
+	server.NewIPAMServer(conf.Server, clusterConfig, poolSelector, k8sClient, ipamClient, metricsPort).Start(stopCh)
+	if devicePlugin != nil {
+		devicePlugin.Start(stopCh)
+	}
+	if egressManager != nil {
+		egressManager.Start(stopCh)
+	}
+	if flowOpts.Enabled() {
+		collector, err := flowlog.NewCollector(flowOpts)
+		if err != nil {
+			log.Fatalf("flow log setup error: %v", err)
+		}
+		collector.Start(stopCh)
+	}
 
 	<-stopCh
 	log.Info("daemon exited")
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/yunify/hostnic-cni/pkg/constants"
 	"github.com/yunify/hostnic-cni/pkg/rpc"
@@ -18,16 +22,62 @@ func usage() {
 	fmt.Println("\t./hostnic-client")
 	fmt.Println("\t./hostnic-client -clear true")
 	fmt.Println("\t./hostnic-client -clear true -force true")
+	fmt.Println("\t./hostnic-client -check true")
+	fmt.Println("\t./hostnic-client -check true -repair true")
+	fmt.Println("\t./hostnic-client -leases true")
+	fmt.Println("\t./hostnic-client -pods true")
+	fmt.Println("\t./hostnic-client -server 192.168.0.2:9193 -cert client.crt -key client.key -ca ca.crt -pods true")
+}
+
+// dialOptions connects to the local socket, or the admin listener of a remote node over mTLS
+func dialOptions(server, serverName, cert, key, ca string) ([]grpc.DialOption, error) {
+	if server == constants.DefaultUnixSocketPath {
+		return []grpc.DialOption{grpc.WithInsecure()}, nil
+	}
+
+	keyPair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %v", err)
+	}
+	caPEM, err := os.ReadFile(ca)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ca: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificate found in %s", ca)
+	}
+	return []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}))}, nil
 }
 
 func main() {
-	var clear, force bool
+	var clear, force, check, repair, leases, pods bool
+	var server, serverName, cert, key, ca string
 	flag.BoolVar(&clear, "clear", false, "clear free hostnics")
 	flag.BoolVar(&force, "force", false, "force clear all hostnics, be careful, it will remove all hostnics, including the hostnics that are in use")
+	flag.BoolVar(&check, "check", false, "show the differences between the policy routing state of node and hostnic records")
+	flag.BoolVar(&repair, "repair", false, "remove the orphan bridges, route tables and rules found by check, and repair hostnics")
+	flag.BoolVar(&leases, "leases", false, "show the dhcp leases of vlan bridges")
+	flag.BoolVar(&pods, "pods", false, "show the pods recorded on the hostnics")
+	flag.StringVar(&server, "server", constants.DefaultUnixSocketPath, "the local socket, or the address of admin listener of a remote node")
+	flag.StringVar(&serverName, "server-name", "", "the name to verify the certificate of admin listener, defaults to the host of server")
+	flag.StringVar(&cert, "cert", "tls.crt", "client certificate for admin listener")
+	flag.StringVar(&key, "key", "tls.key", "client key for admin listener")
+	flag.StringVar(&ca, "ca", "ca.crt", "ca of admin listener")
 	flag.Usage = usage
 	flag.Parse()
 
-	conn, err := grpc.Dial(constants.DefaultUnixSocketPath, grpc.WithInsecure())
+	opts, err := dialOptions(server, serverName, cert, key, ca)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	conn, err := grpc.Dial(server, opts...)
 	if err != nil {
 		fmt.Printf("failed to connect ipam: %v\n", err)
 		return
@@ -68,4 +118,44 @@ func main() {
 			fmt.Printf("%s %s %s %s %d\n\n", nic.Vxnet, nic.Id, nic.Phase, nic.Status, nic.Pods)
 		}
 	}
+
+	if check {
+		result, err := client.CheckNetwork(context.Background(), &rpc.NetworkCheckRequest{DryRun: !repair})
+		if err != nil {
+			fmt.Printf("CheckNetwork failed: %v\n", err)
+			return
+		}
+
+		fmt.Println("-------------------- node network drifts --------------------")
+		for _, drift := range result.Items {
+			fmt.Printf("%s %s %s %t %s\n\n", drift.Kind, drift.Object, drift.VxNet, drift.Repaired, drift.Error)
+		}
+	}
+
+	if leases {
+		result, err := client.ShowLeases(context.Background(), &rpc.Nothing{})
+		if err != nil {
+			fmt.Printf("ShowLeases failed: %v\n", err)
+			return
+		}
+
+		fmt.Println("-------------------- bridge dhcp leases --------------------")
+		for _, lease := range result.Items {
+			fmt.Printf("%s %s %s %s %s renew:%s rebind:%s expiry:%s\n\n", lease.Bridge, lease.HardwareAddr, lease.Address, lease.ServerID, lease.State,
+				time.Unix(lease.Renew, 0).Format(time.RFC3339), time.Unix(lease.Rebind, 0).Format(time.RFC3339), time.Unix(lease.Expiry, 0).Format(time.RFC3339))
+		}
+	}
+
+	if pods {
+		result, err := client.ShowPods(context.Background(), &rpc.Nothing{})
+		if err != nil {
+			fmt.Printf("ShowPods failed: %v\n", err)
+			return
+		}
+
+		fmt.Println("-------------------- hostnic pods --------------------")
+		for _, pod := range result.Items {
+			fmt.Printf("%s/%s %s %s %s %s %s\n\n", pod.Namespace, pod.Name, pod.Containter, pod.IfName, pod.PodIP, pod.VxNet, pod.HostNic)
+		}
+	}
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/yunify/hostnic-cni/pkg/simple/client/network/ippool/ipam/loadtest"
+)
+
+func main() {
+	cfg := loadtest.DefaultConfig()
+	var strategies string
+
+	klog.InitFlags(nil)
+	flag.IntVar(&cfg.Nodes, "nodes", cfg.Nodes, "number of simulated nodes, each one has its own ipam client and caches")
+	flag.IntVar(&cfg.Workers, "workers", cfg.Workers, "number of parallel workers on every node")
+	flag.IntVar(&cfg.Pods, "pods", cfg.Pods, "number of pods every worker assigns before releasing them")
+	flag.DurationVar(&cfg.Latency, "latency", cfg.Latency, "latency of every request to ipamblocks and ipamhandles")
+	flag.StringVar(&cfg.CIDR, "cidr", cfg.CIDR, "cidr of the simulated ippool")
+	flag.IntVar(&cfg.BlockSize, "blocksize", cfg.BlockSize, "block size of the simulated ippool")
+	flag.StringVar(&strategies, "strategy", "", "comma separated strategies to run, all strategies if empty")
+	_ = flag.Set("logtostderr", "false")
+	_ = flag.Set("stderrthreshold", "FATAL")
+	flag.Parse()
+
+	known := loadtest.Strategies()
+	var names []string
+	if strategies == "" {
+		for name := range known {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	} else {
+		names = strings.Split(strategies, ",")
+	}
+
+	for _, name := range names {
+		strategy, ok := known[name]
+		if !ok {
+			fmt.Printf("unknown strategy %s\n", name)
+			os.Exit(1)
+		}
+		report, err := loadtest.Run(cfg, strategy)
+		if err != nil {
+			fmt.Printf("run strategy %s failed: %v\n", name, err)
+			os.Exit(1)
+		}
+		fmt.Print(report)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+
+	v1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+
+	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	clientset "github.com/yunify/hostnic-cni/pkg/client/clientset/versioned"
+	"github.com/yunify/hostnic-cni/pkg/controller"
+)
+
+var (
+	ippoolResource    = networkResource(networkv1alpha1.ResourcePluralIPPool)
+	vxnetpoolResource = networkResource(networkv1alpha1.ResourcePluralVxNetPool)
+	egressResource    = networkResource(networkv1alpha1.ResourcePluralEgressPolicy)
+	ipamResources     = map[metav1.GroupVersionResource]bool{
+		networkResource(networkv1alpha1.ResourcePluralIPAMBlock):  true,
+		networkResource(networkv1alpha1.ResourcePluralIPAMHandle): true,
+	}
+)
+
+func networkResource(resource string) metav1.GroupVersionResource {
+	return metav1.GroupVersionResource{
+		Group:    networkv1alpha1.SchemeGroupVersion.Group,
+		Version:  networkv1alpha1.SchemeGroupVersion.Version,
+		Resource: resource,
+	}
+}
+
+// crdValidator validates the network.qingcloud.com resources
+type crdValidator struct {
+	client          clientset.Interface
+	ippoolValidator *controller.IPPoolController
+	// ipamUsers are the users allowed to modify ipamblocks and ipamhandles
+	ipamUsers map[string]bool
+}
+
+func newCRDValidator(client clientset.Interface, ipamUsers []string) *crdValidator {
+	v := &crdValidator{
+		client:          client,
+		ippoolValidator: controller.NewIPPoolValidator(client),
+		ipamUsers:       make(map[string]bool),
+	}
+	for _, user := range ipamUsers {
+		v.ipamUsers[user] = true
+	}
+	return v
+}
+
+func toAdmissionResponse(err error) *v1.AdmissionResponse {
+	if err == nil {
+		return &v1.AdmissionResponse{Allowed: true}
+	}
+	klog.Error(err)
+	return &v1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Reason:  metav1.StatusReason(err.Error()),
+			Message: err.Error(),
+		},
+	}
+}
+
+// decodeObjects decodes the new and old object of request into obj and old, the one not carried by request is left empty
+func decodeObjects(ar v1.AdmissionReview, obj, old runtime.Object) error {
+	if len(ar.Request.Object.Raw) > 0 {
+		if _, _, err := deserializer.Decode(ar.Request.Object.Raw, nil, obj); err != nil {
+			return err
+		}
+	}
+	if len(ar.Request.OldObject.Raw) > 0 {
+		if _, _, err := deserializer.Decode(ar.Request.OldObject.Raw, nil, old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *crdValidator) admitIPPools(ar v1.AdmissionReview) *v1.AdmissionResponse {
+	if ar.Request.Resource != ippoolResource {
+		klog.Errorf("expect resource to be %s", ippoolResource)
+		return nil
+	}
+
+	pool, old := &networkv1alpha1.IPPool{}, &networkv1alpha1.IPPool{}
+	if err := decodeObjects(ar, pool, old); err != nil {
+		return toAdmissionResponse(err)
+	}
+
+	switch ar.Request.Operation {
+	case v1.Create:
+		return toAdmissionResponse(c.ippoolValidator.ValidateCreate(pool))
+	case v1.Update:
+		return toAdmissionResponse(c.ippoolValidator.ValidateUpdate(old, pool))
+	case v1.Delete:
+		return toAdmissionResponse(c.ippoolValidator.ValidateDelete(old))
+	}
+	return toAdmissionResponse(nil)
+}
+
+func (c *crdValidator) admitVxNetPools(ar v1.AdmissionReview) *v1.AdmissionResponse {
+	if ar.Request.Resource != vxnetpoolResource {
+		klog.Errorf("expect resource to be %s", vxnetpoolResource)
+		return nil
+	}
+
+	// resources are released by controller with the finalizer
+	if ar.Request.Operation != v1.Create && ar.Request.Operation != v1.Update {
+		return toAdmissionResponse(nil)
+	}
+
+	pool, old := &networkv1alpha1.VxNetPool{}, &networkv1alpha1.VxNetPool{}
+	if err := decodeObjects(ar, pool, old); err != nil {
+		return toAdmissionResponse(err)
+	}
+	// nothing to check when the pool is being deleted, or only metadata changes such as finalizers
+	if pool.DeletionTimestamp != nil || (ar.Request.Operation == v1.Update && reflect.DeepEqual(pool.Spec, old.Spec)) {
+		return toAdmissionResponse(nil)
+	}
+
+	return toAdmissionResponse(c.validateVxNetPool(pool, old))
+}
+
+func (c *crdValidator) validateVxNetPool(pool, old *networkv1alpha1.VxNetPool) error {
+	if err := pool.Validate(); err != nil {
+		return err
+	}
+
+	// a vxnet could only belong to one vxnetpool
+	pools, err := c.client.NetworkV1alpha1().VxNetPools().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, p := range pools.Items {
+		if p.Name == pool.Name {
+			continue
+		}
+		for _, v := range pool.Spec.Vxnets {
+			if p.HasVxNet(v.Name) {
+				return fmt.Errorf("vxnet %s already belongs to vxnetpool %s", v.Name, p.Name)
+			}
+		}
+	}
+
+	// the ippool of a vxnet is named after it
+	for _, v := range pool.Spec.Vxnets {
+		ippool, err := c.client.NetworkV1alpha1().IPPools().Get(context.TODO(), v.Name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		_, cidr, err := net.ParseCIDR(ippool.Spec.CIDR)
+		if err != nil {
+			continue
+		}
+		if size, _ := cidr.Mask.Size(); pool.Spec.BlockSize < size {
+			return fmt.Errorf("blockSize %d is larger than vxnet %s(%s)", pool.Spec.BlockSize, v.Name, ippool.Spec.CIDR)
+		}
+	}
+
+	for _, v := range old.Spec.Vxnets {
+		if pool.HasVxNet(v.Name) {
+			continue
+		}
+		ippool, err := c.client.NetworkV1alpha1().IPPools().Get(context.TODO(), v.Name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if ippool.Status.Allocations > 0 {
+			return fmt.Errorf("vxnet %s still has %d allocations, please remove the workload before removing it", v.Name, ippool.Status.Allocations)
+		}
+	}
+
+	return nil
+}
+
+func (c *crdValidator) admitEgressPolicies(ar v1.AdmissionReview) *v1.AdmissionResponse {
+	if ar.Request.Resource != egressResource {
+		klog.Errorf("expect resource to be %s", egressResource)
+		return nil
+	}
+
+	if ar.Request.Operation != v1.Create && ar.Request.Operation != v1.Update {
+		return toAdmissionResponse(nil)
+	}
+
+	policy, old := &networkv1alpha1.EgressPolicy{}, &networkv1alpha1.EgressPolicy{}
+	if err := decodeObjects(ar, policy, old); err != nil {
+		return toAdmissionResponse(err)
+	}
+	if policy.DeletionTimestamp != nil || (ar.Request.Operation == v1.Update && reflect.DeepEqual(policy.Spec, old.Spec)) {
+		return toAdmissionResponse(nil)
+	}
+
+	return toAdmissionResponse(c.validateEgressPolicy(policy))
+}
+
+func (c *crdValidator) validateEgressPolicy(policy *networkv1alpha1.EgressPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	// the ippool of a vxnet is named after it
+	ippool, err := c.client.NetworkV1alpha1().IPPools().Get(context.TODO(), policy.Spec.VxNet, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("ippool of vxnet %s not found", policy.Spec.VxNet)
+		}
+		return err
+	}
+	_, cidr, err := net.ParseCIDR(ippool.Spec.CIDR)
+	if err != nil {
+		return fmt.Errorf("invalid cidr %s of ippool %s: %v", ippool.Spec.CIDR, ippool.Name, err)
+	}
+	if !cidr.Contains(net.ParseIP(policy.Spec.EgressIP)) {
+		return fmt.Errorf("egressIP %s is not in vxnet %s(%s)", policy.Spec.EgressIP, policy.Spec.VxNet, ippool.Spec.CIDR)
+	}
+
+	policies, err := c.client.NetworkV1alpha1().EgressPolicies().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, p := range policies.Items {
+		if p.Name != policy.Name && p.Spec.EgressIP == policy.Spec.EgressIP {
+			return fmt.Errorf("egressIP %s is already used by egresspolicy %s", policy.Spec.EgressIP, p.Name)
+		}
+	}
+
+	return nil
+}
+
+// admitIPAMObjects only allows hostnic itself to modify ipamblocks and ipamhandles,
+// a manual edit easily leaks addresses or assigns one address twice.
+func (c *crdValidator) admitIPAMObjects(ar v1.AdmissionReview) *v1.AdmissionResponse {
+	if !ipamResources[ar.Request.Resource] {
+		klog.Errorf("expect resource to be ipamblocks or ipamhandles")
+		return nil
+	}
+
+	if c.ipamUsers[ar.Request.UserInfo.Username] {
+		return toAdmissionResponse(nil)
+	}
+	return toAdmissionResponse(fmt.Errorf("%s are managed by hostnic, %s is not allowed to %s them",
+		ar.Request.Resource.Resource, ar.Request.UserInfo.Username, ar.Request.Operation))
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	v1 "k8s.io/api/admission/v1"
@@ -15,7 +17,18 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
+
+	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	clientset "github.com/yunify/hostnic-cni/pkg/client/clientset/versioned"
+	informers "github.com/yunify/hostnic-cni/pkg/client/informers/externalversions"
+	"github.com/yunify/hostnic-cni/pkg/config"
+	"github.com/yunify/hostnic-cni/pkg/constants"
+	"github.com/yunify/hostnic-cni/pkg/signals"
 )
 
 var (
@@ -32,12 +45,15 @@ func addToScheme(scheme *runtime.Scheme) {
 	utilruntime.Must(corev1.AddToScheme(scheme))
 	utilruntime.Must(admissionv1.AddToScheme(scheme))
 	utilruntime.Must(admissionregistrationv1.AddToScheme(scheme))
+	utilruntime.Must(networkv1alpha1.AddToScheme(scheme))
 }
 
 var (
-	certFile string
-	keyFile  string
-	port     int
+	certFile        string
+	keyFile         string
+	port            int
+	enablePodMutate bool
+	ipamUsers       string
 )
 
 // admitv1Func handles a v1 admission
@@ -116,6 +132,46 @@ func configTLS(certFile, keyFile string) *tls.Config {
 	}
 }
 
+func newClients() (kubernetes.Interface, clientset.Interface) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", "")
+	if err != nil {
+		klog.Fatalf("Error building kubeconfig: %v", err)
+	}
+	k8sClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		klog.Fatalf("Error building kubernetes clientset: %v", err)
+	}
+	client, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		klog.Fatalf("Error building hostnic clientset: %v", err)
+	}
+	return k8sClient, client
+}
+
+// setupPodMutator watches the ipam configmap and ipamblocks which are needed to resolve the vxnet of pods
+func setupPodMutator(k8sClient kubernetes.Interface, client clientset.Interface, stopCh <-chan struct{}) *podMutator {
+	k8sInformerFactory := k8sinformers.NewSharedInformerFactoryWithOptions(k8sClient, time.Second*30,
+		k8sinformers.WithNamespace(constants.IPAMConfigNamespace))
+	informerFactory := informers.NewSharedInformerFactory(client, time.Second*30)
+
+	clusterConfig := config.NewClusterConfig(k8sInformerFactory.Core().V1().ConfigMaps())
+	blockInformer := informerFactory.Network().V1alpha1().IPAMBlocks()
+	mutator := &podMutator{
+		clusterConfig: clusterConfig,
+		blockLister:   blockInformer.Lister(),
+	}
+
+	k8sInformerFactory.Start(stopCh)
+	informerFactory.Start(stopCh)
+	if err := clusterConfig.Sync(stopCh); err != nil {
+		klog.Fatalf("clusterConfig sync error: %v", err)
+	}
+	if !cache.WaitForCacheSync(stopCh, blockInformer.Informer().HasSynced) {
+		klog.Fatal("failed to wait for ipamblock caches to sync")
+	}
+	return mutator
+}
+
 func main() {
 	klog.InitFlags(flag.CommandLine)
 	flag.StringVar(&certFile, "tls-cert-file", "",
@@ -124,9 +180,35 @@ func main() {
 		"File containing the default x509 private key matching --tls-cert-file.")
 	flag.IntVar(&port, "port", 8443,
 		"Secure port that the webhook listens on")
+	flag.BoolVar(&enablePodMutate, "enable-pod-mutate", false,
+		"Request the nic resource of vxnet for pods, hostnic-node must run with --enable-device-plugin")
+	flag.StringVar(&ipamUsers, "ipam-users",
+		"system:serviceaccount:kube-system:hostnic-node,system:serviceaccount:kube-system:generic-garbage-collector",
+		"Comma separated users allowed to modify ipamblocks and ipamhandles")
 	flag.Parse()
 
+	k8sClient, client := newClients()
+	validator := newCRDValidator(client, strings.Split(ipamUsers, ","))
+
 	http.HandleFunc("/ipam-configmap-validate", serveConfigmaps)
+	http.HandleFunc("/ippool-validate", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, validator.admitIPPools)
+	})
+	http.HandleFunc("/vxnetpool-validate", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, validator.admitVxNetPools)
+	})
+	http.HandleFunc("/egresspolicy-validate", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, validator.admitEgressPolicies)
+	})
+	http.HandleFunc("/ipam-object-validate", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, validator.admitIPAMObjects)
+	})
+	if enablePodMutate {
+		mutator := setupPodMutator(k8sClient, client, signals.SetupSignalHandler())
+		http.HandleFunc("/pod-mutate", func(w http.ResponseWriter, r *http.Request) {
+			serve(w, r, mutator.admitPods)
+		})
+	}
 	http.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) { w.Write([]byte("ok")) })
 	server := &http.Server{
 		Addr:      fmt.Sprintf(":%d", port),
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	networklisters "github.com/yunify/hostnic-cni/pkg/client/listers/network/v1alpha1"
+	"github.com/yunify/hostnic-cni/pkg/config"
+	"github.com/yunify/hostnic-cni/pkg/constants"
+)
+
+// podMutator requests the nic resource of the vxnet a pod will be assigned from,
+// so that pods are only scheduled to nodes which still have a nic slot for the vxnet.
+type podMutator struct {
+	clusterConfig *config.ClusterConfig
+	blockLister   networklisters.IPAMBlockLister
+}
+
+// jsonPointerEscaper escapes "~" and "/" as required by RFC 6901
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func (m *podMutator) admitPods(ar v1.AdmissionReview) *v1.AdmissionResponse {
+	podResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+	if ar.Request.Resource != podResource {
+		klog.Errorf("expect resource to be %s", podResource)
+		return nil
+	}
+
+	pod := corev1.Pod{}
+	if _, _, err := deserializer.Decode(ar.Request.Object.Raw, nil, &pod); err != nil {
+		klog.Error(err)
+		return &v1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: err.Error(),
+			},
+		}
+	}
+
+	reviewResponse := v1.AdmissionResponse{}
+	reviewResponse.Allowed = true
+	if pod.Spec.HostNetwork || len(pod.Spec.Containers) == 0 {
+		return &reviewResponse
+	}
+
+	// the namespace of pod is empty when it is created by a controller
+	namespace := pod.Namespace
+	if namespace == "" {
+		namespace = ar.Request.Namespace
+	}
+	vxnet := m.resolveVxNet(namespace)
+	if vxnet == "" {
+		return &reviewResponse
+	}
+
+	resourceName := corev1.ResourceName(constants.GetVxNetResourceName(vxnet))
+	for _, c := range pod.Spec.Containers {
+		if _, ok := c.Resources.Limits[resourceName]; ok {
+			return &reviewResponse
+		}
+		if _, ok := c.Resources.Requests[resourceName]; ok {
+			return &reviewResponse
+		}
+	}
+
+	patch, err := json.Marshal(resourcePatch(pod.Spec.Containers[0].Resources, resourceName))
+	if err != nil {
+		klog.Error(err)
+		return &v1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: err.Error(),
+			},
+		}
+	}
+	klog.V(2).Infof("request %s for pod %s/%s", resourceName, namespace, pod.GenerateName+pod.Name)
+
+	patchType := v1.PatchTypeJSONPatch
+	reviewResponse.Patch = patch
+	reviewResponse.PatchType = &patchType
+	return &reviewResponse
+}
+
+// resolveVxNet returns the vxnet pods in namespace are assigned from, the same way as hostnic-node does.
+// Nothing is returned if there are more than one candidates, the node picks one of them at runtime.
+func (m *podMutator) resolveVxNet(namespace string) string {
+	vxnets := make(map[string]struct{})
+	if blocks := m.clusterConfig.GetBlocksForAPP(namespace); len(blocks) > 0 {
+		for _, name := range blocks {
+			block, err := m.blockLister.Get(name)
+			if err != nil {
+				klog.Warningf("get ipamblock %s failed: %v", name, err)
+				return ""
+			}
+			vxnets[block.Labels[networkv1alpha1.IPPoolNameLabel]] = struct{}{}
+		}
+	} else {
+		for _, pool := range m.clusterConfig.GetDefaultIPPools() {
+			vxnets[pool] = struct{}{}
+		}
+	}
+
+	if len(vxnets) != 1 {
+		return ""
+	}
+	for vxnet := range vxnets {
+		return vxnet
+	}
+	return ""
+}
+
+// resourcePatch adds one resourceName to both requests and limits of the first container,
+// extended resources require the two to be equal.
+func resourcePatch(resources corev1.ResourceRequirements, resourceName corev1.ResourceName) []patchOperation {
+	var patches []patchOperation
+	quantity := resource.MustParse("1")
+	for _, field := range []struct {
+		name string
+		list corev1.ResourceList
+	}{
+		{"requests", resources.Requests},
+		{"limits", resources.Limits},
+	} {
+		path := "/spec/containers/0/resources/" + field.name
+		if field.list == nil {
+			patches = append(patches, patchOperation{
+				Op:    "add",
+				Path:  path,
+				Value: corev1.ResourceList{resourceName: quantity},
+			})
+		} else {
+			patches = append(patches, patchOperation{
+				Op:    "add",
+				Path:  path + "/" + jsonPointerEscaper.Replace(string(resourceName)),
+				Value: quantity,
+			})
+		}
+	}
+	return patches
+}
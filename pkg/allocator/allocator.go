@@ -1,21 +1,26 @@
 package allocator
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/klog/v2"
 	log "k8s.io/klog/v2"
 
 	"github.com/yunify/hostnic-cni/pkg/conf"
 	"github.com/yunify/hostnic-cni/pkg/constants"
 	"github.com/yunify/hostnic-cni/pkg/db"
+	"github.com/yunify/hostnic-cni/pkg/metrics/instrument"
 	"github.com/yunify/hostnic-cni/pkg/networkutils"
 	"github.com/yunify/hostnic-cni/pkg/qcclient"
 	"github.com/yunify/hostnic-cni/pkg/rpc"
+	"github.com/yunify/hostnic-cni/pkg/tracing"
 )
 
 type nicStatus struct {
@@ -66,6 +71,10 @@ func (n *nicStatus) isOK() bool {
 	return n.Nic.Phase == rpc.Phase_Succeeded
 }
 
+func (n *nicStatus) isFailed() bool {
+	return n.Nic.Phase == rpc.Phase_AttachFailed
+}
+
 func (n *nicStatus) getPhase() string {
 	return n.Nic.Phase.String()
 }
@@ -74,6 +83,11 @@ type Allocator struct {
 	lock sync.RWMutex
 	nics map[string]*nicStatus
 	conf conf.PoolConf
+	// vxnets whose nic is held without pods, see HoldHostNic
+	held map[string]bool
+	// vxnets whose nic is being attached or rolled back without the lock held,
+	// the channel is closed once it is done
+	attaching map[string]chan struct{}
 }
 
 func (a *Allocator) setNicStatus(nic *rpc.HostNic, pahse rpc.Phase) error {
@@ -134,36 +148,103 @@ func (a *Allocator) delNic(vxnet string) error {
 	if err := db.DeleteNetworkInfo(vxnet); err != nil {
 		return err
 	}
+	// network of nic has been cleaned up, so its route table could be reused
+	if status, ok := a.nics[vxnet]; ok && status.Nic.RouteTableNum > 0 {
+		if err := db.DeleteRouteTable(int(status.Nic.RouteTableNum)); err != nil {
+			log.Errorf("release routetable num %d of vxnet %s failed: %v", status.Nic.RouteTableNum, vxnet, err)
+		}
+	}
 	delete(a.nics, vxnet)
+	delete(a.held, vxnet)
 
 	return nil
 }
 
-func (a *Allocator) getNicRouteTableNum(nic *rpc.HostNic) int32 {
-	if nic.RouteTableNum <= 0 {
-		exists := make(map[int]bool)
-		for _, nic := range a.nics {
-			exists[int(nic.Nic.RouteTableNum)] = true
+// getNicRouteTableNum allocates the lowest route table in range which is neither recorded
+// nor used by routes and rules in kernel, the allocation is recorded before use.
+func (a *Allocator) getNicRouteTableNum(nic *rpc.HostNic) (int32, error) {
+	if nic.RouteTableNum > 0 {
+		return nic.RouteTableNum, nil
+	}
+
+	allocated, err := db.ListRouteTables()
+	if err != nil {
+		return 0, fmt.Errorf("list routetable records failed: %v", err)
+	}
+	for _, status := range a.nics {
+		allocated[int(status.Nic.RouteTableNum)] = status.Nic.VxNet.ID
+	}
+	inUse, err := networkutils.NetworkHelper.RouteTablesInUse()
+	if err != nil {
+		return 0, err
+	}
+
+	for table := a.conf.RouteTableBase; table < a.conf.RouteTableBase+a.conf.RouteTableSize; table++ {
+		if _, ok := allocated[table]; ok {
+			continue
+		}
+		if inUse[table] {
+			log.Warningf("routetable num %d is in use by routes or rules not managed by hostnic, skip it", table)
+			continue
+		}
+		if err := db.SetRouteTable(table, nic.VxNet.ID); err != nil {
+			return 0, fmt.Errorf("record routetable num %d failed: %v", table, err)
+		}
+		log.Infof("Assign nic %s routetable num %d", getNicKey(nic), table)
+		return int32(table), nil
+	}
+	return 0, fmt.Errorf("no routetable num left in [%d, %d)", a.conf.RouteTableBase, a.conf.RouteTableBase+a.conf.RouteTableSize)
+}
+
+// syncRouteTables records the route tables of restored nics, and releases the records
+// which no nic owns, unless the table is still in use in kernel.
+func (a *Allocator) syncRouteTables() error {
+	allocated, err := db.ListRouteTables()
+	if err != nil {
+		return err
+	}
+	owned := make(map[int]string)
+	for vxnet, status := range a.nics {
+		table := int(status.Nic.RouteTableNum)
+		if table <= 0 {
+			continue
 		}
-		for start := a.conf.RouteTableBase; ; start++ {
-			if !exists[start] {
-				log.Infof("Assign nic %s routetable num %d", getNicKey(nic), start)
-				return int32(start)
+		owned[table] = vxnet
+		if allocated[table] != vxnet {
+			if err := db.SetRouteTable(table, vxnet); err != nil {
+				return err
 			}
 		}
-	} else {
-		return nic.RouteTableNum
 	}
+
+	inUse, err := networkutils.NetworkHelper.RouteTablesInUse()
+	if err != nil {
+		return err
+	}
+	for table, vxnet := range allocated {
+		if _, ok := owned[table]; ok {
+			continue
+		}
+		if inUse[table] {
+			log.Infof("routetable num %d of vxnet %s has no nic but is still in use, keep it", table, vxnet)
+			continue
+		}
+		log.Infof("release routetable num %d of vxnet %s which has no nic", table, vxnet)
+		if err := db.DeleteRouteTable(table); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (a *Allocator) getVxnets(vxnet string) (*rpc.VxNet, error) {
+func (a *Allocator) getVxnets(ctx context.Context, vxnet string) (*rpc.VxNet, error) {
 	for _, nic := range a.nics {
 		if nic.Nic.VxNet.ID == vxnet {
 			return nic.Nic.VxNet, nil
 		}
 	}
 
-	result, err := qcclient.QClient.GetVxNets([]string{vxnet}, 0)
+	result, err := qcclient.WithContext(ctx, qcclient.QClient).GetVxNets([]string{vxnet}, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -176,83 +257,237 @@ func (a *Allocator) getVxnets(vxnet string) (*rpc.VxNet, error) {
 }
 
 func (a *Allocator) canAlloc() int {
-	return a.conf.MaxNic - len(a.nics)
+	count := len(a.nics)
+	for vxnet := range a.attaching {
+		if _, ok := a.nics[vxnet]; !ok {
+			count++
+		}
+	}
+	return a.conf.MaxNic - count
+}
+
+// Unavailable returns why no pod could get a nic on this node, it is empty if there is room for a new nic
+// or some nic attached is usable.
+func (a *Allocator) Unavailable() string {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if a.canAlloc() > 0 {
+		return ""
+	}
+	for _, nic := range a.nics {
+		if !nic.isFailed() {
+			return ""
+		}
+	}
+	return fmt.Sprintf("all %d hostnics failed to attach and no more could be allocated", len(a.nics))
+}
+
+// CanServe reports whether a pod in vxnet could get a nic on this node,
+// either the nic of vxnet is attached or there is room for a new one.
+// A vxnet whose nic failed to attach could not be served until the nic is released.
+func (a *Allocator) CanServe(vxnet string) bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if nic, ok := a.nics[vxnet]; ok {
+		return !nic.isFailed()
+	}
+	return a.canAlloc() > 0
 }
 
-func (a *Allocator) AllocHostNic(args *rpc.PodInfo) (*rpc.HostNic, error) {
+func (a *Allocator) AllocHostNic(ctx context.Context, args *rpc.PodInfo) (_ *rpc.HostNic, err error) {
+	ctx, span := tracing.Start(ctx, "allocator.AllocHostNic", attribute.String("vxnet", args.VxNet))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
-	vxnetName := args.VxNet
+	nic, err := a.prepareHostNic(ctx, args.VxNet)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.addNicPod(nic, args); err != nil {
+		log.Errorf("addNicPod failed: %s %s %v", getNicKey(nic), getPodKey(args), err)
+	}
+	return nic, nil
+}
+
+// HoldHostNic makes sure the nic of vxnet is attached and its network is set up, the nic is not
+// freed while it is held even if no pod uses it. Holds are not persisted, holders renew them periodically.
+func (a *Allocator) HoldHostNic(ctx context.Context, vxnet string) (*rpc.HostNic, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	nic, err := a.prepareHostNic(ctx, vxnet)
+	if err != nil {
+		return nil, err
+	}
+	if !a.held[vxnet] {
+		log.Infof("hold hostNic %s", getNicKey(nic))
+		a.held[vxnet] = true
+	}
+	if nic.Phase != rpc.Phase_Succeeded {
+		if err := a.setNicStatus(nic, rpc.Phase_Succeeded); err != nil {
+			log.Errorf("setNicStatus failed: %s %s %v", getNicKey(nic), rpc.Phase_Succeeded.String(), err)
+		}
+	}
+	return nic, nil
+}
+
+// UnholdHostNic lets the nic of vxnet be freed once no pod uses it
+func (a *Allocator) UnholdHostNic(vxnet string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.held[vxnet] {
+		log.Infof("unhold hostNic of vxnet %s", vxnet)
+		delete(a.held, vxnet)
+	}
+}
+
+// prepareHostNic returns the nic of vxnet whose network is set up, the nic is created and attached if
+// there is none. The phase of nic is left to the callers, it is set once it is used.
+// It is called with a.lock held, which is released while the nic is being attached.
+func (a *Allocator) prepareHostNic(ctx context.Context, vxnetName string) (*rpc.HostNic, error) {
+	// wait for the nic of vxnet being attached by others
+	for {
+		done, ok := a.attaching[vxnetName]
+		if !ok {
+			break
+		}
+		a.lock.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		a.lock.Lock()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("wait for nic of vxnet %s attaching failed: %v", vxnetName, ctx.Err())
+		}
+	}
+
 	if nic, ok := a.nics[vxnetName]; ok {
 		log.Infof("Find hostNic %s: %s", getNicKey(nic.Nic), nic.getPhase())
-		if nic.isOK() {
-			// just update Nic's pods
-			if err := a.addNicPod(nic.Nic, args); err != nil {
-				log.Errorf("addNicPod failed: %s %s %v", getNicKey(nic.Nic), getPodKey(args), err)
-			}
-			return nic.Nic, nil
-		} else {
+		if nic.isFailed() {
+			return nil, fmt.Errorf("hostNic %s failed to attach and is waiting to be released", getNicKey(nic.Nic))
+		}
+		if !nic.isOK() {
 			// create bridge and rule here
-			phase, err := networkutils.NetworkHelper.SetupNetwork(nic.Nic)
+			phase, err := a.setupNetwork(ctx, nic.Nic)
 			if err != nil {
 				if err := a.setNicStatus(nic.Nic, phase); err != nil {
 					log.Errorf("setNicStatus failed: %s %s %v", getNicKey(nic.Nic), phase.String(), err)
 				}
 				return nil, err
 			}
-			if err := a.addNicPod(nic.Nic, args); err != nil {
-				log.Errorf("addNicPod failed: %s %s %v", getNicKey(nic.Nic), getPodKey(args), err)
-			}
-			return nic.Nic, nil
 		}
+		return nic.Nic, nil
 	}
 
 	if a.canAlloc() <= 0 {
 		return nil, constants.ErrNoAvailableNIC
 	}
 
-	vxnet, err := a.getVxnets(vxnetName)
+	vxnet, err := a.getVxnets(ctx, vxnetName)
+	if err != nil {
+		return nil, err
+	}
+
+	// other pods on this node should not wait for the nic, so the lock is released
+	// while the nic is attached, and the vxnet is marked as attaching meanwhile
+	done := make(chan struct{})
+	a.attaching[vxnetName] = done
+	defer func() {
+		delete(a.attaching, vxnetName)
+		close(done)
+	}()
+	a.lock.Unlock()
+	nic, err := a.attachHostNic(ctx, vxnet)
+	a.lock.Lock()
 	if err != nil {
 		return nil, err
 	}
-	nics, _, err := qcclient.QClient.CreateNicsAndAttach(vxnet, 1, nil, 1)
+
+	nic.Reserved = true
+	nic.RouteTableNum, err = a.getNicRouteTableNum(nic)
+	if err != nil {
+		a.rollbackHostNic(nic)
+		return nil, fmt.Errorf("assign routetable for nic %s failed: %v", getNicKey(nic), err)
+	}
+
+	// create bridge and rule here
+	phase, err := a.setupNetwork(ctx, nic)
+	if err != nil {
+		if err := a.setNicStatus(nic, phase); err != nil {
+			log.Errorf("setNicStatus failed: %s %s %v", getNicKey(nic), phase.String(), err)
+		}
+		return nil, err
+	}
+
+	return nic, nil
+}
+
+// attachHostNic creates a nic in vxnet and waits for it to be attached, the nic is rolled back
+// if it is not attached in time. It is called without a.lock held.
+func (a *Allocator) attachHostNic(ctx context.Context, vxnet *rpc.VxNet) (*rpc.HostNic, error) {
+	nics, _, err := qcclient.WithContext(ctx, qcclient.QClient).CreateNicsAndAttach(vxnet, 1, nil, 1)
 	if err != nil {
 		return nil, fmt.Errorf("create and attach nic failed: %v", err)
 	}
 	log.Infof("create and attach nic %s", getNicKey(nics[0]))
 
 	//wait for nic attach
-	for {
-		link, err := networkutils.NetworkHelper.LinkByMacAddr(nics[0].HardwareAddr)
-		if err != nil && err != constants.ErrNicNotFound {
-			return nil, err
-		}
-		if link != nil {
-			break
-		}
-		time.Sleep(1 * time.Second)
+	waitCtx, cancel := ctx, func() {}
+	if _, ok := ctx.Deadline(); !ok {
+		waitCtx, cancel = context.WithTimeout(ctx, constants.NicAttachTimeout)
+	}
+	waitStart := time.Now()
+	_, waitSpan := tracing.Start(ctx, "link.Wait", attribute.String("mac", nics[0].HardwareAddr))
+	_, err = networkutils.NetworkHelper.WaitLinkByMacAddr(waitCtx, nics[0].HardwareAddr)
+	cancel()
+	instrument.ObserveSince(instrument.LinkWaitDuration, waitStart, instrument.Outcome(err))
+	tracing.End(waitSpan, err)
+	if err != nil {
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		a.rollbackHostNic(nics[0])
+		return nil, fmt.Errorf("wait for nic %s failed: %v", getNicKey(nics[0]), err)
 	}
 
 	log.Infof("attach nic %s success", getNicKey(nics[0]))
+	return nics[0], nil
+}
 
-	nics[0].Reserved = true
-	nics[0].RouteTableNum = a.getNicRouteTableNum(nics[0])
+// rollbackHostNic detaches and deletes a nic which failed to attach. The failed phase is recorded
+// first, so that the nic is released by ClearFreeHostnic later if the rollback fails too.
+// It is called with a.lock held, which is released while the nic is detached.
+func (a *Allocator) rollbackHostNic(nic *rpc.HostNic) {
+	nicKey := getNicKey(nic)
+	if err := a.setNicStatus(nic, rpc.Phase_AttachFailed); err != nil {
+		log.Errorf("setNicStatus failed: %s %s %v", nicKey, rpc.Phase_AttachFailed.String(), err)
+	}
 
-	// create bridge and rule here
-	phase, err := networkutils.NetworkHelper.SetupNetwork(nics[0])
+	a.lock.Unlock()
+	err := a.freeHostnic(nic)
+	a.lock.Lock()
 	if err != nil {
-		if err := a.setNicStatus(nics[0], phase); err != nil {
-			log.Errorf("setNicStatus failed: %s %s %v", getNicKey(nics[0]), phase.String(), err)
-		}
-		return nil, err
+		log.Errorf("rollback hostNic %s failed, it will be released later: %v", nicKey, err)
+		return
 	}
-
-	if err := a.addNicPod(nics[0], args); err != nil {
-		log.Errorf("addNicPod failed: %s %s %v", getNicKey(nics[0]), getPodKey(args), err)
+	if err := a.delNic(nic.VxNet.ID); err != nil {
+		log.Errorf("delNic failed: %s %v", nicKey, err)
 	}
+	log.Infof("rollback hostNic %s success", nicKey)
+}
 
-	return nics[0], nil
+func (a *Allocator) setupNetwork(ctx context.Context, nic *rpc.HostNic) (rpc.Phase, error) {
+	_, span := tracing.Start(ctx, "netlink.SetupNetwork", attribute.String("nic", nic.ID))
+	phase, err := networkutils.NetworkHelper.SetupNetwork(nic)
+	tracing.End(span, err)
+	return phase, err
 }
 
 func (a *Allocator) FreeHostNic(args *rpc.PodInfo, peek bool) (*rpc.HostNic, string, error) {
@@ -296,7 +531,10 @@ func (a *Allocator) HostNicCheck() {
 	defer a.lock.Unlock()
 
 	for _, nic := range a.nics {
-		nicKey := getNicKey(nic.Nic)
+		// failed nics are released instead of repaired
+		if nic.isFailed() {
+			continue
+		}
 
 		exists := true
 		_, err := networkutils.NetworkHelper.LinkByMacAddr(nic.Nic.ID)
@@ -305,39 +543,121 @@ func (a *Allocator) HostNicCheck() {
 		}
 
 		if !nic.isOK() || !exists {
-			log.Infof("hostNic %s status: %s , exists: %t, try to repair it", nicKey, nic.getPhase(), exists)
-			phase, err := networkutils.NetworkHelper.CheckAndRepairNetwork(nic.Nic)
-			if err := a.setNicStatus(nic.Nic, phase); err != nil {
-				log.Errorf("setNicStatus failed: %s %s %v", nicKey, phase.String(), err)
-			}
-			log.Infof("Repair hostNic %s: %s, %v", nicKey, nic.getPhase(), err)
+			log.Infof("hostNic %s status: %s , exists: %t, try to repair it", getNicKey(nic.Nic), nic.getPhase(), exists)
+			a.repairHostNic(nic)
 		}
 	}
 }
 
-func (a *Allocator) IPAddrReNew() {
+// RepairHostNics repairs the nics whose network disappeared right away, the periodic check is only a fallback
+func (a *Allocator) RepairHostNics(events []networkutils.NetworkEvent) {
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
 	for _, nic := range a.nics {
-		if nic != nil {
-			nicKey := getNicKey(nic.Nic)
-			if nic.isOK() && nic.Nic.VxNet.TunnelType == constants.TunnelTypeVlan {
-				brName := constants.GetHostNicBridgeName(int(nic.Nic.RouteTableNum))
-				// renew ip lease
-				err := networkutils.UpdateLinkIPAddrAndLease(nic.Nic)
-				if err != nil {
-					log.Errorf("renew hostNic %s bridge %s ip addr lease error: %v", nicKey, brName, err)
-				} else {
-					log.Infof("renew hostNic %s bridge %s ip addr lease success!", nicKey, brName)
+		if nic.isFailed() {
+			continue
+		}
+		brName := constants.GetHostNicBridgeName(int(nic.Nic.RouteTableNum))
+		for _, event := range events {
+			if event.RouteTable != 0 {
+				// routes of vlan nics are cleared by hostnic itself
+				if event.RouteTable != int(nic.Nic.RouteTableNum) || nic.Nic.VxNet.TunnelType == constants.TunnelTypeVlan {
+					continue
 				}
+			} else if event.HardwareAddr != nic.Nic.HardwareAddr && event.Name != brName {
+				continue
+			}
+
+			log.Infof("network of hostNic %s changed: %s, try to repair it", getNicKey(nic.Nic), event)
+			a.repairHostNic(nic)
+			break
+		}
+	}
+}
+
+func (a *Allocator) repairHostNic(nic *nicStatus) error {
+	nicKey := getNicKey(nic.Nic)
+	phase, err := networkutils.NetworkHelper.CheckAndRepairNetwork(nic.Nic)
+	if err := a.setNicStatus(nic.Nic, phase); err != nil {
+		log.Errorf("setNicStatus failed: %s %s %v", nicKey, phase.String(), err)
+	}
+	log.Infof("Repair hostNic %s: %s, %v", nicKey, nic.getPhase(), err)
+	return err
+}
+
+// CheckNetwork diffs the policy routing state of node against the nics and pods recorded in leveldb.
+// Unless dryRun, orphans are removed and nics missing part of their network are repaired.
+func (a *Allocator) CheckNetwork(dryRun bool) ([]*rpc.NetworkDrift, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	state := networkutils.NetworkState{
+		RouteTableBase: a.conf.RouteTableBase,
+		RouteTableSize: a.conf.RouteTableSize,
+	}
+	for _, nic := range a.nics {
+		// network is never set up for failed nics
+		if nic.isFailed() {
+			continue
+		}
+		state.Nics = append(state.Nics, nic.Nic)
+		for _, pod := range nic.Pods {
+			if pod.PodIP != "" {
+				state.PodIPs = append(state.PodIPs, pod.PodIP)
 			}
 		}
 	}
+
+	drifts, err := networkutils.NetworkHelper.DiffNetwork(state, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dryRun {
+		repaired := make(map[string]error)
+		for _, drift := range drifts {
+			nic, ok := a.nics[drift.VxNet]
+			if !ok {
+				continue
+			}
+			err, ok := repaired[drift.VxNet]
+			if !ok {
+				log.Infof("hostNic %s has drift %s %s, try to repair it", getNicKey(nic.Nic), drift.Kind, drift.Object)
+				err = a.repairHostNic(nic)
+				repaired[drift.VxNet] = err
+			}
+			if err != nil {
+				drift.Error = err.Error()
+			} else {
+				drift.Repaired = true
+			}
+		}
+	}
+
+	instrument.NetworkDrifts.Reset()
+	for _, drift := range drifts {
+		instrument.NetworkDrifts.WithLabelValues(drift.Kind, strconv.FormatBool(drift.Repaired)).Inc()
+	}
+	return drifts, nil
 }
 
 func (a *Allocator) Start(stopCh <-chan struct{}) error {
-	go a.run(stopCh)
+	if err := networkutils.Leases.Start(stopCh); err != nil {
+		return fmt.Errorf("restore dhcp leases failed: %v", err)
+	}
+	go a.run(stopCh, networkutils.NetworkHelper.WatchNetwork(stopCh))
+	return nil
+}
+
+// GetHostNic returns the nic of vxnet if its network is set up, or nil
+func (a *Allocator) GetHostNic(vxnet string) *rpc.HostNic {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if nic, ok := a.nics[vxnet]; ok && nic.isOK() {
+		return nic.Nic
+	}
 	return nil
 }
 
@@ -345,10 +665,27 @@ func (a *Allocator) GetNics() map[string]*nicStatus {
 	return a.nics
 }
 
+// GetPods returns the pods recorded on the nics of this node
+func (a *Allocator) GetPods() []*rpc.PodInfo {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	var pods []*rpc.PodInfo
+	for _, nic := range a.nics {
+		for _, pod := range nic.Pods {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
 func (a *Allocator) freeHostnic(nic *rpc.HostNic) error {
-	if err := networkutils.NetworkHelper.CleanupNetwork(nic); err != nil {
-		log.Errorf("CleanupNetwork for vxnet %s failed: nic %s %v", nic.VxNet.ID, nic.ID, err)
-		return err
+	// network is never set up for nics failed to attach
+	if nic.Phase != rpc.Phase_AttachFailed {
+		if err := networkutils.NetworkHelper.CleanupNetwork(nic); err != nil {
+			log.Errorf("CleanupNetwork for vxnet %s failed: nic %s %v", nic.VxNet.ID, nic.ID, err)
+			return err
+		}
 	}
 
 	if _, err := qcclient.QClient.DeattachNics([]string{nic.ID}, true); err != nil {
@@ -386,7 +723,11 @@ func (a *Allocator) ClearFreeHostnic(force bool) error {
 	}()
 
 	for vxnet, status := range a.nics {
-		if len(status.Pods) == 0 || force {
+		// nics failed to attach are being rolled back
+		if _, ok := a.attaching[vxnet]; ok {
+			continue
+		}
+		if (len(status.Pods) == 0 && !a.held[vxnet]) || force {
 			nicKey := getNicKey(status.Nic)
 			if len(status.Pods) == 0 {
 				log.Infof("vxnet %s has no pod left on this node, going to clear free Hostnic %s", vxnet, nicKey)
@@ -397,7 +738,10 @@ func (a *Allocator) ClearFreeHostnic(force bool) error {
 
 			if err := a.freeHostnic(status.Nic); err != nil {
 				log.Errorf("freeHostnic for vxnet %s failed: nic %s %v", vxnet, status.Nic.ID, err)
-				// set status to init to repair nics which free failed
+				// set status to init to repair nics which free failed, failed nics are released again next time
+				if status.isFailed() {
+					continue
+				}
 				if err := a.setNicStatus(status.Nic, rpc.Phase_Init); err != nil {
 					log.Errorf("setNicStatus failed: %s %s %v", nicKey, rpc.Phase_Init.String(), err)
 				}
@@ -428,24 +772,46 @@ func (a *Allocator) getVxnetMaxNicNum() int {
 	return maxNicsCount
 }
 
-func (a *Allocator) run(stopCh <-chan struct{}) {
+func (a *Allocator) run(stopCh <-chan struct{}, events <-chan networkutils.NetworkEvent) {
 	jobTimer := time.NewTicker(time.Duration(a.conf.Sync) * time.Second).C
 	freeTimer := time.NewTicker(time.Duration(a.conf.FreePeriod) * time.Minute).C
+	var networkCheckTimer <-chan time.Time
+	if a.conf.NetworkCheckPeriod > 0 {
+		networkCheckTimer = time.NewTicker(time.Duration(a.conf.NetworkCheckPeriod) * time.Second).C
+	}
+
+	// a nic usually loses its link, bridge, routes and rules at once, repair it once for them
+	var pending []networkutils.NetworkEvent
+	var repairTimer <-chan time.Time
 
 	for {
 		select {
 		case <-stopCh:
 			log.Info("stoped allocator")
 			return
+		case event := <-events:
+			if pending == nil {
+				repairTimer = time.After(constants.NicRepairDelay)
+			}
+			pending = append(pending, event)
+		case <-repairTimer:
+			a.RepairHostNics(pending)
+			pending, repairTimer = nil, nil
 		case <-jobTimer:
 			log.Infof("period job sync")
 			a.HostNicCheck()
 		case <-freeTimer:
 			log.Infof("period free sync")
 			a.ClearFreeHostnic(false)
-		case <-constants.IpAddrReNewTicker.C:
-			log.Infof("ip addr renew sync")
-			a.IPAddrReNew()
+		case <-networkCheckTimer:
+			log.Infof("period network check")
+			drifts, err := a.CheckNetwork(!a.conf.NetworkCheckRepair)
+			if err != nil {
+				log.Errorf("network check failed: %v", err)
+			}
+			for _, drift := range drifts {
+				log.Infof("network drift: %s %s vxnet=%s repaired=%t %s", drift.Kind, drift.Object, drift.VxNet, drift.Repaired, drift.Error)
+			}
 		}
 	}
 }
@@ -456,13 +822,21 @@ var (
 
 func SetupAllocator(conf conf.PoolConf) {
 	Alloc = &Allocator{
-		nics: make(map[string]*nicStatus),
-		conf: conf,
+		nics:      make(map[string]*nicStatus),
+		conf:      conf,
+		held:      make(map[string]bool),
+		attaching: make(map[string]chan struct{}),
 	}
 
 	err := db.Iterator(func(value interface{}) error {
 		var nic nicStatus
 		json.Unmarshal(value.([]byte), &nic)
+		// pods were keyed by container only before secondary networks
+		pods := make(map[string]*rpc.PodInfo, len(nic.Pods))
+		for _, pod := range nic.Pods {
+			pods[getContainterKey(pod)] = pod
+		}
+		nic.Pods = pods
 		Alloc.nics[nic.Nic.VxNet.ID] = &nic
 		return nil
 	})
@@ -470,6 +844,10 @@ func SetupAllocator(conf conf.PoolConf) {
 		log.Fatalf("Failed to restore allocator from leveldb: %v", err)
 	}
 
+	if err := Alloc.syncRouteTables(); err != nil {
+		log.Fatalf("Failed to sync routetable records: %v", err)
+	}
+
 	// restore create nics
 	nics, err := qcclient.QClient.GetCreatedNicsByName(constants.NicPrefix + qcclient.QClient.GetInstanceID())
 	if err != nil {
@@ -486,8 +864,13 @@ func SetupAllocator(conf conf.PoolConf) {
 	}
 }
 
+// getContainterKey returns the key of pod records, a pod may have several attachments in secondary networks
 func getContainterKey(info *rpc.PodInfo) string {
-	return info.Containter
+	ifName := info.IfName
+	if ifName == "" {
+		ifName = constants.DefaultPodIfName
+	}
+	return info.Containter + "/" + ifName
 }
 
 func getPodKey(info *rpc.PodInfo) string {
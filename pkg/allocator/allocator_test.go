@@ -0,0 +1,105 @@
+package allocator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+
+	"github.com/yunify/hostnic-cni/pkg/conf"
+	"github.com/yunify/hostnic-cni/pkg/db"
+	"github.com/yunify/hostnic-cni/pkg/networkutils"
+	"github.com/yunify/hostnic-cni/pkg/qcclient"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+)
+
+// fakeQingCloud creates nics which never show up, and blocks DeattachNics until release is closed
+type fakeQingCloud struct {
+	qcclient.QingCloudAPI
+	detaching chan struct{}
+	release   chan struct{}
+}
+
+func (f *fakeQingCloud) GetVxNets(ids []string, customReservedIPCount int64) (map[string]*rpc.VxNet, error) {
+	return map[string]*rpc.VxNet{ids[0]: {ID: ids[0]}}, nil
+}
+
+func (f *fakeQingCloud) CreateNicsAndAttach(vxnet *rpc.VxNet, num int, ips []string, disableIP int) ([]*rpc.HostNic, string, error) {
+	return []*rpc.HostNic{{ID: "52:54:00:00:00:01", VxNet: vxnet, HardwareAddr: "52:54:00:00:00:01"}}, "j-1", nil
+}
+
+func (f *fakeQingCloud) DeattachNics(nicIDs []string, sync bool) (string, error) {
+	close(f.detaching)
+	<-f.release
+	return "j-2", nil
+}
+
+func (f *fakeQingCloud) DeleteNics(nicIDs []string) error {
+	return nil
+}
+
+func TestAllocHostNicAttachTimeout(t *testing.T) {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.LevelDB = ldb
+	defer ldb.Close()
+	qc := &fakeQingCloud{detaching: make(chan struct{}), release: make(chan struct{})}
+	qcclient.QClient = qc
+	networkutils.NetworkHelper = networkutils.NetworkUtilsFake{}
+
+	a := &Allocator{
+		nics:      make(map[string]*nicStatus),
+		conf:      conf.PoolConf{MaxNic: 2, RouteTableBase: 260, RouteTableSize: 2},
+		held:      make(map[string]bool),
+		attaching: make(map[string]chan struct{}),
+	}
+	pod := &rpc.PodInfo{Namespace: "default", Name: "web", Containter: "c1", IfName: "eth0", VxNet: "vxnet-a"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := a.AllocHostNic(ctx, pod)
+		errCh <- err
+	}()
+
+	select {
+	case <-qc.detaching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nic not attached in time should be rolled back")
+	}
+
+	// the lock is not held during rollback
+	served := make(chan bool, 1)
+	go func() {
+		served <- a.CanServe("vxnet-a")
+	}()
+	select {
+	case ok := <-served:
+		if ok {
+			t.Errorf("vxnet whose nic failed to attach should not be served")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("allocator is locked during rollback")
+	}
+	a.lock.RLock()
+	if status, ok := a.nics["vxnet-a"]; !ok || status.Nic.Phase != rpc.Phase_AttachFailed {
+		t.Errorf("nic should be recorded as %s during rollback", rpc.Phase_AttachFailed)
+	}
+	a.lock.RUnlock()
+
+	close(qc.release)
+	if err := <-errCh; err == nil {
+		t.Fatal("AllocHostNic should fail once the nic is not attached in time")
+	}
+	if len(a.nics) != 0 || len(a.attaching) != 0 {
+		t.Errorf("nic rolled back should be removed, got nics %v attaching %v", a.nics, a.attaching)
+	}
+	if a.canAlloc() != 2 {
+		t.Errorf("nic rolled back should not be counted, got %d left", a.canAlloc())
+	}
+}
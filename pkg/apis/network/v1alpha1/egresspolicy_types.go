@@ -0,0 +1,137 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"net"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	ResourceKindEgressPolicy     = "EgressPolicy"
+	ResourceSingularEgressPolicy = "egresspolicy"
+	ResourcePluralEgressPolicy   = "egresspolicies"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+
+// EgressPolicy makes the off-cluster traffic of selected pods leave from an egress ip
+type EgressPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec EgressPolicySpec `json:"spec"`
+	// +optional
+	Status EgressPolicyStatus `json:"status"`
+}
+
+// EgressPolicySpec is the spec for a EgressPolicy resource
+type EgressPolicySpec struct {
+	// EgressIP is the source ip of the traffic, usually one of the VIPs of the vxnet.
+	// It is claimed from the ippool of the vxnet so that no pod gets it.
+	EgressIP string `json:"egressIP"`
+
+	// VxNet the egress ip belongs to
+	VxNet string `json:"vxnet"`
+
+	// NodeName is the node whose hostnic in the vxnet carries the egress ip
+	NodeName string `json:"nodeName"`
+
+	// NamespaceSelector selects the namespaces of pods. Defaults to all namespaces
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// PodSelector selects the pods in the namespaces. Defaults to all pods
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+}
+
+// EgressPolicyStatus is the status for a EgressPolicy resource, it is reported by the egress node
+type EgressPolicyStatus struct {
+	// Ready is true when the egress ip is set up on the egress node
+	// +optional
+	Ready bool `json:"ready"`
+	// Message describes what the egress ip is waiting for, empty when it is ready
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +genclient:nonNamespaced
+
+// EgressPolicyList is a list of EgressPolicy resources
+type EgressPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []EgressPolicy `json:"items"`
+}
+
+// Validate checks the spec without looking at other objects
+func (p *EgressPolicy) Validate() error {
+	if ip := net.ParseIP(p.Spec.EgressIP); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("egressIP %q is not a valid ipv4 address", p.Spec.EgressIP)
+	}
+	if p.Spec.VxNet == "" {
+		return fmt.Errorf("vxnet must not be empty")
+	}
+	if p.Spec.NodeName == "" {
+		return fmt.Errorf("nodeName must not be empty")
+	}
+	if p.Spec.NamespaceSelector == nil && p.Spec.PodSelector == nil {
+		return fmt.Errorf("one of namespaceSelector and podSelector must be set")
+	}
+
+	for _, selector := range []*metav1.LabelSelector{p.Spec.NamespaceSelector, p.Spec.PodSelector} {
+		if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+			return fmt.Errorf("invalid selector: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Selects returns true if the pod with podLabels in the namespace with nsLabels is selected
+func (p *EgressPolicy) Selects(nsLabels, podLabels map[string]string) bool {
+	if p.Spec.NamespaceSelector == nil && p.Spec.PodSelector == nil {
+		return false
+	}
+	for _, s := range []struct {
+		selector *metav1.LabelSelector
+		labels   map[string]string
+	}{
+		{p.Spec.NamespaceSelector, nsLabels},
+		{p.Spec.PodSelector, podLabels},
+	} {
+		if s.selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(s.selector)
+		if err != nil || !selector.Matches(labels.Set(s.labels)) {
+			return false
+		}
+	}
+	return true
+}
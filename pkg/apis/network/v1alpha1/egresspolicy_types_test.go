@@ -0,0 +1,85 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEgressPolicyValidate(t *testing.T) {
+	policy := &EgressPolicy{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "team-a",
+		},
+		Spec: EgressPolicySpec{
+			EgressIP: "172.22.13.200",
+			VxNet:    "vxnet-aaaaaaa",
+			NodeName: "node1",
+			NamespaceSelector: &v1.LabelSelector{
+				MatchLabels: map[string]string{"team": "a"},
+			},
+		},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("valid policy rejected: %v", err)
+	}
+
+	for _, ip := range []string{"", "172.22.13", "fd00::1"} {
+		invalid := policy.DeepCopy()
+		invalid.Spec.EgressIP = ip
+		if invalid.Validate() == nil {
+			t.Errorf("egressIP %q should be rejected", ip)
+		}
+	}
+
+	invalid := policy.DeepCopy()
+	invalid.Spec.NodeName = ""
+	if invalid.Validate() == nil {
+		t.Error("empty nodeName should be rejected")
+	}
+
+	invalid = policy.DeepCopy()
+	invalid.Spec.NamespaceSelector = nil
+	if invalid.Validate() == nil {
+		t.Error("policy without selectors should be rejected")
+	}
+
+	invalid = policy.DeepCopy()
+	invalid.Spec.PodSelector = &v1.LabelSelector{
+		MatchExpressions: []v1.LabelSelectorRequirement{{Key: "app", Operator: "Unknown"}},
+	}
+	if invalid.Validate() == nil {
+		t.Error("invalid podSelector should be rejected")
+	}
+}
+
+func TestEgressPolicySelects(t *testing.T) {
+	policy := &EgressPolicy{
+		Spec: EgressPolicySpec{
+			NamespaceSelector: &v1.LabelSelector{
+				MatchLabels: map[string]string{"team": "a"},
+			},
+		},
+	}
+	if !policy.Selects(map[string]string{"team": "a"}, nil) {
+		t.Error("pods in selected namespace should be selected")
+	}
+	if policy.Selects(map[string]string{"team": "b"}, nil) {
+		t.Error("pods in other namespaces should not be selected")
+	}
+
+	policy.Spec.PodSelector = &v1.LabelSelector{
+		MatchLabels: map[string]string{"app": "billing"},
+	}
+	if policy.Selects(map[string]string{"team": "a"}, map[string]string{"app": "web"}) {
+		t.Error("pods not matching podSelector should not be selected")
+	}
+	if !policy.Selects(map[string]string{"team": "a"}, map[string]string{"app": "billing"}) {
+		t.Error("pods matching both selectors should be selected")
+	}
+
+	policy.Spec = EgressPolicySpec{}
+	if policy.Selects(nil, nil) {
+		t.Error("policy without selectors should select nothing")
+	}
+}
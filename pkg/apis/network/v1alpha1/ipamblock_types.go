@@ -44,6 +44,9 @@ const (
 
 	ReservedHandle = "kubesphere-reserved-handle"
 	ReservedNote   = "kubesphere reserved"
+
+	// IPAMBlockAffinityHost is the prefix of the affinity of blocks claimed by nodes
+	IPAMBlockAffinityHost = "host:"
 )
 
 // +genclient
@@ -67,6 +70,9 @@ type IPAMBlockSpec struct {
 	Unallocated []int                 `json:"unallocated"`
 	Attributes  []AllocationAttribute `json:"attributes"`
 	Deleted     bool                  `json:"deleted"`
+	// Affinity is "host:<node>" when the block is claimed by a node, which prefers it
+	// to the other blocks. Other nodes only borrow from it when their own blocks are full.
+	Affinity string `json:"affinity,omitempty"`
 }
 
 type AllocationAttribute struct {
@@ -106,10 +112,15 @@ func (b *IPAMBlock) AutoAssign(
 		ipNets.IP = cnet.IncrementIP(*ip, big.NewInt(int64(o))).IP
 		ips = append(ips, ipNets)
 
-		// add ip to attrs
-		attrs[IPAMBlockAttributeIP] = ipNets.IP.String()
+		// add ip to a copy of attrs, the attribute of each ordinal must not share the map,
+		// or ReleaseByIP of one ordinal drops the attribute of the others
+		ordinalAttrs := make(map[string]string, len(attrs)+1)
+		for k, v := range attrs {
+			ordinalAttrs[k] = v
+		}
+		ordinalAttrs[IPAMBlockAttributeIP] = ipNets.IP.String()
 
-		attrIndex := b.findOrAddAttribute(handleID, attrs)
+		attrIndex := b.findOrAddAttribute(handleID, ordinalAttrs)
 		b.Spec.Allocations[o] = &attrIndex
 	}
 
@@ -165,6 +176,22 @@ func (b *IPAMBlock) Empty() bool {
 	return b.containsOnlyReservedIPs()
 }
 
+// AffinityNode returns the node which claimed the block, empty if none
+func (b *IPAMBlock) AffinityNode() string {
+	if !strings.HasPrefix(b.Spec.Affinity, IPAMBlockAffinityHost) {
+		return ""
+	}
+	return strings.TrimPrefix(b.Spec.Affinity, IPAMBlockAffinityHost)
+}
+
+func (b *IPAMBlock) SetAffinityNode(node string) {
+	if node == "" {
+		b.Spec.Affinity = ""
+		return
+	}
+	b.Spec.Affinity = IPAMBlockAffinityHost + node
+}
+
 func (b *IPAMBlock) MarkDeleted() {
 	b.Spec.Deleted = true
 }
@@ -283,9 +310,12 @@ func (b *IPAMBlock) ReleaseByIP(ip string) error {
 		// Release this ordinal.
 		ordinals = append(ordinals, ordinal)
 
-		attrIndexes := []int{*b.Spec.Allocations[ordinal]}
-		// Clean and reorder attributes.
-		b.deleteAttributes(attrIndexes, ordinals)
+		// Clean and reorder attributes, unless the attribute is still used by other ordinals,
+		// like the reserved ones.
+		attrIndex := *b.Spec.Allocations[ordinal]
+		if !b.attributeInUse(attrIndex, ordinal) {
+			b.deleteAttributes([]int{attrIndex}, ordinals)
+		}
 	}
 
 	// Release the addresses.
@@ -296,6 +326,16 @@ func (b *IPAMBlock) ReleaseByIP(ip string) error {
 	return nil
 }
 
+// attributeInUse returns true if any allocated ordinal other than except refers to the attribute
+func (b *IPAMBlock) attributeInUse(attrIndex, except int) bool {
+	for o, idx := range b.Spec.Allocations {
+		if o != except && idx != nil && *idx == attrIndex {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *IPAMBlock) GetHandleOrdinals(handleID string) []int {
 	attrIndexes := b.attributeIndexesByHandle(handleID)
 	if len(attrIndexes) == 0 {
@@ -330,6 +370,62 @@ func (b *IPAMBlock) findOrAddAttribute(handleID string, attrs map[string]string)
 	return attrIndex
 }
 
+// Validate checks the bookkeeping of Allocations, Unallocated and Attributes, a block failing it
+// must not be written back, since it is what GetBrokenBlocks reports later.
+func (b *IPAMBlock) Validate() error {
+	if _, _, err := cnet.ParseCIDR(b.Spec.CIDR); err != nil {
+		return fmt.Errorf("block %s has invalid cidr: %v", b.Name, err)
+	}
+	num := b.NumAddresses()
+	if len(b.Spec.Allocations) != num {
+		return fmt.Errorf("block %s has %d allocations, but %d addresses", b.Name, len(b.Spec.Allocations), num)
+	}
+
+	unallocated := make(map[int]struct{}, len(b.Spec.Unallocated))
+	for _, o := range b.Spec.Unallocated {
+		if o < 0 || o >= num {
+			return fmt.Errorf("block %s has unallocated ordinal %d out of range", b.Name, o)
+		}
+		if _, ok := unallocated[o]; ok {
+			return fmt.Errorf("block %s has duplicate unallocated ordinal %d", b.Name, o)
+		}
+		if b.Spec.Allocations[o] != nil {
+			return fmt.Errorf("block %s has ordinal %d both allocated and unallocated", b.Name, o)
+		}
+		unallocated[o] = struct{}{}
+	}
+
+	allocated := 0
+	for o, attrIdx := range b.Spec.Allocations {
+		if attrIdx == nil {
+			continue
+		}
+		if *attrIdx < 0 || *attrIdx >= len(b.Spec.Attributes) {
+			return fmt.Errorf("block %s has ordinal %d pointing at attribute %d, but only %d attributes", b.Name, o, *attrIdx, len(b.Spec.Attributes))
+		}
+		allocated++
+	}
+	// ordinals neither allocated nor unallocated are leaked
+	if allocated+len(unallocated) != num {
+		return fmt.Errorf("block %s has %d allocated and %d unallocated ordinals, but %d addresses", b.Name, allocated, len(unallocated), num)
+	}
+
+	return nil
+}
+
+// HandleCounts returns the number of allocated ordinals of every handle, which is
+// what IPAMHandle records for the block.
+func (b *IPAMBlock) HandleCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, attrIdx := range b.Spec.Allocations {
+		if attrIdx == nil || *attrIdx < 0 || *attrIdx >= len(b.Spec.Attributes) {
+			continue
+		}
+		counts[b.Spec.Attributes[*attrIdx].AttrPrimary]++
+	}
+	return counts
+}
+
 func intInSlice(searchInt int, slice []int) bool {
 	for _, v := range slice {
 		if v == searchInt {
@@ -84,3 +84,161 @@ func TestIPAMBlock(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func newTestBlock() *IPAMBlock {
+	pool := &IPPool{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "testippool",
+		},
+	}
+	_, cidr, _ := cnet.ParseCIDR("192.168.0.0/27")
+	block := NewBlock(pool, *cidr, &ReservedAttr{
+		StartOfBlock: 2,
+		EndOfBlock:   1,
+		Handle:       ReservedHandle,
+		Note:         ReservedNote,
+	})
+	return block
+}
+
+// checkBlockInvariants checks the block against Validate, and that handle counts match the block.
+func checkBlockInvariants(t *testing.T, block *IPAMBlock, handles map[string]*IPAMHandle) {
+	t.Helper()
+
+	if err := block.Validate(); err != nil {
+		t.Fatalf("invalid block: %v", err)
+	}
+	if block.NumReservedAddresses() != 3 {
+		t.Fatalf("reserved addresses changed to %d", block.NumReservedAddresses())
+	}
+
+	counts := block.HandleCounts()
+	for handleID, handle := range handles {
+		if counts[handleID] != handle.Spec.Block[block.String()] {
+			t.Fatalf("handle %s records %d addresses, but block has %d", handleID, handle.Spec.Block[block.String()], counts[handleID])
+		}
+	}
+	for handleID, count := range counts {
+		if _, ok := handles[handleID]; !ok && handleID != ReservedHandle {
+			t.Fatalf("block has %d addresses of unknown handle %s", count, handleID)
+		}
+	}
+}
+
+func TestIPAMBlockValidate(t *testing.T) {
+	block := newTestBlock()
+	if err := block.Validate(); err != nil {
+		t.Fatalf("new block is invalid: %v", err)
+	}
+
+	broken := block.DeepCopy()
+	attrIdx := 0
+	broken.Spec.Allocations[broken.Spec.Unallocated[0]] = &attrIdx
+	if broken.Validate() == nil {
+		t.Error("ordinal both allocated and unallocated is not detected")
+	}
+
+	broken = block.DeepCopy()
+	attrIdx = len(broken.Spec.Attributes)
+	broken.Spec.Allocations[0] = &attrIdx
+	if broken.Validate() == nil {
+		t.Error("allocation pointing at missing attribute is not detected")
+	}
+
+	broken = block.DeepCopy()
+	broken.Spec.Unallocated = append(broken.Spec.Unallocated, broken.Spec.Unallocated[0])
+	if broken.Validate() == nil {
+		t.Error("duplicate unallocated ordinal is not detected")
+	}
+
+	broken = block.DeepCopy()
+	broken.Spec.Allocations = broken.Spec.Allocations[1:]
+	if broken.Validate() == nil {
+		t.Error("short allocations is not detected")
+	}
+
+	broken = block.DeepCopy()
+	broken.Spec.Unallocated = broken.Spec.Unallocated[1:]
+	if broken.Validate() == nil {
+		t.Error("leaked ordinal is not detected")
+	}
+}
+
+func TestIPAMBlockReleaseReservedIP(t *testing.T) {
+	block := newTestBlock()
+	// the reserved addresses share one attribute, releasing one of them must keep it for the others
+	if err := block.ReleaseByIP("192.168.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := block.Validate(); err != nil {
+		t.Fatalf("invalid block: %v", err)
+	}
+	if block.NumReservedAddresses() != 2 {
+		t.Fatalf("expect 2 reserved addresses, but got %d", block.NumReservedAddresses())
+	}
+	if len(block.Spec.Unallocated)+2 != block.NumAddresses() {
+		t.Fatalf("ordinals leaked after releasing reserved ip")
+	}
+}
+
+// FuzzIPAMBlock applies a sequence of assign and release operations decoded from data to a block,
+// the same way IPAMClient does with the block and handles, and checks the invariants after each one.
+func FuzzIPAMBlock(f *testing.F) {
+	f.Add([]byte{0x00, 0x01, 0x10, 0x21})
+	f.Add([]byte{0x03, 0x13, 0x23, 0x02, 0x32, 0x12})
+	f.Add([]byte{0x0f, 0x21, 0x22, 0x23, 0x11, 0x0f, 0x30})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		block := newTestBlock()
+		handles := map[string]*IPAMHandle{}
+		handleIDs := []string{"h0", "h1", "h2", "h3"}
+
+		handleOf := func(handleID string) *IPAMHandle {
+			h, ok := handles[handleID]
+			if !ok {
+				h = &IPAMHandle{Spec: IPAMHandleSpec{HandleID: handleID, Block: map[string]int{}}}
+				handles[handleID] = h
+			}
+			return h
+		}
+
+		for _, op := range data {
+			handleID := handleIDs[op&0x3]
+			arg := int(op>>2) & 0x3
+			switch op >> 4 & 0x3 {
+			case 0:
+				ips := block.AutoAssign(arg+1, handleID, map[string]string{IPAMBlockAttributePod: handleID})
+				if len(ips) > 0 {
+					handleOf(handleID).IncrementBlock(block, len(ips))
+				}
+			case 1:
+				if num := block.ReleaseByHandle(handleID); num > 0 {
+					if _, err := handleOf(handleID).DecrementBlock(block, num); err != nil {
+						t.Fatal(err)
+					}
+				}
+			case 2:
+				ordinals := block.GetHandleOrdinals(handleID)
+				if len(ordinals) == 0 {
+					break
+				}
+				ip, _ := block.OrdinalToIP(ordinals[arg%len(ordinals)])
+				if err := block.ReleaseByIP(ip.String()); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := handleOf(handleID).DecrementBlock(block, 1); err != nil {
+					t.Fatal(err)
+				}
+			case 3:
+				// releasing an ip not allocated changes nothing
+				ip, _ := block.OrdinalToIP(block.NumAddresses() - 2 - arg)
+				if block.Spec.Allocations[block.NumAddresses()-2-arg] == nil {
+					if err := block.ReleaseByIP(ip.String()); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			checkBlockInvariants(t, block, handles)
+		}
+	})
+}
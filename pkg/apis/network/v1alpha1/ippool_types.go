@@ -39,6 +39,11 @@ const (
 	IPPoolTypeNone   = "none"
 	IPPoolTypeLocal  = "local"
 	IPPoolTypeCalico = "calico"
+
+	// condition types of IPPool
+	IPPoolConditionSynced    = "Synced"
+	IPPoolConditionExhausted = "Exhausted"
+	IPPoolConditionDisabled  = "Disabled"
 )
 
 // +genclient
@@ -86,6 +91,10 @@ type IPPoolStatus struct {
 	Reserved    int                        `json:"reserved,omitempty"`
 	Synced      bool                       `json:"synced,omitempty"`
 	Workspaces  map[string]WorkspaceStatus `json:"workspaces,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 type IPPoolSpec struct {
@@ -44,6 +44,7 @@ func init() {
 	SchemeBuilder.Register(&IPAMBlock{}, &IPAMBlockList{})
 	SchemeBuilder.Register(&IPPool{}, &IPPoolList{})
 	SchemeBuilder.Register(&VxNetPool{}, &VxNetPoolList{})
+	SchemeBuilder.Register(&EgressPolicy{}, &EgressPolicyList{})
 }
 
 // Resource is required by pkg/client/listers/...
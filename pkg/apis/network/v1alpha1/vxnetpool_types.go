@@ -17,9 +17,45 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"fmt"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	ResourceKindVxNetPool     = "VxNetPool"
+	ResourceSingularVxNetPool = "vxnetpool"
+	ResourcePluralVxNetPool   = "vxnetpools"
+
+	// VxNetPoolNameLabel marks the ippools generated from a vxnetpool
+	VxNetPoolNameLabel = "vxnetpool.network.qingcloud.com/name"
+
+	// condition types of VxNetPool, each of them is a step to make the pool ready
+	VxNetPoolConditionVxNetsSynced       = "VxNetsSynced"
+	VxNetPoolConditionVIPsReady          = "VIPsReady"
+	VxNetPoolConditionSecurityGroupReady = "SecurityGroupReady"
+	VxNetPoolConditionIPPoolsCreated     = "IPPoolsCreated"
+	VxNetPoolConditionBlocksGenerated    = "BlocksGenerated"
+
+	// VxNetPoolConditionResourcesReleased reports the progress of releasing resources when the pool is deleted
+	VxNetPoolConditionResourcesReleased = "ResourcesReleased"
+
+	VxNetPoolFinalizer = "finalizers.network.qingcloud.com/vxnetpool"
+
+	// bounds of VxNetPool blockSize, a block smaller than /30 has no room for pods
+	VxNetPoolMinBlockSize = 16
+	VxNetPoolMaxBlockSize = 30
+)
+
+// VxNetPoolConditions lists the condition types of VxNetPool in the order they are handled
+var VxNetPoolConditions = []string{
+	VxNetPoolConditionVxNetsSynced,
+	VxNetPoolConditionVIPsReady,
+	VxNetPoolConditionSecurityGroupReady,
+	VxNetPoolConditionIPPoolsCreated,
+	VxNetPoolConditionBlocksGenerated,
+}
+
 // +genclient
 // +genclient:nonNamespaced
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -52,12 +88,29 @@ type VxNetPoolSpec struct {
 	// CustomReservedIPCount used to specify user custom reserved ip count. Defaults to 0
 	// +optional
 	CustomReservedIPCount int64 `json:"customReservedIPCount,omitempty"`
+
+	// SecurityGroup the vxnets are added to. Defaults to the security group of the cluster
+	// +optional
+	SecurityGroup string `json:"securityGroup,omitempty"`
+
+	// NamespaceSelector selects the namespaces whose subnets are auto assigned from this pool.
+	// Namespaces not selected by any pool are assigned from the default pool v-pool.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// NodeSelector selects the nodes which could allocate from the vxnets of this pool
+	// when the pod's namespace has no subnet assigned. Defaults to all nodes
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
 }
 
 type PoolInfo struct {
 	Name    string   `json:"name"`
 	IPPool  string   `json:"ippool"`
 	Subnets []string `json:"subnets,omitempty"`
+	// Message describes what the vxnet is waiting for, empty when it is ready
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // VxNetPoolStatus is the status for a VxNetPool resource
@@ -70,6 +123,15 @@ type VxNetPoolStatus struct {
 	Process *string `json:"process,omitempty"`
 	// +optional
 	Pools []PoolInfo `json:"pools,omitempty"`
+	// Conditions of the steps to prepare the vxnets
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Jobs are the QingCloud jobs releasing the resources of the pool, keyed by the resource.
+	// They are polled until finished instead of being submitted again.
+	// +optional
+	Jobs map[string]string `json:"jobs,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -82,3 +144,43 @@ type VxNetPoolList struct {
 
 	Items []VxNetPool `json:"items"`
 }
+
+// HasVxNet returns true if the vxnet is in this pool
+func (p *VxNetPool) HasVxNet(vxnet string) bool {
+	for _, v := range p.Spec.Vxnets {
+		if v.Name == vxnet {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the spec without looking at other objects
+func (p *VxNetPool) Validate() error {
+	if p.Spec.BlockSize < VxNetPoolMinBlockSize || p.Spec.BlockSize > VxNetPoolMaxBlockSize {
+		return fmt.Errorf("blockSize must be between %d and %d", VxNetPoolMinBlockSize, VxNetPoolMaxBlockSize)
+	}
+
+	if p.Spec.CustomReservedIPCount < 0 {
+		return fmt.Errorf("customReservedIPCount must not be negative")
+	}
+
+	vxnets := make(map[string]struct{}, len(p.Spec.Vxnets))
+	for _, v := range p.Spec.Vxnets {
+		if v.Name == "" {
+			return fmt.Errorf("vxnet name must not be empty")
+		}
+		if _, ok := vxnets[v.Name]; ok {
+			return fmt.Errorf("vxnet %s is duplicated", v.Name)
+		}
+		vxnets[v.Name] = struct{}{}
+	}
+
+	for _, selector := range []*metav1.LabelSelector{p.Spec.NamespaceSelector, p.Spec.NodeSelector} {
+		if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+			return fmt.Errorf("invalid selector: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVxNetPoolValidate(t *testing.T) {
+	pool := &VxNetPool{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "v-pool",
+		},
+		Spec: VxNetPoolSpec{
+			BlockSize: 26,
+			Vxnets: []VxnetInfo{
+				{Name: "vxnet-aaaaaaa"},
+				{Name: "vxnet-bbbbbbb"},
+			},
+		},
+	}
+	if err := pool.Validate(); err != nil {
+		t.Fatalf("valid pool rejected: %v", err)
+	}
+
+	for _, blockSize := range []int{0, VxNetPoolMinBlockSize - 1, VxNetPoolMaxBlockSize + 1} {
+		invalid := pool.DeepCopy()
+		invalid.Spec.BlockSize = blockSize
+		if invalid.Validate() == nil {
+			t.Errorf("blockSize %d should be rejected", blockSize)
+		}
+	}
+
+	invalid := pool.DeepCopy()
+	invalid.Spec.Vxnets = append(invalid.Spec.Vxnets, VxnetInfo{Name: "vxnet-aaaaaaa"})
+	if invalid.Validate() == nil {
+		t.Error("duplicated vxnet should be rejected")
+	}
+
+	invalid = pool.DeepCopy()
+	invalid.Spec.NodeSelector = &v1.LabelSelector{
+		MatchExpressions: []v1.LabelSelectorRequirement{{Key: "gpu", Operator: "Unknown"}},
+	}
+	if invalid.Validate() == nil {
+		t.Error("invalid nodeSelector should be rejected")
+	}
+}
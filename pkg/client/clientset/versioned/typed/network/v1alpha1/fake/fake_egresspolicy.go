@@ -0,0 +1,133 @@
+/*
+Copyright 2020 The KubeSphere Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	"context"
+
+	v1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeEgressPolicies implements EgressPolicyInterface
+type FakeEgressPolicies struct {
+	Fake *FakeNetworkV1alpha1
+}
+
+var egresspoliciesResource = schema.GroupVersionResource{Group: "network.qingcloud.com", Version: "v1alpha1", Resource: "egresspolicies"}
+
+var egresspoliciesKind = schema.GroupVersionKind{Group: "network.qingcloud.com", Version: "v1alpha1", Kind: "EgressPolicy"}
+
+// Get takes name of the egressPolicy, and returns the corresponding egressPolicy object, and an error if there is any.
+func (c *FakeEgressPolicies) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.EgressPolicy, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootGetAction(egresspoliciesResource, name), &v1alpha1.EgressPolicy{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.EgressPolicy), err
+}
+
+// List takes label and field selectors, and returns the list of EgressPolicies that match those selectors.
+func (c *FakeEgressPolicies) List(ctx context.Context, opts v1.ListOptions) (result *v1alpha1.EgressPolicyList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootListAction(egresspoliciesResource, egresspoliciesKind, opts), &v1alpha1.EgressPolicyList{})
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1alpha1.EgressPolicyList{ListMeta: obj.(*v1alpha1.EgressPolicyList).ListMeta}
+	for _, item := range obj.(*v1alpha1.EgressPolicyList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested egressPolicies.
+func (c *FakeEgressPolicies) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewRootWatchAction(egresspoliciesResource, opts))
+}
+
+// Create takes the representation of a egressPolicy and creates it.  Returns the server's representation of the egressPolicy, and an error, if there is any.
+func (c *FakeEgressPolicies) Create(ctx context.Context, egressPolicy *v1alpha1.EgressPolicy, opts v1.CreateOptions) (result *v1alpha1.EgressPolicy, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootCreateAction(egresspoliciesResource, egressPolicy), &v1alpha1.EgressPolicy{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.EgressPolicy), err
+}
+
+// Update takes the representation of a egressPolicy and updates it. Returns the server's representation of the egressPolicy, and an error, if there is any.
+func (c *FakeEgressPolicies) Update(ctx context.Context, egressPolicy *v1alpha1.EgressPolicy, opts v1.UpdateOptions) (result *v1alpha1.EgressPolicy, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateAction(egresspoliciesResource, egressPolicy), &v1alpha1.EgressPolicy{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.EgressPolicy), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeEgressPolicies) UpdateStatus(ctx context.Context, egressPolicy *v1alpha1.EgressPolicy, opts v1.UpdateOptions) (*v1alpha1.EgressPolicy, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateSubresourceAction(egresspoliciesResource, "status", egressPolicy), &v1alpha1.EgressPolicy{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.EgressPolicy), err
+}
+
+// Delete takes name of the egressPolicy and deletes it. Returns an error if one occurs.
+func (c *FakeEgressPolicies) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewRootDeleteAction(egresspoliciesResource, name), &v1alpha1.EgressPolicy{})
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeEgressPolicies) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewRootDeleteCollectionAction(egresspoliciesResource, listOpts)
+
+	_, err := c.Fake.Invokes(action, &v1alpha1.EgressPolicyList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched egressPolicy.
+func (c *FakeEgressPolicies) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.EgressPolicy, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootPatchSubresourceAction(egresspoliciesResource, name, pt, data, subresources...), &v1alpha1.EgressPolicy{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.EgressPolicy), err
+}
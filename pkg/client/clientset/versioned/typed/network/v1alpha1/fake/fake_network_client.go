@@ -28,6 +28,10 @@ type FakeNetworkV1alpha1 struct {
 	*testing.Fake
 }
 
+func (c *FakeNetworkV1alpha1) EgressPolicies() v1alpha1.EgressPolicyInterface {
+	return &FakeEgressPolicies{c}
+}
+
 func (c *FakeNetworkV1alpha1) IPAMBlocks() v1alpha1.IPAMBlockInterface {
 	return &FakeIPAMBlocks{c}
 }
@@ -18,6 +18,8 @@ limitations under the License.
 
 package v1alpha1
 
+type EgressPolicyExpansion interface{}
+
 type IPAMBlockExpansion interface{}
 
 type IPAMHandleExpansion interface{}
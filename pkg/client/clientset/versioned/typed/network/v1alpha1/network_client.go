@@ -26,6 +26,7 @@ import (
 
 type NetworkV1alpha1Interface interface {
 	RESTClient() rest.Interface
+	EgressPoliciesGetter
 	IPAMBlocksGetter
 	IPAMHandlesGetter
 	IPPoolsGetter
@@ -37,6 +38,10 @@ type NetworkV1alpha1Client struct {
 	restClient rest.Interface
 }
 
+func (c *NetworkV1alpha1Client) EgressPolicies() EgressPolicyInterface {
+	return newEgressPolicies(c)
+}
+
 func (c *NetworkV1alpha1Client) IPAMBlocks() IPAMBlockInterface {
 	return newIPAMBlocks(c)
 }
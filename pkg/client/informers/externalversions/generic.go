@@ -53,6 +53,8 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=network.qingcloud.com, Version=v1alpha1
+	case v1alpha1.SchemeGroupVersion.WithResource("egresspolicies"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Network().V1alpha1().EgressPolicies().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("ipamblocks"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Network().V1alpha1().IPAMBlocks().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("ipamhandles"):
@@ -24,6 +24,8 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// EgressPolicies returns a EgressPolicyInformer.
+	EgressPolicies() EgressPolicyInformer
 	// IPAMBlocks returns a IPAMBlockInformer.
 	IPAMBlocks() IPAMBlockInformer
 	// IPAMHandles returns a IPAMHandleInformer.
@@ -45,6 +47,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// EgressPolicies returns a EgressPolicyInformer.
+func (v *version) EgressPolicies() EgressPolicyInformer {
+	return &egressPolicyInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
 // IPAMBlocks returns a IPAMBlockInformer.
 func (v *version) IPAMBlocks() IPAMBlockInformer {
 	return &iPAMBlockInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
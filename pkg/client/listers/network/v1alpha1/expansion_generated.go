@@ -18,6 +18,10 @@ limitations under the License.
 
 package v1alpha1
 
+// EgressPolicyListerExpansion allows custom methods to be added to
+// EgressPolicyLister.
+type EgressPolicyListerExpansion interface{}
+
 // IPAMBlockListerExpansion allows custom methods to be added to
 // IPAMBlockLister.
 type IPAMBlockListerExpansion interface{}
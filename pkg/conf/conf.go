@@ -8,11 +8,14 @@ import (
 	"k8s.io/apimachinery/pkg/util/yaml"
 
 	"github.com/yunify/hostnic-cni/pkg/constants"
+	"github.com/yunify/hostnic-cni/pkg/tracing"
 )
 
 type IpamConf struct {
 	Pool   PoolConf   `json:"pool,omitempty" yaml:"pool,omitempty"`
 	Server ServerConf `json:"server,omitempty" yaml:"server,omitempty"`
+
+	Tracing tracing.Config `json:"tracing,omitempty" yaml:"tracing,omitempty"`
 }
 
 type PoolConf struct {
@@ -21,6 +24,7 @@ type PoolConf struct {
 	Sync           int      `json:"sync,omitempty" yaml:"sync,omitempty"`
 	NodeSync       int      `json:"nodeSync,omitempty" yaml:"nodeSync,omitempty"`
 	RouteTableBase int      `json:"routeTableBase,omitempty" yaml:"routeTableBase,omitempty"`
+	RouteTableSize int      `json:"routeTableSize,omitempty" yaml:"routeTableSize,omitempty"`
 	Tag            string   `json:"tag,omitempty" yaml:"tag,omitempty"`
 	VxNets         []string `json:"vxNets,omitempty" yaml:"vxNets,omitempty"`
 
@@ -28,11 +32,29 @@ type PoolConf struct {
 	NodeThreshold  int `json:"nodeThreshold,omitempty" yaml:"nodeThreshold,omitempty"`
 	VxnetThreshold int `json:"vxnetThreshold,omitempty" yaml:"vxnetThreshold,omitempty"`
 	FreePeriod     int `json:"freePeriod,omitempty" yaml:"freePeriod,omitempty"`
+
+	//network drift check opts, the periodic check only reports drifts unless NetworkCheckRepair
+	NetworkCheckPeriod int  `json:"networkCheckPeriod,omitempty" yaml:"networkCheckPeriod,omitempty"`
+	NetworkCheckRepair bool `json:"networkCheckRepair,omitempty" yaml:"networkCheckRepair,omitempty"`
 }
 
 type ServerConf struct {
-	ServerPath    string `json:"serverPath,omitempty" yaml:"serverPath,omitempty"`
-	NetworkPolicy string `json:"networkPolicy,omitempty" yaml:"networkPolicy,omitempty"`
+	ServerPath    string    `json:"serverPath,omitempty" yaml:"serverPath,omitempty"`
+	NetworkPolicy string    `json:"networkPolicy,omitempty" yaml:"networkPolicy,omitempty"`
+	Admin         AdminConf `json:"admin,omitempty" yaml:"admin,omitempty"`
+}
+
+// AdminConf configures the remote admin listener, which serves the read-only and admin rpcs over mTLS.
+// Clients are authorized by the common name of their certificates.
+type AdminConf struct {
+	// tcp address to listen on, the listener is disabled if empty
+	Listen string `json:"listen,omitempty" yaml:"listen,omitempty"`
+	// directory of tls.crt, tls.key and ca.crt, usually mounted from a secret
+	CertDir string `json:"certDir,omitempty" yaml:"certDir,omitempty"`
+	// clients allowed to call the read-only rpcs
+	Readers []string `json:"readers,omitempty" yaml:"readers,omitempty"`
+	// clients allowed to call all rpcs of the listener, including ClearNics and CheckNetwork with repair
+	Admins []string `json:"admins,omitempty" yaml:"admins,omitempty"`
 }
 
 // TryLoadFromDisk loads configuration from default location after server startup
@@ -48,13 +70,19 @@ func TryLoadIpamConfFromDisk(name, path string) (*IpamConf, error) {
 			MaxNic:         constants.NicNumLimit,
 			Sync:           constants.DefaultJobSyn,
 			RouteTableBase: constants.DefaultRouteTableBase,
+			RouteTableSize: constants.DefaultRouteTableSize,
 			NodeSync:       constants.DefaultNodeSync,
 			NodeThreshold:  constants.DefaultNodeThreshold,
 			VxnetThreshold: constants.DefaultVxnetThreshold,
 			FreePeriod:     constants.DefaultFreePeriod,
+
+			NetworkCheckPeriod: constants.DefaultNetworkCheckPeriod,
 		},
 		Server: ServerConf{
 			ServerPath: constants.DefaultSocketPath,
+			Admin: AdminConf{
+				CertDir: constants.DefaultAdminCertDir,
+			},
 		},
 	}
 
@@ -81,6 +109,24 @@ func validateConf(conf *IpamConf) error {
 	if conf.Pool.MaxNic > constants.NicNumLimit {
 		return fmt.Errorf("MaxNic should less than 63")
 	}
+	if conf.Pool.RouteTableSize < conf.Pool.MaxNic {
+		return fmt.Errorf("RouteTableSize should not be less than MaxNic %d", conf.Pool.MaxNic)
+	}
+	// 253-255 are the default, main and local tables of kernel
+	if conf.Pool.RouteTableBase <= 0 || (conf.Pool.RouteTableBase < 256 && conf.Pool.RouteTableBase+conf.Pool.RouteTableSize > 253) {
+		return fmt.Errorf("route tables [%d, %d) overlap with the tables reserved by kernel",
+			conf.Pool.RouteTableBase, conf.Pool.RouteTableBase+conf.Pool.RouteTableSize)
+	}
+	if conf.Pool.RouteTableBase < constants.EgressRouteTableBase+constants.EgressRouteTableSize &&
+		conf.Pool.RouteTableBase+conf.Pool.RouteTableSize > constants.EgressRouteTableBase {
+		return fmt.Errorf("route tables [%d, %d) overlap with the tables of egress policies [%d, %d)",
+			conf.Pool.RouteTableBase, conf.Pool.RouteTableBase+conf.Pool.RouteTableSize,
+			constants.EgressRouteTableBase, constants.EgressRouteTableBase+constants.EgressRouteTableSize)
+	}
+
+	if conf.Server.Admin.Listen != "" && len(conf.Server.Admin.Readers)+len(conf.Server.Admin.Admins) == 0 {
+		return fmt.Errorf("admin listener %s has no readers or admins", conf.Server.Admin.Listen)
+	}
 
 	return nil
 }
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	v1Informers "k8s.io/client-go/informers/core/v1"
+	v1Listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	networkinformers "github.com/yunify/hostnic-cni/pkg/client/informers/externalversions/network/v1alpha1"
+	networklisters "github.com/yunify/hostnic-cni/pkg/client/listers/network/v1alpha1"
+)
+
+// PoolSelector filters ippools by the nodeSelector of the vxnetpool which owns them,
+// so that a group of nodes only allocates from its own vxnets.
+type PoolSelector struct {
+	nodeSynced cache.InformerSynced
+	nodeLister v1Listers.NodeLister
+
+	poolSynced cache.InformerSynced
+	poolLister networklisters.VxNetPoolLister
+}
+
+func NewPoolSelector(nodeInformer v1Informers.NodeInformer, poolInformer networkinformers.VxNetPoolInformer) *PoolSelector {
+	return &PoolSelector{
+		nodeSynced: nodeInformer.Informer().HasSynced,
+		nodeLister: nodeInformer.Lister(),
+		poolSynced: poolInformer.Informer().HasSynced,
+		poolLister: poolInformer.Lister(),
+	}
+}
+
+func (p *PoolSelector) Sync(stopCh <-chan struct{}) error {
+	klog.Info("Waiting for node and vxnetpool caches to sync")
+	if ok := cache.WaitForCacheSync(stopCh, p.nodeSynced, p.poolSynced); !ok {
+		return fmt.Errorf("failed to wait for node and vxnetpool caches to sync")
+	}
+	return nil
+}
+
+// FilterIPPools drops the ippools whose vxnetpool doesn't select the node.
+// IPPools which don't belong to any vxnetpool are kept.
+func (p *PoolSelector) FilterIPPools(nodeName string, ippools []string) []string {
+	node, err := p.nodeLister.Get(nodeName)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("Get node %s failed: %v", nodeName, err)
+		}
+		return ippools
+	}
+
+	pools, err := p.poolLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List vxnetpools failed: %v", err)
+		return ippools
+	}
+
+	var rst []string
+	for _, ippool := range ippools {
+		selected := true
+		for _, pool := range pools {
+			if pool.Spec.NodeSelector == nil || !pool.HasVxNet(ippool) {
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(pool.Spec.NodeSelector)
+			if err != nil {
+				klog.Warningf("VxNetPool %s has invalid nodeSelector: %v", pool.Name, err)
+				continue
+			}
+			if !selector.Matches(labels.Set(node.Labels)) {
+				selected = false
+			}
+			break
+		}
+		if selected {
+			rst = append(rst, ippool)
+		}
+	}
+	return rst
+}
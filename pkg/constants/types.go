@@ -26,6 +26,7 @@ import (
 
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/yunify/hostnic-cni/pkg/rpc"
+	"github.com/yunify/hostnic-cni/pkg/tracing"
 )
 
 const (
@@ -33,6 +34,7 @@ const (
 	DefaultUnixSocketPath = "unix://" + DefaultSocketPath
 	DefaultConfigPath     = "/etc/hostnic/"
 	DefaultConfigName     = "hostnic.json"
+	DefaultAdminCertDir   = "/etc/hostnic-admin/"
 
 	DefaultClusterConfigPath = "/etc/kubernetes/qingcloud.yaml"
 
@@ -43,11 +45,14 @@ const (
 	DefaultVxnetThreshold = 128
 	// Minute
 	DefaultFreePeriod = 12 * 60
+	// Second
+	DefaultNetworkCheckPeriod = 5 * 60
 
 	VIPNumLimit           = 253
 	NicNumLimit           = 63
 	VxnetNicNumLimit      = 252
 	DefaultRouteTableBase = 260
+	DefaultRouteTableSize = NicNumLimit
 
 	NicPrefix    = "hostnic_"
 	VxNetPrefix  = "vxnet-"
@@ -58,6 +63,16 @@ const (
 
 	HostNicPrefix = "vnic"
 
+	// DefaultPodIfName is the interface of the primary network of pods, others are attached by multus
+	DefaultPodIfName = "eth0"
+
+	// VxNetResourcePrefix is the prefix of extended resources advertised for every vxnet by device plugin
+	VxNetResourcePrefix = "hostnic.network.qingcloud.com/"
+	// VxNetResourceCapacity is the number of devices advertised for every vxnet by device plugin.
+	// It is not a real limit, only large enough that pods on a node never run out of it: a vxnet
+	// is a /24 with 253 addresses usable by instances, so a node could never run more pods in it.
+	VxNetResourceCapacity = 253
+
 	DefaultNatMark        = "0x10000"
 	DefaultPrimaryNic     = "eth0"
 	MainTable             = 254
@@ -70,6 +85,20 @@ const (
 	ToContainerRulePriority   = 1535
 	FromContainerRulePriority = 1536
 
+	// rules of egress policies are looked up before the rules of pods
+	EgressReplyRulePriority   = 1520
+	EgressExcludeRulePriority = 1521
+	EgressRulePriority        = 1522
+	EgressRouteTableBase      = 1000
+	EgressRouteTableSize      = 64
+	EgressChain               = "HOSTNIC-EGRESS"
+	// DefaultEgressMark is set on the packets in the original direction of connections, so that replies of
+	// connections from outside are not routed to egress ips. It stays clear of kube-proxy (0x4000, 0x8000)
+	// and the default mark mask of calico (0xffff0000).
+	DefaultEgressMark = 0x1000
+	// ReservedMarkMask are the marks used by kube-proxy and calico
+	ReservedMarkMask = 0xffffc000
+
 	CalicoAnnotationPodIP  = "cni.projectcalico.org/podIP"
 	CalicoAnnotationPodIPs = "cni.projectcalico.org/podIPs"
 	CalicoAnnotationIpAddr = "cni.projectcalico.org/ipAddrs"
@@ -81,9 +110,13 @@ const (
 
 	// configmap's data field
 	IPAMAutoAssignForNamespace = "subnet-auto-assign"
+	IPAMAutoReclaimIdle        = "subnet-auto-reclaim-idle"
+	IPAMAutoReclaimMinSubnets  = "subnet-auto-reclaim-min"
 	IPAMConfigDate             = "ipam"
 	IPAMDefaultPoolKey         = "Default"
 
+	DefaultAutoReclaimMinSubnets = 1
+
 	EventADD    = "add"
 	EventUpdate = "update"
 	EventDelete = "delete"
@@ -108,10 +141,20 @@ func GetHostNicBridgeName(routeTableNum int) string {
 	return fmt.Sprintf("%s%d", BridgePrefix, routeTableNum)
 }
 
+// GetVxNetResourceName returns the extended resource requested by pods in vxnet
+func GetVxNetResourceName(vxnet string) string {
+	return VxNetResourcePrefix + vxnet
+}
+
 func GetHostNicName(id string) string {
 	return fmt.Sprintf("%s%s", NicPrefix, strings.TrimPrefix(id, VxNetPrefix))
 }
 
+// IsSecondaryIfName reports whether ifName is an extra interface of pod, which is attached as a secondary network
+func IsSecondaryIfName(ifName string) bool {
+	return ifName != "" && ifName != DefaultPodIfName
+}
+
 func PodInfoKey(info *rpc.PodInfo) string {
 	return fmt.Sprintf("%s", info.Containter)
 }
@@ -147,6 +190,10 @@ type NetConf struct {
 	NatMark  string `json:"natMark,omitempty"`
 	LogLevel int    `json:"logLevel,omitempty"`
 	LogFile  string `json:"logFile,omitempty"`
+	// optional tracing of the plugin
+	Tracing tracing.Config `json:"tracing,omitempty"`
+	// vxnet of the secondary interface when hostnic is attached by multus
+	VxNet string `json:"vxnet,omitempty"`
 }
 
 // K8sArgs is the valid CNI_ARGS used for Kubernetes
@@ -164,6 +211,8 @@ var (
 	ErrNoAvailableNIC = errors.New("no free nic")
 	ErrNicNotFound    = errors.New("hostnic not found")
 
-	LastIPAddrRenewPeriod = 60 * 60 * time.Second //s, default 1h
-	IpAddrReNewTicker     = time.NewTicker(LastIPAddrRenewPeriod)
+	// NicAttachTimeout bounds the wait for an attached nic to appear on node, when the cni call has no deadline
+	NicAttachTimeout = 2 * time.Minute
+	// NicRepairDelay gathers the netlink events of a nic before repairing it
+	NicRepairDelay = time.Second
 )
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+)
+
+const (
+	reasonSucceeded = "Succeeded"
+	reasonPending   = "Pending"
+)
+
+func setCondition(conditions *[]metav1.Condition, generation int64, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// recordConditionEvents emits an event for every condition whose status or reason changed,
+// abnormal maps a condition type to the status which should be reported as a warning.
+func recordConditionEvents(recorder record.EventRecorder, obj runtime.Object, old, new []metav1.Condition, abnormal map[string]metav1.ConditionStatus) {
+	for _, cond := range new {
+		if cond.Status == metav1.ConditionUnknown {
+			continue
+		}
+		prev := meta.FindStatusCondition(old, cond.Type)
+		if prev != nil && prev.Status == cond.Status && prev.Reason == cond.Reason {
+			continue
+		}
+		// a new condition which is false and not abnormal is not worth an event
+		if prev == nil && cond.Status == metav1.ConditionFalse && abnormal[cond.Type] != cond.Status {
+			continue
+		}
+
+		eventType := corev1.EventTypeNormal
+		if abnormal[cond.Type] == cond.Status {
+			eventType = corev1.EventTypeWarning
+		}
+		message := fmt.Sprintf("%s is %s", cond.Type, cond.Status)
+		if cond.Message != "" {
+			message = fmt.Sprintf("%s: %s", message, cond.Message)
+		}
+		recorder.Event(obj, eventType, cond.Reason, message)
+	}
+}
+
+// poolSyncState collects the conditions and the per-vxnet details while syncing a vxnetpool
+type poolSyncState struct {
+	pool   *networkv1alpha1.VxNetPool
+	status *networkv1alpha1.VxNetPoolStatus
+	// vxnets records what the vxnets are waiting for in current step
+	vxnets map[string]string
+}
+
+func newPoolSyncState(pool *networkv1alpha1.VxNetPool) *poolSyncState {
+	return &poolSyncState{
+		pool:   pool,
+		status: pool.Status.DeepCopy(),
+		vxnets: make(map[string]string),
+	}
+}
+
+func (s *poolSyncState) wait(vxnet, message string) {
+	s.vxnets[vxnet] = message
+}
+
+// check sets the condition of current step according to the vxnets waited,
+// and marks the following steps pending if current step is not done.
+func (s *poolSyncState) check(conditionType, reason string) bool {
+	if len(s.vxnets) == 0 {
+		s.setCondition(conditionType, metav1.ConditionTrue, reasonSucceeded, fmt.Sprintf("all %d vxnets are ready", len(s.pool.Spec.Vxnets)))
+		return true
+	}
+
+	var details []string
+	for _, vxnet := range s.pool.Spec.Vxnets {
+		if message, ok := s.vxnets[vxnet.Name]; ok {
+			details = append(details, fmt.Sprintf("%s: %s", vxnet.Name, message))
+		}
+	}
+	s.setCondition(conditionType, metav1.ConditionFalse, reason, strings.Join(details, "; "))
+
+	pending := false
+	for _, t := range networkv1alpha1.VxNetPoolConditions {
+		if pending {
+			s.setCondition(t, metav1.ConditionUnknown, reasonPending, fmt.Sprintf("waiting for %s", conditionType))
+		}
+		if t == conditionType {
+			pending = true
+		}
+	}
+	return false
+}
+
+// releasing sets the release condition according to the vxnets waited, it returns true if nothing is waited
+func (s *poolSyncState) releasing(reason string) bool {
+	if len(s.vxnets) == 0 {
+		s.setCondition(networkv1alpha1.VxNetPoolConditionResourcesReleased, metav1.ConditionTrue, reasonSucceeded, "")
+		return true
+	}
+
+	var details []string
+	for vxnet, message := range s.vxnets {
+		details = append(details, fmt.Sprintf("%s: %s", vxnet, message))
+	}
+	sort.Strings(details)
+	s.setCondition(networkv1alpha1.VxNetPoolConditionResourcesReleased, metav1.ConditionFalse, reason, strings.Join(details, "; "))
+	return false
+}
+
+// setJob records the job submitted for key in status
+func (s *poolSyncState) setJob(key, job string) {
+	if s.status.Jobs == nil {
+		s.status.Jobs = make(map[string]string)
+	}
+	s.status.Jobs[key] = job
+}
+
+// fail records the error of vxnet and sets the condition of current step
+func (s *poolSyncState) fail(conditionType, reason, vxnet string, err error) {
+	s.wait(vxnet, err.Error())
+	s.check(conditionType, reason)
+}
+
+func (s *poolSyncState) setCondition(conditionType string, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&s.status.Conditions, s.pool.Generation, conditionType, status, reason, message)
+}
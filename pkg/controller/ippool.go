@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	cnet "github.com/projectcalico/libcalico-go/lib/net"
@@ -52,6 +55,18 @@ type IPPoolController struct {
 	ipamblockInformer networkInformer.IPAMBlockInformer
 	ipamblockSynced   cache.InformerSynced
 
+	vxnetpoolInformer networkInformer.VxNetPoolInformer
+	vxnetpoolSynced   cache.InformerSynced
+
+	// nodes are watched to release the blocks claimed by them when deleted
+	nodeInformer coreinfomers.NodeInformer
+	nodeSynced   cache.InformerSynced
+	nodeQueue    workqueue.RateLimitingInterface
+
+	// emptySince records when an auto-assigned block was first seen empty
+	emptyLock  sync.Mutex
+	emptySince map[string]time.Time
+
 	k8sclient k8sclientset.Interface
 	client    clientset.Interface
 }
@@ -96,6 +111,14 @@ func (c *IPPoolController) removeFinalizer(pool *networkv1alpha1.IPPool) error {
 	return nil
 }
 
+// NewIPPoolValidator returns an IPPoolController which only serves the Validate methods,
+// it is used by the admission webhook.
+func NewIPPoolValidator(client clientset.Interface) *IPPoolController {
+	return &IPPoolController{
+		client: client,
+	}
+}
+
 func (c *IPPoolController) ValidateCreate(obj runtime.Object) error {
 	b := obj.(*networkv1alpha1.IPPool)
 	ip, cidr, err := cnet.ParseCIDR(b.Spec.CIDR)
@@ -222,21 +245,63 @@ func (c *IPPoolController) disableIPPool(old *networkv1alpha1.IPPool) error {
 	return err
 }
 
+// ippoolAbnormalConditions are reported as warning events
+var ippoolAbnormalConditions = map[string]metav1.ConditionStatus{
+	networkv1alpha1.IPPoolConditionSynced:    metav1.ConditionFalse,
+	networkv1alpha1.IPPoolConditionExhausted: metav1.ConditionTrue,
+}
+
+func setIPPoolConditions(pool *networkv1alpha1.IPPool) {
+	conditions := &pool.Status.Conditions
+	setCondition(conditions, pool.Generation, networkv1alpha1.IPPoolConditionSynced, metav1.ConditionTrue, reasonSucceeded, "")
+
+	if pool.Status.Capacity > 0 && pool.Status.Unallocated == 0 {
+		setCondition(conditions, pool.Generation, networkv1alpha1.IPPoolConditionExhausted, metav1.ConditionTrue, "NoFreeAddresses",
+			fmt.Sprintf("all %d addresses are allocated or reserved", pool.Status.Capacity))
+	} else {
+		setCondition(conditions, pool.Generation, networkv1alpha1.IPPoolConditionExhausted, metav1.ConditionFalse, "FreeAddresses",
+			fmt.Sprintf("%d of %d addresses are free", pool.Status.Unallocated, pool.Status.Capacity))
+	}
+
+	if pool.Spec.Disabled {
+		reason := "Disabled"
+		if pool.DeletionTimestamp != nil {
+			reason = "Deleting"
+		}
+		setCondition(conditions, pool.Generation, networkv1alpha1.IPPoolConditionDisabled, metav1.ConditionTrue, reason, "")
+	} else {
+		setCondition(conditions, pool.Generation, networkv1alpha1.IPPoolConditionDisabled, metav1.ConditionFalse, "Enabled", "")
+	}
+}
+
 func (c *IPPoolController) updateIPPoolStatus(old *networkv1alpha1.IPPool) error {
 	new, err := c.provider.GetIPPoolStats(old)
 	if err != nil {
-		return fmt.Errorf("failed to get ippool %s status %v", old.Name, err)
+		err = fmt.Errorf("failed to get ippool %s status %v", old.Name, err)
+		new = old.DeepCopy()
+		new.Status.Synced = false
+		setCondition(&new.Status.Conditions, new.Generation, networkv1alpha1.IPPoolConditionSynced, metav1.ConditionFalse, "GetStatsFailed", err.Error())
+		if updateErr := c.syncIPPoolStatus(old, new); updateErr != nil {
+			klog.Error(updateErr)
+		}
+		return err
 	}
 
+	setIPPoolConditions(new)
+	return c.syncIPPoolStatus(old, new)
+}
+
+func (c *IPPoolController) syncIPPoolStatus(old, new *networkv1alpha1.IPPool) error {
 	if reflect.DeepEqual(old.Status, new.Status) {
 		return nil
 	}
 
-	_, err = c.client.NetworkV1alpha1().IPPools().UpdateStatus(context.TODO(), new, metav1.UpdateOptions{})
+	_, err := c.client.NetworkV1alpha1().IPPools().UpdateStatus(context.TODO(), new, metav1.UpdateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to update ippool %s status  %v", old.Name, err)
 	}
 
+	recordConditionEvents(c.eventRecorder, new, old.Status.Conditions, new.Status.Conditions, ippoolAbnormalConditions)
 	return nil
 }
 
@@ -314,22 +379,45 @@ func (c *IPPoolController) Start(stopCh <-chan struct{}) error {
 func (c *IPPoolController) Run(workers int, stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 	defer c.ippoolQueue.ShutDown()
+	defer c.nsQueue.ShutDown()
+	defer c.nodeQueue.ShutDown()
 
 	klog.Info("starting ippool controller")
 	defer klog.Info("shutting down ippool controller")
 
-	if !cache.WaitForCacheSync(stopCh, c.ippoolSynced, c.ipamblockSynced, c.nsSynced) {
+	if !cache.WaitForCacheSync(stopCh, c.ippoolSynced, c.ipamblockSynced, c.nsSynced, c.vxnetpoolSynced, c.nodeSynced) {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
+	var wg sync.WaitGroup
 	for i := 0; i < workers; i++ {
-		go wait.Until(c.runIPPoolWorker, time.Second, stopCh)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(c.runIPPoolWorker, time.Second, stopCh)
+		}()
 	}
 
 	// only one thread to handle ns
-	go wait.Until(c.runNSWorker, time.Second, stopCh)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wait.Until(c.runNSWorker, time.Second, stopCh)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wait.Until(c.runNodeWorker, time.Second, stopCh)
+	}()
 
 	<-stopCh
+
+	// drain the queues before return, so that a new leader never races with us
+	c.ippoolQueue.ShutDown()
+	c.nsQueue.ShutDown()
+	c.nodeQueue.ShutDown()
+	wg.Wait()
 	return nil
 }
 
@@ -374,24 +462,59 @@ func contains(items []string, item string) bool {
 	return false
 }
 
-func (c *IPPoolController) getFreeIPAMBlock(apps map[string][]string) (string, error) {
-	vxnetpool, err := c.client.NetworkV1alpha1().VxNetPools().Get(context.TODO(), constants.IPAMVxnetPoolName, metav1.GetOptions{})
+// getVxNetPoolForNamespace returns the first vxnetpool (sorted by name) whose namespaceSelector
+// matches the namespace, and falls back to v-pool when none of them matches.
+func (c *IPPoolController) getVxNetPoolForNamespace(ns *corev1.Namespace) (*networkv1alpha1.VxNetPool, error) {
+	pools, err := c.vxnetpoolInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		return pools[i].Name < pools[j].Name
+	})
+
+	for _, pool := range pools {
+		if pool.Spec.NamespaceSelector == nil || pool.DeletionTimestamp != nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pool.Spec.NamespaceSelector)
+		if err != nil {
+			klog.Warningf("VxNetPool %s has invalid namespaceSelector: %v", pool.Name, err)
+			continue
+		}
+		if selector.Matches(labels.Set(ns.Labels)) {
+			return pool, nil
+		}
+	}
+
+	return c.vxnetpoolInformer.Lister().Get(constants.IPAMVxnetPoolName)
+}
+
+func (c *IPPoolController) getFreeIPAMBlock(ns *corev1.Namespace, apps map[string][]string) (string, error) {
+	vxnetpool, err := c.getVxNetPoolForNamespace(ns)
 	if err != nil {
 		return "", err
 	}
 
 	status := vxnetpool.Status
 	if !status.Ready {
-		return "", fmt.Errorf("waiting for VxNetPool %s to be ready", constants.IPAMVxnetPoolName)
+		return "", fmt.Errorf("waiting for VxNetPool %s to be ready", vxnetpool.Name)
+	}
+
+	pools, err := c.vxnetpoolInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return "", err
 	}
 
 	used := make(map[string]struct{})
 	for k, v := range apps {
 		if k == constants.IPAMDefaultPoolKey {
-			for _, pool := range status.Pools {
-				if contains(v, pool.IPPool) {
-					for _, subnet := range pool.Subnets {
-						used[subnet] = struct{}{}
+			for _, p := range pools {
+				for _, pool := range p.Status.Pools {
+					if contains(v, pool.IPPool) {
+						for _, subnet := range pool.Subnets {
+							used[subnet] = struct{}{}
+						}
 					}
 				}
 			}
@@ -410,7 +533,7 @@ func (c *IPPoolController) getFreeIPAMBlock(apps map[string][]string) (string, e
 		}
 	}
 
-	return "", fmt.Errorf("no free subnet was found")
+	return "", fmt.Errorf("no free subnet was found in VxNetPool %s", vxnetpool.Name)
 }
 
 func (c *IPPoolController) getRelationNSFromBlock(block string) (string, error) {
@@ -478,34 +601,54 @@ func (c *IPPoolController) processNS(name string) error {
 	}
 
 	change := false
+	var reclaimed []string
 	if ns == nil || ns.DeletionTimestamp != nil {
 		klog.V(4).Infof("Namespace %s is deleted", name)
 		// delete ns's subnet config
-		if _, ok := apps[name]; ok {
+		if subnets, ok := apps[name]; ok {
 			change = true
 			delete(apps, name)
+			c.forgetEmptyBlocks(subnets)
 		}
 	} else {
 		subnets, ok := apps[name]
 		if ok {
-			isEmpty, err := c.isBlocksEmpty(subnets)
+			var delay time.Duration
+			reclaimed, delay, err = c.reclaimIdleBlocks(name, subnets, cm.Data)
 			if err != nil {
 				return err
 			}
-			if !isEmpty {
-				klog.V(4).Infof("Namespace %s has subnets %v", name, subnets)
-				return nil
+			if delay > 0 {
+				c.nsQueue.AddAfter(name, delay)
 			}
 		}
 
-		// expand namespace's subnets when it has no subnets or free addresses
-		subnet, err := c.getFreeIPAMBlock(apps)
-		if err != nil {
-			return err
+		if len(reclaimed) > 0 {
+			// shrink namespace's subnets when some of them have been idle for long enough
+			klog.V(4).Infof("Namespace %s reclaim subnets %v from %v", name, reclaimed, subnets)
+			change = true
+			apps[name] = utils.RemoveStrings(subnets, reclaimed)
+		} else {
+			if ok {
+				isEmpty, err := c.isBlocksEmpty(subnets)
+				if err != nil {
+					return err
+				}
+				if !isEmpty {
+					klog.V(4).Infof("Namespace %s has subnets %v", name, subnets)
+					return nil
+				}
+			}
+
+			// expand namespace's subnets when it has no subnets or free addresses
+			subnet, err := c.getFreeIPAMBlock(ns, apps)
+			if err != nil {
+				return err
+			}
+			klog.V(4).Infof("Namespace %s expand subnet %s on %v", name, subnet, subnets)
+			change = true
+			apps[name] = append(subnets, subnet)
 		}
-		klog.V(4).Infof("Namespace %s expand subnet %s on %v", name, subnet, subnets)
-		change = true
-		apps[name] = append(subnets, subnet)
 	}
 
 	if change {
@@ -513,12 +656,128 @@ func (c *IPPoolController) processNS(name string) error {
 		clone := cm.DeepCopy()
 		clone.Data[constants.IPAMConfigDate] = string(data)
 		_, err := c.k8sclient.CoreV1().ConfigMaps(constants.IPAMConfigNamespace).Update(context.TODO(), clone, metav1.UpdateOptions{})
-		return err
+		if err != nil {
+			return err
+		}
+
+		if len(reclaimed) > 0 {
+			c.forgetEmptyBlocks(reclaimed)
+			c.eventRecorder.Eventf(ns, corev1.EventTypeNormal, "SubnetReclaimed",
+				"Reclaimed idle subnets %v, %d subnets left", reclaimed, len(apps[name]))
+		}
 	}
 
 	return nil
 }
 
+// getReclaimConf parses the reclaim settings from ipam configmap,
+// a zero idle period means subnets are never reclaimed.
+func getReclaimConf(data map[string]string) (time.Duration, int) {
+	min := constants.DefaultAutoReclaimMinSubnets
+	if value := data[constants.IPAMAutoReclaimMinSubnets]; value != "" {
+		if v, err := strconv.Atoi(value); err != nil || v < 0 {
+			klog.Warningf("invalid %s %q, use default %d", constants.IPAMAutoReclaimMinSubnets, value, min)
+		} else {
+			min = v
+		}
+	}
+
+	value := data[constants.IPAMAutoReclaimIdle]
+	if value == "" {
+		return 0, min
+	}
+	idle, err := time.ParseDuration(value)
+	if err != nil || idle < 0 {
+		klog.Warningf("invalid %s %q, subnet reclaim disabled", constants.IPAMAutoReclaimIdle, value)
+		return 0, min
+	}
+	return idle, min
+}
+
+// reclaimIdleBlocks returns the subnets of namespace which have been empty longer than
+// the idle period, keeping at least the configured minimum. The returned delay is the
+// time left before the next subnet becomes reclaimable.
+func (c *IPPoolController) reclaimIdleBlocks(name string, subnets []string, data map[string]string) ([]string, time.Duration, error) {
+	idle, min := getReclaimConf(data)
+	if idle <= 0 || len(subnets) <= min {
+		c.forgetEmptyBlocks(subnets)
+		return nil, 0, nil
+	}
+
+	c.emptyLock.Lock()
+	defer c.emptyLock.Unlock()
+
+	var (
+		reclaimed []string
+		delay     time.Duration
+	)
+	now := time.Now()
+	// walk subnets from the newest one, so that namespace keeps its first subnets
+	for i := len(subnets) - 1; i >= 0; i-- {
+		subnet := subnets[i]
+		blockObj, exists, err := c.ipamblockInformer.Informer().GetStore().GetByKey(subnet)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !exists || !blockObj.(*networkv1alpha1.IPAMBlock).Empty() {
+			delete(c.emptySince, subnet)
+			continue
+		}
+
+		since, ok := c.emptySince[subnet]
+		if !ok {
+			since = now
+			c.emptySince[subnet] = now
+		}
+		if left := idle - now.Sub(since); left > 0 {
+			if delay == 0 || left < delay {
+				delay = left
+			}
+			continue
+		}
+		if len(subnets)-len(reclaimed) <= min {
+			continue
+		}
+
+		// the cache may be stale, double check with apiserver before giving it back
+		block, err := c.client.NetworkV1alpha1().IPAMBlocks().Get(context.TODO(), subnet, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, 0, err
+		}
+		if !block.Empty() {
+			delete(c.emptySince, subnet)
+			continue
+		}
+		reclaimed = append(reclaimed, subnet)
+	}
+
+	// keep one idle subnet if the rest are full, otherwise it would be expanded again at once
+	for len(reclaimed) > 0 {
+		isFull, err := c.isBlocksEmpty(utils.RemoveStrings(subnets, reclaimed))
+		if err != nil {
+			return nil, 0, err
+		}
+		if !isFull {
+			break
+		}
+		reclaimed = reclaimed[:len(reclaimed)-1]
+	}
+
+	return reclaimed, delay, nil
+}
+
+func (c *IPPoolController) forgetEmptyBlocks(subnets []string) {
+	c.emptyLock.Lock()
+	defer c.emptyLock.Unlock()
+
+	for _, subnet := range subnets {
+		delete(c.emptySince, subnet)
+	}
+}
+
 func (c *IPPoolController) processNSItem() bool {
 	obj, quit := c.nsQueue.Get()
 	if quit {
@@ -543,13 +802,21 @@ func (c *IPPoolController) enqueueIPAMBlocks(obj interface{}) {
 		return
 	}
 
+	// the node claiming the block may be deleted while the controller is down
+	if node := block.AffinityNode(); node != "" {
+		if _, err := c.nodeInformer.Lister().Get(node); apierrors.IsNotFound(err) {
+			c.nodeQueue.Add(node)
+		}
+	}
+
 	// notify ippool controller to update status
 	c.ippoolQueue.Add(block.Labels[networkv1alpha1.IPPoolNameLabel])
 
-	// notify ns controller to update subnets
-	if block.NumFreeAddresses() == 0 {
+	// notify ns controller to expand subnets when the block is full, or to start counting
+	// its idle period as soon as it becomes empty
+	if block.NumFreeAddresses() == 0 || block.Empty() {
 		ns, err := c.getRelationNSFromBlock(block.Name)
-		klog.V(4).Infof("subnet %s has no free addresses: (%s, %v)", block.Name, ns, err)
+		klog.V(4).Infof("subnet %s is full or empty: (%s, %v)", block.Name, ns, err)
 		if err != nil || ns == "" {
 			return
 		}
@@ -558,6 +825,59 @@ func (c *IPPoolController) enqueueIPAMBlocks(obj interface{}) {
 	}
 }
 
+func (c *IPPoolController) enqueueDeletedNode(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("node informer returned non-node object: %#v", obj))
+		return
+	}
+
+	c.nodeQueue.Add(node.Name)
+}
+
+func (c *IPPoolController) processNode(name string) error {
+	if _, err := c.nodeInformer.Lister().Get(name); err == nil {
+		return nil
+	}
+	// the cache may be stale, double check with apiserver before releasing the blocks
+	_, err := c.k8sclient.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	klog.V(4).Infof("Node %s is deleted, release its blocks", name)
+	return c.provider.ReleaseAffinity(name)
+}
+
+func (c *IPPoolController) runNodeWorker() {
+	for c.processNodeItem() {
+	}
+}
+
+func (c *IPPoolController) processNodeItem() bool {
+	obj, quit := c.nodeQueue.Get()
+	if quit {
+		return false
+	}
+	defer c.nodeQueue.Done(obj)
+
+	err := c.processNode(obj.(string))
+	if err == nil {
+		c.nodeQueue.Forget(obj)
+		return true
+	}
+
+	c.nodeQueue.AddRateLimited(obj)
+	utilruntime.HandleError(fmt.Errorf("error processing node %v (will retry): %v", obj, err))
+	return true
+}
+
 func (c *IPPoolController) enqueueNamespace(obj interface{}) {
 	ns, ok := obj.(*corev1.Namespace)
 	if !ok {
@@ -587,6 +907,8 @@ func NewIPPoolController(
 		eventRecorder:    recorder,
 		ippoolQueue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ippool"),
 		nsQueue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ippool-ns"),
+		nodeQueue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ippool-node"),
+		emptySince:       make(map[string]time.Time),
 		k8sclient:        k8sclient,
 		client:           client,
 		provider:         provider,
@@ -597,6 +919,10 @@ func NewIPPoolController(
 	c.ipamblockSynced = c.ipamblockInformer.Informer().HasSynced
 	c.nsInformer = k8sInformers.Core().V1().Namespaces()
 	c.nsSynced = c.nsInformer.Informer().HasSynced
+	c.vxnetpoolInformer = informers.Network().V1alpha1().VxNetPools()
+	c.vxnetpoolSynced = c.vxnetpoolInformer.Informer().HasSynced
+	c.nodeInformer = k8sInformers.Core().V1().Nodes()
+	c.nodeSynced = c.nodeInformer.Informer().HasSynced
 
 	c.ippoolInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: c.enqueueIPPools,
@@ -623,5 +949,9 @@ func NewIPPoolController(
 		DeleteFunc: c.enqueueNamespace,
 	})
 
+	c.nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: c.enqueueDeletedNode,
+	})
+
 	return c
 }
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	"github.com/yunify/hostnic-cni/pkg/client/clientset/versioned/fake"
+	informers "github.com/yunify/hostnic-cni/pkg/client/informers/externalversions"
+	"github.com/yunify/hostnic-cni/pkg/constants"
+)
+
+// testBlock returns a block with used addresses allocated to pods and free addresses left
+func testBlock(name string, used, free int) *networkv1alpha1.IPAMBlock {
+	block := &networkv1alpha1.IPAMBlock{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	block.Spec.Attributes = []networkv1alpha1.AllocationAttribute{{AttrPrimary: "pod"}}
+	for i := 0; i < used; i++ {
+		attr := 0
+		block.Spec.Allocations = append(block.Spec.Allocations, &attr)
+	}
+	for i := 0; i < free; i++ {
+		block.Spec.Allocations = append(block.Spec.Allocations, nil)
+		block.Spec.Unallocated = append(block.Spec.Unallocated, used+i)
+	}
+	return block
+}
+
+func TestGetReclaimConf(t *testing.T) {
+	cases := []struct {
+		data     map[string]string
+		wantIdle time.Duration
+		wantMin  int
+	}{
+		{data: nil, wantIdle: 0, wantMin: constants.DefaultAutoReclaimMinSubnets},
+		{data: map[string]string{constants.IPAMAutoReclaimIdle: "10m", constants.IPAMAutoReclaimMinSubnets: "3"}, wantIdle: 10 * time.Minute, wantMin: 3},
+		{data: map[string]string{constants.IPAMAutoReclaimIdle: "ten minutes"}, wantIdle: 0, wantMin: constants.DefaultAutoReclaimMinSubnets},
+		{data: map[string]string{constants.IPAMAutoReclaimIdle: "-1m"}, wantIdle: 0, wantMin: constants.DefaultAutoReclaimMinSubnets},
+		{data: map[string]string{constants.IPAMAutoReclaimIdle: "1h", constants.IPAMAutoReclaimMinSubnets: "-2"}, wantIdle: time.Hour, wantMin: constants.DefaultAutoReclaimMinSubnets},
+	}
+	for _, c := range cases {
+		idle, min := getReclaimConf(c.data)
+		if idle != c.wantIdle || min != c.wantMin {
+			t.Errorf("getReclaimConf(%v) = %v, %d, want %v, %d", c.data, idle, min, c.wantIdle, c.wantMin)
+		}
+	}
+}
+
+func TestReclaimIdleBlocks(t *testing.T) {
+	conf := map[string]string{constants.IPAMAutoReclaimIdle: "10m"}
+	cases := []struct {
+		name    string
+		subnets []string
+		// blocks in informer cache, and in apiserver if not overridden by stored
+		blocks []*networkv1alpha1.IPAMBlock
+		stored []*networkv1alpha1.IPAMBlock
+		// idleFor is how long the subnets have been seen empty
+		idleFor map[string]time.Duration
+		conf    map[string]string
+
+		wantReclaimed []string
+		wantDelay     bool
+	}{
+		{
+			name:      "wait for the idle period",
+			subnets:   []string{"b1", "b2"},
+			blocks:    []*networkv1alpha1.IPAMBlock{testBlock("b1", 1, 2), testBlock("b2", 0, 3)},
+			idleFor:   map[string]time.Duration{"b2": 5 * time.Minute},
+			conf:      conf,
+			wantDelay: true,
+		},
+		{
+			name:          "reclaim after the idle period",
+			subnets:       []string{"b1", "b2"},
+			blocks:        []*networkv1alpha1.IPAMBlock{testBlock("b1", 1, 2), testBlock("b2", 0, 3)},
+			idleFor:       map[string]time.Duration{"b2": 11 * time.Minute},
+			conf:          conf,
+			wantReclaimed: []string{"b2"},
+		},
+		{
+			name:          "keep the first subnets of min",
+			subnets:       []string{"b1", "b2", "b3"},
+			blocks:        []*networkv1alpha1.IPAMBlock{testBlock("b1", 0, 3), testBlock("b2", 0, 3), testBlock("b3", 0, 3)},
+			idleFor:       map[string]time.Duration{"b1": time.Hour, "b2": time.Hour, "b3": time.Hour},
+			conf:          map[string]string{constants.IPAMAutoReclaimIdle: "10m", constants.IPAMAutoReclaimMinSubnets: "2"},
+			wantReclaimed: []string{"b3"},
+		},
+		{
+			name:    "never go below min",
+			subnets: []string{"b1", "b2"},
+			blocks:  []*networkv1alpha1.IPAMBlock{testBlock("b1", 0, 3), testBlock("b2", 0, 3)},
+			idleFor: map[string]time.Duration{"b1": time.Hour, "b2": time.Hour},
+			conf:    map[string]string{constants.IPAMAutoReclaimIdle: "10m", constants.IPAMAutoReclaimMinSubnets: "2"},
+		},
+		{
+			name:    "keep one idle subnet when the rest are full",
+			subnets: []string{"b1", "b2"},
+			blocks:  []*networkv1alpha1.IPAMBlock{testBlock("b1", 3, 0), testBlock("b2", 0, 3)},
+			idleFor: map[string]time.Duration{"b2": time.Hour},
+			conf:    conf,
+		},
+		{
+			name:    "subnet refilled before the apiserver check",
+			subnets: []string{"b1", "b2"},
+			blocks:  []*networkv1alpha1.IPAMBlock{testBlock("b1", 1, 2), testBlock("b2", 0, 3)},
+			stored:  []*networkv1alpha1.IPAMBlock{testBlock("b1", 1, 2), testBlock("b2", 1, 2)},
+			idleFor: map[string]time.Duration{"b2": time.Hour},
+			conf:    conf,
+		},
+		{
+			name:    "reclaim disabled",
+			subnets: []string{"b1", "b2"},
+			blocks:  []*networkv1alpha1.IPAMBlock{testBlock("b1", 1, 2), testBlock("b2", 0, 3)},
+			idleFor: map[string]time.Duration{"b2": time.Hour},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stored := tc.stored
+			if stored == nil {
+				stored = tc.blocks
+			}
+			var objects []runtime.Object
+			for _, block := range stored {
+				objects = append(objects, block)
+			}
+			client := fake.NewSimpleClientset(objects...)
+			blockInformer := informers.NewSharedInformerFactory(client, 0).Network().V1alpha1().IPAMBlocks()
+			for _, block := range tc.blocks {
+				if err := blockInformer.Informer().GetIndexer().Add(block); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := &IPPoolController{
+				ipamblockInformer: blockInformer,
+				emptySince:        make(map[string]time.Time),
+				client:            client,
+			}
+			now := time.Now()
+			for subnet, d := range tc.idleFor {
+				c.emptySince[subnet] = now.Add(-d)
+			}
+
+			reclaimed, delay, err := c.reclaimIdleBlocks("ns", tc.subnets, tc.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reclaimed)+len(tc.wantReclaimed) > 0 && !reflect.DeepEqual(reclaimed, tc.wantReclaimed) {
+				t.Errorf("got reclaimed %v, want %v", reclaimed, tc.wantReclaimed)
+			}
+			if tc.wantDelay && (delay <= 0 || delay > 5*time.Minute) {
+				t.Errorf("got delay %v, want the 5m left of idle period", delay)
+			}
+			if !tc.wantDelay && delay != 0 {
+				t.Errorf("got delay %v, want none", delay)
+			}
+		})
+	}
+}
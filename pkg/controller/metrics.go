@@ -0,0 +1,256 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinfomers "k8s.io/client-go/informers/core/v1"
+	k8sclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	informers "github.com/yunify/hostnic-cni/pkg/client/informers/externalversions"
+	networkInformer "github.com/yunify/hostnic-cni/pkg/client/informers/externalversions/network/v1alpha1"
+	"github.com/yunify/hostnic-cni/pkg/constants"
+)
+
+// IPAMMetrics exports the cluster level ipam utilization of vxnets, subnets and namespaces.
+// The figures are computed from informer caches on a schedule, scrapes only read the last result.
+type IPAMMetrics struct {
+	ippoolInformer    networkInformer.IPPoolInformer
+	ipamblockInformer networkInformer.IPAMBlockInformer
+	cmInformer        cache.SharedIndexInformer
+
+	vxnetAllocator       *prometheus.Desc
+	vxnetUnallocator     *prometheus.Desc
+	vxnetTotal           *prometheus.Desc
+	subnetAllocator      *prometheus.Desc
+	subnetUnallocator    *prometheus.Desc
+	subnetTotal          *prometheus.Desc
+	namespaceAllocator   *prometheus.Desc
+	namespaceUnallocator *prometheus.Desc
+	namespaceTotal       *prometheus.Desc
+
+	lock    sync.RWMutex
+	metrics []prometheus.Metric
+}
+
+func NewIPAMMetrics(k8sclient k8sclientset.Interface, informers informers.SharedInformerFactory) *IPAMMetrics {
+	return &IPAMMetrics{
+		ippoolInformer:    informers.Network().V1alpha1().IPPools(),
+		ipamblockInformer: informers.Network().V1alpha1().IPAMBlocks(),
+		// only watch the ipam configmap instead of all configmaps in cluster
+		cmInformer: coreinfomers.NewFilteredConfigMapInformer(k8sclient, constants.IPAMConfigNamespace, 30*time.Second,
+			cache.Indexers{}, func(options *metav1.ListOptions) {
+				options.FieldSelector = fmt.Sprintf("metadata.name=%s", constants.IPAMConfigName)
+			}),
+
+		vxnetAllocator: prometheus.NewDesc(
+			"hostnic_ipam_vxnet_allocator",
+			"describe vxnet ipam allocator in cluster with hostnic cni",
+			[]string{"vxnet_name"},
+			prometheus.Labels{},
+		),
+		vxnetUnallocator: prometheus.NewDesc(
+			"hostnic_ipam_vxnet_unallocator",
+			"describe vxnet ipam unallocator in cluster with hostnic cni",
+			[]string{"vxnet_name"},
+			prometheus.Labels{},
+		),
+		vxnetTotal: prometheus.NewDesc(
+			"hostnic_ipam_vxnet_total",
+			"describe vxnet ipam total in cluster with hostnic cni",
+			[]string{"vxnet_name"},
+			prometheus.Labels{},
+		),
+		subnetAllocator: prometheus.NewDesc(
+			"hostnic_ipam_subnet_allocator",
+			"describe subnet ipam allocator in cluster with hostnic cni",
+			[]string{"subnet_name"},
+			prometheus.Labels{},
+		),
+		subnetUnallocator: prometheus.NewDesc(
+			"hostnic_ipam_subnet_unallocator",
+			"describe subnet ipam unallocator in cluster with hostnic cni",
+			[]string{"subnet_name"},
+			prometheus.Labels{},
+		),
+		subnetTotal: prometheus.NewDesc(
+			"hostnic_ipam_subnet_total",
+			"describe subnet ipam total in cluster with hostnic cni",
+			[]string{"subnet_name"},
+			prometheus.Labels{},
+		),
+		namespaceAllocator: prometheus.NewDesc(
+			"hostnic_ipam_namespace_allocator",
+			"describe namespace ipam allocator in cluster with hostnic cni",
+			[]string{"ns_name"},
+			prometheus.Labels{},
+		),
+		namespaceUnallocator: prometheus.NewDesc(
+			"hostnic_ipam_namespace_unallocator",
+			"describe namespace ipam unallocator in cluster with hostnic cni",
+			[]string{"ns_name"},
+			prometheus.Labels{},
+		),
+		namespaceTotal: prometheus.NewDesc(
+			"hostnic_ipam_namespace_total",
+			"describe namespace ipam total in cluster with hostnic cni",
+			[]string{"ns_name"},
+			prometheus.Labels{},
+		),
+	}
+}
+
+// Run refreshes the metrics every interval until stopCh is closed
+func (m *IPAMMetrics) Run(interval time.Duration, stopCh <-chan struct{}) error {
+	go m.cmInformer.Run(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, m.ippoolInformer.Informer().HasSynced, m.ipamblockInformer.Informer().HasSynced, m.cmInformer.HasSynced) {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	wait.Until(m.refresh, interval, stopCh)
+	return nil
+}
+
+func (m *IPAMMetrics) refresh() {
+	metrics, err := m.generate()
+	if err != nil {
+		klog.Errorf("generate ipam metrics failed: %v", err)
+		return
+	}
+
+	m.lock.Lock()
+	m.metrics = metrics
+	m.lock.Unlock()
+}
+
+func (m *IPAMMetrics) generate() ([]prometheus.Metric, error) {
+	pools, err := m.ippoolInformer.Lister().List(labels.SelectorFromSet(labels.Set{
+		networkv1alpha1.IPPoolTypeLabel: networkv1alpha1.IPPoolTypeLocal,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	datas := m.getNamespaceSubnets()
+	var (
+		metrics    []prometheus.Metric
+		namespaces []string
+	)
+	allocMap := make(map[string]float64)
+	unallocMap := make(map[string]float64)
+	for _, pool := range pools {
+		capacity := pool.NumAddresses()
+		reserved := pool.NumReservedAddresses()
+		allocate := 0
+
+		blocks, err := m.ipamblockInformer.Lister().List(labels.SelectorFromSet(labels.Set{
+			networkv1alpha1.IPPoolNameLabel: pool.Name,
+		}))
+		if err != nil {
+			return nil, err
+		}
+		if len(blocks) > 0 {
+			reserved = 0
+		}
+		for _, block := range blocks {
+			blockCap := block.NumAddresses()
+			blockFree := block.NumFreeAddresses()
+			blockReserved := block.NumReservedAddresses()
+			blockAllocate := blockCap - blockFree - blockReserved
+			allocate += blockAllocate
+			reserved += blockReserved
+
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(m.subnetAllocator, prometheus.GaugeValue, float64(blockAllocate), block.Name),
+				prometheus.MustNewConstMetric(m.subnetUnallocator, prometheus.GaugeValue, float64(blockFree), block.Name),
+				prometheus.MustNewConstMetric(m.subnetTotal, prometheus.GaugeValue, float64(blockAllocate+blockFree), block.Name),
+			)
+
+			ns := getNamespaceByBlock(block.Name, datas)
+			if _, ok := allocMap[ns]; !ok {
+				namespaces = append(namespaces, ns)
+			}
+			allocMap[ns] += float64(blockAllocate)
+			unallocMap[ns] += float64(blockFree)
+		}
+
+		unallocate := capacity - allocate - reserved
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(m.vxnetAllocator, prometheus.GaugeValue, float64(allocate), pool.Name),
+			prometheus.MustNewConstMetric(m.vxnetUnallocator, prometheus.GaugeValue, float64(unallocate), pool.Name),
+			prometheus.MustNewConstMetric(m.vxnetTotal, prometheus.GaugeValue, float64(allocate+unallocate), pool.Name),
+		)
+	}
+
+	for _, ns := range namespaces {
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(m.namespaceAllocator, prometheus.GaugeValue, allocMap[ns], ns),
+			prometheus.MustNewConstMetric(m.namespaceUnallocator, prometheus.GaugeValue, unallocMap[ns], ns),
+			prometheus.MustNewConstMetric(m.namespaceTotal, prometheus.GaugeValue, allocMap[ns]+unallocMap[ns], ns),
+		)
+	}
+
+	return metrics, nil
+}
+
+// getNamespaceSubnets returns the subnets of namespaces recorded in ipam configmap
+func (m *IPAMMetrics) getNamespaceSubnets() map[string][]string {
+	obj, exists, err := m.cmInformer.GetStore().GetByKey(constants.IPAMConfigNamespace + "/" + constants.IPAMConfigName)
+	if err != nil || !exists {
+		klog.V(4).Infof("configmap %s not found: %v", constants.IPAMConfigName, err)
+		return nil
+	}
+
+	var datas map[string][]string
+	cm := obj.(*corev1.ConfigMap)
+	if err := json.Unmarshal([]byte(cm.Data[constants.IPAMConfigDate]), &datas); err != nil {
+		klog.Errorf("unmarshal ipam data failed: %v", err)
+		return nil
+	}
+	return datas
+}
+
+func (m *IPAMMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.vxnetAllocator
+	ch <- m.vxnetUnallocator
+	ch <- m.vxnetTotal
+	ch <- m.subnetAllocator
+	ch <- m.subnetUnallocator
+	ch <- m.subnetTotal
+	ch <- m.namespaceAllocator
+	ch <- m.namespaceUnallocator
+	ch <- m.namespaceTotal
+}
+
+func (m *IPAMMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, metric := range m.metrics {
+		ch <- metric
+	}
+}
+
+func getNamespaceByBlock(block string, datas map[string][]string) string {
+	for ns, subnets := range datas {
+		for _, subnet := range subnets {
+			if block == subnet {
+				return ns
+			}
+		}
+	}
+
+	// return a dummy name when no mapping rule found for this subnet
+	return constants.MetricsDummyNamespaceForSubnet
+}
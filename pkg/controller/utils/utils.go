@@ -70,3 +70,16 @@ func RemoveString(slice []string, s string, modifier func(s string) string) []st
 	}
 	return newSlice
 }
+
+// RemoveStrings returns a newly created []string that contains all items from slice that
+// are not in items.
+func RemoveStrings(slice []string, items []string) []string {
+	newSlice := make([]string, 0)
+	for _, item := range slice {
+		if ContainsString(items, item, nil) {
+			continue
+		}
+		newSlice = append(newSlice, item)
+	}
+	return newSlice
+}
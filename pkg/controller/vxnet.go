@@ -20,9 +20,11 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -32,9 +34,12 @@ import (
 	k8sinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	clientcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
 	clientset "github.com/yunify/hostnic-cni/pkg/client/clientset/versioned"
@@ -43,13 +48,19 @@ import (
 	networklisters "github.com/yunify/hostnic-cni/pkg/client/listers/network/v1alpha1"
 	"github.com/yunify/hostnic-cni/pkg/conf"
 	"github.com/yunify/hostnic-cni/pkg/constants"
+	"github.com/yunify/hostnic-cni/pkg/controller/utils"
 	"github.com/yunify/hostnic-cni/pkg/qcclient"
 	"github.com/yunify/hostnic-cni/pkg/rpc"
 	"github.com/yunify/hostnic-cni/pkg/simple/client/network/ippool/ipam"
 	"github.com/yunify/hostnic-cni/pkg/timer"
 )
 
-const controllerAgentName = "hostnic-controller"
+const (
+	controllerAgentName = "hostnic-controller"
+
+	// poolTeardownInterval is the interval to check the progress of releasing resources
+	poolTeardownInterval = 5 * time.Second
+)
 
 // Controller is the controller implementation for vxnetpool resources
 type VxNetPoolController struct {
@@ -76,6 +87,8 @@ type VxNetPoolController struct {
 	// simultaneously in two different workers.
 	workqueue workqueue.RateLimitingInterface
 
+	eventRecorder record.EventRecorder
+
 	// qingcloud cluster config
 	conf *conf.ClusterConfig
 	// qingcloud info
@@ -102,6 +115,11 @@ func NewVxNetPoolController(
 
 	utilruntime.Must(poolscheme.AddToScheme(scheme.Scheme))
 
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(klog.Infof)
+	broadcaster.StartRecordingToSink(&clientcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
+
 	ipamblocskInformer := informers.Network().V1alpha1().IPAMBlocks()
 	ippoolInformer := informers.Network().V1alpha1().IPPools()
 	vxnetpoolInformer := informers.Network().V1alpha1().VxNetPools()
@@ -117,6 +135,7 @@ func NewVxNetPoolController(
 		ipamblocksLister: ipamblocskInformer.Lister(),
 		ipamblocksSynced: ipamblocskInformer.Informer().HasSynced,
 		workqueue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerAgentName),
+		eventRecorder:    recorder,
 
 		// iaas
 		conf:       conf,
@@ -168,7 +187,12 @@ func (c *VxNetPoolController) Run(threadiness int, stopCh <-chan struct{}) error
 	// Start the informer factories to begin populating the informer caches
 	klog.Infof("Starting %s", controllerAgentName)
 
-	go c.timer.Run(stopCh)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.timer.Run(stopCh)
+	}()
 
 	// Wait for the caches to be synced before starting workers
 	klog.Info("Waiting for informer caches to sync")
@@ -179,13 +203,23 @@ func (c *VxNetPoolController) Run(threadiness int, stopCh <-chan struct{}) error
 	klog.Info("Starting workers")
 	// Launch two workers to process resources
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(c.runWorker, time.Second, stopCh)
+		}()
 	}
 
 	klog.Info("Started workers")
 	<-stopCh
 	klog.Info("Shutting down workers")
 
+	// workers exit after the items left in workqueue are processed,
+	// wait for them and the running qingCloudSync to finish.
+	c.workqueue.ShutDown()
+	wg.Wait()
+	klog.Info("Workers and timer stopped")
+
 	return nil
 }
 
@@ -255,11 +289,6 @@ func (c *VxNetPoolController) processNextWorkItem() bool {
 // converge the two. It then updates the Status block of the resource
 // with the current status of the resource.
 func (c *VxNetPoolController) syncHandler(name string) error {
-	if name != constants.IPAMVxnetPoolName {
-		klog.V(3).Infof("Don't care about %s", name)
-		return nil
-	}
-
 	pool, err := c.poolsLister.Get(name)
 	if err != nil {
 		// The resource may no longer exist, in which case we stop processing.
@@ -269,31 +298,46 @@ func (c *VxNetPoolController) syncHandler(name string) error {
 		}
 		return err
 	}
-	if pool.DeletionTimestamp != nil {
-		klog.V(3).Infof("Graceful delete vxnetpool %s", name)
-		return nil
+	if utils.NeedToAddFinalizer(pool, networkv1alpha1.VxNetPoolFinalizer) {
+		return c.addFinalizer(pool)
 	}
 
-	// takeup QCloud resource
-	if ok, err := c.prepareQcloudResource(pool); err != nil {
+	state := newPoolSyncState(pool)
+
+	if utils.IsDeletionCandidate(pool, networkv1alpha1.VxNetPoolFinalizer) {
+		klog.V(3).Infof("Graceful delete vxnetpool %s", name)
+		done, err := c.teardownPool(pool, state)
+		if err == nil && done {
+			return c.removeFinalizer(pool)
+		}
+		if updateErr := c.updatePoolStatus(pool, state, false); updateErr != nil {
+			klog.Errorf("update status of vxnetpool %s failed: %v", name, updateErr)
+		}
+		if err == nil {
+			c.workqueue.AddAfter(name, poolTeardownInterval)
+		}
 		return err
-	} else if !ok {
-		return c.updatePoolStatus(pool, false)
+	}
+	if pool.DeletionTimestamp != nil {
+		return nil
 	}
 
-	// allocator k8s resource
-	if ok, err := c.prepareK8SResource(pool); err != nil {
-		return err
-	} else if !ok {
-		return c.updatePoolStatus(pool, false)
+	// takeup QCloud resource
+	ok, err := c.prepareQcloudResource(pool, state)
+	if ok && err == nil {
+		// allocator k8s resource
+		ok, err = c.prepareK8SResource(pool, state)
 	}
 
-	err = c.updatePoolStatus(pool, true)
-	if err != nil {
-		return err
+	if updateErr := c.updatePoolStatus(pool, state, ok && err == nil); updateErr != nil {
+		if err != nil {
+			klog.Errorf("update status of vxnetpool %s failed: %v", name, updateErr)
+			return err
+		}
+		return updateErr
 	}
 
-	return nil
+	return err
 }
 
 func (c *VxNetPoolController) getPools(pool *networkv1alpha1.VxNetPool) ([]networkv1alpha1.PoolInfo, error) {
@@ -326,21 +370,41 @@ func (c *VxNetPoolController) getPools(pool *networkv1alpha1.VxNetPool) ([]netwo
 	return pools, nil
 }
 
-func (c *VxNetPoolController) updatePoolStatus(pool *networkv1alpha1.VxNetPool, ready bool) error {
+func (c *VxNetPoolController) updatePoolStatus(pool *networkv1alpha1.VxNetPool, state *poolSyncState, ready bool) error {
 	pools, err := c.getPools(pool)
 	if err != nil {
 		return err
 	}
-	if reflect.DeepEqual(pool.Status.Pools, pools) && pool.Status.Ready == ready {
+	for i := range pools {
+		pools[i].Message = state.vxnets[pools[i].Name]
+	}
+
+	status := state.status
+	status.Ready = ready
+	status.Pools = pools
+	if reflect.DeepEqual(pool.Status, *status) {
 		return nil
 	}
 
 	poolCopy := pool.DeepCopy()
-	poolCopy.Status.Ready = ready
-	poolCopy.Status.Pools = pools
+	poolCopy.Status = *status
 
 	_, err = c.clientset.NetworkV1alpha1().VxNetPools().UpdateStatus(context.TODO(), poolCopy, metav1.UpdateOptions{})
-	return err
+	if err != nil {
+		return err
+	}
+
+	recordConditionEvents(c.eventRecorder, pool, pool.Status.Conditions, status.Conditions, vxnetPoolAbnormalConditions)
+	return nil
+}
+
+// vxnetPoolAbnormalConditions are reported as warning events
+var vxnetPoolAbnormalConditions = map[string]metav1.ConditionStatus{
+	networkv1alpha1.VxNetPoolConditionVxNetsSynced:       metav1.ConditionFalse,
+	networkv1alpha1.VxNetPoolConditionVIPsReady:          metav1.ConditionFalse,
+	networkv1alpha1.VxNetPoolConditionSecurityGroupReady: metav1.ConditionFalse,
+	networkv1alpha1.VxNetPoolConditionIPPoolsCreated:     metav1.ConditionFalse,
+	networkv1alpha1.VxNetPoolConditionBlocksGenerated:    metav1.ConditionFalse,
 }
 
 func (c *VxNetPoolController) enqueuePool(obj interface{}) {
@@ -353,16 +417,11 @@ func (c *VxNetPoolController) enqueuePool(obj interface{}) {
 	c.workqueue.Add(key)
 }
 
-// clearVxnet try to clear the related resources with the vxnet deleted from vxnetpool named v-pool
+// clearVxnet try to clear the related resources with the vxnet deleted from vxnetpool
 // clear related resources if this vxnet was not being used by pod (ippool.status.allocations == 0)
 // 1. release vip
 // 2. delete ippool
 func (c *VxNetPoolController) clearVxnet(old, new *networkv1alpha1.VxNetPool) {
-	if new.Name != constants.IPAMVxnetPoolName {
-		klog.V(3).Infof("Don't care about %s", new.Name)
-		return
-	}
-
 	newVxnetsMap := make(map[string]bool)
 	for _, newVxnet := range new.Spec.Vxnets {
 		newVxnetsMap[newVxnet.Name] = true
@@ -376,6 +435,11 @@ func (c *VxNetPoolController) clearVxnet(old, new *networkv1alpha1.VxNetPool) {
 				continue
 			}
 
+			if owner := getIPPoolOwner(ippool); owner != new.Name {
+				klog.Infof("vxnet %s was being deleted from vxnetpool %s, but ippool was owned by vxnetpool %s, skip clear vip and ippool!", oldVxnet.Name, new.Name, owner)
+				continue
+			}
+
 			if ippool.Status.Allocations > 0 {
 				klog.Infof("vxnet %s was being deleted from vxnetpool, but this vxnet was already used by some pods, skip clear vip and ippool!", oldVxnet.Name)
 				continue
@@ -434,25 +498,248 @@ func (c *VxNetPoolController) handleObject(obj interface{}) {
 		c.enqueuePool(pool)
 		return
 	}
+
+	if name, ok := object.GetLabels()[networkv1alpha1.VxNetPoolNameLabel]; ok {
+		pool, err := c.poolsLister.Get(name)
+		if err != nil {
+			klog.V(4).Infof("ignoring orphaned object '%s' of pool '%s'", object.GetName(), name)
+			return
+		}
+
+		c.enqueuePool(pool)
+	}
+}
+
+func (c *VxNetPoolController) addFinalizer(pool *networkv1alpha1.VxNetPool) error {
+	clone := pool.DeepCopy()
+	controllerutil.AddFinalizer(clone, networkv1alpha1.VxNetPoolFinalizer)
+	_, err := c.clientset.NetworkV1alpha1().VxNetPools().Update(context.TODO(), clone, metav1.UpdateOptions{})
+	if err != nil {
+		klog.V(3).Infof("Error adding finalizer to vxnetpool %s: %v", pool.Name, err)
+		return err
+	}
+	klog.V(3).Infof("Added finalizer to vxnetpool %s", pool.Name)
+	return nil
+}
+
+func (c *VxNetPoolController) removeFinalizer(pool *networkv1alpha1.VxNetPool) error {
+	clone := pool.DeepCopy()
+	controllerutil.RemoveFinalizer(clone, networkv1alpha1.VxNetPoolFinalizer)
+	_, err := c.clientset.NetworkV1alpha1().VxNetPools().Update(context.TODO(), clone, metav1.UpdateOptions{})
+	if err != nil {
+		klog.V(3).Infof("Error removing finalizer from vxnetpool %s: %v", pool.Name, err)
+		return err
+	}
+	klog.V(3).Infof("Removed finalizer from vxnetpool %s", pool.Name)
+	c.eventRecorder.Event(pool, corev1.EventTypeNormal, "ResourcesReleased", "all resources of vxnetpool are released")
+	return nil
+}
+
+// getReleasableVxNets returns the vxnets whose resources could be released with the pool,
+// vxnets which are still used by other vxnetpools are skipped.
+func (c *VxNetPoolController) getReleasableVxNets(pool *networkv1alpha1.VxNetPool) ([]string, error) {
+	pools, err := c.poolsLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var vxnets []string
+	for _, vxnet := range pool.Spec.Vxnets {
+		ippool, err := c.ippoolsLister.Get(vxnet.Name)
+		if err == nil {
+			if owner := getIPPoolOwner(ippool); owner != pool.Name {
+				klog.V(3).Infof("ippool %s is owned by vxnetpool %s, skip releasing vxnet %s", ippool.Name, owner, vxnet.Name)
+				continue
+			}
+		} else if !errors.IsNotFound(err) {
+			return nil, err
+		}
+
+		shared := false
+		for _, p := range pools {
+			if p.Name != pool.Name && p.DeletionTimestamp == nil && p.HasVxNet(vxnet.Name) {
+				shared = true
+				break
+			}
+		}
+		if shared {
+			klog.V(3).Infof("vxnet %s is used by other vxnetpool, skip releasing it", vxnet.Name)
+			continue
+		}
+		vxnets = append(vxnets, vxnet.Name)
+	}
+
+	return vxnets, nil
+}
+
+// teardownPool releases the resources of a deleting vxnetpool step by step:
+// 1. refuse while pods still hold addresses from its ippools
+// 2. delete ippools, blocks are deleted along with them by ippool controller
+// 3. release vips
+// 4. remove security group rules
+// It returns true once everything is released and the finalizer could be removed.
+func (c *VxNetPoolController) teardownPool(pool *networkv1alpha1.VxNetPool, state *poolSyncState) (bool, error) {
+	vxnets, err := c.getReleasableVxNets(pool)
+	if err != nil {
+		return false, err
+	}
+
+	// 1. check addresses in use
+	for _, name := range vxnets {
+		ippool, err := c.ippoolsLister.Get(name)
+		if err != nil {
+			continue
+		}
+		if ippool.Status.Allocations > 0 {
+			state.wait(name, fmt.Sprintf("%d addresses are still in use", ippool.Status.Allocations))
+		}
+	}
+	if !state.releasing("AddressesInUse") {
+		return false, nil
+	}
+
+	// 2. delete ippools
+	for _, name := range vxnets {
+		ippool, err := c.ippoolsLister.Get(name)
+		if err != nil {
+			continue
+		}
+		if ippool.DeletionTimestamp == nil {
+			err = c.clientset.NetworkV1alpha1().IPPools().Delete(context.TODO(), name, metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				state.wait(name, err.Error())
+				state.releasing("DeleteIPPoolFailed")
+				return false, err
+			}
+			klog.Infof("delete ippool %s of vxnetpool %s", name, pool.Name)
+		}
+		state.wait(name, "waiting for ippool and its blocks to be deleted")
+	}
+	if !state.releasing("DeletingIPPools") {
+		return false, nil
+	}
+
+	// 3. release vips
+	var qcVxNets []*rpc.VxNet
+	for _, name := range vxnets {
+		vxnet, ok := c.getVxNetInfo(name)
+		if !ok {
+			result, err := qcclient.QClient.GetVxNets([]string{name}, pool.Spec.CustomReservedIPCount)
+			if err != nil {
+				state.wait(name, err.Error())
+				state.releasing("GetVxNetFailed")
+				return false, err
+			}
+			if vxnet, ok = result[name]; !ok {
+				klog.Infof("VxNet %s: not found, skip releasing its resources", name)
+				continue
+			}
+		}
+		qcVxNets = append(qcVxNets, vxnet)
+
+		jobKey := keyForVxNetVIP(vxnet.ID)
+		if job, ok := c.runningJob(state, jobKey); ok {
+			state.wait(name, fmt.Sprintf("waiting for job %s to delete vips", job))
+			continue
+		}
+		vips, err := qcclient.QClient.DescribeVIPs(vxnet)
+		if err != nil {
+			state.wait(name, err.Error())
+			state.releasing("DescribeVIPsFailed")
+			return false, err
+		}
+		if len(vips) == 0 {
+			continue
+		}
+		var vipsToDel []string
+		for _, vip := range vips {
+			vipsToDel = append(vipsToDel, vip.ID)
+		}
+		if job, err := qcclient.QClient.DeleteVIPs(vipsToDel); err != nil {
+			klog.Errorf("Clear vips for VxNet %s failed: %v, will retry", vxnet.ID, err)
+			state.wait(name, fmt.Sprintf("failed to delete %d vips: %v", len(vips), err))
+		} else {
+			klog.Infof("Clear vips for VxNet %s: count %d, job id %s", vxnet.ID, len(vips), job)
+			state.setJob(jobKey, job)
+			state.wait(name, fmt.Sprintf("waiting for job %s to delete %d vips", job, len(vips)))
+		}
+	}
+	if !state.releasing("ReleasingVIPs") {
+		return false, nil
+	}
+
+	// 4. remove security group rules
+	if sg := c.getSecurityGroup(pool); sg != "" {
+		for _, vxnet := range qcVxNets {
+			jobKey := keyForVxNetSG(sg, vxnet.ID)
+			if job, ok := c.runningJob(state, jobKey); ok {
+				state.wait(vxnet.ID, fmt.Sprintf("waiting for job %s to remove rule from %s", job, sg))
+				continue
+			}
+			rule, err := qcclient.QClient.GetSecurityGroupRuleForVxNet(sg, vxnet)
+			if err != nil {
+				state.wait(vxnet.ID, err.Error())
+				state.releasing("DescribeSecurityGroupRulesFailed")
+				return false, err
+			}
+			if rule == nil {
+				continue
+			}
+			if job, err := qcclient.QClient.DeleteSecurityGroupRuleForVxNet(sg, rule.ID); err != nil {
+				klog.Errorf("Remove SecurityGroupRule %s for vxnet %s failed: %v, will retry", rule.ID, vxnet.ID, err)
+				state.wait(vxnet.ID, fmt.Sprintf("failed to remove rule %s from %s: %v", rule.ID, sg, err))
+			} else {
+				klog.Infof("Remove SecurityGroupRule %s for vxnet %s: job id %s", rule.ID, vxnet.ID, job)
+				state.setJob(jobKey, job)
+				state.wait(vxnet.ID, fmt.Sprintf("waiting for job %s to remove rule %s from %s", job, rule.ID, sg))
+			}
+		}
+		if !state.releasing("RemovingSecurityGroupRules") {
+			return false, nil
+		}
+	}
+
+	for _, vxnet := range qcVxNets {
+		c.forgetVxNet(vxnet.ID)
+	}
+	return true, nil
+}
+
+// getIPPoolOwner returns the vxnetpool which generates the ippool, ippools created
+// before multiple vxnetpools are supported have no label and belong to v-pool.
+func getIPPoolOwner(ippool *networkv1alpha1.IPPool) string {
+	if name, ok := ippool.Labels[networkv1alpha1.VxNetPoolNameLabel]; ok {
+		return name
+	}
+	return constants.IPAMVxnetPoolName
+}
+
+// getSecurityGroup returns the security group for vxnets in pool
+func (c *VxNetPoolController) getSecurityGroup(pool *networkv1alpha1.VxNetPool) string {
+	if pool.Spec.SecurityGroup != "" {
+		return pool.Spec.SecurityGroup
+	}
+	return c.conf.SecurityGroup
 }
 
 // newIPPool creates a new IPPool for a vxnet resource. It also sets
-// the appropriate OwnerReferences on the resource so handleObject can discover
-// the vxnet resource that 'owns' it.
-func (c *VxNetPoolController) createIPPool(name string, blockSize int, customReservedIPCount int64, vxnet *rpc.VxNet) (*networkv1alpha1.IPPool, error) {
+// the vxnetpool label on the resource so handleObject can discover
+// the vxnetpool resource that 'owns' it.
+func (c *VxNetPoolController) createIPPool(pool *networkv1alpha1.VxNetPool, name string, vxnet *rpc.VxNet) (*networkv1alpha1.IPPool, error) {
 	ippool := &networkv1alpha1.IPPool{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: name,
 			Labels: map[string]string{
-				"controller": name,
+				"controller":                       name,
+				networkv1alpha1.VxNetPoolNameLabel: pool.Name,
 			},
 		},
 		Spec: networkv1alpha1.IPPoolSpec{
 			Type:                  networkv1alpha1.IPPoolTypeLocal,
 			CIDR:                  vxnet.Network,
 			Gateway:               vxnet.Gateway,
-			BlockSize:             blockSize,
-			CustomReservedIPCount: customReservedIPCount,
+			BlockSize:             pool.Spec.BlockSize,
+			CustomReservedIPCount: pool.Spec.CustomReservedIPCount,
 			RangeStart:            vxnet.IPStart,
 			RangeEnd:              vxnet.IPEnd,
 		},
@@ -475,84 +762,118 @@ func keyForVxNetSG(sg, vxnet string) string {
 	return "SG|" + sg + "/" + vxnet
 }
 
-func (c *VxNetPoolController) prepareQcloudResource(pool *networkv1alpha1.VxNetPool) (bool, error) {
+func (c *VxNetPoolController) prepareQcloudResource(pool *networkv1alpha1.VxNetPool, state *poolSyncState) (bool, error) {
 	// 1. check vxnet
 	for _, vxnet := range pool.Spec.Vxnets {
 		if _, ok := c.getVxNetInfo(vxnet.Name); !ok {
 			klog.Warningf("vxnet %s not sync", vxnet.Name)
-			return false, nil
+			state.wait(vxnet.Name, "not synced from QingCloud")
 		}
 	}
+	if !state.check(networkv1alpha1.VxNetPoolConditionVxNetsSynced, "VxNetsNotSynced") {
+		return false, nil
+	}
 
 	// 2. check and pre-Allocator vips
 	for _, vxnet := range pool.Spec.Vxnets {
-		if _, ok := c.getVxNetVIPInfo(vxnet.Name); !ok {
-			klog.Warningf("vips for vxnet %s not ready", vxnet.Name)
-			v, _ := c.getVxNetInfo(vxnet.Name)
-			if job, ok := c.getJob(keyForVxNetVIP(v.ID)); ok {
-				klog.Warningf("vips for vxnet %s: wait job %s", vxnet.Name, job)
-				return false, nil
-			}
-			if job, err := qcclient.QClient.CreateVIPs(v); err != nil {
-				return false, err
-			} else {
-				c.setJob(keyForVxNetVIP(v.ID), job)
-				klog.V(3).Infof("CreateVIPs for vxnet %s: %s", vxnet.Name, job)
-			}
-			return false, nil
+		if _, ok := c.getVxNetVIPInfo(vxnet.Name); ok {
+			continue
 		}
+		klog.Warningf("vips for vxnet %s not ready", vxnet.Name)
+		v, _ := c.getVxNetInfo(vxnet.Name)
+		if job, ok := c.getJob(keyForVxNetVIP(v.ID)); ok {
+			klog.Warningf("vips for vxnet %s: wait job %s", vxnet.Name, job)
+			state.wait(vxnet.Name, fmt.Sprintf("waiting for job %s to create vips", job))
+			continue
+		}
+		if job, err := qcclient.QClient.CreateVIPs(v); err != nil {
+			state.fail(networkv1alpha1.VxNetPoolConditionVIPsReady, "CreateVIPsFailed", vxnet.Name, err)
+			return false, err
+		} else {
+			c.setJob(keyForVxNetVIP(v.ID), job)
+			klog.V(3).Infof("CreateVIPs for vxnet %s: %s", vxnet.Name, job)
+			state.wait(vxnet.Name, fmt.Sprintf("waiting for job %s to create vips", job))
+		}
+	}
+	if !state.check(networkv1alpha1.VxNetPoolConditionVIPsReady, "WaitingForVIPs") {
+		return false, nil
 	}
 
 	// 3. check and add security-group for vxnet
-	if sg := c.conf.SecurityGroup; sg != "" {
+	if sg := c.getSecurityGroup(pool); sg != "" {
 		for _, vxnet := range pool.Spec.Vxnets {
 			id := keyForVxNetSG(sg, vxnet.Name)
-			if _, ok := c.getSecurityGroupRule(id); !ok {
-				klog.Warningf("SecurityGroupRule for vxnet %s not ready", vxnet.Name)
-				if job, ok := c.getJob(id); ok {
-					klog.Warningf("sg for vxnet %s: wait job %s", vxnet.Name, job)
-					return false, nil
-				}
-				v, _ := c.getVxNetInfo(vxnet.Name)
-				if job, err := qcclient.QClient.CreateSecurityGroupRuleForVxNet(sg, v); err != nil {
-					return false, err
-				} else {
-					c.setJob(id, job)
-					klog.V(3).Infof("CreateSecurityGroupRuleForVxNet for vxnet %s: %s", vxnet.Name, job)
-				}
-				return false, nil
+			if _, ok := c.getSecurityGroupRule(id); ok {
+				continue
+			}
+			klog.Warningf("SecurityGroupRule for vxnet %s not ready", vxnet.Name)
+			if job, ok := c.getJob(id); ok {
+				klog.Warningf("sg for vxnet %s: wait job %s", vxnet.Name, job)
+				state.wait(vxnet.Name, fmt.Sprintf("waiting for job %s to add rule to %s", job, sg))
+				continue
+			}
+			v, _ := c.getVxNetInfo(vxnet.Name)
+			if job, err := qcclient.QClient.CreateSecurityGroupRuleForVxNet(sg, v); err != nil {
+				state.fail(networkv1alpha1.VxNetPoolConditionSecurityGroupReady, "CreateSecurityGroupRuleFailed", vxnet.Name, err)
+				return false, err
+			} else {
+				c.setJob(id, job)
+				klog.V(3).Infof("CreateSecurityGroupRuleForVxNet for vxnet %s: %s", vxnet.Name, job)
+				state.wait(vxnet.Name, fmt.Sprintf("waiting for job %s to add rule to %s", job, sg))
 			}
 		}
+		if !state.check(networkv1alpha1.VxNetPoolConditionSecurityGroupReady, "WaitingForSecurityGroupRules") {
+			return false, nil
+		}
+	} else {
+		state.setCondition(networkv1alpha1.VxNetPoolConditionSecurityGroupReady, metav1.ConditionTrue, "NoSecurityGroup", "no security group configured")
 	}
 
 	return true, nil
 }
 
 // create ippool and ipamblock
-func (c *VxNetPoolController) prepareK8SResource(pool *networkv1alpha1.VxNetPool) (bool, error) {
+func (c *VxNetPoolController) prepareK8SResource(pool *networkv1alpha1.VxNetPool, state *poolSyncState) (bool, error) {
+	var ippools []*networkv1alpha1.IPPool
+	reason := "WaitingForIPPools"
 	for _, vxnet := range pool.Spec.Vxnets {
 		ippool, err := c.ippoolsLister.Get(vxnet.Name)
 		if err != nil {
-			if errors.IsNotFound(err) {
-				// create ippool
-				if v, ok := c.getVxNetInfo(vxnet.Name); ok {
-					if _, err := c.createIPPool(vxnet.Name, pool.Spec.BlockSize, pool.Spec.CustomReservedIPCount, v); err != nil {
-						return false, err
-					} else {
-						// handle it's block at next event
-						return false, nil
-					}
-				} else {
-					return false, nil
-				}
+			if !errors.IsNotFound(err) {
+				state.fail(networkv1alpha1.VxNetPoolConditionIPPoolsCreated, "GetIPPoolFailed", vxnet.Name, err)
+				return false, err
 			}
-			return false, err
+			// create ippool
+			v, _ := c.getVxNetInfo(vxnet.Name)
+			if _, err := c.createIPPool(pool, vxnet.Name, v); err != nil {
+				state.fail(networkv1alpha1.VxNetPoolConditionIPPoolsCreated, "CreateIPPoolFailed", vxnet.Name, err)
+				return false, err
+			}
+			// handle it's block at next event
+			state.wait(vxnet.Name, "ippool created, waiting for it to be synced")
+			continue
+		}
+		if owner := getIPPoolOwner(ippool); owner != pool.Name {
+			klog.Warningf("ippool %s for vxnet %s is owned by vxnetpool %s, skip it", ippool.Name, vxnet.Name, owner)
+			c.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "IPPoolConflict", "ippool %s for vxnet %s is owned by vxnetpool %s", ippool.Name, vxnet.Name, owner)
+			state.wait(vxnet.Name, fmt.Sprintf("ippool %s is owned by vxnetpool %s", ippool.Name, owner))
+			reason = "IPPoolConflict"
+			continue
 		}
-		// prepare blocks for ippool
+		ippools = append(ippools, ippool)
+	}
+	if !state.check(networkv1alpha1.VxNetPoolConditionIPPoolsCreated, reason) {
+		return false, nil
+	}
+
+	// prepare blocks for ippool
+	for _, ippool := range ippools {
 		if err := c.createBlocksFromIPPool(ippool); err != nil {
+			state.fail(networkv1alpha1.VxNetPoolConditionBlocksGenerated, "GenerateBlocksFailed", ippool.Name, err)
 			return false, err
 		}
 	}
+	state.check(networkv1alpha1.VxNetPoolConditionBlocksGenerated, "")
 	return true, nil
 }
 
@@ -623,6 +944,41 @@ func (c *VxNetPoolController) setJob(id, job string) {
 	c.jobs[id] = job
 }
 
+// runningJob returns the release job recorded in status for key if it is still running. Finished jobs
+// are forgotten, so that the resource is checked again, and submitted again if the job failed.
+func (c *VxNetPoolController) runningJob(state *poolSyncState, key string) (string, bool) {
+	job, ok := state.status.Jobs[key]
+	if !ok {
+		return "", false
+	}
+	status, err := qcclient.QClient.GetJobStatus(job)
+	if err != nil {
+		// keep waiting rather than submitting a duplicate job
+		klog.Warningf("get status of job %s for %s failed: %v", job, key, err)
+		return job, true
+	}
+	if status != qcclient.JobStatusSuccessful && status != qcclient.JobStatusFailed {
+		return job, true
+	}
+	klog.Infof("job %s for %s finished: %s", job, key, status)
+	delete(state.status.Jobs, key)
+	return "", false
+}
+
+// forgetVxNet drops all the cached qingcloud resources of vxnet
+func (c *VxNetPoolController) forgetVxNet(vxnet string) {
+	c.rwLock.Lock()
+	defer c.rwLock.Unlock()
+
+	delete(c.vxNetCache, vxnet)
+	delete(c.vipCache, vxnet)
+	for id := range c.sgCache {
+		if strings.HasSuffix(id, "/"+vxnet) {
+			delete(c.sgCache, id)
+		}
+	}
+}
+
 func (c *VxNetPoolController) deleteVIPsByVxnetID(vxnetID string) {
 	vxnets, err := qcclient.QClient.GetVxNets([]string{vxnetID}, 0)
 	if err != nil {
@@ -668,23 +1024,29 @@ func (c *VxNetPoolController) qingCloudSync() {
 	defer klog.V(4).Infof("qingCloudSync: end")
 
 	var change bool
-	pool, err := c.poolsLister.Get(constants.IPAMVxnetPoolName)
+	pools, err := c.poolsLister.List(labels.Everything())
 	if err != nil {
-		klog.Errorf("Get VxNetPool failed: %v", err)
+		klog.Errorf("List VxNetPools failed: %v", err)
 		return
 	}
 
 	// 1. update vxnets
-	var needUpdate []string
-	for _, vxnet := range pool.Spec.Vxnets {
-		if _, ok := c.getVxNetInfo(vxnet.Name); !ok {
-			needUpdate = append(needUpdate, vxnet.Name)
+	for _, pool := range pools {
+		if pool.DeletionTimestamp != nil {
+			continue
+		}
+		var needUpdate []string
+		for _, vxnet := range pool.Spec.Vxnets {
+			if _, ok := c.getVxNetInfo(vxnet.Name); !ok {
+				needUpdate = append(needUpdate, vxnet.Name)
+			}
+		}
+		if len(needUpdate) == 0 {
+			continue
 		}
-	}
-	if len(needUpdate) > 0 {
 		if result, err := qcclient.QClient.GetVxNets(needUpdate, pool.Spec.CustomReservedIPCount); err != nil {
-			klog.Errorf("Get vxnet %v from QingCloud failed: %v", needUpdate, err)
-			return
+			klog.Errorf("Get vxnet %v of VxNetPool %s from QingCloud failed: %v", needUpdate, pool.Name, err)
+			continue
 		} else {
 			for k, v := range result {
 				c.setVxNetInfo(k, v)
@@ -731,21 +1093,29 @@ func (c *VxNetPoolController) qingCloudSync() {
 			c.conf.SecurityGroup = result
 		}
 	}
-	if c.conf.SecurityGroup != "" {
-		for _, vxnet := range c.vxNetCache {
-			id := keyForVxNetSG(c.conf.SecurityGroup, vxnet.ID)
+	for _, pool := range pools {
+		sg := c.getSecurityGroup(pool)
+		if sg == "" || pool.DeletionTimestamp != nil {
+			continue
+		}
+		for _, v := range pool.Spec.Vxnets {
+			vxnet, ok := c.getVxNetInfo(v.Name)
+			if !ok {
+				continue
+			}
+			id := keyForVxNetSG(sg, vxnet.ID)
 			if _, ok := c.getSecurityGroupRule(id); !ok {
-				if result, err := qcclient.QClient.GetSecurityGroupRuleForVxNet(c.conf.SecurityGroup, vxnet); err != nil {
-					klog.Errorf("Get SecurityGroupRule for vxnet %s in %s from QingCloud failed: %v", vxnet.ID, c.conf.SecurityGroup, err)
+				if result, err := qcclient.QClient.GetSecurityGroupRuleForVxNet(sg, vxnet); err != nil {
+					klog.Errorf("Get SecurityGroupRule for vxnet %s in %s from QingCloud failed: %v", vxnet.ID, sg, err)
 					return
 				} else {
 					if result != nil {
 						c.setSecurityGroupRule(id, result)
 						c.deleteJob(id)
-						klog.V(4).Infof("Get SecurityGroupRule for vxnet %s in %s from QingCloud: %v", vxnet.ID, c.conf.SecurityGroup, result)
+						klog.V(4).Infof("Get SecurityGroupRule for vxnet %s in %s from QingCloud: %v", vxnet.ID, sg, result)
 						change = true
 					} else {
-						klog.Warningf("Get SecurityGroupRule for vxnet %s in %s from QingCloud failed: not prepare", vxnet.ID, c.conf.SecurityGroup)
+						klog.Warningf("Get SecurityGroupRule for vxnet %s in %s from QingCloud failed: not prepare", vxnet.ID, sg)
 					}
 				}
 			}
@@ -754,6 +1124,8 @@ func (c *VxNetPoolController) qingCloudSync() {
 
 	// 4. sync to controller
 	if change {
-		c.enqueuePool(pool)
+		for _, pool := range pools {
+			c.enqueuePool(pool)
+		}
 	}
 }
@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	"github.com/yunify/hostnic-cni/pkg/client/clientset/versioned/fake"
+	informers "github.com/yunify/hostnic-cni/pkg/client/informers/externalversions"
+	"github.com/yunify/hostnic-cni/pkg/conf"
+	"github.com/yunify/hostnic-cni/pkg/qcclient"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+)
+
+// fakeQingCloud records the calls releasing the resources of vxnets
+type fakeQingCloud struct {
+	qcclient.QingCloudAPI
+	calls     *[]string
+	vips      []*rpc.VIP
+	sgRule    *rpc.SecurityGroupRule
+	jobStatus string
+}
+
+func (f *fakeQingCloud) GetVxNets(ids []string, customReservedIPCount int64) (map[string]*rpc.VxNet, error) {
+	return map[string]*rpc.VxNet{ids[0]: {ID: ids[0]}}, nil
+}
+
+func (f *fakeQingCloud) GetJobStatus(id string) (string, error) {
+	*f.calls = append(*f.calls, "GetJobStatus "+id)
+	return f.jobStatus, nil
+}
+
+func (f *fakeQingCloud) DescribeVIPs(vxnet *rpc.VxNet) ([]*rpc.VIP, error) {
+	*f.calls = append(*f.calls, "DescribeVIPs "+vxnet.ID)
+	return f.vips, nil
+}
+
+func (f *fakeQingCloud) DeleteVIPs(vips []string) (string, error) {
+	*f.calls = append(*f.calls, "DeleteVIPs")
+	return "j-vip", nil
+}
+
+func (f *fakeQingCloud) GetSecurityGroupRuleForVxNet(sg string, vxnet *rpc.VxNet) (*rpc.SecurityGroupRule, error) {
+	*f.calls = append(*f.calls, "GetSecurityGroupRule "+vxnet.ID)
+	return f.sgRule, nil
+}
+
+func (f *fakeQingCloud) DeleteSecurityGroupRuleForVxNet(sg, sgr string) (string, error) {
+	*f.calls = append(*f.calls, "DeleteSecurityGroupRule "+sgr)
+	return "j-sg", nil
+}
+
+func deletingPool(jobs map[string]string) *networkv1alpha1.VxNetPool {
+	now := metav1.Now()
+	return &networkv1alpha1.VxNetPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "pool",
+			Finalizers:        []string{networkv1alpha1.VxNetPoolFinalizer},
+			DeletionTimestamp: &now,
+		},
+		Spec: networkv1alpha1.VxNetPoolSpec{
+			Vxnets:        []networkv1alpha1.VxnetInfo{{Name: "vxnet-a"}},
+			SecurityGroup: "sg-1",
+		},
+		Status: networkv1alpha1.VxNetPoolStatus{Jobs: jobs},
+	}
+}
+
+func ownedIPPool(allocations int) *networkv1alpha1.IPPool {
+	ippool := &networkv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "vxnet-a",
+			Labels: map[string]string{networkv1alpha1.VxNetPoolNameLabel: "pool"},
+		},
+	}
+	ippool.Status.Allocations = allocations
+	return ippool
+}
+
+func newTestVxNetPoolController(t *testing.T, calls *[]string, objects ...runtime.Object) (*VxNetPoolController, *fake.Clientset) {
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("delete", "ippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		*calls = append(*calls, "delete ippool "+action.(k8stesting.DeleteAction).GetName())
+		return false, nil, nil
+	})
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	ippoolInformer := factory.Network().V1alpha1().IPPools()
+	poolInformer := factory.Network().V1alpha1().VxNetPools()
+	blockInformer := factory.Network().V1alpha1().IPAMBlocks()
+	for _, obj := range objects {
+		var err error
+		switch obj.(type) {
+		case *networkv1alpha1.IPPool:
+			err = ippoolInformer.Informer().GetIndexer().Add(obj)
+		case *networkv1alpha1.VxNetPool:
+			err = poolInformer.Informer().GetIndexer().Add(obj)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &VxNetPoolController{
+		clientset:        client,
+		ippoolsLister:    ippoolInformer.Lister(),
+		poolsLister:      poolInformer.Lister(),
+		ipamblocksLister: blockInformer.Lister(),
+		workqueue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test"),
+		eventRecorder:    record.NewFakeRecorder(100),
+		conf:             &conf.ClusterConfig{},
+		vxNetCache:       make(map[string]*rpc.VxNet),
+		vipCache:         make(map[string][]*rpc.VIP),
+		sgCache:          make(map[string]*rpc.SecurityGroupRule),
+		jobs:             make(map[string]string),
+	}
+	return c, client
+}
+
+func TestTeardownPool(t *testing.T) {
+	cases := []struct {
+		name      string
+		jobs      map[string]string
+		ippool    *networkv1alpha1.IPPool
+		vips      []*rpc.VIP
+		sgRule    *rpc.SecurityGroupRule
+		jobStatus string
+
+		wantCalls    []string
+		wantJobs     map[string]string
+		wantReason   string
+		wantReleased bool
+	}{
+		{
+			name:       "wait for addresses in use",
+			ippool:     ownedIPPool(2),
+			wantReason: "AddressesInUse",
+		},
+		{
+			name:       "delete ippools before releasing vips",
+			ippool:     ownedIPPool(0),
+			vips:       []*rpc.VIP{{ID: "vip-1"}},
+			wantCalls:  []string{"delete ippool vxnet-a"},
+			wantReason: "DeletingIPPools",
+		},
+		{
+			name:       "release vips once ippools are gone",
+			vips:       []*rpc.VIP{{ID: "vip-1"}},
+			wantCalls:  []string{"DescribeVIPs vxnet-a", "DeleteVIPs"},
+			wantJobs:   map[string]string{keyForVxNetVIP("vxnet-a"): "j-vip"},
+			wantReason: "ReleasingVIPs",
+		},
+		{
+			name:       "poll the job recorded in status",
+			jobs:       map[string]string{keyForVxNetVIP("vxnet-a"): "j-1"},
+			vips:       []*rpc.VIP{{ID: "vip-1"}},
+			jobStatus:  "working",
+			wantCalls:  []string{"GetJobStatus j-1"},
+			wantJobs:   map[string]string{keyForVxNetVIP("vxnet-a"): "j-1"},
+			wantReason: "ReleasingVIPs",
+		},
+		{
+			name:       "check vips again after the job finished",
+			jobs:       map[string]string{keyForVxNetVIP("vxnet-a"): "j-1"},
+			jobStatus:  qcclient.JobStatusSuccessful,
+			sgRule:     &rpc.SecurityGroupRule{ID: "sgr-1"},
+			wantCalls:  []string{"GetJobStatus j-1", "DescribeVIPs vxnet-a", "GetSecurityGroupRule vxnet-a", "DeleteSecurityGroupRule sgr-1"},
+			wantJobs:   map[string]string{keyForVxNetSG("sg-1", "vxnet-a"): "j-sg"},
+			wantReason: "RemovingSecurityGroupRules",
+		},
+		{
+			name:         "remove finalizer after everything is released",
+			wantCalls:    []string{"DescribeVIPs vxnet-a", "GetSecurityGroupRule vxnet-a"},
+			wantReleased: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls []string
+			qcclient.QClient = &fakeQingCloud{calls: &calls, vips: tc.vips, sgRule: tc.sgRule, jobStatus: tc.jobStatus}
+			objects := []runtime.Object{deletingPool(tc.jobs)}
+			if tc.ippool != nil {
+				objects = append(objects, tc.ippool)
+			}
+			c, client := newTestVxNetPoolController(t, &calls, objects...)
+
+			if err := c.syncHandler("pool"); err != nil {
+				t.Fatalf("sync failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(calls, tc.wantCalls) {
+				t.Errorf("got calls %v, want %v", calls, tc.wantCalls)
+			}
+			pool, err := client.NetworkV1alpha1().VxNetPools().Get(context.TODO(), "pool", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if released := len(pool.Finalizers) == 0; released != tc.wantReleased {
+				t.Errorf("got finalizer removed %v, want %v", released, tc.wantReleased)
+			}
+			if tc.wantReleased {
+				return
+			}
+			if len(pool.Status.Jobs) != len(tc.wantJobs) || (len(tc.wantJobs) > 0 && !reflect.DeepEqual(pool.Status.Jobs, tc.wantJobs)) {
+				t.Errorf("got jobs %v, want %v", pool.Status.Jobs, tc.wantJobs)
+			}
+			var reason string
+			for _, cond := range pool.Status.Conditions {
+				if cond.Type == networkv1alpha1.VxNetPoolConditionResourcesReleased {
+					reason = cond.Reason
+				}
+			}
+			if reason != tc.wantReason {
+				t.Errorf("got release reason %q, want %q", reason, tc.wantReason)
+			}
+		})
+	}
+}
+
+func TestPrepareK8SResourceIPPoolConflict(t *testing.T) {
+	var calls []string
+	pool := &networkv1alpha1.VxNetPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+		Spec:       networkv1alpha1.VxNetPoolSpec{Vxnets: []networkv1alpha1.VxnetInfo{{Name: "vxnet-a"}}},
+	}
+	ippool := ownedIPPool(0)
+	ippool.Labels[networkv1alpha1.VxNetPoolNameLabel] = "other"
+	c, _ := newTestVxNetPoolController(t, &calls, pool, ippool)
+
+	state := newPoolSyncState(pool)
+	ready, err := c.prepareK8SResource(pool, state)
+	if err != nil || ready {
+		t.Fatalf("got ready %v err %v, want waiting for the conflict", ready, err)
+	}
+	cond := meta.FindStatusCondition(state.status.Conditions, networkv1alpha1.VxNetPoolConditionIPPoolsCreated)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "IPPoolConflict" || !strings.Contains(cond.Message, "vxnetpool other") {
+		t.Errorf("conflict should be reported in the condition, got %+v", cond)
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 	"k8s.io/klog/v2"
 
 	"github.com/yunify/hostnic-cni/pkg/constants"
@@ -13,6 +16,12 @@ import (
 
 const (
 	defaultDBPath = "/var/lib/hostnic"
+
+	// routeTablePrefix and leasePrefix are the key prefixes of route table allocations and
+	// dhcp leases of bridges, nics are keyed by the ids of their vxnets, which share nicPrefix
+	routeTablePrefix = "routetable/"
+	leasePrefix      = "lease/"
+	nicPrefix        = "vxnet-"
 )
 
 var (
@@ -68,7 +77,7 @@ func DeleteNetworkInfo(key string) error {
 }
 
 func Iterator(fn func(info interface{}) error) error {
-	iter := LevelDB.NewIterator(nil, nil)
+	iter := LevelDB.NewIterator(util.BytesPrefix([]byte(nicPrefix)), nil)
 	for iter.Next() {
 		// Remember that the contents of the returned slice should not be modified, and
 		// only valid until the next call to Next.
@@ -78,3 +87,52 @@ func Iterator(fn func(info interface{}) error) error {
 
 	return iter.Error()
 }
+
+// SetRouteTable records that route table is allocated to the nic of vxnet
+func SetRouteTable(table int, vxnet string) error {
+	return LevelDB.Put([]byte(routeTablePrefix+strconv.Itoa(table)), []byte(vxnet), nil)
+}
+
+func DeleteRouteTable(table int) error {
+	return LevelDB.Delete([]byte(routeTablePrefix+strconv.Itoa(table)), nil)
+}
+
+// ListRouteTables returns the allocated route tables and their vxnets
+func ListRouteTables() (map[int]string, error) {
+	tables := make(map[int]string)
+	iter := LevelDB.NewIterator(util.BytesPrefix([]byte(routeTablePrefix)), nil)
+	for iter.Next() {
+		table, err := strconv.Atoi(strings.TrimPrefix(string(iter.Key()), routeTablePrefix))
+		if err != nil {
+			klog.Warningf("invalid route table record %s", string(iter.Key()))
+			continue
+		}
+		tables[table] = string(iter.Value())
+	}
+	iter.Release()
+
+	return tables, iter.Error()
+}
+
+// SetLease records the dhcp lease of bridge
+func SetLease(bridge string, lease interface{}) error {
+	value, _ := json.Marshal(lease)
+	return LevelDB.Put([]byte(leasePrefix+bridge), value, nil)
+}
+
+func DeleteLease(bridge string) error {
+	return LevelDB.Delete([]byte(leasePrefix+bridge), nil)
+}
+
+// IterateLeases calls fn with every recorded dhcp lease
+func IterateLeases(fn func(value []byte) error) error {
+	iter := LevelDB.NewIterator(util.BytesPrefix([]byte(leasePrefix)), nil)
+	for iter.Next() {
+		if err := fn(iter.Value()); err != nil {
+			klog.Warningf("invalid lease record %s: %v", string(iter.Key()), err)
+		}
+	}
+	iter.Release()
+
+	return iter.Error()
+}
@@ -0,0 +1,105 @@
+// Package deviceplugin advertises an extended resource for every vxnet the node could serve,
+// so that the scheduler avoids nodes which have no nic slot left for the vxnet of a pod.
+package deviceplugin
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/yunify/hostnic-cni/pkg/allocator"
+	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	networklisters "github.com/yunify/hostnic-cni/pkg/client/listers/network/v1alpha1"
+	"github.com/yunify/hostnic-cni/pkg/config"
+)
+
+const resyncPeriod = 10 * time.Second
+
+type Manager struct {
+	nodeName     string
+	ippoolLister networklisters.IPPoolLister
+	poolSelector *config.PoolSelector
+
+	plugins map[string]*vxnetPlugin
+}
+
+func NewManager(nodeName string, ippoolLister networklisters.IPPoolLister, poolSelector *config.PoolSelector) *Manager {
+	return &Manager{
+		nodeName:     nodeName,
+		ippoolLister: ippoolLister,
+		poolSelector: poolSelector,
+		plugins:      make(map[string]*vxnetPlugin),
+	}
+}
+
+// Start keeps the plugins in sync with ippools and the nics of node until stopCh is closed
+func (m *Manager) Start(stopCh <-chan struct{}) {
+	go func() {
+		wait.Until(m.sync, resyncPeriod, stopCh)
+		for vxnet, plugin := range m.plugins {
+			plugin.shutdown()
+			delete(m.plugins, vxnet)
+		}
+	}()
+}
+
+func (m *Manager) sync() {
+	vxnets, err := m.getVxNets()
+	if err != nil {
+		klog.Errorf("device plugin list ippools failed: %v", err)
+		return
+	}
+
+	for vxnet, plugin := range m.plugins {
+		if _, ok := vxnets[vxnet]; !ok {
+			klog.Infof("vxnet %s is not served by node any more, stop its device plugin", vxnet)
+			plugin.shutdown()
+			delete(m.plugins, vxnet)
+		}
+	}
+
+	for vxnet := range vxnets {
+		healthy := allocator.Alloc.CanServe(vxnet)
+		if plugin, ok := m.plugins[vxnet]; ok {
+			if plugin.alive() {
+				plugin.setHealthy(healthy)
+				continue
+			}
+			klog.Infof("socket of device plugin for %s is gone, kubelet may have restarted", vxnet)
+			plugin.shutdown()
+			delete(m.plugins, vxnet)
+		}
+
+		plugin := newVxNetPlugin(vxnet, healthy)
+		if err := plugin.start(); err != nil {
+			klog.Errorf("start device plugin for %s failed: %v", vxnet, err)
+			continue
+		}
+		m.plugins[vxnet] = plugin
+	}
+}
+
+// getVxNets returns the vxnets of local ippools which could be used by node
+func (m *Manager) getVxNets() (map[string]struct{}, error) {
+	pools, err := m.ippoolLister.List(labels.SelectorFromSet(labels.Set{
+		networkv1alpha1.IPPoolTypeLabel: networkv1alpha1.IPPoolTypeLocal,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, pool := range pools {
+		if pool.DeletionTimestamp == nil && !pool.Disabled() {
+			names = append(names, pool.Name)
+		}
+	}
+
+	vxnets := make(map[string]struct{})
+	for _, name := range m.poolSelector.FilterIPPools(m.nodeName, names) {
+		vxnets[name] = struct{}{}
+	}
+	return vxnets, nil
+}
@@ -0,0 +1,180 @@
+package deviceplugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"k8s.io/klog/v2"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+
+	"github.com/yunify/hostnic-cni/pkg/constants"
+)
+
+const (
+	socketPrefix    = "hostnic-"
+	registerTimeout = 10 * time.Second
+)
+
+// vxnetPlugin advertises the pod slots of one vxnet. The devices are not real, all of them
+// are healthy when pods in the vxnet could get a nic on this node, otherwise unhealthy.
+type vxnetPlugin struct {
+	vxnet    string
+	resource string
+	socket   string
+
+	server *grpc.Server
+	update chan struct{}
+	stop   chan struct{}
+
+	lock    sync.Mutex
+	healthy bool
+}
+
+func newVxNetPlugin(vxnet string, healthy bool) *vxnetPlugin {
+	return &vxnetPlugin{
+		vxnet:    vxnet,
+		resource: constants.GetVxNetResourceName(vxnet),
+		socket:   filepath.Join(pluginapi.DevicePluginPath, socketPrefix+vxnet+".sock"),
+		update:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		healthy:  healthy,
+	}
+}
+
+// start serves the plugin on its socket and registers it to kubelet
+func (p *vxnetPlugin) start() error {
+	if err := os.Remove(p.socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove socket %s failed: %v", p.socket, err)
+	}
+
+	listener, err := net.Listen("unix", p.socket)
+	if err != nil {
+		return fmt.Errorf("listen on %s failed: %v", p.socket, err)
+	}
+
+	p.server = grpc.NewServer()
+	pluginapi.RegisterDevicePluginServer(p.server, p)
+	go func() {
+		if err := p.server.Serve(listener); err != nil {
+			klog.Errorf("device plugin for %s stopped serving: %v", p.vxnet, err)
+		}
+	}()
+
+	if err := p.register(); err != nil {
+		p.shutdown()
+		return err
+	}
+	klog.Infof("device plugin for %s registered as %s", p.vxnet, p.resource)
+	return nil
+}
+
+func (p *vxnetPlugin) register() error {
+	ctx, cancel := context.WithTimeout(context.Background(), registerTimeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, "unix://"+pluginapi.KubeletSocket, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return fmt.Errorf("connect to kubelet failed: %v", err)
+	}
+	defer conn.Close()
+
+	_, err = pluginapi.NewRegistrationClient(conn).Register(ctx, &pluginapi.RegisterRequest{
+		Version:      pluginapi.Version,
+		Endpoint:     filepath.Base(p.socket),
+		ResourceName: p.resource,
+		Options:      &pluginapi.DevicePluginOptions{},
+	})
+	if err != nil {
+		return fmt.Errorf("register %s to kubelet failed: %v", p.resource, err)
+	}
+	return nil
+}
+
+func (p *vxnetPlugin) shutdown() {
+	close(p.stop)
+	if p.server != nil {
+		p.server.Stop()
+	}
+	if err := os.Remove(p.socket); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("remove socket %s failed: %v", p.socket, err)
+	}
+}
+
+// alive checks the socket, kubelet removes all plugin sockets when it restarts
+func (p *vxnetPlugin) alive() bool {
+	_, err := os.Stat(p.socket)
+	return err == nil
+}
+
+func (p *vxnetPlugin) setHealthy(healthy bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.healthy == healthy {
+		return
+	}
+	p.healthy = healthy
+	select {
+	case p.update <- struct{}{}:
+	default:
+	}
+}
+
+func (p *vxnetPlugin) devices() []*pluginapi.Device {
+	p.lock.Lock()
+	health := pluginapi.Unhealthy
+	if p.healthy {
+		health = pluginapi.Healthy
+	}
+	p.lock.Unlock()
+
+	devs := make([]*pluginapi.Device, constants.VxNetResourceCapacity)
+	for i := range devs {
+		devs[i] = &pluginapi.Device{
+			ID:     fmt.Sprintf("%s-%d", p.vxnet, i),
+			Health: health,
+		}
+	}
+	return devs
+}
+
+func (p *vxnetPlugin) GetDevicePluginOptions(context.Context, *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
+	return &pluginapi.DevicePluginOptions{}, nil
+}
+
+func (p *vxnetPlugin) ListAndWatch(_ *pluginapi.Empty, s pluginapi.DevicePlugin_ListAndWatchServer) error {
+	for {
+		if err := s.Send(&pluginapi.ListAndWatchResponse{Devices: p.devices()}); err != nil {
+			return err
+		}
+
+		select {
+		case <-p.update:
+		case <-p.stop:
+			return nil
+		}
+	}
+}
+
+func (p *vxnetPlugin) GetPreferredAllocation(context.Context, *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
+	return &pluginapi.PreferredAllocationResponse{}, nil
+}
+
+// Allocate has nothing to set up, the nic is prepared by cni when the pod sandbox is created
+func (p *vxnetPlugin) Allocate(_ context.Context, req *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+	resp := &pluginapi.AllocateResponse{}
+	for range req.ContainerRequests {
+		resp.ContainerResponses = append(resp.ContainerResponses, &pluginapi.ContainerAllocateResponse{})
+	}
+	return resp, nil
+}
+
+func (p *vxnetPlugin) PreStartContainer(context.Context, *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+	return &pluginapi.PreStartContainerResponse{}, nil
+}
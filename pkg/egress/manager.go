@@ -0,0 +1,365 @@
+// Package egress programs the policy routing and SNAT of egress policies, so that the off-cluster
+// traffic of selected pods leaves from the egress ip on the egress node.
+//
+// The egress node holds a hostnic in the vxnet of the egress ip, answers arp requests for the egress ip,
+// and SNATs the traffic of selected pods leaving the bridge of the hostnic. Other nodes route the
+// traffic of selected pods in the same vxnet to the egress ip, pods in other vxnets are not reachable
+// in layer 2 and are left alone.
+package egress
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sinformers "k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/yunify/hostnic-cni/pkg/allocator"
+	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	clientset "github.com/yunify/hostnic-cni/pkg/client/clientset/versioned"
+	informers "github.com/yunify/hostnic-cni/pkg/client/informers/externalversions"
+	networklisters "github.com/yunify/hostnic-cni/pkg/client/listers/network/v1alpha1"
+	"github.com/yunify/hostnic-cni/pkg/constants"
+	"github.com/yunify/hostnic-cni/pkg/networkutils"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+	"github.com/yunify/hostnic-cni/pkg/simple/client/network/ippool/ipam"
+)
+
+const (
+	resyncPeriod = 10 * time.Second
+
+	handlePrefix = "egresspolicy-"
+)
+
+type Manager struct {
+	nodeName   string
+	client     clientset.Interface
+	ipamclient ipam.IPAMClient
+	// mark of the connections initiated by pods
+	mark int
+
+	policyLister networklisters.EgressPolicyLister
+	ippoolLister networklisters.IPPoolLister
+	podLister    corelisters.PodLister
+	nsLister     corelisters.NamespaceLister
+	synced       []cache.InformerSynced
+
+	// vxnets whose nic is held for egress ips
+	held map[string]bool
+}
+
+func NewManager(nodeName string, mark int, client clientset.Interface, ipamclient ipam.IPAMClient,
+	informerFactory informers.SharedInformerFactory, k8sInformerFactory k8sinformers.SharedInformerFactory) *Manager {
+	policyInformer := informerFactory.Network().V1alpha1().EgressPolicies()
+	ippoolInformer := informerFactory.Network().V1alpha1().IPPools()
+	podInformer := k8sInformerFactory.Core().V1().Pods()
+	nsInformer := k8sInformerFactory.Core().V1().Namespaces()
+
+	return &Manager{
+		nodeName:     nodeName,
+		mark:         mark,
+		client:       client,
+		ipamclient:   ipamclient,
+		policyLister: policyInformer.Lister(),
+		ippoolLister: ippoolInformer.Lister(),
+		podLister:    podInformer.Lister(),
+		nsLister:     nsInformer.Lister(),
+		synced: []cache.InformerSynced{
+			policyInformer.Informer().HasSynced,
+			ippoolInformer.Informer().HasSynced,
+			podInformer.Informer().HasSynced,
+			nsInformer.Informer().HasSynced,
+		},
+		held: make(map[string]bool),
+	}
+}
+
+// ValidateMark checks that mark is a single bit not used by kube-proxy or calico
+func ValidateMark(mark int) error {
+	if mark <= 0 || mark&(mark-1) != 0 {
+		return fmt.Errorf("mark %#x should be a single bit", mark)
+	}
+	if mark&constants.ReservedMarkMask != 0 {
+		return fmt.Errorf("mark %#x overlaps the marks of kube-proxy or calico %#x", mark, constants.ReservedMarkMask)
+	}
+	return nil
+}
+
+// Start keeps the network of node in sync with egress policies until stopCh is closed
+func (m *Manager) Start(stopCh <-chan struct{}) {
+	go func() {
+		if ok := cache.WaitForCacheSync(stopCh, m.synced...); !ok {
+			klog.Errorf("egress manager failed to wait for caches to sync")
+			return
+		}
+		wait.Until(m.sync, resyncPeriod, stopCh)
+	}()
+}
+
+func (m *Manager) sync() {
+	policies, err := m.policyLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("egress manager list policies failed: %v", err)
+		return
+	}
+	pools, err := m.ippoolLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("egress manager list ippools failed: %v", err)
+		return
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Name < policies[j].Name
+	})
+
+	state := networkutils.EgressState{Mark: m.mark}
+	cidrs := make(map[string]*net.IPNet)
+	for _, pool := range pools {
+		_, cidr, err := net.ParseCIDR(pool.Spec.CIDR)
+		if err != nil {
+			continue
+		}
+		cidrs[pool.Name] = cidr
+		state.ExcludeCIDRs = append(state.ExcludeCIDRs, cidr.String())
+	}
+
+	m.releaseStaleEgressIPs(policies)
+
+	held := make(map[string]bool)
+	// a pod is only taken by the first policy selecting it
+	taken := make(map[string]bool)
+	table := constants.EgressRouteTableBase
+	for _, policy := range policies {
+		if policy.DeletionTimestamp != nil {
+			continue
+		}
+		if err := policy.Validate(); err != nil {
+			klog.Warningf("skip invalid egress policy %s: %v", policy.Name, err)
+			continue
+		}
+
+		if policy.Spec.NodeName == m.nodeName {
+			held[policy.Spec.VxNet] = true
+			err := m.syncEgressNode(policy, cidrs[policy.Spec.VxNet], taken, &state)
+			m.updateStatus(policy, err)
+			continue
+		}
+
+		// traffic is blackholed before the egress ip is up
+		if !policy.Status.Ready {
+			continue
+		}
+		nic := allocator.Alloc.GetHostNic(policy.Spec.VxNet)
+		if nic == nil {
+			continue
+		}
+		if table >= constants.EgressRouteTableBase+constants.EgressRouteTableSize {
+			klog.Warningf("no route table left for egress policy %s", policy.Name)
+			continue
+		}
+		if m.syncPeerNode(policy, nic, table, taken, &state) {
+			table++
+		}
+	}
+
+	for vxnet := range m.held {
+		if !held[vxnet] {
+			allocator.Alloc.UnholdHostNic(vxnet)
+		}
+	}
+	m.held = held
+
+	if err := networkutils.NetworkHelper.SyncEgress(state); err != nil {
+		klog.Errorf("egress manager sync network failed: %v", err)
+	}
+}
+
+// syncEgressNode claims the egress ip, holds the nic of its vxnet, and adds the SNAT of selected
+// pods of this node and the pods of other nodes in the vxnet
+func (m *Manager) syncEgressNode(policy *networkv1alpha1.EgressPolicy, cidr *net.IPNet, taken map[string]bool, state *networkutils.EgressState) error {
+	if cidr == nil {
+		return fmt.Errorf("ippool of vxnet %s not found", policy.Spec.VxNet)
+	}
+	if err := m.claimEgressIP(policy); err != nil {
+		return err
+	}
+	nic, err := allocator.Alloc.HoldHostNic(context.Background(), policy.Spec.VxNet)
+	if err != nil {
+		return fmt.Errorf("hold hostnic of vxnet %s failed: %v", policy.Spec.VxNet, err)
+	}
+
+	table := int(nic.RouteTableNum)
+	br := constants.GetHostNicBridgeName(table)
+	state.ArpReplies = append(state.ArpReplies, networkutils.EgressArpReply{
+		Bridge:       br,
+		IP:           policy.Spec.EgressIP,
+		HardwareAddr: nic.HardwareAddr,
+	})
+
+	pods, err := m.podLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("list pods failed: %v", err)
+	}
+	local := m.localPodIPs()
+	for _, pod := range pods {
+		ip := pod.Status.PodIP
+		if pod.Spec.HostNetwork || ip == "" || taken[ip] || !m.selects(policy, pod) {
+			continue
+		}
+
+		if pod.Spec.NodeName == m.nodeName {
+			if !local[ip] {
+				continue
+			}
+		} else if cidr.Contains(net.ParseIP(ip)) {
+			// replies to pods of other nodes go back through the bridge instead of the main table
+			state.Rules = append(state.Rules, networkutils.EgressRule{Dst: ip, IifName: br, Table: table})
+		} else {
+			continue
+		}
+
+		taken[ip] = true
+		state.Rules = append(state.Rules, networkutils.EgressRule{Src: ip, Table: table})
+		state.SNATs = append(state.SNATs, networkutils.EgressSNAT{Src: ip, Bridge: br, EgressIP: policy.Spec.EgressIP})
+	}
+	return nil
+}
+
+// syncPeerNode routes the traffic of selected pods of this node to the egress ip through table,
+// it returns false if no pod is selected and table is not used
+func (m *Manager) syncPeerNode(policy *networkv1alpha1.EgressPolicy, nic *rpc.HostNic, table int, taken map[string]bool, state *networkutils.EgressState) bool {
+	var used bool
+	for _, info := range allocator.Alloc.GetPods() {
+		if constants.IsSecondaryIfName(info.IfName) || info.PodIP == "" || taken[info.PodIP] {
+			continue
+		}
+		pod, err := m.podLister.Pods(info.Namespace).Get(info.Name)
+		if err != nil || !m.selects(policy, pod) {
+			continue
+		}
+		if info.VxNet != policy.Spec.VxNet {
+			klog.V(4).Infof("pod %s/%s in vxnet %s could not reach egress ip %s of vxnet %s, skip it",
+				info.Namespace, info.Name, info.VxNet, policy.Spec.EgressIP, policy.Spec.VxNet)
+			continue
+		}
+
+		taken[info.PodIP] = true
+		used = true
+		state.Rules = append(state.Rules, networkutils.EgressRule{Src: info.PodIP, Table: table})
+	}
+
+	if used {
+		state.Routes = append(state.Routes, networkutils.EgressRoute{
+			Table:   table,
+			Gateway: policy.Spec.EgressIP,
+			Bridge:  constants.GetHostNicBridgeName(int(nic.RouteTableNum)),
+		})
+	}
+	return used
+}
+
+// localPodIPs returns the ips of primary interfaces of pods recorded on this node
+func (m *Manager) localPodIPs() map[string]bool {
+	ips := make(map[string]bool)
+	for _, info := range allocator.Alloc.GetPods() {
+		if !constants.IsSecondaryIfName(info.IfName) && info.PodIP != "" {
+			ips[info.PodIP] = true
+		}
+	}
+	return ips
+}
+
+func (m *Manager) selects(policy *networkv1alpha1.EgressPolicy, pod *corev1.Pod) bool {
+	ns, err := m.nsLister.Get(pod.Namespace)
+	if err != nil {
+		return false
+	}
+	return policy.Selects(ns.Labels, pod.Labels)
+}
+
+// claimEgressIP allocates the egress ip to the policy in ipam, so that no pod gets it
+func (m *Manager) claimEgressIP(policy *networkv1alpha1.EgressPolicy) error {
+	handleID := handlePrefix + policy.Name
+	handles, err := m.ipamclient.GetNodeHandles(m.nodeName)
+	if err != nil {
+		return fmt.Errorf("get handles of node failed: %v", err)
+	}
+	if attrs, ok := handles[handleID]; ok && attrs[ipam.IPAMBlockAttributeIP] == policy.Spec.EgressIP {
+		return nil
+	}
+
+	attrs := map[string]string{
+		ipam.IPAMBlockAttributeNode:         m.nodeName,
+		ipam.IPAMBlockAttributeEgressPolicy: policy.Name,
+		ipam.IPAMBlockAttributeIP:           policy.Spec.EgressIP,
+		ipam.IPAMBlockAttributeTimestamp:    time.Now().UTC().Format(ipam.IPAMBlockAttributeTimestampLayout),
+	}
+	var info ipam.PoolInfo
+	if _, err := m.ipamclient.AssignFixIps(handleID, []string{policy.Spec.EgressIP}, []string{policy.Spec.VxNet}, nil, &info, attrs); err != nil {
+		return fmt.Errorf("claim egress ip %s failed: %v", policy.Spec.EgressIP, err)
+	}
+	klog.Infof("claimed egress ip %s for policy %s", policy.Spec.EgressIP, policy.Name)
+	return nil
+}
+
+// releaseStaleEgressIPs releases the egress ips claimed by this node whose policies are gone,
+// moved to other nodes or changed their egress ip
+func (m *Manager) releaseStaleEgressIPs(policies []*networkv1alpha1.EgressPolicy) {
+	handles, err := m.ipamclient.GetNodeHandles(m.nodeName)
+	if err != nil {
+		klog.Errorf("egress manager get handles of node failed: %v", err)
+		return
+	}
+
+	active := make(map[string]string)
+	for _, policy := range policies {
+		if policy.DeletionTimestamp == nil && policy.Spec.NodeName == m.nodeName {
+			active[handlePrefix+policy.Name] = policy.Spec.EgressIP
+		}
+	}
+
+	for handleID, attrs := range handles {
+		if attrs[ipam.IPAMBlockAttributeEgressPolicy] == "" {
+			continue
+		}
+		ip := attrs[ipam.IPAMBlockAttributeIP]
+		if egressIP, ok := active[handleID]; ok && egressIP == ip {
+			continue
+		}
+
+		klog.Infof("release egress ip %s of policy %s", ip, attrs[ipam.IPAMBlockAttributeEgressPolicy])
+		if err := networkutils.NetworkHelper.CleanupEgressIP(ip); err != nil {
+			klog.Errorf("cleanup egress ip %s failed: %v", ip, err)
+			continue
+		}
+		if err := m.ipamclient.ReleaseByHandle(handleID); err != nil {
+			klog.Errorf("release egress handle %s failed: %v", handleID, err)
+		}
+	}
+}
+
+func (m *Manager) updateStatus(policy *networkv1alpha1.EgressPolicy, err error) {
+	status := networkv1alpha1.EgressPolicyStatus{Ready: err == nil}
+	if err != nil {
+		klog.Errorf("egress policy %s is not ready: %v", policy.Name, err)
+		status.Message = err.Error()
+	}
+	if policy.Status == status {
+		return
+	}
+
+	clone := policy.DeepCopy()
+	clone.Status = status
+	if _, err := m.client.NetworkV1alpha1().EgressPolicies().UpdateStatus(context.Background(), clone, metav1.UpdateOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+		klog.Errorf("update status of egress policy %s failed: %v", policy.Name, err)
+	}
+}
@@ -0,0 +1,241 @@
+// Package flowlog records the conntrack flows of pods on this node. Flows are reported by conntrack
+// when they are destroyed, and the pods are resolved by the ips recorded by the allocator.
+package flowlog
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/yunify/hostnic-cni/pkg/allocator"
+	"github.com/yunify/hostnic-cni/pkg/metrics/instrument"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+)
+
+const (
+	DirectionEgress  = "egress"
+	DirectionIngress = "ingress"
+
+	// VerdictUnreplied flows got no reply, such as connections refused silently or dropped on the way.
+	// Packets dropped before conntrack confirms them, such as by network policies, are not reported at all.
+	VerdictReplied   = "replied"
+	VerdictUnreplied = "unreplied"
+
+	podResyncPeriod = 10 * time.Second
+	retryInterval   = 5 * time.Second
+	flowBufferSize  = 1024
+)
+
+var protocols = map[uint8]string{
+	1:   "icmp",
+	6:   "tcp",
+	17:  "udp",
+	132: "sctp",
+}
+
+// Record is the aggregation of the flows between a pod and a peer ended in an interval
+type Record struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	PodIP     string    `json:"podIP"`
+	Direction string    `json:"direction"`
+	Peer      string    `json:"peer"`
+	Port      uint16    `json:"port"`
+	Protocol  string    `json:"protocol"`
+	Verdict   string    `json:"verdict"`
+	Flows     uint64    `json:"flows"`
+	Bytes     uint64    `json:"bytes"`
+	Packets   uint64    `json:"packets"`
+}
+
+type recordKey struct {
+	podIP     string
+	direction string
+	peer      string
+	port      uint16
+	protocol  string
+	verdict   string
+}
+
+// Collector aggregates the flows of pods, all of its state is owned by the goroutine of run
+type Collector struct {
+	opts   *Options
+	writer io.WriteCloser
+
+	pods    map[string]*rpc.PodInfo
+	records map[recordKey]*Record
+	start   time.Time
+	// label values of metrics observed, they are deleted once the pod is gone
+	series map[[4]string]bool
+}
+
+func NewCollector(opts *Options) (*Collector, error) {
+	c := &Collector{
+		opts:    opts,
+		pods:    make(map[string]*rpc.PodInfo),
+		records: make(map[recordKey]*Record),
+		series:  make(map[[4]string]bool),
+		start:   time.Now(),
+	}
+	if opts.File != "" {
+		writer, err := newRotatingWriter(opts.File, opts.MaxSizeMB, opts.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		c.writer = writer
+	}
+	return c, nil
+}
+
+// Start collects flows until stopCh is closed
+func (c *Collector) Start(stopCh <-chan struct{}) {
+	// counters of flows are only kept with accounting on
+	if _, err := sysctl.Sysctl("net/netfilter/nf_conntrack_acct", "1"); err != nil {
+		klog.Warningf("failed to enable conntrack accounting, bytes and packets of flows are not available: %v", err)
+	}
+
+	flows := make(chan flow, flowBufferSize)
+	go wait.Until(func() {
+		done := make(chan struct{})
+		defer close(done)
+		errCh, err := subscribeConntrack(flows, done)
+		if err != nil {
+			klog.Errorf("subscribe conntrack events failed, will retry in %s: %v", retryInterval, err)
+			return
+		}
+		select {
+		case err := <-errCh:
+			// usually ENOBUFS when events come faster than they are consumed
+			klog.Errorf("conntrack events subscription failed, will retry in %s: %v", retryInterval, err)
+		case <-stopCh:
+		}
+	}, retryInterval, stopCh)
+
+	go c.run(stopCh, flows)
+}
+
+func (c *Collector) run(stopCh <-chan struct{}, flows <-chan flow) {
+	podTicker := time.NewTicker(podResyncPeriod)
+	defer podTicker.Stop()
+	flushTicker := time.NewTicker(c.opts.FlushInterval)
+	defer flushTicker.Stop()
+
+	c.refreshPods(allocator.Alloc.GetPods())
+	for {
+		select {
+		case <-stopCh:
+			c.flush(time.Now())
+			if c.writer != nil {
+				c.writer.Close()
+			}
+			return
+		case f := <-flows:
+			c.add(f)
+		case <-podTicker.C:
+			c.refreshPods(allocator.Alloc.GetPods())
+		case now := <-flushTicker.C:
+			c.flush(now)
+		}
+	}
+}
+
+// refreshPods indexes pods by ip, and deletes the metrics of pods gone
+func (c *Collector) refreshPods(pods []*rpc.PodInfo) {
+	c.pods = make(map[string]*rpc.PodInfo)
+	names := make(map[[2]string]bool)
+	for _, pod := range pods {
+		if pod.PodIP != "" {
+			c.pods[pod.PodIP] = pod
+			names[[2]string{pod.Namespace, pod.Name}] = true
+		}
+	}
+
+	for lvs := range c.series {
+		if !names[[2]string{lvs[0], lvs[1]}] {
+			instrument.FlowBytes.DeleteLabelValues(lvs[:]...)
+			instrument.FlowPackets.DeleteLabelValues(lvs[:]...)
+			delete(c.series, lvs)
+		}
+	}
+}
+
+// add records f for the pod it is from, and the pod it is to. The peer of pod is the address replying,
+// which is the backend of a service instead of the service ip.
+func (c *Collector) add(f flow) {
+	if pod := c.pods[f.orig.src.String()]; pod != nil {
+		c.record(pod, DirectionEgress, f.reply.src.String(), f.reply.srcPort, f)
+	}
+	if pod := c.pods[f.reply.src.String()]; pod != nil {
+		c.record(pod, DirectionIngress, f.orig.src.String(), f.reply.srcPort, f)
+	}
+}
+
+func (c *Collector) record(pod *rpc.PodInfo, direction, peer string, port uint16, f flow) {
+	protocol, ok := protocols[f.proto]
+	if !ok {
+		protocol = strconv.Itoa(int(f.proto))
+	}
+	verdict := VerdictUnreplied
+	if f.replied {
+		verdict = VerdictReplied
+	}
+
+	key := recordKey{
+		podIP:     pod.PodIP,
+		direction: direction,
+		peer:      peer,
+		port:      port,
+		protocol:  protocol,
+		verdict:   verdict,
+	}
+	r, ok := c.records[key]
+	if !ok {
+		r = &Record{
+			Namespace: pod.Namespace,
+			Pod:       pod.Name,
+			PodIP:     pod.PodIP,
+			Direction: direction,
+			Peer:      peer,
+			Port:      port,
+			Protocol:  protocol,
+			Verdict:   verdict,
+		}
+		c.records[key] = r
+	}
+	r.Flows++
+	r.Bytes += f.bytes
+	r.Packets += f.packets
+
+	if c.opts.Metrics {
+		lvs := [4]string{pod.Namespace, pod.Name, direction, verdict}
+		c.series[lvs] = true
+		instrument.FlowBytes.WithLabelValues(lvs[:]...).Observe(float64(f.bytes))
+		instrument.FlowPackets.WithLabelValues(lvs[:]...).Observe(float64(f.packets))
+	}
+}
+
+// flush writes the records aggregated since the last flush as json lines
+func (c *Collector) flush(now time.Time) {
+	if c.writer != nil && len(c.records) > 0 {
+		for _, r := range c.records {
+			r.Start, r.End = c.start, now
+			line, err := json.Marshal(r)
+			if err != nil {
+				continue
+			}
+			if _, err := c.writer.Write(append(line, '\n')); err != nil {
+				klog.Errorf("write flow records failed: %v", err)
+				break
+			}
+		}
+	}
+	c.records = make(map[recordKey]*Record)
+	c.start = now
+}
@@ -0,0 +1,138 @@
+package flowlog
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+)
+
+func attr(typ uint16, value []byte) []byte {
+	b := make([]byte, 4, 4+len(value)+3)
+	nl.NativeEndian().PutUint16(b[0:2], uint16(4+len(value)))
+	nl.NativeEndian().PutUint16(b[2:4], typ)
+	b = append(b, value...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func nested(typ uint16, attrs ...[]byte) []byte {
+	var value []byte
+	for _, a := range attrs {
+		value = append(value, a...)
+	}
+	return attr(typ|nl.NLA_F_NESTED, value)
+}
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func be64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func tupleAttr(typ uint16, src, dst string, srcPort, dstPort uint16) []byte {
+	return nested(typ,
+		nested(nl.CTA_TUPLE_IP,
+			attr(nl.CTA_IP_V4_SRC, net.ParseIP(src).To4()),
+			attr(nl.CTA_IP_V4_DST, net.ParseIP(dst).To4())),
+		nested(nl.CTA_TUPLE_PROTO,
+			attr(nl.CTA_PROTO_NUM, []byte{6}),
+			attr(nl.CTA_PROTO_SRC_PORT, be16(srcPort)),
+			attr(nl.CTA_PROTO_DST_PORT, be16(dstPort))))
+}
+
+func counterAttr(typ uint16, packets, bytes uint64) []byte {
+	return nested(typ, attr(nl.CTA_COUNTERS_PACKETS, be64(packets)), attr(nl.CTA_COUNTERS_BYTES, be64(bytes)))
+}
+
+func TestParseFlow(t *testing.T) {
+	// pod 10.0.0.5 connects to service 10.96.0.10:80, which is served by 10.0.1.7:8080
+	data := []byte{unix.AF_INET, nl.NFNETLINK_V0, 0, 0}
+	data = append(data, tupleAttr(nl.CTA_TUPLE_ORIG, "10.0.0.5", "10.96.0.10", 40000, 80)...)
+	data = append(data, tupleAttr(nl.CTA_TUPLE_REPLY, "10.0.1.7", "10.0.0.5", 8080, 40000)...)
+	data = append(data, attr(nl.CTA_STATUS, be32(statusSeenReply))...)
+	data = append(data, counterAttr(nl.CTA_COUNTERS_ORIG, 5, 400)...)
+	data = append(data, counterAttr(nl.CTA_COUNTERS_REPLY, 4, 3000)...)
+	m := syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: unix.NFNL_SUBSYS_CTNETLINK<<8 | nl.IPCTNL_MSG_CT_DELETE},
+		Data:   data,
+	}
+
+	f, err := parseFlow(m)
+	if err != nil {
+		t.Fatalf("parse flow failed: %v", err)
+	}
+	if f.proto != 6 || f.orig.src.String() != "10.0.0.5" || f.reply.src.String() != "10.0.1.7" || f.reply.srcPort != 8080 {
+		t.Errorf("unexpected tuples %+v", f)
+	}
+	if f.packets != 9 || f.bytes != 3400 || !f.replied {
+		t.Errorf("unexpected counters %+v", f)
+	}
+
+	m.Data[0] = unix.AF_INET6
+	if _, err := parseFlow(m); err == nil {
+		t.Error("ipv6 flows should be skipped")
+	}
+}
+
+func TestCollectorAdd(t *testing.T) {
+	c, err := NewCollector(NewOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.refreshPods([]*rpc.PodInfo{
+		{Namespace: "default", Name: "client", PodIP: "10.0.0.5"},
+		{Namespace: "default", Name: "server", PodIP: "10.0.0.6"},
+	})
+
+	f := flow{
+		proto:   6,
+		orig:    tuple{src: net.ParseIP("10.0.0.5"), dst: net.ParseIP("10.96.0.10"), srcPort: 40000, dstPort: 80},
+		reply:   tuple{src: net.ParseIP("10.0.0.6"), dst: net.ParseIP("10.0.0.5"), srcPort: 8080, dstPort: 40000},
+		packets: 10,
+		bytes:   1000,
+		replied: true,
+	}
+	c.add(f)
+	c.add(f)
+	f.replied = false
+	c.add(f)
+
+	if len(c.records) != 4 {
+		t.Fatalf("expect 4 records, got %d", len(c.records))
+	}
+	egress := c.records[recordKey{podIP: "10.0.0.5", direction: DirectionEgress, peer: "10.0.0.6", port: 8080, protocol: "tcp", verdict: VerdictReplied}]
+	if egress == nil || egress.Pod != "client" || egress.Flows != 2 || egress.Bytes != 2000 || egress.Packets != 20 {
+		t.Errorf("unexpected egress record %+v", egress)
+	}
+	ingress := c.records[recordKey{podIP: "10.0.0.6", direction: DirectionIngress, peer: "10.0.0.5", port: 8080, protocol: "tcp", verdict: VerdictUnreplied}]
+	if ingress == nil || ingress.Pod != "server" || ingress.Flows != 1 {
+		t.Errorf("unexpected ingress record %+v", ingress)
+	}
+
+	// flows of unknown ips are dropped
+	f.orig.src, f.reply.src = net.ParseIP("192.168.0.1"), net.ParseIP("192.168.0.2")
+	c.add(f)
+	if len(c.records) != 4 {
+		t.Errorf("flows of unknown ips should be dropped")
+	}
+}
@@ -0,0 +1,182 @@
+package flowlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// nfnetlink attributes may carry the byte order flag besides the nested one
+	nlaFlagsMask = nl.NLA_F_NESTED | 1<<14
+
+	// IPS_SEEN_REPLY of enum ip_conntrack_status
+	statusSeenReply = 1 << 1
+)
+
+// flow is a conntrack entry reported when it is destroyed
+type flow struct {
+	proto uint8
+	orig  tuple
+	reply tuple
+	// packets and bytes of both directions, zero unless nf_conntrack_acct is on
+	packets uint64
+	bytes   uint64
+	replied bool
+}
+
+type tuple struct {
+	src, dst         net.IP
+	srcPort, dstPort uint16
+}
+
+// subscribeConntrack subscribes the destroy events of conntrack, flows are sent to ch until done is closed
+func subscribeConntrack(ch chan<- flow, done <-chan struct{}) (<-chan error, error) {
+	s, err := nl.Subscribe(unix.NETLINK_NETFILTER, unix.NFNLGRP_CONNTRACK_DESTROY)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-done
+		s.Close()
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			msgs, from, err := s.Receive()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if from.Pid != nl.PidKernel {
+				continue
+			}
+			for _, m := range msgs {
+				f, err := parseFlow(m)
+				if err != nil {
+					continue
+				}
+				select {
+				case ch <- f:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return errCh, nil
+}
+
+// parseFlow parses the ipv4 flow in a conntrack message
+func parseFlow(m syscall.NetlinkMessage) (flow, error) {
+	var f flow
+	if m.Header.Type != unix.NFNL_SUBSYS_CTNETLINK<<8|nl.IPCTNL_MSG_CT_DELETE {
+		return f, fmt.Errorf("unexpected message type %d", m.Header.Type)
+	}
+	if len(m.Data) < nl.SizeofNfgenmsg || m.Data[0] != unix.AF_INET {
+		return f, fmt.Errorf("not an ipv4 flow")
+	}
+	attrs, err := nl.ParseRouteAttr(m.Data[nl.SizeofNfgenmsg:])
+	if err != nil {
+		return f, err
+	}
+
+	for _, attr := range attrs {
+		switch attr.Attr.Type &^ nlaFlagsMask {
+		case nl.CTA_TUPLE_ORIG:
+			if f.proto, err = parseTuple(attr.Value, &f.orig); err != nil {
+				return f, err
+			}
+		case nl.CTA_TUPLE_REPLY:
+			if _, err = parseTuple(attr.Value, &f.reply); err != nil {
+				return f, err
+			}
+		case nl.CTA_COUNTERS_ORIG, nl.CTA_COUNTERS_REPLY:
+			packets, bytes, err := parseCounters(attr.Value)
+			if err != nil {
+				return f, err
+			}
+			f.packets += packets
+			f.bytes += bytes
+		case nl.CTA_STATUS:
+			if len(attr.Value) >= 4 {
+				f.replied = binary.BigEndian.Uint32(attr.Value)&statusSeenReply != 0
+			}
+		}
+	}
+	if f.orig.src == nil || f.reply.src == nil {
+		return f, fmt.Errorf("flow without tuples")
+	}
+	return f, nil
+}
+
+func parseTuple(data []byte, t *tuple) (uint8, error) {
+	var proto uint8
+	attrs, err := nl.ParseRouteAttr(data)
+	if err != nil {
+		return 0, err
+	}
+	for _, attr := range attrs {
+		typ := attr.Attr.Type &^ nlaFlagsMask
+		if typ != nl.CTA_TUPLE_IP && typ != nl.CTA_TUPLE_PROTO {
+			continue
+		}
+		nested, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return 0, err
+		}
+		switch typ {
+		case nl.CTA_TUPLE_IP:
+			for _, a := range nested {
+				switch a.Attr.Type &^ nlaFlagsMask {
+				case nl.CTA_IP_V4_SRC:
+					t.src = net.IP(append([]byte(nil), a.Value...)).To4()
+				case nl.CTA_IP_V4_DST:
+					t.dst = net.IP(append([]byte(nil), a.Value...)).To4()
+				}
+			}
+		case nl.CTA_TUPLE_PROTO:
+			for _, a := range nested {
+				switch a.Attr.Type &^ nlaFlagsMask {
+				case nl.CTA_PROTO_NUM:
+					if len(a.Value) >= 1 {
+						proto = a.Value[0]
+					}
+				case nl.CTA_PROTO_SRC_PORT:
+					if len(a.Value) >= 2 {
+						t.srcPort = binary.BigEndian.Uint16(a.Value)
+					}
+				case nl.CTA_PROTO_DST_PORT:
+					if len(a.Value) >= 2 {
+						t.dstPort = binary.BigEndian.Uint16(a.Value)
+					}
+				}
+			}
+		}
+	}
+	return proto, nil
+}
+
+func parseCounters(data []byte) (packets, bytes uint64, err error) {
+	attrs, err := nl.ParseRouteAttr(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, attr := range attrs {
+		if len(attr.Value) < 8 {
+			continue
+		}
+		switch attr.Attr.Type &^ nlaFlagsMask {
+		case nl.CTA_COUNTERS_PACKETS:
+			packets = binary.BigEndian.Uint64(attr.Value)
+		case nl.CTA_COUNTERS_BYTES:
+			bytes = binary.BigEndian.Uint64(attr.Value)
+		}
+	}
+	return packets, bytes, nil
+}
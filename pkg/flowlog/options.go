@@ -0,0 +1,43 @@
+package flowlog
+
+import (
+	"flag"
+	"time"
+)
+
+const (
+	defaultFlushInterval = time.Minute
+	defaultMaxSize       = 100
+	defaultMaxBackups    = 5
+)
+
+type Options struct {
+	// File is the path of flow records, empty disables the file
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+	// Metrics exports the flows of pods as prometheus summaries
+	Metrics       bool
+	FlushInterval time.Duration
+}
+
+func NewOptions() *Options {
+	return &Options{
+		MaxSizeMB:     defaultMaxSize,
+		MaxBackups:    defaultMaxBackups,
+		FlushInterval: defaultFlushInterval,
+	}
+}
+
+func (opt *Options) AddFlags() {
+	flag.StringVar(&opt.File, "flow-log-file", "", "write aggregated conntrack flows of pods to this file, disabled if empty")
+	flag.IntVar(&opt.MaxSizeMB, "flow-log-max-size", defaultMaxSize, "size in megabytes of the flow log file before it is rotated")
+	flag.IntVar(&opt.MaxBackups, "flow-log-max-backups", defaultMaxBackups, "number of rotated flow log files to keep")
+	flag.BoolVar(&opt.Metrics, "flow-log-metrics", false, "export conntrack flows of pods as prometheus summaries")
+	flag.DurationVar(&opt.FlushInterval, "flow-log-flush-interval", defaultFlushInterval, "interval to write aggregated flow records")
+}
+
+// Enabled reports whether any output of flow logs is configured
+func (opt *Options) Enabled() bool {
+	return opt.File != "" || opt.Metrics
+}
@@ -0,0 +1,167 @@
+// Package instrument holds the counters and histograms of hostnic operations.
+// It has no dependency on other hostnic packages so that every package could record into it.
+package instrument
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "hostnic"
+
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+
+	NicOperationCreate = "create"
+	NicOperationAttach = "attach"
+	NicOperationDetach = "detach"
+	NicOperationDelete = "delete"
+)
+
+var (
+	// buckets from 5ms to about 80s
+	latencyBuckets = prometheus.ExponentialBuckets(0.005, 2, 15)
+
+	AddNetworkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "add_network_duration_seconds",
+		Help:      "Latency of AddNetwork requests handled by hostnic daemon.",
+		Buckets:   latencyBuckets,
+	}, []string{"outcome", "pool"})
+
+	DelNetworkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "del_network_duration_seconds",
+		Help:      "Latency of DelNetwork requests handled by hostnic daemon.",
+		Buckets:   latencyBuckets,
+	}, []string{"outcome", "pool"})
+
+	NicOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "nic_operation_duration_seconds",
+		Help:      "Latency of creating, attaching, detaching and deleting nics.",
+		Buckets:   latencyBuckets,
+	}, []string{"operation", "outcome"})
+
+	LinkWaitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "link_wait_duration_seconds",
+		Help:      "Time spent waiting for the link of an attached nic to show up.",
+		Buckets:   latencyBuckets,
+	}, []string{"outcome"})
+
+	DHCPExchangeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dhcp_exchange_duration_seconds",
+		Help:      "Latency of dhcp exchanges on hostnic bridges.",
+		Buckets:   latencyBuckets,
+	}, []string{"outcome"})
+
+	QingCloudAPIDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "qingcloud_api_duration_seconds",
+		Help:      "Latency of QingCloud api calls.",
+		Buckets:   latencyBuckets,
+	}, []string{"action", "outcome"})
+
+	QingCloudAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "qingcloud_api_errors_total",
+		Help:      "Number of failed QingCloud api calls.",
+	}, []string{"action"})
+
+	DHCPLeaseOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dhcp_lease_operations_total",
+		Help:      "Number of dhcp lease acquisitions, renewals, rebindings and releases of hostnic bridges.",
+	}, []string{"operation", "outcome"})
+
+	DHCPLeaseExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dhcp_lease_expiry_timestamp_seconds",
+		Help:      "Expiry time of the dhcp lease of hostnic bridges.",
+	}, []string{"bridge"})
+
+	NetworkDrifts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "network_drifts",
+		Help:      "Differences between the policy routing state of node and hostnic records found by the last check.",
+	}, []string{"kind", "repaired"})
+
+	FlowBytes = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: namespace,
+		Name:      "flow_bytes",
+		Help:      "Bytes of both directions of the conntrack flows of pods, observed when the flows end.",
+	}, []string{"namespace", "pod", "direction", "verdict"})
+
+	FlowPackets = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: namespace,
+		Name:      "flow_packets",
+		Help:      "Packets of both directions of the conntrack flows of pods, observed when the flows end.",
+	}, []string{"namespace", "pod", "direction", "verdict"})
+
+	// the following counters keep the names of the gauges they replace
+	AllocFromBlockFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "hostnic_ipam_alloc_from_block_failed",
+		Help: "Number of failures allocating ip from the subnets of namespace.",
+	})
+	AllocFromPoolFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "hostnic_ipam_alloc_from_pool_failed",
+		Help: "Number of failures allocating ip from the default ippools.",
+	})
+	AllocResourceNotFound = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "hostnic_ipam_alloc_resource_notfound",
+		Help: "Number of allocations which found neither subnet nor ippool.",
+	})
+	AllocFromHostFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "hostnic_ipam_alloc_from_host_failed",
+		Help: "Number of failures allocating hostnic for pods.",
+	})
+	FreeFromPoolFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "hostnic_ipam_free_from_pool_failed",
+		Help: "Number of failures releasing ip to ippools.",
+	})
+	FreeFromHostFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "hostnic_ipam_free_from_host_failed",
+		Help: "Number of failures clearing hostnic records of pods.",
+	})
+)
+
+// MustRegister registers all the collectors of this package into reg
+func MustRegister(reg prometheus.Registerer) {
+	reg.MustRegister(
+		AddNetworkDuration,
+		DelNetworkDuration,
+		NicOperationDuration,
+		LinkWaitDuration,
+		DHCPExchangeDuration,
+		DHCPLeaseOperations,
+		DHCPLeaseExpiry,
+		QingCloudAPIDuration,
+		QingCloudAPIErrors,
+		NetworkDrifts,
+		FlowBytes,
+		FlowPackets,
+		AllocFromBlockFailed,
+		AllocFromPoolFailed,
+		AllocResourceNotFound,
+		AllocFromHostFailed,
+		FreeFromPoolFailed,
+		FreeFromHostFailed,
+	)
+}
+
+// Outcome returns the outcome label for err
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
+
+// ObserveSince records the time elapsed since start into h
+func ObserveSince(h *prometheus.HistogramVec, start time.Time, lvs ...string) {
+	h.WithLabelValues(lvs...).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,518 @@
+package networkutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/client4"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	"github.com/yunify/hostnic-cni/pkg/db"
+	"github.com/yunify/hostnic-cni/pkg/metrics/instrument"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+)
+
+const (
+	LeaseStateBound     = "Bound"
+	LeaseStateRenewing  = "Renewing"
+	LeaseStateRebinding = "Rebinding"
+	LeaseStateExpired   = "Expired"
+
+	leaseOperationAcquire = "acquire"
+	leaseOperationRenew   = "renew"
+	leaseOperationRebind  = "rebind"
+	leaseOperationRelease = "release"
+
+	// leaseRetryMin is the minimal interval to retry renewing or rebinding, as suggested by RFC 2131
+	leaseRetryMin = time.Minute
+	// leaseAcquireRetry is the interval to acquire a new lease again after the lease expired
+	leaseAcquireRetry = 30 * time.Second
+)
+
+var (
+	errLeaseNak     = errors.New("dhcp server declined the lease")
+	errLeaseChanged = errors.New("dhcp lease was released or acquired again meanwhile")
+)
+
+// Lease is the dhcp lease of a hostnic bridge, it is recorded in leveldb to survive restart
+type Lease struct {
+	Bridge       string    `json:"bridge"`
+	HardwareAddr string    `json:"hardwareAddr"`
+	Address      string    `json:"address"`
+	ServerID     string    `json:"serverID"`
+	Renew        time.Time `json:"renew"`
+	Rebind       time.Time `json:"rebind"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+func (l *Lease) State(now time.Time) string {
+	switch {
+	case now.Before(l.Renew):
+		return LeaseStateBound
+	case now.Before(l.Rebind):
+		return LeaseStateRenewing
+	case now.Before(l.Expiry):
+		return LeaseStateRebinding
+	}
+	return LeaseStateExpired
+}
+
+func leaseFromAck(bridge, mac string, ack *dhcpv4.DHCPv4, now time.Time) (*Lease, error) {
+	leaseTime := ack.IPAddressLeaseTime(0)
+	if leaseTime <= 0 {
+		return nil, fmt.Errorf("dhcp ack for %s has no lease time", bridge)
+	}
+	mask := ack.SubnetMask()
+	if mask == nil {
+		mask = ack.YourIPAddr.DefaultMask()
+	}
+	server := ack.ServerIdentifier()
+	if server == nil {
+		server = ack.ServerIPAddr
+	}
+
+	return &Lease{
+		Bridge:       bridge,
+		HardwareAddr: mac,
+		Address:      (&net.IPNet{IP: ack.YourIPAddr, Mask: mask}).String(),
+		ServerID:     server.String(),
+		Renew:        now.Add(ack.IPAddressRenewalTime(leaseTime / 2)),
+		Rebind:       now.Add(ack.IPAddressRebindingTime(leaseTime * 7 / 8)),
+		Expiry:       now.Add(leaseTime),
+	}, nil
+}
+
+// LeaseManager keeps the dhcp leases of vlan bridges: it renews a lease by unicast at T1,
+// rebinds it by broadcast at T2, and acquires a new one after it expires.
+type LeaseManager struct {
+	lock    sync.Mutex
+	stopped bool
+	leases  map[string]*Lease
+	timers  map[string]*time.Timer
+}
+
+var Leases = NewLeaseManager()
+
+func NewLeaseManager() *LeaseManager {
+	return &LeaseManager{
+		leases: make(map[string]*Lease),
+		timers: make(map[string]*time.Timer),
+	}
+}
+
+// Start restores the leases recorded in leveldb and maintains them until stopCh is closed
+func (m *LeaseManager) Start(stopCh <-chan struct{}) error {
+	var leases []*Lease
+	err := db.IterateLeases(func(value []byte) error {
+		lease := &Lease{}
+		if err := json.Unmarshal(value, lease); err != nil {
+			return err
+		}
+		leases = append(leases, lease)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	for _, lease := range leases {
+		klog.Infof("restore dhcp lease %s of %s, state %s", lease.Address, lease.Bridge, lease.State(time.Now()))
+		m.leases[lease.Bridge] = lease
+		m.schedule(lease.Bridge, lease.Renew)
+		instrument.DHCPLeaseExpiry.WithLabelValues(lease.Bridge).Set(float64(lease.Expiry.Unix()))
+	}
+	m.lock.Unlock()
+
+	go func() {
+		<-stopCh
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		m.stopped = true
+		for _, timer := range m.timers {
+			timer.Stop()
+		}
+	}()
+	return nil
+}
+
+// Acquire sets the address of bridge, a lease still valid for bridge is reused instead of asking dhcp server again
+func (m *LeaseManager) Acquire(bridge string) error {
+	link, err := netlink.LinkByName(bridge)
+	if err != nil {
+		return fmt.Errorf("failed to lookup link %s: %v", bridge, err)
+	}
+	mac := link.Attrs().HardwareAddr.String()
+
+	m.lock.Lock()
+	lease := m.leases[bridge]
+	m.lock.Unlock()
+	if lease != nil && lease.HardwareAddr == mac && time.Now().Before(lease.Expiry) {
+		klog.Infof("reuse dhcp lease %s of %s", lease.Address, bridge)
+		return m.bind(link, lease, lease)
+	}
+	prev := lease
+
+	ack, err := dhcpExchange(bridge)
+	instrument.DHCPLeaseOperations.WithLabelValues(leaseOperationAcquire, instrument.Outcome(err)).Inc()
+	if err != nil {
+		return err
+	}
+	lease, err = leaseFromAck(bridge, mac, ack, time.Now())
+	if err != nil {
+		return err
+	}
+	klog.Infof("acquire dhcp lease %s from %s for %s, expiry %s", lease.Address, lease.ServerID, bridge, lease.Expiry)
+	return m.bind(link, lease, prev)
+}
+
+// Release gives the lease of bridge back to dhcp server, it should be called before the bridge is deleted
+func (m *LeaseManager) Release(bridge string) {
+	m.lock.Lock()
+	lease := m.leases[bridge]
+	delete(m.leases, bridge)
+	if timer, ok := m.timers[bridge]; ok {
+		timer.Stop()
+		delete(m.timers, bridge)
+	}
+	m.lock.Unlock()
+	if lease == nil {
+		return
+	}
+
+	if err := db.DeleteLease(bridge); err != nil {
+		klog.Errorf("delete dhcp lease record of %s failed: %v", bridge, err)
+	}
+	instrument.DHCPLeaseExpiry.DeleteLabelValues(bridge)
+
+	if !time.Now().Before(lease.Expiry) {
+		return
+	}
+	// the server reclaims the address after the lease expires anyway, so failures are only logged
+	err := dhcpRelease(lease)
+	instrument.DHCPLeaseOperations.WithLabelValues(leaseOperationRelease, instrument.Outcome(err)).Inc()
+	if err != nil {
+		klog.Warningf("release dhcp lease %s of %s failed: %v", lease.Address, bridge, err)
+	} else {
+		klog.Infof("release dhcp lease %s of %s", lease.Address, bridge)
+	}
+}
+
+// List returns the leases ordered by bridge
+func (m *LeaseManager) List() []*rpc.DHCPLease {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	var result []*rpc.DHCPLease
+	for _, lease := range m.leases {
+		result = append(result, &rpc.DHCPLease{
+			Bridge:       lease.Bridge,
+			HardwareAddr: lease.HardwareAddr,
+			Address:      lease.Address,
+			ServerID:     lease.ServerID,
+			State:        lease.State(now),
+			Renew:        lease.Renew.Unix(),
+			Rebind:       lease.Rebind.Unix(),
+			Expiry:       lease.Expiry.Unix(),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Bridge < result[j].Bridge
+	})
+	return result
+}
+
+// bind sets the address of lease to link, records the lease and schedules its renewal.
+// It fails with errLeaseChanged if the lease of bridge is no longer prev, e.g. it was released while
+// the dhcp server was asked, so that the released lease is not recorded again.
+func (m *LeaseManager) bind(link netlink.Link, lease, prev *Lease) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.leases[lease.Bridge] != prev {
+		return errLeaseChanged
+	}
+	if err := applyLease(link, lease); err != nil {
+		return err
+	}
+
+	m.leases[lease.Bridge] = lease
+	if err := db.SetLease(lease.Bridge, lease); err != nil {
+		klog.Errorf("record dhcp lease of %s failed: %v", lease.Bridge, err)
+	}
+	m.schedule(lease.Bridge, lease.Renew)
+	instrument.DHCPLeaseExpiry.WithLabelValues(lease.Bridge).Set(float64(lease.Expiry.Unix()))
+	return nil
+}
+
+// schedule runs maintain for bridge at when, the lock should be held
+func (m *LeaseManager) schedule(bridge string, when time.Time) {
+	if m.stopped {
+		return
+	}
+	if timer, ok := m.timers[bridge]; ok {
+		timer.Stop()
+	}
+	m.timers[bridge] = time.AfterFunc(time.Until(when), func() {
+		m.maintain(bridge)
+	})
+}
+
+// maintain renews, rebinds or acquires the lease of bridge according to its state
+func (m *LeaseManager) maintain(bridge string) {
+	m.lock.Lock()
+	lease := m.leases[bridge]
+	m.lock.Unlock()
+	if lease == nil {
+		return
+	}
+
+	link, err := netlink.LinkByName(bridge)
+	if err != nil {
+		// the lease is acquired again when the bridge is repaired
+		klog.Warningf("bridge %s of dhcp lease %s is gone: %v", bridge, lease.Address, err)
+		return
+	}
+	mac := link.Attrs().HardwareAddr.String()
+
+	now := time.Now()
+	var (
+		operation string
+		ack       *dhcpv4.DHCPv4
+	)
+	switch lease.State(now) {
+	case LeaseStateBound, LeaseStateRenewing:
+		operation = leaseOperationRenew
+		ack, err = dhcpRequest(lease, true)
+	case LeaseStateRebinding:
+		operation = leaseOperationRebind
+		ack, err = dhcpRequest(lease, false)
+	}
+	if operation == "" || err == errLeaseNak {
+		if err == errLeaseNak {
+			klog.Warningf("dhcp lease %s of %s is declined, acquire a new one", lease.Address, bridge)
+		}
+		operation = leaseOperationAcquire
+		ack, err = dhcpExchange(bridge)
+	}
+	instrument.DHCPLeaseOperations.WithLabelValues(operation, instrument.Outcome(err)).Inc()
+
+	var renewed *Lease
+	if err == nil {
+		renewed, err = leaseFromAck(bridge, mac, ack, now)
+	}
+
+	m.lock.Lock()
+	if m.leases[bridge] != lease {
+		// released or acquired again meanwhile
+		m.lock.Unlock()
+		return
+	}
+	if err != nil {
+		retry := retryTime(lease, now)
+		klog.Errorf("%s dhcp lease %s of %s failed, retry at %s: %v", operation, lease.Address, bridge, retry, err)
+		m.schedule(bridge, retry)
+		m.lock.Unlock()
+		return
+	}
+	m.lock.Unlock()
+
+	err = m.bind(link, renewed, lease)
+	switch err {
+	case nil:
+		klog.Infof("%s dhcp lease %s of %s, expiry %s", operation, renewed.Address, bridge, renewed.Expiry)
+	case errLeaseChanged:
+		klog.Infof("drop dhcp lease %s of %s: %v", renewed.Address, bridge, err)
+	default:
+		klog.Errorf("set dhcp lease %s to %s failed: %v", renewed.Address, bridge, err)
+	}
+}
+
+// retryTime waits half of the time left to the next state, but no less than one minute
+func retryTime(lease *Lease, now time.Time) time.Time {
+	var next time.Time
+	switch lease.State(now) {
+	case LeaseStateBound, LeaseStateRenewing:
+		next = lease.Rebind
+	case LeaseStateRebinding:
+		next = lease.Expiry
+	default:
+		return now.Add(leaseAcquireRetry)
+	}
+
+	wait := next.Sub(now) / 2
+	if wait < leaseRetryMin {
+		wait = leaseRetryMin
+	}
+	if retry := now.Add(wait); retry.Before(next) {
+		return retry
+	}
+	return next
+}
+
+// applyLease replaces the addresses of link with the one of lease, which expires with the lease
+func applyLease(link netlink.Link, lease *Lease) error {
+	ipnet, err := netlink.ParseIPNet(lease.Address)
+	if err != nil {
+		return fmt.Errorf("invalid lease address %s: %v", lease.Address, err)
+	}
+	lifetime := int(time.Until(lease.Expiry).Seconds())
+	if lifetime <= 0 {
+		return fmt.Errorf("dhcp lease %s of %s has expired", lease.Address, lease.Bridge)
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return fmt.Errorf("failed to list addresses of %s: %v", lease.Bridge, err)
+	}
+	for i := range addrs {
+		if !addrs[i].IPNet.IP.Equal(ipnet.IP) {
+			if err := netlink.AddrDel(link, &addrs[i]); err != nil {
+				return fmt.Errorf("failed to delete stale address %s of %s: %v", addrs[i].IPNet, lease.Bridge, err)
+			}
+		}
+	}
+
+	addr := &netlink.Addr{
+		IPNet:       ipnet,
+		ValidLft:    lifetime,
+		PreferedLft: lifetime,
+	}
+	if err := netlink.AddrReplace(link, addr); err != nil {
+		return fmt.Errorf("replace addr %+v to link %s error: %v", addr, lease.Bridge, err)
+	}
+	return nil
+}
+
+func newDHCPClient() *client4.Client {
+	return &client4.Client{
+		ReadTimeout:  client4.DefaultReadTimeout * 5,
+		WriteTimeout: client4.DefaultWriteTimeout * 5,
+	}
+}
+
+// dhcpExchange runs a full DORA exchange on ifname and returns the ack
+func dhcpExchange(ifname string) (*dhcpv4.DHCPv4, error) {
+	start := time.Now()
+	conv, err := newDHCPClient().Exchange(ifname)
+	instrument.ObserveSince(instrument.DHCPExchangeDuration, start, instrument.Outcome(err))
+	if err != nil {
+		return nil, fmt.Errorf("dhcp client exchange error: %v", err)
+	}
+	ack := conv[len(conv)-1]
+	if ack.MessageType() != dhcpv4.MessageTypeAck {
+		return nil, fmt.Errorf("dhcp client exchange got %s instead of ack", ack.MessageType())
+	}
+	return ack, nil
+}
+
+// dhcpRequest extends lease with a REQUEST, which is sent to the server of lease if unicast, or broadcast otherwise
+func dhcpRequest(lease *Lease, unicast bool) (*dhcpv4.DHCPv4, error) {
+	ipnet, err := netlink.ParseIPNet(lease.Address)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := net.ParseMAC(lease.HardwareAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	client := newDHCPClient()
+	client.LocalAddr = &net.UDPAddr{IP: ipnet.IP, Port: dhcpv4.ClientPort}
+	if unicast {
+		client.RemoteAddr = &net.UDPAddr{IP: net.ParseIP(lease.ServerID), Port: dhcpv4.ServerPort}
+	}
+
+	sfd, err := client4.MakeBroadcastSocket(lease.Bridge)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(sfd)
+	rfd, err := client4.MakeListeningSocket(lease.Bridge)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(rfd)
+
+	// RFC 2131 4.3.2: ciaddr is set, server identifier and requested ip are not
+	request, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(mac),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithClientIP(ipnet.IP),
+		dhcpv4.WithBroadcast(false),
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionSubnetMask, dhcpv4.OptionRouter),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	reply, err := client.SendReceive(sfd, rfd, request, dhcpv4.MessageTypeNone)
+	instrument.ObserveSince(instrument.DHCPExchangeDuration, start, instrument.Outcome(err))
+	if err != nil {
+		return nil, err
+	}
+	switch reply.MessageType() {
+	case dhcpv4.MessageTypeAck:
+		// the server may not repeat the address in ack of a renewal
+		if reply.YourIPAddr == nil || reply.YourIPAddr.IsUnspecified() {
+			reply.YourIPAddr = ipnet.IP
+		}
+		if reply.SubnetMask() == nil {
+			reply.UpdateOption(dhcpv4.OptSubnetMask(ipnet.Mask))
+		}
+		return reply, nil
+	case dhcpv4.MessageTypeNak:
+		return nil, errLeaseNak
+	}
+	return nil, fmt.Errorf("unexpected dhcp reply %s", reply.MessageType())
+}
+
+// dhcpRelease tells the server of lease that the address is not used any more, no reply is expected
+func dhcpRelease(lease *Lease) error {
+	ipnet, err := netlink.ParseIPNet(lease.Address)
+	if err != nil {
+		return err
+	}
+	mac, err := net.ParseMAC(lease.HardwareAddr)
+	if err != nil {
+		return err
+	}
+	server := net.ParseIP(lease.ServerID).To4()
+	if server == nil {
+		return fmt.Errorf("invalid dhcp server %s", lease.ServerID)
+	}
+
+	release, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(mac),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+		dhcpv4.WithClientIP(ipnet.IP),
+		dhcpv4.WithBroadcast(false),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(server)),
+	)
+	if err != nil {
+		return err
+	}
+	packet, err := client4.MakeRawUDPPacket(release.ToBytes(),
+		net.UDPAddr{IP: server, Port: dhcpv4.ServerPort}, net.UDPAddr{IP: ipnet.IP, Port: dhcpv4.ClientPort})
+	if err != nil {
+		return err
+	}
+
+	sfd, err := client4.MakeBroadcastSocket(lease.Bridge)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(sfd)
+	var destination [net.IPv4len]byte
+	copy(destination[:], server)
+	return unix.Sendto(sfd, packet, 0, &unix.SockaddrInet4{Port: dhcpv4.ServerPort, Addr: destination})
+}
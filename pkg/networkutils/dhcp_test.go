@@ -0,0 +1,100 @@
+package networkutils
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func ack(t *testing.T, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	modifiers = append([]dhcpv4.Modifier{
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.ParseIP("192.168.1.10")),
+		dhcpv4.WithServerIP(net.ParseIP("192.168.1.2")),
+	}, modifiers...)
+	m, err := dhcpv4.New(modifiers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestLeaseFromAck(t *testing.T) {
+	now := time.Date(2024, 5, 20, 10, 0, 0, 0, time.UTC)
+
+	if _, err := leaseFromAck("br_260", "52:54:00:00:00:01", ack(t), now); err == nil {
+		t.Error("ack without lease time should be rejected")
+	}
+
+	// renewal and rebinding default to 1/2 and 7/8 of the lease time, the mask to the class of address
+	lease, err := leaseFromAck("br_260", "52:54:00:00:00:01", ack(t, dhcpv4.WithLeaseTime(3600)), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Address != "192.168.1.10/24" || lease.ServerID != "192.168.1.2" {
+		t.Errorf("unexpected address %s from server %s", lease.Address, lease.ServerID)
+	}
+	if !lease.Renew.Equal(now.Add(30*time.Minute)) || !lease.Rebind.Equal(now.Add(3150*time.Second)) || !lease.Expiry.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected lease times renew %s rebind %s expiry %s", lease.Renew, lease.Rebind, lease.Expiry)
+	}
+
+	lease, err = leaseFromAck("br_260", "52:54:00:00:00:01", ack(t,
+		dhcpv4.WithLeaseTime(3600),
+		dhcpv4.WithNetmask(net.CIDRMask(20, 32)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.ParseIP("192.168.1.1"))),
+		dhcpv4.WithOption(dhcpv4.Option{Code: dhcpv4.OptionRenewTimeValue, Value: dhcpv4.Duration(10 * time.Minute)}),
+		dhcpv4.WithOption(dhcpv4.Option{Code: dhcpv4.OptionRebindingTimeValue, Value: dhcpv4.Duration(20 * time.Minute)}),
+	), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Address != "192.168.1.10/20" || lease.ServerID != "192.168.1.1" {
+		t.Errorf("unexpected address %s from server %s", lease.Address, lease.ServerID)
+	}
+	if !lease.Renew.Equal(now.Add(10*time.Minute)) || !lease.Rebind.Equal(now.Add(20*time.Minute)) {
+		t.Errorf("unexpected lease times renew %s rebind %s", lease.Renew, lease.Rebind)
+	}
+}
+
+func TestRetryTime(t *testing.T) {
+	now := time.Date(2024, 5, 20, 10, 0, 0, 0, time.UTC)
+	lease := &Lease{
+		Renew:  now.Add(-time.Minute),
+		Rebind: now.Add(20 * time.Minute),
+		Expiry: now.Add(30 * time.Minute),
+	}
+
+	cases := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{name: "renewing", now: now, want: now.Add(10 * time.Minute)},
+		{name: "renewing no less than a minute", now: now.Add(19 * time.Minute), want: now.Add(20 * time.Minute)},
+		{name: "renewing before rebinding", now: now.Add(19*time.Minute + 30*time.Second), want: now.Add(20 * time.Minute)},
+		{name: "rebinding", now: now.Add(20 * time.Minute), want: now.Add(25 * time.Minute)},
+		{name: "rebinding before expiry", now: now.Add(29*time.Minute + 30*time.Second), want: now.Add(30 * time.Minute)},
+		{name: "expired", now: now.Add(time.Hour), want: now.Add(time.Hour + leaseAcquireRetry)},
+	}
+	for _, c := range cases {
+		if got := retryTime(lease, c.now); !got.Equal(c.want) {
+			t.Errorf("%s: got retry at %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestBindReleasedLease(t *testing.T) {
+	m := NewLeaseManager()
+	lease := &Lease{Bridge: "br_260", Address: "192.168.1.10/24", Expiry: time.Now().Add(time.Hour)}
+	renewed := *lease
+
+	// the lease is released while it is renewed
+	if err := m.bind(nil, &renewed, lease); err != errLeaseChanged {
+		t.Fatalf("renewed lease of a released bridge should be dropped, got %v", err)
+	}
+	if len(m.leases) != 0 || len(m.timers) != 0 {
+		t.Errorf("released lease should not be recorded again, got %v", m.leases)
+	}
+}
@@ -0,0 +1,217 @@
+package networkutils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	"github.com/yunify/hostnic-cni/pkg/constants"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+)
+
+// kinds of NetworkDrift
+const (
+	DriftMissingBridge     = "MissingBridge"
+	DriftMissingRouteTable = "MissingRouteTable"
+	DriftMissingRule       = "MissingRule"
+	DriftMissingPodRule    = "MissingPodRule"
+	DriftOrphanBridge      = "OrphanBridge"
+	DriftOrphanRouteTable  = "OrphanRouteTable"
+	DriftStaleRule         = "StaleRule"
+	DriftOrphanPodRule     = "OrphanPodRule"
+)
+
+// NetworkState is the network of node expected by the records of hostnic-node
+type NetworkState struct {
+	Nics   []*rpc.HostNic
+	PodIPs []string
+	// route tables in [RouteTableBase, RouteTableBase+RouteTableSize) are owned by hostnic
+	RouteTableBase int
+	RouteTableSize int
+}
+
+func (s NetworkState) ownsTable(table int) bool {
+	return table >= s.RouteTableBase && table < s.RouteTableBase+s.RouteTableSize
+}
+
+// kernelState is the policy routing state of node related to hostnic
+type kernelState struct {
+	bridges   map[int]netlink.Link
+	routes    map[int][]netlink.Route
+	fromRules map[int][]netlink.Rule
+	podRules  map[string][]netlink.Rule
+}
+
+func listKernelState(state NetworkState) (*kernelState, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %v", err)
+	}
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %v", err)
+	}
+	rules, err := netlink.RuleList(unix.AF_INET)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules: %v", err)
+	}
+	return newKernelState(state, links, routes, rules), nil
+}
+
+// newKernelState picks the bridges, routes and rules related to hostnic, those of tables out of the range
+// of state are left alone.
+func newKernelState(state NetworkState, links []netlink.Link, routes []netlink.Route, rules []netlink.Rule) *kernelState {
+	ks := &kernelState{
+		bridges:   make(map[int]netlink.Link),
+		routes:    make(map[int][]netlink.Route),
+		fromRules: make(map[int][]netlink.Rule),
+		podRules:  make(map[string][]netlink.Rule),
+	}
+
+	for _, link := range links {
+		name := link.Attrs().Name
+		if link.Type() != "bridge" || !strings.HasPrefix(name, constants.BridgePrefix) {
+			continue
+		}
+		table, err := strconv.Atoi(strings.TrimPrefix(name, constants.BridgePrefix))
+		if err != nil || !state.ownsTable(table) {
+			continue
+		}
+		ks.bridges[table] = link
+	}
+
+	for _, route := range routes {
+		if state.ownsTable(route.Table) {
+			ks.routes[route.Table] = append(ks.routes[route.Table], route)
+		}
+	}
+
+	for _, rule := range rules {
+		switch {
+		case rule.Priority == constants.FromContainerRulePriority && state.ownsTable(rule.Table):
+			ks.fromRules[rule.Table] = append(ks.fromRules[rule.Table], rule)
+		case rule.Priority == constants.ToContainerRulePriority && rule.Dst != nil:
+			ip := rule.Dst.IP.String()
+			ks.podRules[ip] = append(ks.podRules[ip], rule)
+		}
+	}
+
+	return ks
+}
+
+// DiffNetwork compares the policy routing state of node with state, and removes the orphans unless dryRun.
+// Drifts of nics are only reported, they are repaired by CheckAndRepairNetwork.
+func (n NetworkUtils) DiffNetwork(state NetworkState, dryRun bool) ([]*rpc.NetworkDrift, error) {
+	ks, err := listKernelState(state)
+	if err != nil {
+		return nil, err
+	}
+	return diffNetwork(state, ks, dryRun), nil
+}
+
+func diffNetwork(state NetworkState, ks *kernelState, dryRun bool) []*rpc.NetworkDrift {
+	var drifts []*rpc.NetworkDrift
+	nics := make(map[int]*rpc.HostNic)
+	for _, nic := range state.Nics {
+		table := int(nic.RouteTableNum)
+		nics[table] = nic
+
+		brName := constants.GetHostNicBridgeName(table)
+		if _, ok := ks.bridges[table]; !ok {
+			drifts = append(drifts, &rpc.NetworkDrift{Kind: DriftMissingBridge, Object: brName, VxNet: nic.VxNet.ID})
+		}
+		// routes of vlan nics are cleared by hostnic
+		if nic.VxNet.TunnelType != constants.TunnelTypeVlan && len(ks.routes[table]) == 0 {
+			drifts = append(drifts, &rpc.NetworkDrift{Kind: DriftMissingRouteTable, Object: strconv.Itoa(table), VxNet: nic.VxNet.ID})
+		}
+		if len(ks.fromRules[table]) == 0 {
+			drifts = append(drifts, &rpc.NetworkDrift{Kind: DriftMissingRule, Object: fmt.Sprintf("from %s lookup %d", nic.VxNet.Network, table), VxNet: nic.VxNet.ID})
+		}
+	}
+
+	// rules of pods are added by hostnic cni after the pod is recorded, report it only to avoid racing with it
+	pods := make(map[string]bool)
+	for _, ip := range state.PodIPs {
+		pods[ip] = true
+		if len(ks.podRules[ip]) == 0 {
+			drifts = append(drifts, &rpc.NetworkDrift{Kind: DriftMissingPodRule, Object: fmt.Sprintf("to %s lookup main", ip)})
+		}
+	}
+
+	// routes are gone with the bridge, so remove rules and routes before bridges
+	for table, rules := range ks.fromRules {
+		if nics[table] != nil {
+			continue
+		}
+		for i := range rules {
+			drifts = append(drifts, removeOrphan(DriftStaleRule, rules[i].String(), dryRun, func() error {
+				return ignoreNotExist(netlink.RuleDel(&rules[i]))
+			}))
+		}
+	}
+	for ip, rules := range ks.podRules {
+		if pods[ip] {
+			continue
+		}
+		for i := range rules {
+			drifts = append(drifts, removeOrphan(DriftOrphanPodRule, rules[i].String(), dryRun, func() error {
+				return ignoreNotExist(netlink.RuleDel(&rules[i]))
+			}))
+		}
+	}
+	for table, routes := range ks.routes {
+		if nics[table] != nil {
+			continue
+		}
+		drifts = append(drifts, removeOrphan(DriftOrphanRouteTable, strconv.Itoa(table), dryRun, func() error {
+			for i := range routes {
+				if err := ignoreNotExist(netlink.RouteDel(&routes[i])); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	for table, br := range ks.bridges {
+		if nics[table] != nil {
+			continue
+		}
+		drifts = append(drifts, removeOrphan(DriftOrphanBridge, br.Attrs().Name, dryRun, func() error {
+			return ignoreNotExist(netlink.LinkDel(br))
+		}))
+	}
+
+	return drifts
+}
+
+func removeOrphan(kind, object string, dryRun bool, remove func() error) *rpc.NetworkDrift {
+	drift := &rpc.NetworkDrift{Kind: kind, Object: object}
+	if dryRun {
+		return drift
+	}
+
+	if err := remove(); err != nil {
+		klog.Errorf("failed to remove %s %s: %v", kind, object, err)
+		drift.Error = err.Error()
+	} else {
+		klog.Infof("removed %s %s", kind, object)
+		drift.Repaired = true
+	}
+	return drift
+}
+
+// ignoreNotExist ignores the error of deleting rules, routes and links already deleted
+func ignoreNotExist(err error) error {
+	if err == nil || os.IsNotExist(err) || err == unix.ESRCH || strings.Contains(err.Error(), constants.RouteNotExistsError) {
+		return nil
+	}
+	if _, ok := err.(netlink.LinkNotFoundError); ok {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package networkutils
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/yunify/hostnic-cni/pkg/constants"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+)
+
+func bridge(table int) netlink.Link {
+	return &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: constants.GetHostNicBridgeName(table)}}
+}
+
+func podRule(ip string) netlink.Rule {
+	rule := *netlink.NewRule()
+	rule.Priority = constants.ToContainerRulePriority
+	rule.Dst = &net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(32, 32)}
+	return rule
+}
+
+func fromRule(table int) netlink.Rule {
+	rule := *netlink.NewRule()
+	rule.Priority = constants.FromContainerRulePriority
+	rule.Table = table
+	return rule
+}
+
+func driftKinds(drifts []*rpc.NetworkDrift) []string {
+	var kinds []string
+	for _, drift := range drifts {
+		kinds = append(kinds, drift.Kind+" "+drift.Object)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func TestDiffNetwork(t *testing.T) {
+	state := NetworkState{
+		Nics: []*rpc.HostNic{
+			{ID: "nic-a", RouteTableNum: 260, VxNet: &rpc.VxNet{ID: "vxnet-a", Network: "172.16.0.0/24"}},
+			{ID: "nic-b", RouteTableNum: 261, VxNet: &rpc.VxNet{ID: "vxnet-b", Network: "172.16.1.0/24"}},
+		},
+		PodIPs:         []string{"172.16.0.10", "172.16.1.10"},
+		RouteTableBase: 260,
+		RouteTableSize: 4,
+	}
+	links := []netlink.Link{
+		bridge(260),
+		// bridge of nic-b is missing, br_262 is left by a nic released
+		bridge(262),
+		// tables out of range are not owned by hostnic
+		bridge(300),
+		&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "br_261"}},
+	}
+	routes := []netlink.Route{
+		{Table: 260}, {Table: 261}, {Table: 262}, {Table: 300},
+	}
+	rules := []netlink.Rule{
+		fromRule(260),
+		// rule of nic-b is missing
+		fromRule(262), fromRule(300),
+		podRule("172.16.0.10"),
+		// the pod of 172.16.1.10 has no rule yet, and 172.16.0.11 was deleted
+		podRule("172.16.0.11"),
+	}
+
+	drifts := diffNetwork(state, newKernelState(state, links, routes, rules), true)
+	got := driftKinds(drifts)
+	want := []string{
+		DriftMissingBridge + " br_261",
+		DriftMissingPodRule + " to 172.16.1.10 lookup main",
+		DriftMissingRule + " from 172.16.1.0/24 lookup 261",
+		DriftOrphanBridge + " br_262",
+		DriftOrphanPodRule + " " + podRule("172.16.0.11").String(),
+		DriftOrphanRouteTable + " 262",
+		DriftStaleRule + " " + fromRule(262).String(),
+	}
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Fatalf("got drifts %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got drift %q, want %q", got[i], want[i])
+		}
+	}
+
+	for _, drift := range drifts {
+		if drift.Repaired || drift.Error != "" {
+			t.Errorf("drift %s %s should only be reported in dry run", drift.Kind, drift.Object)
+		}
+		// drifts of nics are repaired through their nic
+		switch drift.Kind {
+		case DriftMissingBridge, DriftMissingRule:
+			if drift.VxNet != "vxnet-b" {
+				t.Errorf("drift %s %s should belong to vxnet-b, got %q", drift.Kind, drift.Object, drift.VxNet)
+			}
+		default:
+			if drift.VxNet != "" {
+				t.Errorf("drift %s %s should not belong to a nic", drift.Kind, drift.Object)
+			}
+		}
+	}
+}
+
+func TestDiffNetworkVlan(t *testing.T) {
+	// routes of vlan nics are cleared by hostnic, so an empty table is expected
+	state := NetworkState{
+		Nics:           []*rpc.HostNic{{ID: "nic-a", RouteTableNum: 260, VxNet: &rpc.VxNet{ID: "vxnet-a", TunnelType: constants.TunnelTypeVlan}}},
+		RouteTableBase: 260,
+		RouteTableSize: 4,
+	}
+	drifts := diffNetwork(state, newKernelState(state, []netlink.Link{bridge(260)}, nil, []netlink.Rule{fromRule(260)}), true)
+	if len(drifts) != 0 {
+		t.Errorf("vlan nic should have no drift, got %v", driftKinds(drifts))
+	}
+}
@@ -0,0 +1,403 @@
+package networkutils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	"github.com/yunify/hostnic-cni/pkg/constants"
+)
+
+// EgressState is the policy routing and SNAT of node expected by the egress policies
+type EgressState struct {
+	// Mark is set on the connections initiated by pods, only they are routed to egress ips
+	Mark int
+	// ExcludeCIDRs are routed as usual, they are the cidrs of pods
+	ExcludeCIDRs []string
+	Rules        []EgressRule
+	Routes       []EgressRoute
+	SNATs        []EgressSNAT
+	ArpReplies   []EgressArpReply
+}
+
+// EgressRule is "from Src fwmark mark lookup Table" if Src is set, or "iif IifName to Dst lookup Table" for replies
+type EgressRule struct {
+	Src     string
+	Dst     string
+	IifName string
+	Table   int
+}
+
+// EgressRoute is "default via Gateway dev Bridge onlink" in Table
+type EgressRoute struct {
+	Table   int
+	Gateway string
+	Bridge  string
+}
+
+// EgressSNAT rewrites the source of traffic from Src leaving Bridge to EgressIP
+type EgressSNAT struct {
+	Src      string
+	Bridge   string
+	EgressIP string
+}
+
+// EgressArpReply answers arp requests for IP on Bridge with HardwareAddr
+type EgressArpReply struct {
+	Bridge       string
+	IP           string
+	HardwareAddr string
+}
+
+func (r EgressRule) netlinkRule(mark int) *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Table = r.Table
+	if r.Src != "" {
+		rule.Priority = constants.EgressRulePriority
+		rule.Src = hostIPNet(r.Src)
+		rule.Mark = mark
+		rule.Mask = mark
+	} else {
+		rule.Priority = constants.EgressReplyRulePriority
+		rule.Dst = hostIPNet(r.Dst)
+		rule.IifName = r.IifName
+	}
+	return rule
+}
+
+func (s EgressSNAT) ruleSpec() []string {
+	return []string{"-s", s.Src + "/32", "-o", s.Bridge, "-j", "SNAT", "--to-source", s.EgressIP}
+}
+
+func hostIPNet(ip string) *net.IPNet {
+	return &net.IPNet{IP: net.ParseIP(ip).To4(), Mask: net.CIDRMask(32, 32)}
+}
+
+// ruleKey identifies the rules at egress priorities
+func ruleKey(rule netlink.Rule) string {
+	table := rule.Table
+	if rule.Goto >= 0 {
+		table = 0
+	}
+	return fmt.Sprintf("%d from %v to %v fwmark %#x/%#x iif %s lookup %d goto %d",
+		rule.Priority, rule.Src, rule.Dst, rule.Mark, rule.Mask, rule.IifName, table, rule.Goto)
+}
+
+func routeKey(route netlink.Route) string {
+	return fmt.Sprintf("%d via %s dev %d", route.Table, route.Gw, route.LinkIndex)
+}
+
+func isEgressRulePriority(priority int) bool {
+	return priority == constants.EgressReplyRulePriority || priority == constants.EgressExcludeRulePriority ||
+		priority == constants.EgressRulePriority
+}
+
+func isEgressTable(table int) bool {
+	return table >= constants.EgressRouteTableBase && table < constants.EgressRouteTableBase+constants.EgressRouteTableSize
+}
+
+// SyncEgress makes the rules at egress priorities, the routes in egress tables and the SNAT of egress chain the same as state.
+// Arp replies are only added, they are removed by CleanupEgressIP once the egress ip is released.
+func (n NetworkUtils) SyncEgress(state EgressState) error {
+	var errs []string
+
+	if err := syncEgressRules(state); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := syncEgressRoutes(state); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := syncEgressIptables(state); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := syncEgressArpReplies(state); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("sync egress failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func syncEgressRules(state EgressState) error {
+	expected := make(map[string]*netlink.Rule)
+	for _, cidr := range state.ExcludeCIDRs {
+		_, dst, err := net.ParseCIDR(cidr)
+		if err != nil {
+			klog.Warningf("skip invalid egress exclude cidr %s: %v", cidr, err)
+			continue
+		}
+		rule := netlink.NewRule()
+		rule.Priority = constants.EgressExcludeRulePriority
+		rule.Dst = dst
+		rule.Goto = constants.ToContainerRulePriority
+		expected[ruleKey(*rule)] = rule
+	}
+	// nothing is routed by egress tables without exclusions, otherwise traffic between pods is broken
+	if len(expected) > 0 {
+		for _, r := range state.Rules {
+			rule := r.netlinkRule(state.Mark)
+			expected[ruleKey(*rule)] = rule
+		}
+	}
+
+	rules, err := netlink.RuleList(unix.AF_INET)
+	if err != nil {
+		return fmt.Errorf("failed to list rules: %v", err)
+	}
+	var errs []string
+	for i := range rules {
+		if !isEgressRulePriority(rules[i].Priority) {
+			continue
+		}
+		key := ruleKey(rules[i])
+		if _, ok := expected[key]; ok {
+			delete(expected, key)
+			continue
+		}
+		if err := ignoreNotExist(netlink.RuleDel(&rules[i])); err != nil {
+			errs = append(errs, fmt.Sprintf("del rule %s: %v", key, err))
+			continue
+		}
+		klog.Infof("removed egress rule %s", key)
+	}
+
+	// exclusions are added before the rules they guard
+	for _, priority := range []int{constants.EgressExcludeRulePriority, constants.EgressReplyRulePriority, constants.EgressRulePriority} {
+		for key, rule := range expected {
+			if rule.Priority != priority {
+				continue
+			}
+			if err := netlink.RuleAdd(rule); err != nil && !strings.Contains(err.Error(), constants.RouteExistsError) {
+				errs = append(errs, fmt.Sprintf("add rule %s: %v", key, err))
+				continue
+			}
+			klog.Infof("added egress rule %s", key)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func syncEgressRoutes(state EgressState) error {
+	var errs []string
+	expected := make(map[string]*netlink.Route)
+	for _, r := range state.Routes {
+		br, err := netlink.LinkByName(r.Bridge)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("get br %s: %v", r.Bridge, err))
+			continue
+		}
+		route := &netlink.Route{
+			LinkIndex: br.Attrs().Index,
+			Dst: &net.IPNet{
+				IP:   net.IPv4zero,
+				Mask: net.CIDRMask(0, 32),
+			},
+			Scope: netlink.SCOPE_UNIVERSE,
+			Gw:    net.ParseIP(r.Gateway).To4(),
+			Flags: int(netlink.FLAG_ONLINK),
+			Table: r.Table,
+		}
+		expected[routeKey(*route)] = route
+	}
+
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return fmt.Errorf("failed to list routes: %v", err)
+	}
+	for i := range routes {
+		if !isEgressTable(routes[i].Table) {
+			continue
+		}
+		key := routeKey(routes[i])
+		if _, ok := expected[key]; ok {
+			delete(expected, key)
+			continue
+		}
+		if err := ignoreNotExist(netlink.RouteDel(&routes[i])); err != nil {
+			errs = append(errs, fmt.Sprintf("del route %s: %v", key, err))
+			continue
+		}
+		klog.Infof("removed egress route %s", key)
+	}
+
+	for key, route := range expected {
+		if err := netlink.RouteReplace(route); err != nil {
+			errs = append(errs, fmt.Sprintf("add route %s: %v", key, err))
+			continue
+		}
+		klog.Infof("added egress route %s", key)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func syncEgressIptables(state EgressState) error {
+	ipt, err := iptables.New()
+	if err != nil {
+		return err
+	}
+
+	// jump before the masquerade rules of kube-proxy and network policy plugins
+	for _, jump := range [][]string{{"mangle", "PREROUTING"}, {"nat", "POSTROUTING"}} {
+		if err := ensureEgressChain(ipt, jump[0], jump[1]); err != nil {
+			return err
+		}
+	}
+	// the mark is configurable, so marks left by an old setting are removed like stale snats
+	mark := []string{"-m", "conntrack", "--ctdir", "ORIGINAL", "-j", "MARK", "--set-xmark",
+		fmt.Sprintf("%#x/%#x", state.Mark, state.Mark)}
+	var errs []string
+	if err := syncEgressChain(ipt, "mangle", [][]string{mark}); err != nil {
+		errs = append(errs, err.Error())
+	}
+	var snats [][]string
+	for _, snat := range state.SNATs {
+		snats = append(snats, snat.ruleSpec())
+	}
+	if err := syncEgressChain(ipt, "nat", snats); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// syncEgressChain makes the rules of egress chain in table the same as specs
+func syncEgressChain(ipt *iptables.IPTables, table string, specs [][]string) error {
+	expected := make(map[string][]string)
+	for _, spec := range specs {
+		expected[strings.Join(spec, " ")] = spec
+	}
+
+	lines, err := ipt.List(table, constants.EgressChain)
+	if err != nil {
+		return fmt.Errorf("failed to list chain %s of %s, err=%v", constants.EgressChain, table, err)
+	}
+	var errs []string
+	prefix := "-A " + constants.EgressChain + " "
+	for _, line := range lines {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(line, prefix)
+		if _, ok := expected[key]; ok {
+			delete(expected, key)
+			continue
+		}
+		if err := ipt.Delete(table, constants.EgressChain, strings.Fields(key)...); err != nil {
+			errs = append(errs, fmt.Sprintf("del rule %s: %v", key, err))
+			continue
+		}
+		klog.Infof("removed egress rule %s from %s", key, table)
+	}
+
+	for key, spec := range expected {
+		if err := ipt.Append(table, constants.EgressChain, spec...); err != nil {
+			errs = append(errs, fmt.Sprintf("add rule %s: %v", key, err))
+			continue
+		}
+		klog.Infof("added egress rule %s to %s", key, table)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func ensureEgressChain(ipt *iptables.IPTables, table, parent string) error {
+	ipt.NewChain(table, constants.EgressChain)
+	jump := []string{"-j", constants.EgressChain}
+	exist, err := ipt.Exists(table, parent, jump...)
+	if err != nil {
+		return fmt.Errorf("failed to check rule %v, err=%v", jump, err)
+	}
+	if !exist {
+		if err = ipt.Insert(table, parent, 1, jump...); err != nil {
+			return fmt.Errorf("failed to add rule %v, err=%v", jump, err)
+		}
+	}
+	return nil
+}
+
+func syncEgressArpReplies(state EgressState) error {
+	if len(state.ArpReplies) == 0 {
+		return nil
+	}
+	out, err := ListArpReply()
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, reply := range state.ArpReplies {
+		if _, ok := findArpReply(out, reply.IP)[reply.Bridge]; ok {
+			continue
+		}
+		if err := setArpReply(reply.Bridge, reply.IP, reply.HardwareAddr, "-I"); err != nil {
+			errs = append(errs, fmt.Sprintf("add arpreply for %s on %s: %v", reply.IP, reply.Bridge, err))
+			continue
+		}
+		klog.Infof("added egress arpreply for %s on %s", reply.IP, reply.Bridge)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// CleanupEgressIP removes the arp replies for ip on all bridges
+func (n NetworkUtils) CleanupEgressIP(ip string) error {
+	out, err := ListArpReply()
+	if err != nil {
+		return err
+	}
+	for br, mac := range findArpReply(out, ip) {
+		err := setArpReply(br, ip, mac, "-D")
+		if err != nil && !strings.Contains(err.Error(), "rule does not exist") && !strings.Contains(err.Error(), "does a matching rule exist in that chain") {
+			return fmt.Errorf("delete ebtables rule for ip %s error: %v", ip, err)
+		}
+		klog.Infof("removed egress arpreply for %s on %s", ip, br)
+	}
+	return nil
+}
+
+// findArpReply parses the output of ListArpReply, and returns the macs replied for ip keyed by bridge
+func findArpReply(out, ip string) map[string]string {
+	replies := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		var br, dst, mac string
+		for i := 0; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "--logical-in":
+				br = fields[i+1]
+			case "--arp-ip-dst":
+				dst = fields[i+1]
+			case "--arpreply-mac":
+				mac = fields[i+1]
+			}
+		}
+		if br != "" && mac != "" && dst == ip {
+			replies[br] = mac
+		}
+	}
+	return replies
+}
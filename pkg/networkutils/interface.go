@@ -1,6 +1,7 @@
 package networkutils
 
 import (
+	"context"
 	"net"
 
 	"github.com/yunify/hostnic-cni/pkg/constants"
@@ -14,12 +15,19 @@ type NetworkUtilsWrap interface {
 	SetupNetwork(nic *rpc.HostNic) (rpc.Phase, error)
 	CleanupNetwork(nic *rpc.HostNic) error
 	CheckAndRepairNetwork(nic *rpc.HostNic) (rpc.Phase, error)
+	DiffNetwork(state NetworkState, dryRun bool) ([]*rpc.NetworkDrift, error)
+	RouteTablesInUse() (map[int]bool, error)
+	SyncEgress(state EgressState) error
+	CleanupEgressIP(ip string) error
 
 	// for hostnic-cni
 	SetupPodNetwork(nic *rpc.HostNic, ip string) error
 	CleanupPodNetwork(nic *rpc.HostNic, ip string) error
+	CheckPodNetwork(nic *rpc.HostNic, ip string) error
 
 	LinkByMacAddr(macAddr string) (netlink.Link, error)
+	WaitLinkByMacAddr(ctx context.Context, macAddr string) (netlink.Link, error)
+	WatchNetwork(stopCh <-chan struct{}) <-chan NetworkEvent
 	IsNSorErr(nspath string) error
 }
 
@@ -51,6 +59,18 @@ func (n NetworkUtilsFake) LinkByMacAddr(macAddr string) (netlink.Link, error) {
 	return n.Links[macAddr], nil
 }
 
+func (n NetworkUtilsFake) WaitLinkByMacAddr(ctx context.Context, macAddr string) (netlink.Link, error) {
+	if link, ok := n.Links[macAddr]; ok {
+		return link, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (n NetworkUtilsFake) WatchNetwork(stopCh <-chan struct{}) <-chan NetworkEvent {
+	return nil
+}
+
 func (n NetworkUtilsFake) SetupPodNetwork(nic *rpc.HostNic, ip string) error {
 	return nil
 }
@@ -59,6 +79,10 @@ func (n NetworkUtilsFake) CleanupPodNetwork(nic *rpc.HostNic, ip string) error {
 	return nil
 }
 
+func (n NetworkUtilsFake) CheckPodNetwork(nic *rpc.HostNic, ip string) error {
+	return nil
+}
+
 func (n NetworkUtilsFake) SetupNetwork(nic *rpc.HostNic) (rpc.Phase, error) {
 	link := n.Links[nic.ID]
 
@@ -106,6 +130,29 @@ func (n NetworkUtilsFake) CleanupNetwork(nic *rpc.HostNic) error {
 	return nil
 }
 
+func (n NetworkUtilsFake) DiffNetwork(state NetworkState, dryRun bool) ([]*rpc.NetworkDrift, error) {
+	return nil, nil
+}
+
+func (n NetworkUtilsFake) RouteTablesInUse() (map[int]bool, error) {
+	tables := make(map[int]bool)
+	for table := range n.Routes {
+		tables[table] = true
+	}
+	for _, rule := range n.Rules {
+		tables[rule.Table] = true
+	}
+	return tables, nil
+}
+
+func (n NetworkUtilsFake) SyncEgress(state EgressState) error {
+	return nil
+}
+
+func (n NetworkUtilsFake) CleanupEgressIP(ip string) error {
+	return nil
+}
+
 func SetupNetworkFakeHelper() {
 	NetworkHelper = newNetworkUtilsFake()
 }
@@ -0,0 +1,311 @@
+package networkutils
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/yunify/hostnic-cni/pkg/constants"
+)
+
+const (
+	// monitorRetryInterval is the interval to subscribe again after a netlink subscription fails
+	monitorRetryInterval = 5 * time.Second
+	monitorBufferSize    = 128
+)
+
+// NetworkEvent tells that part of the network of a hostnic disappeared
+type NetworkEvent struct {
+	// HardwareAddr and Name are set when a link is deleted
+	HardwareAddr string
+	Name         string
+	// RouteTable is set when a route or rule of the table is deleted
+	RouteTable int
+}
+
+func (e NetworkEvent) String() string {
+	if e.RouteTable != 0 {
+		return fmt.Sprintf("route table %d", e.RouteTable)
+	}
+	return fmt.Sprintf("link %s(%s)", e.Name, e.HardwareAddr)
+}
+
+// linkMonitor keeps an index of links by mac address with netlink updates,
+// and reports the deletion of hostnic links, bridges, routes and rules.
+type linkMonitor struct {
+	lock   sync.RWMutex
+	synced bool
+	links  map[int]netlink.Link
+	macs   map[string]map[int]struct{}
+
+	events chan NetworkEvent
+}
+
+// monitor is set once WatchNetwork is called, links are listed from kernel before that
+var monitor *linkMonitor
+
+func newLinkMonitor() *linkMonitor {
+	return &linkMonitor{
+		links:  make(map[int]netlink.Link),
+		macs:   make(map[string]map[int]struct{}),
+		events: make(chan NetworkEvent, monitorBufferSize),
+	}
+}
+
+// WatchNetwork starts the link monitor and returns the events of hostnic network deletion
+func (n NetworkUtils) WatchNetwork(stopCh <-chan struct{}) <-chan NetworkEvent {
+	if monitor == nil {
+		monitor = newLinkMonitor()
+		go wait.Until(func() {
+			if err := monitor.watch(stopCh); err != nil {
+				klog.Errorf("link monitor failed, will retry in %s: %v", monitorRetryInterval, err)
+			}
+		}, monitorRetryInterval, stopCh)
+	}
+	return monitor.events
+}
+
+// watch returns when any subscription fails or stopCh is closed
+func (m *linkMonitor) watch(stopCh <-chan struct{}) error {
+	done := make(chan struct{})
+	defer close(done)
+	defer m.reset(nil)
+
+	errCh := make(chan error, 3)
+	onError := func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
+
+	linkCh := make(chan netlink.LinkUpdate, monitorBufferSize)
+	if err := netlink.LinkSubscribeWithOptions(linkCh, done, netlink.LinkSubscribeOptions{ErrorCallback: onError}); err != nil {
+		return fmt.Errorf("subscribe link updates failed: %v", err)
+	}
+	routeCh := make(chan netlink.RouteUpdate, monitorBufferSize)
+	if err := netlink.RouteSubscribeWithOptions(routeCh, done, netlink.RouteSubscribeOptions{ErrorCallback: onError}); err != nil {
+		return fmt.Errorf("subscribe route updates failed: %v", err)
+	}
+	ruleCh := make(chan int, monitorBufferSize)
+	if err := subscribeRuleDeletion(ruleCh, done, onError); err != nil {
+		return fmt.Errorf("subscribe rule updates failed: %v", err)
+	}
+	// the subscriptions stop sending after done is closed, drain them so that they could exit
+	defer func() {
+		go func() {
+			for range linkCh {
+			}
+		}()
+		go func() {
+			for range routeCh {
+			}
+		}()
+		go func() {
+			for range ruleCh {
+			}
+		}()
+	}()
+
+	// list links after subscribing so that no update is missed
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("list links failed: %v", err)
+	}
+	m.reset(links)
+	klog.Infof("link monitor started with %d links", len(links))
+
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case err := <-errCh:
+			return err
+		case update, ok := <-linkCh:
+			if !ok {
+				return fmt.Errorf("link subscription closed")
+			}
+			m.handleLink(update)
+		case update, ok := <-routeCh:
+			if !ok {
+				return fmt.Errorf("route subscription closed")
+			}
+			if update.Type == unix.RTM_DELROUTE && isHostNicTable(update.Table) {
+				m.notify(NetworkEvent{RouteTable: update.Table})
+			}
+		case table, ok := <-ruleCh:
+			if !ok {
+				return fmt.Errorf("rule subscription closed")
+			}
+			if isHostNicTable(table) {
+				m.notify(NetworkEvent{RouteTable: table})
+			}
+		}
+	}
+}
+
+func (m *linkMonitor) handleLink(update netlink.LinkUpdate) {
+	attrs := update.Attrs()
+	m.lock.Lock()
+	m.delLink(attrs.Index)
+	if update.Header.Type == unix.RTM_NEWLINK {
+		m.addLink(update.Link)
+	}
+	m.lock.Unlock()
+
+	if update.Header.Type == unix.RTM_DELLINK &&
+		(strings.HasPrefix(attrs.Name, constants.NicPrefix) || strings.HasPrefix(attrs.Name, constants.BridgePrefix)) {
+		m.notify(NetworkEvent{HardwareAddr: attrs.HardwareAddr.String(), Name: attrs.Name})
+	}
+}
+
+// notify drops the event if nobody is consuming, the periodic check repairs it later
+func (m *linkMonitor) notify(event NetworkEvent) {
+	select {
+	case m.events <- event:
+	default:
+		klog.Warningf("too many network events, drop the one of %s", event)
+	}
+}
+
+// reset rebuilds the index with links, the index is marked unsynced if links is nil
+func (m *linkMonitor) reset(links []netlink.Link) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.links = make(map[int]netlink.Link)
+	m.macs = make(map[string]map[int]struct{})
+	for _, link := range links {
+		m.addLink(link)
+	}
+	m.synced = links != nil
+}
+
+func (m *linkMonitor) addLink(link netlink.Link) {
+	attrs := link.Attrs()
+	m.links[attrs.Index] = link
+	mac := attrs.HardwareAddr.String()
+	if m.macs[mac] == nil {
+		m.macs[mac] = make(map[int]struct{})
+	}
+	m.macs[mac][attrs.Index] = struct{}{}
+}
+
+func (m *linkMonitor) delLink(index int) {
+	link, ok := m.links[index]
+	if !ok {
+		return
+	}
+	delete(m.links, index)
+	mac := link.Attrs().HardwareAddr.String()
+	delete(m.macs[mac], index)
+	if len(m.macs[mac]) == 0 {
+		delete(m.macs, mac)
+	}
+}
+
+// linksByMacAddr returns the links with macAddr, ok is false if the index is not ready
+func (m *linkMonitor) linksByMacAddr(macAddr string) (links []netlink.Link, ok bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if !m.synced {
+		return nil, false
+	}
+	for index := range m.macs[macAddr] {
+		links = append(links, m.links[index])
+	}
+	return links, true
+}
+
+// listLinksByMacAddr looks up the index of monitor first, and lists links from kernel if it misses,
+// since the index may lag behind links just attached.
+func listLinksByMacAddr(macAddr string) ([]netlink.Link, error) {
+	if monitor != nil {
+		if links, ok := monitor.linksByMacAddr(macAddr); ok && len(links) > 0 {
+			return links, nil
+		}
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	var result []netlink.Link
+	for _, link := range links {
+		if link.Attrs().HardwareAddr.String() == macAddr {
+			result = append(result, link)
+		}
+	}
+	return result, nil
+}
+
+// isHostNicTable skips the tables reserved by kernel, which are never used by hostnic
+func isHostNicTable(table int) bool {
+	switch table {
+	case unix.RT_TABLE_UNSPEC, unix.RT_TABLE_DEFAULT, unix.RT_TABLE_MAIN, unix.RT_TABLE_LOCAL:
+		return false
+	}
+	return true
+}
+
+// subscribeRuleDeletion sends the table of every deleted ipv4 rule to ch, netlink has no helper for rules
+func subscribeRuleDeletion(ch chan<- int, done <-chan struct{}, cberr func(error)) error {
+	s, err := nl.Subscribe(unix.NETLINK_ROUTE, unix.RTNLGRP_IPV4_RULE)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-done
+		s.Close()
+	}()
+
+	go func() {
+		defer close(ch)
+		for {
+			msgs, from, err := s.Receive()
+			if err != nil {
+				cberr(err)
+				return
+			}
+			if from.Pid != nl.PidKernel {
+				continue
+			}
+			for _, m := range msgs {
+				if m.Header.Type != unix.RTM_DELRULE || len(m.Data) < unix.SizeofRtMsg {
+					continue
+				}
+				table := ruleTable(m)
+				if table < 0 {
+					continue
+				}
+				ch <- table
+			}
+		}
+	}()
+	return nil
+}
+
+// ruleTable returns the table of rule message, the table in header is only 8 bits
+func ruleTable(m syscall.NetlinkMessage) int {
+	msg := nl.DeserializeRtMsg(m.Data)
+	table := int(msg.Table)
+	attrs, err := nl.ParseRouteAttr(m.Data[unix.SizeofRtMsg:])
+	if err != nil {
+		return -1
+	}
+	for _, attr := range attrs {
+		if attr.Attr.Type == nl.FRA_TABLE && len(attr.Value) >= 4 {
+			table = int(nl.NativeEndian().Uint32(attr.Value[0:4]))
+		}
+	}
+	return table
+}
@@ -2,6 +2,7 @@ package networkutils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net"
 	"os"
@@ -11,8 +12,6 @@ import (
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/utils/sysctl"
-	"github.com/insomniacslk/dhcp/dhcpv4/client4"
-	"github.com/insomniacslk/dhcp/netboot"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 	"k8s.io/klog/v2"
@@ -24,6 +23,9 @@ import (
 
 const (
 	ebtablesLock = "/var/run/hostnic/hostnic.lock"
+
+	// linkRecheckInterval lists links again in case the netlink subscription misses updates
+	linkRecheckInterval = 5 * time.Second
 )
 
 type NetworkUtils struct {
@@ -74,6 +76,39 @@ func (n NetworkUtils) CleanupPodNetwork(nic *rpc.HostNic, podIP string) error {
 	return nil
 }
 
+// CheckPodNetwork checks the rule and arpreply set by SetupPodNetwork, and the bridge of nic they rely on.
+func (n NetworkUtils) CheckPodNetwork(nic *rpc.HostNic, podIP string) error {
+	brName := constants.GetHostNicBridgeName(int(nic.RouteTableNum))
+	if _, err := netlink.LinkByName(brName); err != nil {
+		return fmt.Errorf("failed to get br %s: %v", brName, err)
+	}
+
+	rules, err := getRuleListByDst(net.ParseIP(podIP))
+	if err != nil {
+		return fmt.Errorf("get rule list by ip %s error: %v", podIP, err)
+	}
+	found := false
+	for _, rule := range rules {
+		if rule.Priority == constants.ToContainerRulePriority && rule.Table == constants.MainTable {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("rule to %s lookup main not found", podIP)
+	}
+
+	out, err := ListArpReply()
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, "--logical-in "+brName+" ") && strings.Contains(line, "--arp-ip-dst "+podIP+" ") {
+			return nil
+		}
+	}
+	return fmt.Errorf("arpreply for %s on %s not found", podIP, brName)
+}
+
 // Note: setup NetworkManager to disable dhcp on nic
 // SetupNicNetwork adds default route to route table (nic-<nic_table>)
 func (n NetworkUtils) SetupNetwork(nic *rpc.HostNic) (rpc.Phase, error) {
@@ -232,19 +267,10 @@ func (n NetworkUtils) setupBridgeNetwork(link netlink.Link, brName, tunnelType s
 		return fmt.Errorf("failed to set link %s up: %v", la.Name, err)
 	}
 	if tunnelType == constants.TunnelTypeVlan {
-		// get an ip addr and add to br
-		// 1.get an ip addr from dhcp server
-		bootConf, err := getIPAddrFromDHCPServer(brName)
-		if err != nil {
+		// get an ip addr from dhcp server and add to br, the lease is renewed by Leases
+		if err := Leases.Acquire(brName); err != nil {
 			return fmt.Errorf("failed to get ip address for link %s: %v", brName, err)
 		}
-		klog.Infof("get ip addr %+v success from dhcp server for link %s", bootConf.Addresses, brName)
-
-		// 2. replace addr to br
-		err = replaceLinkIPAddr(br, bootConf.Addresses)
-		if err != nil {
-			return fmt.Errorf("failed to replace ip address for link %s: %v", brName, err)
-		}
 	}
 
 	return nil
@@ -255,10 +281,13 @@ func (n NetworkUtils) clearBridgeNetwork(nic *rpc.HostNic) error {
 	br, err := netlink.LinkByName(brName)
 	if err != nil {
 		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			Leases.Release(brName)
 			return nil
 		}
 		return fmt.Errorf("failed to lookup br %s: %v", brName, err)
 	}
+	// the lease is released through the bridge, so release it before deleting bridge
+	Leases.Release(brName)
 
 	if err = netlink.LinkDel(br); err != nil {
 		return fmt.Errorf("failed to del br %s: %v", brName, err)
@@ -339,35 +368,78 @@ func (n NetworkUtils) clearRouteTable(nic *rpc.HostNic) error {
 
 // should check result, maybe empty
 func (n NetworkUtils) LinkByMacAddr(macAddr string) (netlink.Link, error) {
-	links, err := netlink.LinkList()
+	links, err := listLinksByMacAddr(macAddr)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, link := range links {
-		attr := link.Attrs()
-		if attr.HardwareAddr.String() == macAddr {
-			return link, nil
-		}
+	if len(links) > 0 {
+		return links[0], nil
 	}
 	return nil, constants.ErrNicNotFound
 }
 
+// WaitLinkByMacAddr waits for the link with macAddr to appear by netlink link updates until ctx is done
+func (n NetworkUtils) WaitLinkByMacAddr(ctx context.Context, macAddr string) (netlink.Link, error) {
+	updates := make(chan netlink.LinkUpdate)
+	done := make(chan struct{})
+	defer close(done)
+
+	if err := netlink.LinkSubscribeWithOptions(updates, done, netlink.LinkSubscribeOptions{
+		ListExisting: true,
+		ErrorCallback: func(err error) {
+			klog.Warningf("link subscription for %s failed: %v", macAddr, err)
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("subscribe link updates failed: %v", err)
+	}
+	// the subscription stops sending after done is closed, drain it so that it could exit
+	defer func() {
+		go func() {
+			for range updates {
+			}
+		}()
+	}()
+
+	ticker := time.NewTicker(linkRecheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				// rely on the ticker once the subscription fails
+				updates = nil
+				continue
+			}
+			if update.Header.Type == unix.RTM_NEWLINK && update.Attrs().HardwareAddr.String() == macAddr {
+				return update.Link, nil
+			}
+		case <-ticker.C:
+			link, err := n.LinkByMacAddr(macAddr)
+			if err == nil {
+				return link, nil
+			}
+			if err != constants.ErrNicNotFound {
+				return nil, err
+			}
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for nic %s: %w", macAddr, ctx.Err())
+		}
+	}
+}
+
 func (n NetworkUtils) getLinksByMacAddr(macAddr string) (netlink.Link, netlink.Link, error) {
 	var master, slave netlink.Link
-	links, err := netlink.LinkList()
+	links, err := listLinksByMacAddr(macAddr)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	for _, link := range links {
-		attr := link.Attrs()
-		if attr.HardwareAddr.String() == macAddr {
-			if attr.MasterIndex != 0 {
-				slave = link
-			} else {
-				master = link
-			}
+		if link.Attrs().MasterIndex != 0 {
+			slave = link
+		} else {
+			master = link
 		}
 	}
 	// attention: br has the same mac addr with hostnic link
@@ -422,6 +494,27 @@ func getRuleListBySrc(src net.IP) ([]netlink.Rule, error) {
 	return srcRuleList, nil
 }
 
+// RouteTablesInUse returns the tables which have routes or are looked up by rules
+func (n NetworkUtils) RouteTablesInUse() (map[int]bool, error) {
+	tables := make(map[int]bool)
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %v", err)
+	}
+	for _, route := range routes {
+		tables[route.Table] = true
+	}
+
+	rules, err := netlink.RuleList(unix.AF_INET)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules: %v", err)
+	}
+	for _, rule := range rules {
+		tables[rule.Table] = true
+	}
+	return tables, nil
+}
+
 func SetupNetworkHelper() {
 	NetworkHelper = NetworkUtils{}
 }
@@ -440,76 +533,3 @@ func ExecuteCommand(command string) (string, error) {
 
 	return out.String(), nil
 }
-
-func getIPAddrFromDHCPServer(ifname string) (*netboot.BootConf, error) {
-	// client := client4.NewClient()
-	client := &client4.Client{
-		ReadTimeout:  client4.DefaultReadTimeout * 5,
-		WriteTimeout: client4.DefaultWriteTimeout * 5,
-	}
-	conv, err := client.Exchange(ifname)
-	if err != nil {
-		return nil, fmt.Errorf("dhcp client exchange error: %v", err)
-	}
-	// extract the network configuration
-	netconf, err := netboot.ConversationToNetconfv4(conv)
-	if err != nil {
-		return nil, fmt.Errorf("dhcp client ConversationToNetconfv4 error: %v", err)
-	}
-	return netconf, nil
-}
-
-func replaceLinkIPAddr(link netlink.Link, addres []netboot.AddrConf) error {
-	var shortestLeaseTime time.Duration
-	ifName := link.Attrs().Name
-	if len(addres) < 1 {
-		return fmt.Errorf("there is no avaliable addr for link %s", ifName)
-	}
-	for _, addrConf := range addres {
-		if shortestLeaseTime.Seconds() == 0 {
-			shortestLeaseTime = addrConf.ValidLifetime
-		}
-		if addrConf.ValidLifetime.Seconds() < shortestLeaseTime.Seconds() {
-			shortestLeaseTime = addrConf.ValidLifetime
-		}
-
-		addr := &netlink.Addr{
-			IPNet:       &addrConf.IPNet,
-			ValidLft:    int(addrConf.ValidLifetime.Seconds()),
-			PreferedLft: int(addrConf.ValidLifetime.Seconds()),
-		}
-		err := netlink.AddrReplace(link, addr)
-		if err != nil {
-			return fmt.Errorf("replace addr %+v to link %s error: %v", addr, ifName, err)
-		}
-	}
-
-	if shortestLeaseTime.Seconds()/2 != constants.LastIPAddrRenewPeriod.Seconds() && shortestLeaseTime != 0 {
-		klog.Infof("update LastIPAddrRenewPeriod from %v to %v", constants.LastIPAddrRenewPeriod, shortestLeaseTime/2)
-		constants.LastIPAddrRenewPeriod = shortestLeaseTime / 2
-		constants.IpAddrReNewTicker.Reset(shortestLeaseTime / 2)
-	}
-
-	return nil
-}
-
-func UpdateLinkIPAddrAndLease(nic *rpc.HostNic) error {
-	brName := constants.GetHostNicBridgeName(int(nic.RouteTableNum))
-	la := netlink.NewLinkAttrs()
-	la.Name = brName
-	br := &netlink.Bridge{LinkAttrs: la}
-
-	// 1. get an ip form dhcp server
-	bootConf, err := getIPAddrFromDHCPServer(brName)
-	if err != nil || bootConf.Addresses == nil {
-		return fmt.Errorf("failed to get ip address for link %s: %v", brName, err)
-	}
-	klog.Infof("get ip addr %+v success from dhcp server for link %s", bootConf.Addresses, brName)
-
-	// 2. replace addr to br
-	err = replaceLinkIPAddr(br, bootConf.Addresses)
-	if err != nil {
-		return fmt.Errorf("failed to replace ip address for link %s: %v", brName, err)
-	}
-	return nil
-}
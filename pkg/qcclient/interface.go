@@ -17,6 +17,7 @@ type QingCloudAPI interface {
 
 	//job info
 	DescribeNicJobs(ids []string) ([]string, map[string]bool, error)
+	GetJobStatus(id string) (string, error)
 
 	//nic operations
 	CreateNicsAndAttach(vxnet *rpc.VxNet, num int, ips []string, disableIP int) ([]*rpc.HostNic, string, error)
@@ -33,7 +34,7 @@ type QingCloudAPI interface {
 
 	CreateSecurityGroupRuleForVxNet(sg string, vxnet *rpc.VxNet) (string, error)
 	GetSecurityGroupRuleForVxNet(sg string, vxnet *rpc.VxNet) (*rpc.SecurityGroupRule, error)
-	DeleteSecurityGroupRuleForVxNet(sgr string) error
+	DeleteSecurityGroupRuleForVxNet(sg, sgr string) (string, error)
 
 	DescribeClusterSecurityGroup(clusterID string) (string, error)
 	DescribeClusterNodes(clusterID string) ([]*rpc.Node, error)
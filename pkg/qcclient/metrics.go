@@ -0,0 +1,66 @@
+package qcclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/yunify/hostnic-cni/pkg/metrics/instrument"
+)
+
+// instrumentedTransport records the latency and errors of every QingCloud api call by its action.
+type instrumentedTransport struct {
+	base http.RoundTripper
+}
+
+func newInstrumentedTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &instrumentedTransport{base: base}
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	action := req.URL.Query().Get("action")
+	if action == "" && req.Form != nil {
+		action = req.Form.Get("action")
+	}
+	if action == "" {
+		action = "unknown"
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	failed := err != nil || resp.StatusCode >= http.StatusBadRequest
+	if !failed {
+		failed = hasRetCode(resp)
+	}
+
+	outcome := instrument.OutcomeSuccess
+	if failed {
+		outcome = instrument.OutcomeError
+		instrument.QingCloudAPIErrors.WithLabelValues(action).Inc()
+	}
+	instrument.ObserveSince(instrument.QingCloudAPIDuration, start, action, outcome)
+	return resp, err
+}
+
+// hasRetCode checks the ret_code of response body, and puts the body back for the sdk to unpack
+func hasRetCode(resp *http.Response) bool {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return true
+	}
+
+	var ret struct {
+		RetCode int `json:"ret_code"`
+	}
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return false
+	}
+	return ret.RetCode != 0
+}
@@ -0,0 +1,451 @@
+package qcclient
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	log "k8s.io/klog/v2"
+
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+	"github.com/yunify/hostnic-cni/pkg/tracing"
+	qcerrors "github.com/yunify/qingcloud-sdk-go/request/errors"
+)
+
+// ErrAPIUnavailable is returned without calling QingCloud while the circuit breaker is open
+var ErrAPIUnavailable = errors.New("QingCloud api is unavailable")
+
+// rejectedRetCodes are the ret_codes meaning the request was not executed and could be sent again:
+// server busy and resource busy
+var rejectedRetCodes = map[int]bool{
+	5100: true,
+	5200: true,
+}
+
+// retCodeInternalError may be returned after the request was partly executed, so only read actions
+// are sent again, otherwise e.g. CreateNics could create the nics twice
+const retCodeInternalError = 5000
+
+// statuses of finished jobs, others are still running
+const (
+	JobStatusSuccessful = "successful"
+	JobStatusFailed     = "failed"
+)
+
+type MiddlewareOptions struct {
+	// QPS and Burst limit the calls of every action
+	QPS   float64
+	Burst int
+
+	// MaxRetries is the retries of a call failed with retryable errors, the delay starts
+	// from RetryDelay and doubles every retry
+	MaxRetries int
+	RetryDelay time.Duration
+
+	// JobTimeout bounds the wait for AttachNics and DetachNics jobs in sync mode
+	JobTimeout      time.Duration
+	JobPollInterval time.Duration
+
+	// the circuit breaker opens after BreakerThreshold consecutive failures, and lets one
+	// call through to probe the api after BreakerCooldown
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+func NewMiddlewareOptions() *MiddlewareOptions {
+	return &MiddlewareOptions{
+		QPS:              5,
+		Burst:            10,
+		MaxRetries:       3,
+		RetryDelay:       time.Second,
+		JobTimeout:       defaultOpTimeout,
+		JobPollInterval:  defaultWaitInterval,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+}
+
+func (opt *MiddlewareOptions) AddFlags() {
+	flag.Float64Var(&opt.QPS, "qingcloud-api-qps", opt.QPS, "maximum QPS of every QingCloud api action.")
+	flag.IntVar(&opt.Burst, "qingcloud-api-burst", opt.Burst, "maximum burst of every QingCloud api action.")
+	flag.IntVar(&opt.MaxRetries, "qingcloud-api-retries", opt.MaxRetries, "retries of QingCloud api calls failed with retryable errors.")
+	flag.DurationVar(&opt.JobTimeout, "qingcloud-job-timeout", opt.JobTimeout, "timeout of waiting for QingCloud nic jobs.")
+	flag.IntVar(&opt.BreakerThreshold, "qingcloud-breaker-threshold", opt.BreakerThreshold, "consecutive QingCloud api failures to fail fast, 0 to disable.")
+	flag.DurationVar(&opt.BreakerCooldown, "qingcloud-breaker-cooldown", opt.BreakerCooldown, "how long to fail fast before probing QingCloud api again.")
+}
+
+var _ QingCloudAPI = &middleware{}
+
+// middleware rate limits, retries and guards the calls to QingCloudAPI with a circuit breaker,
+// and traces every call as a child of the span in ctx
+type middleware struct {
+	next QingCloudAPI
+	opts MiddlewareOptions
+	ctx  context.Context
+
+	limiters *actionLimiters
+	breaker  *circuitBreaker
+}
+
+func newMiddleware(next QingCloudAPI, opts *MiddlewareOptions) *middleware {
+	if opts == nil {
+		opts = NewMiddlewareOptions()
+	}
+	return &middleware{
+		next:     next,
+		opts:     *opts,
+		ctx:      context.Background(),
+		limiters: &actionLimiters{qps: opts.QPS, burst: opts.Burst, limiters: make(map[string]*rate.Limiter)},
+		breaker:  &circuitBreaker{threshold: opts.BreakerThreshold, cooldown: opts.BreakerCooldown},
+	}
+}
+
+// WithContext returns api whose calls are traced as children of the span in ctx and give up
+// waiting for the rate limit or retries once ctx is done, the limits and circuit breaker are shared with api.
+func WithContext(ctx context.Context, api QingCloudAPI) QingCloudAPI {
+	m, ok := api.(*middleware)
+	if !ok {
+		return api
+	}
+	c := *m
+	c.ctx = ctx
+	return &c
+}
+
+// actionLimiters limits the calls of every action separately
+type actionLimiters struct {
+	qps   float64
+	burst int
+
+	lock     sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func (l *actionLimiters) get(action string) *rate.Limiter {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	limiter, ok := l.limiters[action]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(l.qps), l.burst)
+		l.limiters[action] = limiter
+	}
+	return limiter
+}
+
+// do calls fn for the QingCloud action with rate limiting, retries and the circuit breaker
+func (m *middleware) do(action string, fn func() error) (err error) {
+	ctx, span := tracing.Start(m.ctx, "qingcloud."+action)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	delay := m.opts.RetryDelay
+	for i := 0; ; i++ {
+		if i > 0 {
+			span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", i), attribute.String("error", err.Error())))
+		}
+		if err = m.breaker.allow(); err != nil {
+			return fmt.Errorf("%s: %w", action, err)
+		}
+		if err = m.limiters.get(action).Wait(ctx); err != nil {
+			return err
+		}
+
+		err = fn()
+		m.breaker.record(isUnavailable(err))
+		if err == nil || i >= m.opts.MaxRetries || !isRetryable(action, err) {
+			return err
+		}
+
+		log.Warningf("QingCloud api %s failed, retry in %v: %v", action, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// isRetryable returns true if err ensures the request was not executed, other errors are
+// only retried for read actions as the request may have been executed.
+func isRetryable(action string, err error) bool {
+	var qcErr *qcerrors.QingCloudError
+	if errors.As(err, &qcErr) {
+		if rejectedRetCodes[qcErr.RetCode] {
+			return true
+		}
+		if qcErr.RetCode != retCodeInternalError {
+			return false
+		}
+	}
+	return isReadAction(action)
+}
+
+func isReadAction(action string) bool {
+	return strings.HasPrefix(action, "Describe")
+}
+
+// isUnavailable returns true if err means QingCloud could not serve the request,
+// other errors such as resource not found prove the api works.
+func isUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var qcErr *qcerrors.QingCloudError
+	if errors.As(err, &qcErr) {
+		return qcErr.RetCode >= 5000
+	}
+	return true
+}
+
+// waitJob polls job until it finishes or JobTimeout expires
+func (m *middleware) waitJob(action, job string) error {
+	deadline := time.Now().Add(m.opts.JobTimeout)
+	for {
+		var status string
+		err := m.do("DescribeJobs", func() (err error) {
+			status, err = m.next.GetJobStatus(job)
+			return
+		})
+		if errors.Is(err, ErrAPIUnavailable) {
+			return err
+		}
+
+		switch {
+		case err != nil:
+			log.Warningf("get status of job %s failed: %v", job, err)
+		case status == JobStatusSuccessful:
+			return nil
+		case status == JobStatusFailed:
+			return fmt.Errorf("%s job %s failed", action, job)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s job %s not finished in %v, last status %q", action, job, m.opts.JobTimeout, status)
+		}
+		time.Sleep(m.opts.JobPollInterval)
+	}
+}
+
+func (m *middleware) GetInstanceID() string {
+	return m.next.GetInstanceID()
+}
+
+func (m *middleware) GetCreatedNicsByName(name string) (rst []*rpc.HostNic, err error) {
+	err = m.do("DescribeNics", func() (err error) {
+		rst, err = m.next.GetCreatedNicsByName(name)
+		return
+	})
+	return
+}
+
+func (m *middleware) GetVxNets(ids []string, customReservedIPCount int64) (rst map[string]*rpc.VxNet, err error) {
+	err = m.do("DescribeVxNets", func() (err error) {
+		rst, err = m.next.GetVxNets(ids, customReservedIPCount)
+		return
+	})
+	return
+}
+
+func (m *middleware) GetJobStatus(id string) (rst string, err error) {
+	err = m.do("DescribeJobs", func() (err error) {
+		rst, err = m.next.GetJobStatus(id)
+		return
+	})
+	return
+}
+
+func (m *middleware) DescribeNicJobs(ids []string) (left []string, working map[string]bool, err error) {
+	err = m.do("DescribeJobs", func() (err error) {
+		left, working, err = m.next.DescribeNicJobs(ids)
+		return
+	})
+	return
+}
+
+func (m *middleware) CreateNicsAndAttach(vxnet *rpc.VxNet, num int, ips []string, disableIP int) (rst []*rpc.HostNic, job string, err error) {
+	err = m.do("CreateNics", func() (err error) {
+		rst, job, err = m.next.CreateNicsAndAttach(vxnet, num, ips, disableIP)
+		return
+	})
+	return
+}
+
+func (m *middleware) GetNics(nics []string) (rst map[string]*rpc.HostNic, err error) {
+	err = m.do("DescribeNics", func() (err error) {
+		rst, err = m.next.GetNics(nics)
+		return
+	})
+	return
+}
+
+func (m *middleware) DeleteNics(nicIDs []string) error {
+	return m.do("DeleteNics", func() error {
+		return m.next.DeleteNics(nicIDs)
+	})
+}
+
+func (m *middleware) DeattachNics(nicIDs []string, sync bool) (job string, err error) {
+	err = m.do("DetachNics", func() (err error) {
+		job, err = m.next.DeattachNics(nicIDs, false)
+		return
+	})
+	if err != nil || !sync || job == "" {
+		return
+	}
+	return "", m.waitJob("DetachNics", job)
+}
+
+func (m *middleware) AttachNics(nicIDs []string, sync bool) (job string, err error) {
+	err = m.do("AttachNics", func() (err error) {
+		job, err = m.next.AttachNics(nicIDs, false)
+		return
+	})
+	if err != nil || !sync || job == "" {
+		return
+	}
+	return "", m.waitJob("AttachNics", job)
+}
+
+func (m *middleware) GetAttachedNics() (rst []*rpc.HostNic, err error) {
+	err = m.do("DescribeNics", func() (err error) {
+		rst, err = m.next.GetAttachedNics()
+		return
+	})
+	return
+}
+
+func (m *middleware) GetCreatedNicsByVxNet(vxnet string) (rst []*rpc.HostNic, err error) {
+	err = m.do("DescribeNics", func() (err error) {
+		rst, err = m.next.GetCreatedNicsByVxNet(vxnet)
+		return
+	})
+	return
+}
+
+func (m *middleware) CreateVIPs(vxnet *rpc.VxNet) (job string, err error) {
+	err = m.do("AllocateVips", func() (err error) {
+		job, err = m.next.CreateVIPs(vxnet)
+		return
+	})
+	return
+}
+
+func (m *middleware) DescribeVIPs(vxnet *rpc.VxNet) (rst []*rpc.VIP, err error) {
+	err = m.do("DescribeVips", func() (err error) {
+		rst, err = m.next.DescribeVIPs(vxnet)
+		return
+	})
+	return
+}
+
+func (m *middleware) DeleteVIPs(vips []string) (job string, err error) {
+	err = m.do("ReleaseVips", func() (err error) {
+		job, err = m.next.DeleteVIPs(vips)
+		return
+	})
+	return
+}
+
+func (m *middleware) CreateSecurityGroupRuleForVxNet(sg string, vxnet *rpc.VxNet) (job string, err error) {
+	err = m.do("AddSecurityGroupRules", func() (err error) {
+		job, err = m.next.CreateSecurityGroupRuleForVxNet(sg, vxnet)
+		return
+	})
+	return
+}
+
+func (m *middleware) GetSecurityGroupRuleForVxNet(sg string, vxnet *rpc.VxNet) (rst *rpc.SecurityGroupRule, err error) {
+	err = m.do("DescribeSecurityGroupRules", func() (err error) {
+		rst, err = m.next.GetSecurityGroupRuleForVxNet(sg, vxnet)
+		return
+	})
+	return
+}
+
+func (m *middleware) DeleteSecurityGroupRuleForVxNet(sg, sgr string) (job string, err error) {
+	err = m.do("DeleteSecurityGroupRules", func() (err error) {
+		job, err = m.next.DeleteSecurityGroupRuleForVxNet(sg, sgr)
+		return
+	})
+	return
+}
+
+func (m *middleware) DescribeClusterSecurityGroup(clusterID string) (rst string, err error) {
+	err = m.do("DescribeClusters", func() (err error) {
+		rst, err = m.next.DescribeClusterSecurityGroup(clusterID)
+		return
+	})
+	return
+}
+
+func (m *middleware) DescribeClusterNodes(clusterID string) (rst []*rpc.Node, err error) {
+	err = m.do("DescribeClusterNodes", func() (err error) {
+		rst, err = m.next.DescribeClusterNodes(clusterID)
+		return
+	})
+	return
+}
+
+// circuitBreaker fails calls fast after threshold consecutive failures, after cooldown
+// one call is let through, the breaker closes if it succeeds or opens again if not.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	if now := time.Now(); now.Before(b.openUntil) || b.probing {
+		return fmt.Errorf("%w: %d consecutive failures, retry after %s", ErrAPIUnavailable, b.failures, b.openUntil.Format(time.RFC3339))
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.probing = false
+	if !failed {
+		if b.failures >= b.threshold {
+			log.Info("QingCloud api recovered, close circuit breaker")
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		log.Warningf("QingCloud api failed %d times in a row, fail fast until %s", b.failures, b.openUntil.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,227 @@
+package qcclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+	qcerrors "github.com/yunify/qingcloud-sdk-go/request/errors"
+)
+
+func retCode(code int) error {
+	return &qcerrors.QingCloudError{RetCode: code, Message: "test"}
+}
+
+// fakeQingCloud fails every call with err and counts the calls
+type fakeQingCloud struct {
+	QingCloudAPI
+	err   error
+	calls int
+}
+
+func (f *fakeQingCloud) GetNics(nics []string) (map[string]*rpc.HostNic, error) {
+	f.calls++
+	return nil, f.err
+}
+
+func (f *fakeQingCloud) CreateNicsAndAttach(vxnet *rpc.VxNet, num int, ips []string, disableIP int) ([]*rpc.HostNic, string, error) {
+	f.calls++
+	return nil, "", f.err
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		action string
+		err    error
+		want   bool
+	}{
+		{action: "CreateNics", err: retCode(5100), want: true},
+		{action: "CreateNics", err: retCode(5200), want: true},
+		{action: "CreateNics", err: retCode(5000), want: false},
+		{action: "DescribeNics", err: retCode(5000), want: true},
+		{action: "DescribeNics", err: retCode(2100), want: false},
+		{action: "CreateNics", err: errors.New("connection reset"), want: false},
+		{action: "DescribeNics", err: errors.New("connection reset"), want: true},
+	}
+	for _, c := range cases {
+		if got := isRetryable(c.action, c.err); got != c.want {
+			t.Errorf("isRetryable(%s, %v) = %v, want %v", c.action, c.err, got, c.want)
+		}
+	}
+}
+
+func TestMiddlewareRetry(t *testing.T) {
+	opts := NewMiddlewareOptions()
+	opts.RetryDelay = time.Millisecond
+	opts.BreakerThreshold = 0
+
+	fake := &fakeQingCloud{err: retCode(5000)}
+	m := newMiddleware(fake, opts)
+	if _, err := m.GetNics([]string{"nic-a"}); err == nil {
+		t.Fatal("expect error")
+	}
+	if fake.calls != opts.MaxRetries+1 {
+		t.Errorf("DescribeNics should be retried %d times, called %d times", opts.MaxRetries, fake.calls)
+	}
+
+	fake.calls = 0
+	if _, _, err := m.CreateNicsAndAttach(&rpc.VxNet{ID: "vxnet-a"}, 1, nil, 0); err == nil {
+		t.Fatal("expect error")
+	}
+	if fake.calls != 1 {
+		t.Errorf("CreateNics may have been executed and should not be retried, called %d times", fake.calls)
+	}
+
+	fake.calls = 0
+	fake.err = retCode(5100)
+	m.CreateNicsAndAttach(&rpc.VxNet{ID: "vxnet-a"}, 1, nil, 0)
+	if fake.calls != opts.MaxRetries+1 {
+		t.Errorf("CreateNics rejected by busy server should be retried %d times, called %d times", opts.MaxRetries, fake.calls)
+	}
+}
+
+func TestMiddlewareRetryCanceled(t *testing.T) {
+	opts := NewMiddlewareOptions()
+	opts.RetryDelay = time.Minute
+	opts.BreakerThreshold = 0
+
+	fake := &fakeQingCloud{err: retCode(5100)}
+	m := newMiddleware(fake, opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := WithContext(ctx, m).GetNics(nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("retry should give up once ctx is done, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("retry waited %v after ctx was done", elapsed)
+	}
+	if fake.calls != 1 {
+		t.Errorf("QingCloud should not be called after ctx is done, called %d times", fake.calls)
+	}
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	opts := NewMiddlewareOptions()
+	opts.QPS = 20
+	opts.Burst = 1
+
+	fake := &fakeQingCloud{}
+	m := newMiddleware(fake, opts)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		m.GetNics(nil)
+	}
+	// the first call uses the burst, the others wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("3 calls at 20 qps with burst 1 took %v, expect at least 100ms", elapsed)
+	}
+
+	// actions are limited separately
+	start = time.Now()
+	m.CreateNicsAndAttach(&rpc.VxNet{ID: "vxnet-a"}, 1, nil, 0)
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("first call of another action should not wait, took %v", elapsed)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := &circuitBreaker{threshold: 2, cooldown: 50 * time.Millisecond}
+
+	b.record(true)
+	if err := b.allow(); err != nil {
+		t.Fatalf("breaker should be closed below threshold: %v", err)
+	}
+	b.record(true)
+	if err := b.allow(); !errors.Is(err, ErrAPIUnavailable) {
+		t.Fatalf("breaker should be open after %d failures, got %v", b.threshold, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("breaker should let a probe through after cooldown: %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrAPIUnavailable) {
+		t.Fatalf("only one probe should be let through, got %v", err)
+	}
+	b.record(true)
+	if err := b.allow(); !errors.Is(err, ErrAPIUnavailable) {
+		t.Fatalf("breaker should open again after the probe failed, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("breaker should let a probe through after cooldown: %v", err)
+	}
+	b.record(false)
+	for i := 0; i < 2; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("breaker should close after the probe succeeded: %v", err)
+		}
+	}
+}
+
+func TestMiddlewareBreaker(t *testing.T) {
+	opts := NewMiddlewareOptions()
+	opts.MaxRetries = 0
+	opts.BreakerThreshold = 2
+	opts.BreakerCooldown = time.Minute
+
+	fake := &fakeQingCloud{err: retCode(5100)}
+	m := newMiddleware(fake, opts)
+	m.GetNics(nil)
+	m.GetNics(nil)
+	if _, err := m.GetNics(nil); !errors.Is(err, ErrAPIUnavailable) {
+		t.Fatalf("calls should fail fast once the breaker is open, got %v", err)
+	}
+	if fake.calls != 2 {
+		t.Errorf("QingCloud should not be called while the breaker is open, called %d times", fake.calls)
+	}
+
+	// errors such as resource not found prove the api works
+	fake.err = retCode(2100)
+	m = newMiddleware(fake, opts)
+	for i := 0; i < 3; i++ {
+		if _, err := m.GetNics(nil); errors.Is(err, ErrAPIUnavailable) {
+			t.Fatalf("client errors should not open the breaker")
+		}
+	}
+}
+
+func TestMiddlewareTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	opts := NewMiddlewareOptions()
+	opts.RetryDelay = time.Millisecond
+	opts.MaxRetries = 1
+	m := newMiddleware(&fakeQingCloud{err: retCode(5100)}, opts)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "allocator.AllocHostNic")
+	WithContext(ctx, m).GetNics(nil)
+	parent.End()
+	m.GetNics(nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expect a span for every call and the parent, got %d", len(spans))
+	}
+	if spans[0].Name() != "qingcloud.DescribeNics" || spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("call with context should be traced as a child, got %s with parent %s", spans[0].Name(), spans[0].Parent().SpanID())
+	}
+	if events := spans[0].Events(); len(events) == 0 || events[0].Name != "retry" || spans[0].Status().Description == "" {
+		t.Errorf("retries and the error should be recorded on the span, got events %v status %v", spans[0].Events(), spans[0].Status())
+	}
+	if spans[2].Parent().IsValid() {
+		t.Errorf("call without context should be traced as a root span")
+	}
+}
@@ -13,9 +13,11 @@ import (
 	log "k8s.io/klog/v2"
 
 	"github.com/yunify/hostnic-cni/pkg/constants"
+	"github.com/yunify/hostnic-cni/pkg/metrics/instrument"
 	rpc "github.com/yunify/hostnic-cni/pkg/rpc"
 	"github.com/yunify/qingcloud-sdk-go/client"
 	"github.com/yunify/qingcloud-sdk-go/config"
+	qcerrors "github.com/yunify/qingcloud-sdk-go/request/errors"
 	"github.com/yunify/qingcloud-sdk-go/service"
 )
 
@@ -35,6 +37,8 @@ const (
 
 type Options struct {
 	Tag string
+	// Middleware configures rate limiting, retries and the circuit breaker, defaults are used if nil
+	Middleware *MiddlewareOptions
 }
 
 var _ QingCloudAPI = &qingcloudAPIWrapper{}
@@ -70,6 +74,7 @@ func SetupQingCloudClient(opts Options) {
 	}
 
 	log.Infof("qsdkconfig inited: %v", qsdkconfig)
+	qsdkconfig.Connection.Transport = newInstrumentedTransport(qsdkconfig.Connection.Transport)
 
 	qcService, err := service.Init(qsdkconfig)
 	if err != nil {
@@ -129,7 +134,7 @@ func SetupQingCloudClient(opts Options) {
 	}
 	userId := *output.AccessKeySet[0].Owner
 
-	QClient = &qingcloudAPIWrapper{
+	QClient = newMiddleware(&qingcloudAPIWrapper{
 		nicService:      nicService,
 		vxNetService:    vxNetService,
 		instanceService: instanceService,
@@ -142,7 +147,7 @@ func SetupQingCloudClient(opts Options) {
 		userID:     userId,
 		instanceID: string(instanceID),
 		opts:       opts,
-	}
+	}, opts.Middleware)
 }
 
 func (q *qingcloudAPIWrapper) GetInstanceID() string {
@@ -256,18 +261,23 @@ func (q *qingcloudAPIWrapper) AttachNics(nicIDs []string, sync bool) (string, er
 		Instance: &q.instanceID,
 	}
 
+	start := time.Now()
 	output, err := q.nicService.AttachNics(input)
 	if err != nil {
+		instrument.ObserveSince(instrument.NicOperationDuration, start, instrument.NicOperationAttach, instrument.OutcomeError)
 		log.Errorf("failed to AttachNics: input (%s) output (%s) %v", spew.Sdump(input), spew.Sdump(output), err)
 		return "", err
 	}
 
 	if sync {
-		return "", client.WaitJob(q.jobService, *output.JobID,
+		err = client.WaitJob(q.jobService, *output.JobID,
 			defaultOpTimeout,
 			defaultWaitInterval)
+		instrument.ObserveSince(instrument.NicOperationDuration, start, instrument.NicOperationAttach, instrument.Outcome(err))
+		return "", err
 	}
 
+	instrument.ObserveSince(instrument.NicOperationDuration, start, instrument.NicOperationAttach, instrument.OutcomeSuccess)
 	return *output.JobID, nil
 }
 
@@ -334,7 +344,9 @@ func (q *qingcloudAPIWrapper) CreateNicsAndAttach(vxnet *rpc.VxNet, num int, ips
 		input.PrivateIPs = service.StringSlice(ips)
 	}
 
+	start := time.Now()
 	output, err := q.nicService.CreateNics(input)
+	instrument.ObserveSince(instrument.NicOperationDuration, start, instrument.NicOperationCreate, instrument.Outcome(err))
 	if err != nil {
 		log.Errorf("failed to create nics: input (%s) output (%s) %v", spew.Sdump(input), spew.Sdump(output), err)
 		return nil, "", err
@@ -378,18 +390,23 @@ func (q *qingcloudAPIWrapper) DeattachNics(nicIDs []string, sync bool) (string,
 		Nics: service.StringSlice(nicIDs),
 	}
 
+	start := time.Now()
 	output, err := q.nicService.DetachNics(input)
 	if err != nil {
+		instrument.ObserveSince(instrument.NicOperationDuration, start, instrument.NicOperationDetach, instrument.OutcomeError)
 		log.Errorf("failed to DeattachNics: input (%s) output (%s) %v", spew.Sdump(input), spew.Sdump(output), err)
 		return "", err
 	}
 
 	if sync {
-		return "", client.WaitJob(q.jobService, *output.JobID,
+		err = client.WaitJob(q.jobService, *output.JobID,
 			defaultOpTimeout,
 			defaultWaitInterval)
+		instrument.ObserveSince(instrument.NicOperationDuration, start, instrument.NicOperationDetach, instrument.Outcome(err))
+		return "", err
 	}
 
+	instrument.ObserveSince(instrument.NicOperationDuration, start, instrument.NicOperationDetach, instrument.OutcomeSuccess)
 	return *output.JobID, nil
 }
 
@@ -402,7 +419,9 @@ func (q *qingcloudAPIWrapper) DeleteNics(nicIDs []string) error {
 		Nics: service.StringSlice(nicIDs),
 	}
 
+	start := time.Now()
 	output, err := q.nicService.DeleteNics(input)
+	instrument.ObserveSince(instrument.NicOperationDuration, start, instrument.NicOperationDelete, instrument.Outcome(err))
 	if err != nil {
 		log.Errorf("failed to DeleteNics: input (%s) output (%s) %v", spew.Sdump(input), spew.Sdump(output), err)
 		return err
@@ -445,6 +464,23 @@ func (q *qingcloudAPIWrapper) DescribeNicJobs(ids []string) ([]string, map[strin
 	return left, working, nil
 }
 
+func (q *qingcloudAPIWrapper) GetJobStatus(id string) (string, error) {
+	input := &service.DescribeJobsInput{
+		Jobs: service.StringSlice([]string{id}),
+	}
+
+	output, err := q.jobService.DescribeJobs(input)
+	if err != nil {
+		log.Errorf("failed to GetJobStatus: input (%s) output (%s) %v", spew.Sdump(input), spew.Sdump(output), err)
+		return "", err
+	}
+	if len(output.JobSet) == 0 || output.JobSet[0].Status == nil {
+		return "", fmt.Errorf("job %s not found", id)
+	}
+
+	return *output.JobSet[0].Status, nil
+}
+
 func (q *qingcloudAPIWrapper) getVxNets(ids []string, public bool, customReservedIPCount int64) ([]*rpc.VxNet, error) {
 	input := &service.DescribeVxNetsInput{
 		VxNets: service.StringSlice(ids),
@@ -698,18 +734,42 @@ func (q *qingcloudAPIWrapper) GetSecurityGroupRuleForVxNet(sg string, vxnet *rpc
 	return nil, nil
 }
 
-func (q *qingcloudAPIWrapper) DeleteSecurityGroupRuleForVxNet(sgr string) error {
+func (q *qingcloudAPIWrapper) DeleteSecurityGroupRuleForVxNet(sg, sgr string) (string, error) {
 	input := &service.DeleteSecurityGroupRulesInput{
 		SecurityGroupRules: []*string{service.String(sgr)},
 	}
 
 	output, err := q.sgService.DeleteSecurityGroupRules(input)
-	if err != nil || *output.RetCode != 0 {
+	if err == nil {
+		err = retCodeError(output.RetCode, output.Message)
+	}
+	if err != nil {
 		log.Errorf("failed to DeleteSecurityGroupRules: input (%s) output (%s) %v", spew.Sdump(input), spew.Sdump(output), err)
-		return err
+		return "", err
 	}
 
-	return nil
+	applyInput := &service.ApplySecurityGroupInput{
+		SecurityGroup: service.String(sg),
+	}
+	o, err := q.sgService.ApplySecurityGroup(applyInput)
+	if err == nil {
+		err = retCodeError(o.RetCode, o.Message)
+	}
+	if err != nil {
+		log.Errorf("failed to ApplySecurityGroup: input (%s) output (%s) %v", spew.Sdump(applyInput), spew.Sdump(o), err)
+		return "", err
+	}
+
+	return *o.JobID, nil
+}
+
+// retCodeError returns the QingCloudError of a response whose ret_code is not zero, the sdk only
+// returns errors for some of them.
+func retCodeError(retCode *int, message *string) error {
+	if retCode == nil || *retCode == 0 {
+		return nil
+	}
+	return &qcerrors.QingCloudError{RetCode: *retCode, Message: service.StringValue(message)}
 }
 
 func (q *qingcloudAPIWrapper) DescribeClusterSecurityGroup(clusterID string) (string, error) {
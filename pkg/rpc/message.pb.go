@@ -83,6 +83,7 @@ const (
 	Phase_JoinBridge      Phase = 2
 	Phase_SetRouteTable   Phase = 3
 	Phase_Succeeded       Phase = 4
+	Phase_AttachFailed    Phase = 5
 )
 
 // Enum value maps for Phase.
@@ -93,6 +94,7 @@ var (
 		2: "JoinBridge",
 		3: "SetRouteTable",
 		4: "Succeeded",
+		5: "AttachFailed",
 	}
 	Phase_value = map[string]int32{
 		"Init":            0,
@@ -100,6 +102,7 @@ var (
 		"JoinBridge":      2,
 		"SetRouteTable":   3,
 		"Succeeded":       4,
+		"AttachFailed":    5,
 	}
 )
 
@@ -967,6 +970,605 @@ func (*Nothing) Descriptor() ([]byte, []int) {
 	return file_pkg_rpc_message_proto_rawDescGZIP(), []int{9}
 }
 
+type NetworkCheckRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	DryRun bool `protobuf:"varint,1,opt,name=DryRun,proto3" json:"DryRun,omitempty"`
+}
+
+func (x *NetworkCheckRequest) Reset() {
+	*x = NetworkCheckRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_rpc_message_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NetworkCheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NetworkCheckRequest) ProtoMessage() {}
+
+func (x *NetworkCheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_message_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NetworkCheckRequest.ProtoReflect.Descriptor instead.
+func (*NetworkCheckRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_message_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *NetworkCheckRequest) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+type NetworkDrift struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Kind     string `protobuf:"bytes,1,opt,name=Kind,proto3" json:"Kind,omitempty"`
+	Object   string `protobuf:"bytes,2,opt,name=Object,proto3" json:"Object,omitempty"`
+	VxNet    string `protobuf:"bytes,3,opt,name=VxNet,proto3" json:"VxNet,omitempty"`
+	Repaired bool   `protobuf:"varint,4,opt,name=Repaired,proto3" json:"Repaired,omitempty"`
+	Error    string `protobuf:"bytes,5,opt,name=Error,proto3" json:"Error,omitempty"`
+}
+
+func (x *NetworkDrift) Reset() {
+	*x = NetworkDrift{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_rpc_message_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NetworkDrift) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NetworkDrift) ProtoMessage() {}
+
+func (x *NetworkDrift) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_message_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NetworkDrift.ProtoReflect.Descriptor instead.
+func (*NetworkDrift) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_message_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *NetworkDrift) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *NetworkDrift) GetObject() string {
+	if x != nil {
+		return x.Object
+	}
+	return ""
+}
+
+func (x *NetworkDrift) GetVxNet() string {
+	if x != nil {
+		return x.VxNet
+	}
+	return ""
+}
+
+func (x *NetworkDrift) GetRepaired() bool {
+	if x != nil {
+		return x.Repaired
+	}
+	return false
+}
+
+func (x *NetworkDrift) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type NetworkDriftList struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Items []*NetworkDrift `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+}
+
+func (x *NetworkDriftList) Reset() {
+	*x = NetworkDriftList{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_rpc_message_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NetworkDriftList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NetworkDriftList) ProtoMessage() {}
+
+func (x *NetworkDriftList) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_message_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NetworkDriftList.ProtoReflect.Descriptor instead.
+func (*NetworkDriftList) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_message_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *NetworkDriftList) GetItems() []*NetworkDrift {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type DHCPLease struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Bridge       string `protobuf:"bytes,1,opt,name=Bridge,proto3" json:"Bridge,omitempty"`
+	HardwareAddr string `protobuf:"bytes,2,opt,name=HardwareAddr,proto3" json:"HardwareAddr,omitempty"`
+	Address      string `protobuf:"bytes,3,opt,name=Address,proto3" json:"Address,omitempty"`
+	ServerID     string `protobuf:"bytes,4,opt,name=ServerID,proto3" json:"ServerID,omitempty"`
+	State        string `protobuf:"bytes,5,opt,name=State,proto3" json:"State,omitempty"`
+	Renew        int64  `protobuf:"varint,6,opt,name=Renew,proto3" json:"Renew,omitempty"`
+	Rebind       int64  `protobuf:"varint,7,opt,name=Rebind,proto3" json:"Rebind,omitempty"`
+	Expiry       int64  `protobuf:"varint,8,opt,name=Expiry,proto3" json:"Expiry,omitempty"`
+}
+
+func (x *DHCPLease) Reset() {
+	*x = DHCPLease{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_rpc_message_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DHCPLease) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DHCPLease) ProtoMessage() {}
+
+func (x *DHCPLease) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_message_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DHCPLease.ProtoReflect.Descriptor instead.
+func (*DHCPLease) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_message_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *DHCPLease) GetBridge() string {
+	if x != nil {
+		return x.Bridge
+	}
+	return ""
+}
+
+func (x *DHCPLease) GetHardwareAddr() string {
+	if x != nil {
+		return x.HardwareAddr
+	}
+	return ""
+}
+
+func (x *DHCPLease) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *DHCPLease) GetServerID() string {
+	if x != nil {
+		return x.ServerID
+	}
+	return ""
+}
+
+func (x *DHCPLease) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *DHCPLease) GetRenew() int64 {
+	if x != nil {
+		return x.Renew
+	}
+	return 0
+}
+
+func (x *DHCPLease) GetRebind() int64 {
+	if x != nil {
+		return x.Rebind
+	}
+	return 0
+}
+
+func (x *DHCPLease) GetExpiry() int64 {
+	if x != nil {
+		return x.Expiry
+	}
+	return 0
+}
+
+type DHCPLeaseList struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Items []*DHCPLease `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+}
+
+func (x *DHCPLeaseList) Reset() {
+	*x = DHCPLeaseList{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_rpc_message_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DHCPLeaseList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DHCPLeaseList) ProtoMessage() {}
+
+func (x *DHCPLeaseList) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_message_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DHCPLeaseList.ProtoReflect.Descriptor instead.
+func (*DHCPLeaseList) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_message_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *DHCPLeaseList) GetItems() []*DHCPLease {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type PodInfoList struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Items []*PodInfo `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+}
+
+func (x *PodInfoList) Reset() {
+	*x = PodInfoList{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_rpc_message_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PodInfoList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PodInfoList) ProtoMessage() {}
+
+func (x *PodInfoList) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_message_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PodInfoList.ProtoReflect.Descriptor instead.
+func (*PodInfoList) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_message_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *PodInfoList) GetItems() []*PodInfo {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type Attachment struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ContainerID string `protobuf:"bytes,1,opt,name=ContainerID,proto3" json:"ContainerID,omitempty"`
+	IfName      string `protobuf:"bytes,2,opt,name=IfName,proto3" json:"IfName,omitempty"`
+}
+
+func (x *Attachment) Reset() {
+	*x = Attachment{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_rpc_message_proto_msgTypes[16]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Attachment) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Attachment) ProtoMessage() {}
+
+func (x *Attachment) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_message_proto_msgTypes[16]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Attachment.ProtoReflect.Descriptor instead.
+func (*Attachment) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_message_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *Attachment) GetContainerID() string {
+	if x != nil {
+		return x.ContainerID
+	}
+	return ""
+}
+
+func (x *Attachment) GetIfName() string {
+	if x != nil {
+		return x.IfName
+	}
+	return ""
+}
+
+type GCRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ValidAttachments []*Attachment `protobuf:"bytes,1,rep,name=ValidAttachments,proto3" json:"ValidAttachments,omitempty"`
+	NodeName         string        `protobuf:"bytes,2,opt,name=NodeName,proto3" json:"NodeName,omitempty"`
+	// vxnet of the secondary network collected, empty for the primary network
+	VxNet string `protobuf:"bytes,3,opt,name=VxNet,proto3" json:"VxNet,omitempty"`
+}
+
+func (x *GCRequest) Reset() {
+	*x = GCRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_rpc_message_proto_msgTypes[17]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GCRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GCRequest) ProtoMessage() {}
+
+func (x *GCRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_message_proto_msgTypes[17]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GCRequest.ProtoReflect.Descriptor instead.
+func (*GCRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_message_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *GCRequest) GetValidAttachments() []*Attachment {
+	if x != nil {
+		return x.ValidAttachments
+	}
+	return nil
+}
+
+func (x *GCRequest) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+func (x *GCRequest) GetVxNet() string {
+	if x != nil {
+		return x.VxNet
+	}
+	return ""
+}
+
+type GCReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Pods    []*PodInfo `protobuf:"bytes,1,rep,name=Pods,proto3" json:"Pods,omitempty"`
+	Handles []string   `protobuf:"bytes,2,rep,name=Handles,proto3" json:"Handles,omitempty"`
+}
+
+func (x *GCReply) Reset() {
+	*x = GCReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_rpc_message_proto_msgTypes[18]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GCReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GCReply) ProtoMessage() {}
+
+func (x *GCReply) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_message_proto_msgTypes[18]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GCReply.ProtoReflect.Descriptor instead.
+func (*GCReply) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_message_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *GCReply) GetPods() []*PodInfo {
+	if x != nil {
+		return x.Pods
+	}
+	return nil
+}
+
+func (x *GCReply) GetHandles() []string {
+	if x != nil {
+		return x.Handles
+	}
+	return nil
+}
+
+type StatusReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ready  bool   `protobuf:"varint,1,opt,name=Ready,proto3" json:"Ready,omitempty"`
+	Reason string `protobuf:"bytes,2,opt,name=Reason,proto3" json:"Reason,omitempty"`
+}
+
+func (x *StatusReply) Reset() {
+	*x = StatusReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pkg_rpc_message_proto_msgTypes[19]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StatusReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusReply) ProtoMessage() {}
+
+func (x *StatusReply) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_message_proto_msgTypes[19]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusReply.ProtoReflect.Descriptor instead.
+func (*StatusReply) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_message_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *StatusReply) GetReady() bool {
+	if x != nil {
+		return x.Ready
+	}
+	return false
+}
+
+func (x *StatusReply) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
 var File_pkg_rpc_message_proto protoreflect.FileDescriptor
 
 var file_pkg_rpc_message_proto_rawDesc = []byte{
@@ -1071,31 +1673,104 @@ var file_pkg_rpc_message_proto_rawDesc = []byte{
 	0x4e, 0x69, 0x63, 0x49, 0x6e, 0x66, 0x6f, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x22, 0x0a, 0x05, 0x69,
 	0x74, 0x65, 0x6d, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x72, 0x70, 0x63,
 	0x2e, 0x4e, 0x69, 0x63, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x05, 0x69, 0x74, 0x65, 0x6d, 0x73, 0x22,
-	0x09, 0x0a, 0x07, 0x4e, 0x6f, 0x74, 0x68, 0x69, 0x6e, 0x67, 0x2a, 0x43, 0x0a, 0x06, 0x53, 0x74,
-	0x61, 0x74, 0x75, 0x73, 0x12, 0x08, 0x0a, 0x04, 0x46, 0x52, 0x45, 0x45, 0x10, 0x00, 0x12, 0x09,
-	0x0a, 0x05, 0x55, 0x53, 0x49, 0x4e, 0x47, 0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08, 0x44, 0x45, 0x4c,
-	0x45, 0x54, 0x49, 0x4e, 0x47, 0x10, 0x02, 0x12, 0x09, 0x0a, 0x05, 0x45, 0x52, 0x52, 0x4f, 0x52,
-	0x10, 0x03, 0x12, 0x0b, 0x0a, 0x07, 0x44, 0x45, 0x4c, 0x45, 0x54, 0x45, 0x44, 0x10, 0x04, 0x2a,
-	0x58, 0x0a, 0x05, 0x50, 0x68, 0x61, 0x73, 0x65, 0x12, 0x08, 0x0a, 0x04, 0x49, 0x6e, 0x69, 0x74,
-	0x10, 0x00, 0x12, 0x13, 0x0a, 0x0f, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x41, 0x6e, 0x64, 0x41,
-	0x74, 0x74, 0x61, 0x63, 0x68, 0x10, 0x01, 0x12, 0x0e, 0x0a, 0x0a, 0x4a, 0x6f, 0x69, 0x6e, 0x42,
-	0x72, 0x69, 0x64, 0x67, 0x65, 0x10, 0x02, 0x12, 0x11, 0x0a, 0x0d, 0x53, 0x65, 0x74, 0x52, 0x6f,
-	0x75, 0x74, 0x65, 0x54, 0x61, 0x62, 0x6c, 0x65, 0x10, 0x03, 0x12, 0x0d, 0x0a, 0x09, 0x53, 0x75,
-	0x63, 0x63, 0x65, 0x65, 0x64, 0x65, 0x64, 0x10, 0x04, 0x32, 0xcd, 0x01, 0x0a, 0x0a, 0x43, 0x4e,
-	0x49, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x12, 0x32, 0x0a, 0x0a, 0x41, 0x64, 0x64, 0x4e,
-	0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x12, 0x10, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x49, 0x50, 0x41,
-	0x4d, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x1a, 0x10, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x49,
-	0x50, 0x41, 0x4d, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x00, 0x12, 0x32, 0x0a, 0x0a,
-	0x44, 0x65, 0x6c, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x12, 0x10, 0x2e, 0x72, 0x70, 0x63,
-	0x2e, 0x49, 0x50, 0x41, 0x4d, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x1a, 0x10, 0x2e, 0x72,
-	0x70, 0x63, 0x2e, 0x49, 0x50, 0x41, 0x4d, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x00,
-	0x12, 0x2c, 0x0a, 0x08, 0x53, 0x68, 0x6f, 0x77, 0x4e, 0x69, 0x63, 0x73, 0x12, 0x0c, 0x2e, 0x72,
-	0x70, 0x63, 0x2e, 0x4e, 0x6f, 0x74, 0x68, 0x69, 0x6e, 0x67, 0x1a, 0x10, 0x2e, 0x72, 0x70, 0x63,
-	0x2e, 0x4e, 0x69, 0x63, 0x49, 0x6e, 0x66, 0x6f, 0x4c, 0x69, 0x73, 0x74, 0x22, 0x00, 0x12, 0x29,
-	0x0a, 0x09, 0x43, 0x6c, 0x65, 0x61, 0x72, 0x4e, 0x69, 0x63, 0x73, 0x12, 0x0c, 0x2e, 0x72, 0x70,
-	0x63, 0x2e, 0x4e, 0x6f, 0x74, 0x68, 0x69, 0x6e, 0x67, 0x1a, 0x0c, 0x2e, 0x72, 0x70, 0x63, 0x2e,
-	0x4e, 0x6f, 0x74, 0x68, 0x69, 0x6e, 0x67, 0x22, 0x00, 0x42, 0x0b, 0x5a, 0x09, 0x2e, 0x2f, 0x70,
-	0x6b, 0x67, 0x2f, 0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x09, 0x0a, 0x07, 0x4e, 0x6f, 0x74, 0x68, 0x69, 0x6e, 0x67, 0x22, 0x2d, 0x0a, 0x13, 0x4e, 0x65,
+	0x74, 0x77, 0x6f, 0x72, 0x6b, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x12, 0x16, 0x0a, 0x06, 0x44, 0x72, 0x79, 0x52, 0x75, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x06, 0x44, 0x72, 0x79, 0x52, 0x75, 0x6e, 0x22, 0x82, 0x01, 0x0a, 0x0c, 0x4e, 0x65,
+	0x74, 0x77, 0x6f, 0x72, 0x6b, 0x44, 0x72, 0x69, 0x66, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x4b, 0x69,
+	0x6e, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x4b, 0x69, 0x6e, 0x64, 0x12, 0x16,
+	0x0a, 0x06, 0x4f, 0x62, 0x6a, 0x65, 0x63, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x4f, 0x62, 0x6a, 0x65, 0x63, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x56, 0x78, 0x4e, 0x65, 0x74, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x56, 0x78, 0x4e, 0x65, 0x74, 0x12, 0x1a, 0x0a, 0x08,
+	0x52, 0x65, 0x70, 0x61, 0x69, 0x72, 0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08,
+	0x52, 0x65, 0x70, 0x61, 0x69, 0x72, 0x65, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x45, 0x72, 0x72, 0x6f,
+	0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x22, 0x3b,
+	0x0a, 0x10, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x44, 0x72, 0x69, 0x66, 0x74, 0x4c, 0x69,
+	0x73, 0x74, 0x12, 0x27, 0x0a, 0x05, 0x69, 0x74, 0x65, 0x6d, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x11, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x44,
+	0x72, 0x69, 0x66, 0x74, 0x52, 0x05, 0x69, 0x74, 0x65, 0x6d, 0x73, 0x22, 0xd9, 0x01, 0x0a, 0x09,
+	0x44, 0x48, 0x43, 0x50, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x42, 0x72, 0x69,
+	0x64, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x42, 0x72, 0x69, 0x64, 0x67,
+	0x65, 0x12, 0x22, 0x0a, 0x0c, 0x48, 0x61, 0x72, 0x64, 0x77, 0x61, 0x72, 0x65, 0x41, 0x64, 0x64,
+	0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x48, 0x61, 0x72, 0x64, 0x77, 0x61, 0x72,
+	0x65, 0x41, 0x64, 0x64, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12,
+	0x1a, 0x0a, 0x08, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x44, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x08, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x44, 0x12, 0x14, 0x0a, 0x05, 0x53,
+	0x74, 0x61, 0x74, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x53, 0x74, 0x61, 0x74,
+	0x65, 0x12, 0x14, 0x0a, 0x05, 0x52, 0x65, 0x6e, 0x65, 0x77, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x05, 0x52, 0x65, 0x6e, 0x65, 0x77, 0x12, 0x16, 0x0a, 0x06, 0x52, 0x65, 0x62, 0x69, 0x6e,
+	0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x52, 0x65, 0x62, 0x69, 0x6e, 0x64, 0x12,
+	0x16, 0x0a, 0x06, 0x45, 0x78, 0x70, 0x69, 0x72, 0x79, 0x18, 0x08, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x06, 0x45, 0x78, 0x70, 0x69, 0x72, 0x79, 0x22, 0x35, 0x0a, 0x0d, 0x44, 0x48, 0x43, 0x50, 0x4c,
+	0x65, 0x61, 0x73, 0x65, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x24, 0x0a, 0x05, 0x69, 0x74, 0x65, 0x6d,
+	0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x44, 0x48,
+	0x43, 0x50, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x05, 0x69, 0x74, 0x65, 0x6d, 0x73, 0x22, 0x31,
+	0x0a, 0x0b, 0x50, 0x6f, 0x64, 0x49, 0x6e, 0x66, 0x6f, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x22, 0x0a,
+	0x05, 0x69, 0x74, 0x65, 0x6d, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x72,
+	0x70, 0x63, 0x2e, 0x50, 0x6f, 0x64, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x05, 0x69, 0x74, 0x65, 0x6d,
+	0x73, 0x22, 0x46, 0x0a, 0x0a, 0x41, 0x74, 0x74, 0x61, 0x63, 0x68, 0x6d, 0x65, 0x6e, 0x74, 0x12,
+	0x20, 0x0a, 0x0b, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65, 0x72, 0x49, 0x44, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65, 0x72, 0x49,
+	0x44, 0x12, 0x16, 0x0a, 0x06, 0x49, 0x66, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x49, 0x66, 0x4e, 0x61, 0x6d, 0x65, 0x22, 0x7a, 0x0a, 0x09, 0x47, 0x43, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x3b, 0x0a, 0x10, 0x56, 0x61, 0x6c, 0x69, 0x64, 0x41,
+	0x74, 0x74, 0x61, 0x63, 0x68, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x0f, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x41, 0x74, 0x74, 0x61, 0x63, 0x68, 0x6d, 0x65, 0x6e,
+	0x74, 0x52, 0x10, 0x56, 0x61, 0x6c, 0x69, 0x64, 0x41, 0x74, 0x74, 0x61, 0x63, 0x68, 0x6d, 0x65,
+	0x6e, 0x74, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x4e, 0x6f, 0x64, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x4e, 0x6f, 0x64, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x12,
+	0x14, 0x0a, 0x05, 0x56, 0x78, 0x4e, 0x65, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x56, 0x78, 0x4e, 0x65, 0x74, 0x22, 0x45, 0x0a, 0x07, 0x47, 0x43, 0x52, 0x65, 0x70, 0x6c, 0x79,
+	0x12, 0x20, 0x0a, 0x04, 0x50, 0x6f, 0x64, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c,
+	0x2e, 0x72, 0x70, 0x63, 0x2e, 0x50, 0x6f, 0x64, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x04, 0x50, 0x6f,
+	0x64, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x48, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x73, 0x18, 0x02, 0x20,
+	0x03, 0x28, 0x09, 0x52, 0x07, 0x48, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x73, 0x22, 0x3b, 0x0a, 0x0b,
+	0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x52,
+	0x65, 0x61, 0x64, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x52, 0x65, 0x61, 0x64,
+	0x79, 0x12, 0x16, 0x0a, 0x06, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x2a, 0x43, 0x0a, 0x06, 0x53, 0x74, 0x61,
+	0x74, 0x75, 0x73, 0x12, 0x08, 0x0a, 0x04, 0x46, 0x52, 0x45, 0x45, 0x10, 0x00, 0x12, 0x09, 0x0a,
+	0x05, 0x55, 0x53, 0x49, 0x4e, 0x47, 0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08, 0x44, 0x45, 0x4c, 0x45,
+	0x54, 0x49, 0x4e, 0x47, 0x10, 0x02, 0x12, 0x09, 0x0a, 0x05, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x10,
+	0x03, 0x12, 0x0b, 0x0a, 0x07, 0x44, 0x45, 0x4c, 0x45, 0x54, 0x45, 0x44, 0x10, 0x04, 0x2a, 0x6a,
+	0x0a, 0x05, 0x50, 0x68, 0x61, 0x73, 0x65, 0x12, 0x08, 0x0a, 0x04, 0x49, 0x6e, 0x69, 0x74, 0x10,
+	0x00, 0x12, 0x13, 0x0a, 0x0f, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x41, 0x6e, 0x64, 0x41, 0x74,
+	0x74, 0x61, 0x63, 0x68, 0x10, 0x01, 0x12, 0x0e, 0x0a, 0x0a, 0x4a, 0x6f, 0x69, 0x6e, 0x42, 0x72,
+	0x69, 0x64, 0x67, 0x65, 0x10, 0x02, 0x12, 0x11, 0x0a, 0x0d, 0x53, 0x65, 0x74, 0x52, 0x6f, 0x75,
+	0x74, 0x65, 0x54, 0x61, 0x62, 0x6c, 0x65, 0x10, 0x03, 0x12, 0x0d, 0x0a, 0x09, 0x53, 0x75, 0x63,
+	0x63, 0x65, 0x65, 0x64, 0x65, 0x64, 0x10, 0x04, 0x12, 0x10, 0x0a, 0x0c, 0x41, 0x74, 0x74, 0x61,
+	0x63, 0x68, 0x46, 0x61, 0x69, 0x6c, 0x65, 0x64, 0x10, 0x05, 0x32, 0xc9, 0x03, 0x0a, 0x0a, 0x43,
+	0x4e, 0x49, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x12, 0x32, 0x0a, 0x0a, 0x41, 0x64, 0x64,
+	0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x12, 0x10, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x49, 0x50,
+	0x41, 0x4d, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x1a, 0x10, 0x2e, 0x72, 0x70, 0x63, 0x2e,
+	0x49, 0x50, 0x41, 0x4d, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x00, 0x12, 0x32, 0x0a,
+	0x0a, 0x44, 0x65, 0x6c, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x12, 0x10, 0x2e, 0x72, 0x70,
+	0x63, 0x2e, 0x49, 0x50, 0x41, 0x4d, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x1a, 0x10, 0x2e,
+	0x72, 0x70, 0x63, 0x2e, 0x49, 0x50, 0x41, 0x4d, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22,
+	0x00, 0x12, 0x2c, 0x0a, 0x08, 0x53, 0x68, 0x6f, 0x77, 0x4e, 0x69, 0x63, 0x73, 0x12, 0x0c, 0x2e,
+	0x72, 0x70, 0x63, 0x2e, 0x4e, 0x6f, 0x74, 0x68, 0x69, 0x6e, 0x67, 0x1a, 0x10, 0x2e, 0x72, 0x70,
+	0x63, 0x2e, 0x4e, 0x69, 0x63, 0x49, 0x6e, 0x66, 0x6f, 0x4c, 0x69, 0x73, 0x74, 0x22, 0x00, 0x12,
+	0x29, 0x0a, 0x09, 0x43, 0x6c, 0x65, 0x61, 0x72, 0x4e, 0x69, 0x63, 0x73, 0x12, 0x0c, 0x2e, 0x72,
+	0x70, 0x63, 0x2e, 0x4e, 0x6f, 0x74, 0x68, 0x69, 0x6e, 0x67, 0x1a, 0x0c, 0x2e, 0x72, 0x70, 0x63,
+	0x2e, 0x4e, 0x6f, 0x74, 0x68, 0x69, 0x6e, 0x67, 0x22, 0x00, 0x12, 0x41, 0x0a, 0x0c, 0x43, 0x68,
+	0x65, 0x63, 0x6b, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x12, 0x18, 0x2e, 0x72, 0x70, 0x63,
+	0x2e, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x4e, 0x65, 0x74, 0x77, 0x6f,
+	0x72, 0x6b, 0x44, 0x72, 0x69, 0x66, 0x74, 0x4c, 0x69, 0x73, 0x74, 0x22, 0x00, 0x12, 0x30, 0x0a,
+	0x0a, 0x53, 0x68, 0x6f, 0x77, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x73, 0x12, 0x0c, 0x2e, 0x72, 0x70,
+	0x63, 0x2e, 0x4e, 0x6f, 0x74, 0x68, 0x69, 0x6e, 0x67, 0x1a, 0x12, 0x2e, 0x72, 0x70, 0x63, 0x2e,
+	0x44, 0x48, 0x43, 0x50, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x4c, 0x69, 0x73, 0x74, 0x22, 0x00, 0x12,
+	0x2c, 0x0a, 0x08, 0x53, 0x68, 0x6f, 0x77, 0x50, 0x6f, 0x64, 0x73, 0x12, 0x0c, 0x2e, 0x72, 0x70,
+	0x63, 0x2e, 0x4e, 0x6f, 0x74, 0x68, 0x69, 0x6e, 0x67, 0x1a, 0x10, 0x2e, 0x72, 0x70, 0x63, 0x2e,
+	0x50, 0x6f, 0x64, 0x49, 0x6e, 0x66, 0x6f, 0x4c, 0x69, 0x73, 0x74, 0x22, 0x00, 0x12, 0x2b, 0x0a,
+	0x09, 0x47, 0x43, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x12, 0x0e, 0x2e, 0x72, 0x70, 0x63,
+	0x2e, 0x47, 0x43, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0c, 0x2e, 0x72, 0x70, 0x63,
+	0x2e, 0x47, 0x43, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x2a, 0x0a, 0x06, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x12, 0x0c, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x4e, 0x6f, 0x74, 0x68, 0x69,
+	0x6e, 0x67, 0x1a, 0x10, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52,
+	0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x42, 0x0b, 0x5a, 0x09, 0x2e, 0x2f, 0x70, 0x6b, 0x67, 0x2f,
+	0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -1111,20 +1786,30 @@ func file_pkg_rpc_message_proto_rawDescGZIP() []byte {
 }
 
 var file_pkg_rpc_message_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
-var file_pkg_rpc_message_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_pkg_rpc_message_proto_msgTypes = make([]protoimpl.MessageInfo, 20)
 var file_pkg_rpc_message_proto_goTypes = []interface{}{
-	(Status)(0),               // 0: rpc.Status
-	(Phase)(0),                // 1: rpc.Phase
-	(*VxNet)(nil),             // 2: rpc.VxNet
-	(*HostNic)(nil),           // 3: rpc.HostNic
-	(*PodInfo)(nil),           // 4: rpc.PodInfo
-	(*IPAMMessage)(nil),       // 5: rpc.IPAMMessage
-	(*VIP)(nil),               // 6: rpc.VIP
-	(*SecurityGroupRule)(nil), // 7: rpc.SecurityGroupRule
-	(*Node)(nil),              // 8: rpc.Node
-	(*NicInfo)(nil),           // 9: rpc.NicInfo
-	(*NicInfoList)(nil),       // 10: rpc.NicInfoList
-	(*Nothing)(nil),           // 11: rpc.Nothing
+	(Status)(0),                 // 0: rpc.Status
+	(Phase)(0),                  // 1: rpc.Phase
+	(*VxNet)(nil),               // 2: rpc.VxNet
+	(*HostNic)(nil),             // 3: rpc.HostNic
+	(*PodInfo)(nil),             // 4: rpc.PodInfo
+	(*IPAMMessage)(nil),         // 5: rpc.IPAMMessage
+	(*VIP)(nil),                 // 6: rpc.VIP
+	(*SecurityGroupRule)(nil),   // 7: rpc.SecurityGroupRule
+	(*Node)(nil),                // 8: rpc.Node
+	(*NicInfo)(nil),             // 9: rpc.NicInfo
+	(*NicInfoList)(nil),         // 10: rpc.NicInfoList
+	(*Nothing)(nil),             // 11: rpc.Nothing
+	(*NetworkCheckRequest)(nil), // 12: rpc.NetworkCheckRequest
+	(*NetworkDrift)(nil),        // 13: rpc.NetworkDrift
+	(*NetworkDriftList)(nil),    // 14: rpc.NetworkDriftList
+	(*DHCPLease)(nil),           // 15: rpc.DHCPLease
+	(*DHCPLeaseList)(nil),       // 16: rpc.DHCPLeaseList
+	(*PodInfoList)(nil),         // 17: rpc.PodInfoList
+	(*Attachment)(nil),          // 18: rpc.Attachment
+	(*GCRequest)(nil),           // 19: rpc.GCRequest
+	(*GCReply)(nil),             // 20: rpc.GCReply
+	(*StatusReply)(nil),         // 21: rpc.StatusReply
 }
 var file_pkg_rpc_message_proto_depIdxs = []int32{
 	2,  // 0: rpc.HostNic.VxNet:type_name -> rpc.VxNet
@@ -1133,19 +1818,34 @@ var file_pkg_rpc_message_proto_depIdxs = []int32{
 	4,  // 3: rpc.IPAMMessage.Args:type_name -> rpc.PodInfo
 	3,  // 4: rpc.IPAMMessage.Nic:type_name -> rpc.HostNic
 	9,  // 5: rpc.NicInfoList.items:type_name -> rpc.NicInfo
-	5,  // 6: rpc.CNIBackend.AddNetwork:input_type -> rpc.IPAMMessage
-	5,  // 7: rpc.CNIBackend.DelNetwork:input_type -> rpc.IPAMMessage
-	11, // 8: rpc.CNIBackend.ShowNics:input_type -> rpc.Nothing
-	11, // 9: rpc.CNIBackend.ClearNics:input_type -> rpc.Nothing
-	5,  // 10: rpc.CNIBackend.AddNetwork:output_type -> rpc.IPAMMessage
-	5,  // 11: rpc.CNIBackend.DelNetwork:output_type -> rpc.IPAMMessage
-	10, // 12: rpc.CNIBackend.ShowNics:output_type -> rpc.NicInfoList
-	11, // 13: rpc.CNIBackend.ClearNics:output_type -> rpc.Nothing
-	10, // [10:14] is the sub-list for method output_type
-	6,  // [6:10] is the sub-list for method input_type
-	6,  // [6:6] is the sub-list for extension type_name
-	6,  // [6:6] is the sub-list for extension extendee
-	0,  // [0:6] is the sub-list for field type_name
+	13, // 6: rpc.NetworkDriftList.items:type_name -> rpc.NetworkDrift
+	15, // 7: rpc.DHCPLeaseList.items:type_name -> rpc.DHCPLease
+	4,  // 8: rpc.PodInfoList.items:type_name -> rpc.PodInfo
+	18, // 9: rpc.GCRequest.ValidAttachments:type_name -> rpc.Attachment
+	4,  // 10: rpc.GCReply.Pods:type_name -> rpc.PodInfo
+	5,  // 11: rpc.CNIBackend.AddNetwork:input_type -> rpc.IPAMMessage
+	5,  // 12: rpc.CNIBackend.DelNetwork:input_type -> rpc.IPAMMessage
+	11, // 13: rpc.CNIBackend.ShowNics:input_type -> rpc.Nothing
+	11, // 14: rpc.CNIBackend.ClearNics:input_type -> rpc.Nothing
+	12, // 15: rpc.CNIBackend.CheckNetwork:input_type -> rpc.NetworkCheckRequest
+	11, // 16: rpc.CNIBackend.ShowLeases:input_type -> rpc.Nothing
+	11, // 17: rpc.CNIBackend.ShowPods:input_type -> rpc.Nothing
+	19, // 18: rpc.CNIBackend.GCNetwork:input_type -> rpc.GCRequest
+	11, // 19: rpc.CNIBackend.Status:input_type -> rpc.Nothing
+	5,  // 20: rpc.CNIBackend.AddNetwork:output_type -> rpc.IPAMMessage
+	5,  // 21: rpc.CNIBackend.DelNetwork:output_type -> rpc.IPAMMessage
+	10, // 22: rpc.CNIBackend.ShowNics:output_type -> rpc.NicInfoList
+	11, // 23: rpc.CNIBackend.ClearNics:output_type -> rpc.Nothing
+	14, // 24: rpc.CNIBackend.CheckNetwork:output_type -> rpc.NetworkDriftList
+	16, // 25: rpc.CNIBackend.ShowLeases:output_type -> rpc.DHCPLeaseList
+	17, // 26: rpc.CNIBackend.ShowPods:output_type -> rpc.PodInfoList
+	20, // 27: rpc.CNIBackend.GCNetwork:output_type -> rpc.GCReply
+	21, // 28: rpc.CNIBackend.Status:output_type -> rpc.StatusReply
+	20, // [20:29] is the sub-list for method output_type
+	11, // [11:20] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
 }
 
 func init() { file_pkg_rpc_message_proto_init() }
@@ -1274,6 +1974,126 @@ func file_pkg_rpc_message_proto_init() {
 				return nil
 			}
 		}
+		file_pkg_rpc_message_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NetworkCheckRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_rpc_message_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NetworkDrift); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_rpc_message_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NetworkDriftList); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_rpc_message_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DHCPLease); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_rpc_message_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DHCPLeaseList); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_rpc_message_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PodInfoList); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_rpc_message_proto_msgTypes[16].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Attachment); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_rpc_message_proto_msgTypes[17].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GCRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_rpc_message_proto_msgTypes[18].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GCReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pkg_rpc_message_proto_msgTypes[19].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatusReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -1281,7 +2101,7 @@ func file_pkg_rpc_message_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_pkg_rpc_message_proto_rawDesc,
 			NumEnums:      2,
-			NumMessages:   10,
+			NumMessages:   20,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,10 +19,15 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	CNIBackend_AddNetwork_FullMethodName = "/rpc.CNIBackend/AddNetwork"
-	CNIBackend_DelNetwork_FullMethodName = "/rpc.CNIBackend/DelNetwork"
-	CNIBackend_ShowNics_FullMethodName   = "/rpc.CNIBackend/ShowNics"
-	CNIBackend_ClearNics_FullMethodName  = "/rpc.CNIBackend/ClearNics"
+	CNIBackend_AddNetwork_FullMethodName   = "/rpc.CNIBackend/AddNetwork"
+	CNIBackend_DelNetwork_FullMethodName   = "/rpc.CNIBackend/DelNetwork"
+	CNIBackend_ShowNics_FullMethodName     = "/rpc.CNIBackend/ShowNics"
+	CNIBackend_ClearNics_FullMethodName    = "/rpc.CNIBackend/ClearNics"
+	CNIBackend_CheckNetwork_FullMethodName = "/rpc.CNIBackend/CheckNetwork"
+	CNIBackend_ShowLeases_FullMethodName   = "/rpc.CNIBackend/ShowLeases"
+	CNIBackend_ShowPods_FullMethodName     = "/rpc.CNIBackend/ShowPods"
+	CNIBackend_GCNetwork_FullMethodName    = "/rpc.CNIBackend/GCNetwork"
+	CNIBackend_Status_FullMethodName       = "/rpc.CNIBackend/Status"
 )
 
 // CNIBackendClient is the client API for CNIBackend service.
@@ -33,6 +38,11 @@ type CNIBackendClient interface {
 	DelNetwork(ctx context.Context, in *IPAMMessage, opts ...grpc.CallOption) (*IPAMMessage, error)
 	ShowNics(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*NicInfoList, error)
 	ClearNics(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*Nothing, error)
+	CheckNetwork(ctx context.Context, in *NetworkCheckRequest, opts ...grpc.CallOption) (*NetworkDriftList, error)
+	ShowLeases(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*DHCPLeaseList, error)
+	ShowPods(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*PodInfoList, error)
+	GCNetwork(ctx context.Context, in *GCRequest, opts ...grpc.CallOption) (*GCReply, error)
+	Status(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*StatusReply, error)
 }
 
 type cNIBackendClient struct {
@@ -79,6 +89,51 @@ func (c *cNIBackendClient) ClearNics(ctx context.Context, in *Nothing, opts ...g
 	return out, nil
 }
 
+func (c *cNIBackendClient) CheckNetwork(ctx context.Context, in *NetworkCheckRequest, opts ...grpc.CallOption) (*NetworkDriftList, error) {
+	out := new(NetworkDriftList)
+	err := c.cc.Invoke(ctx, CNIBackend_CheckNetwork_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cNIBackendClient) ShowLeases(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*DHCPLeaseList, error) {
+	out := new(DHCPLeaseList)
+	err := c.cc.Invoke(ctx, CNIBackend_ShowLeases_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cNIBackendClient) ShowPods(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*PodInfoList, error) {
+	out := new(PodInfoList)
+	err := c.cc.Invoke(ctx, CNIBackend_ShowPods_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cNIBackendClient) GCNetwork(ctx context.Context, in *GCRequest, opts ...grpc.CallOption) (*GCReply, error) {
+	out := new(GCReply)
+	err := c.cc.Invoke(ctx, CNIBackend_GCNetwork_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cNIBackendClient) Status(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*StatusReply, error) {
+	out := new(StatusReply)
+	err := c.cc.Invoke(ctx, CNIBackend_Status_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CNIBackendServer is the server API for CNIBackend service.
 // All implementations should embed UnimplementedCNIBackendServer
 // for forward compatibility
@@ -87,6 +142,11 @@ type CNIBackendServer interface {
 	DelNetwork(context.Context, *IPAMMessage) (*IPAMMessage, error)
 	ShowNics(context.Context, *Nothing) (*NicInfoList, error)
 	ClearNics(context.Context, *Nothing) (*Nothing, error)
+	CheckNetwork(context.Context, *NetworkCheckRequest) (*NetworkDriftList, error)
+	ShowLeases(context.Context, *Nothing) (*DHCPLeaseList, error)
+	ShowPods(context.Context, *Nothing) (*PodInfoList, error)
+	GCNetwork(context.Context, *GCRequest) (*GCReply, error)
+	Status(context.Context, *Nothing) (*StatusReply, error)
 }
 
 // UnimplementedCNIBackendServer should be embedded to have forward compatible implementations.
@@ -105,6 +165,21 @@ func (UnimplementedCNIBackendServer) ShowNics(context.Context, *Nothing) (*NicIn
 func (UnimplementedCNIBackendServer) ClearNics(context.Context, *Nothing) (*Nothing, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ClearNics not implemented")
 }
+func (UnimplementedCNIBackendServer) CheckNetwork(context.Context, *NetworkCheckRequest) (*NetworkDriftList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckNetwork not implemented")
+}
+func (UnimplementedCNIBackendServer) ShowLeases(context.Context, *Nothing) (*DHCPLeaseList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ShowLeases not implemented")
+}
+func (UnimplementedCNIBackendServer) ShowPods(context.Context, *Nothing) (*PodInfoList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ShowPods not implemented")
+}
+func (UnimplementedCNIBackendServer) GCNetwork(context.Context, *GCRequest) (*GCReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GCNetwork not implemented")
+}
+func (UnimplementedCNIBackendServer) Status(context.Context, *Nothing) (*StatusReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
 
 // UnsafeCNIBackendServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to CNIBackendServer will
@@ -189,6 +264,96 @@ func _CNIBackend_ClearNics_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CNIBackend_CheckNetwork_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NetworkCheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CNIBackendServer).CheckNetwork(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CNIBackend_CheckNetwork_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CNIBackendServer).CheckNetwork(ctx, req.(*NetworkCheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CNIBackend_ShowLeases_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Nothing)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CNIBackendServer).ShowLeases(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CNIBackend_ShowLeases_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CNIBackendServer).ShowLeases(ctx, req.(*Nothing))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CNIBackend_ShowPods_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Nothing)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CNIBackendServer).ShowPods(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CNIBackend_ShowPods_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CNIBackendServer).ShowPods(ctx, req.(*Nothing))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CNIBackend_GCNetwork_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GCRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CNIBackendServer).GCNetwork(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CNIBackend_GCNetwork_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CNIBackendServer).GCNetwork(ctx, req.(*GCRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CNIBackend_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Nothing)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CNIBackendServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CNIBackend_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CNIBackendServer).Status(ctx, req.(*Nothing))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CNIBackend_ServiceDesc is the grpc.ServiceDesc for CNIBackend service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -212,6 +377,26 @@ var CNIBackend_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ClearNics",
 			Handler:    _CNIBackend_ClearNics_Handler,
 		},
+		{
+			MethodName: "CheckNetwork",
+			Handler:    _CNIBackend_CheckNetwork_Handler,
+		},
+		{
+			MethodName: "ShowLeases",
+			Handler:    _CNIBackend_ShowLeases_Handler,
+		},
+		{
+			MethodName: "ShowPods",
+			Handler:    _CNIBackend_ShowPods_Handler,
+		},
+		{
+			MethodName: "GCNetwork",
+			Handler:    _CNIBackend_GCNetwork_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _CNIBackend_Status_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "pkg/rpc/message.proto",
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/sets"
+	log "k8s.io/klog/v2"
+
+	"github.com/yunify/hostnic-cni/pkg/conf"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+	"github.com/yunify/hostnic-cni/pkg/tracing"
+)
+
+const (
+	adminCertFile = "tls.crt"
+	adminKeyFile  = "tls.key"
+	adminCAFile   = "ca.crt"
+)
+
+// adminMethods are the rpcs served by the admin listener, and whether they need an admin.
+// AddNetwork, DelNetwork and GCNetwork are only served on the local socket of the CNI plugin.
+var adminMethods = map[string]bool{
+	rpc.CNIBackend_ShowNics_FullMethodName:     false,
+	rpc.CNIBackend_ShowPods_FullMethodName:     false,
+	rpc.CNIBackend_ShowLeases_FullMethodName:   false,
+	rpc.CNIBackend_Status_FullMethodName:       false,
+	rpc.CNIBackend_CheckNetwork_FullMethodName: false,
+	rpc.CNIBackend_ClearNics_FullMethodName:    true,
+}
+
+// adminAuthorizer authorizes the calls on the admin listener by the common name of client certificates
+type adminAuthorizer struct {
+	readers sets.String
+	admins  sets.String
+}
+
+func newAdminAuthorizer(conf conf.AdminConf) *adminAuthorizer {
+	return &adminAuthorizer{
+		readers: sets.NewString(conf.Readers...),
+		admins:  sets.NewString(conf.Admins...),
+	}
+}
+
+func (a *adminAuthorizer) authorize(client, method string, req interface{}) error {
+	needAdmin, ok := adminMethods[method]
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "method %s is not served on admin listener", method)
+	}
+	// repairing changes the network of node like ClearNics
+	if check, ok := req.(*rpc.NetworkCheckRequest); ok && !check.DryRun {
+		needAdmin = true
+	}
+
+	if a.admins.Has(client) || (!needAdmin && a.readers.Has(client)) {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "client %q is not allowed to call %s", client, method)
+}
+
+// unaryInterceptor authorizes and audits every call
+func (a *adminAuthorizer) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		client, addr := adminClient(ctx)
+		if err := a.authorize(client, info.FullMethod, req); err != nil {
+			log.Warningf("admin audit: client %q from %s call %s (%v) denied: %v", client, addr, info.FullMethod, req, err)
+			return nil, err
+		}
+
+		resp, err := handler(ctx, req)
+		log.Infof("admin audit: client %q from %s call %s (%v) done: %v", client, addr, info.FullMethod, req, err)
+		return resp, err
+	}
+}
+
+// adminClient returns the common name of the verified client certificate and the address of client
+func adminClient(ctx context.Context) (string, string) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", "unknown"
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", p.Addr.String()
+	}
+	return info.State.VerifiedChains[0][0].Subject.CommonName, p.Addr.String()
+}
+
+// loadAdminTLSConfig loads the certificates from certDir, clients must present a certificate signed by ca.crt
+func loadAdminTLSConfig(certDir string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certDir, adminCertFile), filepath.Join(certDir, adminKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load admin certificate: %v", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(certDir, adminCAFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load admin ca: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s", filepath.Join(certDir, adminCAFile))
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"},
+	}, nil
+}
+
+// newAdminServer creates the grpc server of admin listener. The certificates are loaded again for every
+// connection, so that the secret could be rotated without restarting hostnic-node.
+func newAdminServer(conf conf.AdminConf, backend rpc.CNIBackendServer) (*grpc.Server, error) {
+	if _, err := loadAdminTLSConfig(conf.CertDir); err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return loadAdminTLSConfig(conf.CertDir)
+		},
+	}
+
+	server := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor(), newAdminAuthorizer(conf).unaryInterceptor()),
+	)
+	rpc.RegisterCNIBackendServer(server, backend)
+	return server, nil
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/yunify/hostnic-cni/pkg/conf"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "hostnic-admin-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the pem encoded certificate and key of name
+func (ca *testCA) issue(t *testing.T, name string, server bool) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+type testBackend struct {
+	rpc.UnimplementedCNIBackendServer
+}
+
+func (b *testBackend) AddNetwork(ctx context.Context, in *rpc.IPAMMessage) (*rpc.IPAMMessage, error) {
+	return in, nil
+}
+
+func (b *testBackend) ShowNics(ctx context.Context, in *rpc.Nothing) (*rpc.NicInfoList, error) {
+	return &rpc.NicInfoList{Items: []*rpc.NicInfo{{Id: "hostnic_1"}}}, nil
+}
+
+func (b *testBackend) ClearNics(ctx context.Context, in *rpc.Nothing) (*rpc.Nothing, error) {
+	return in, nil
+}
+
+func (b *testBackend) CheckNetwork(ctx context.Context, in *rpc.NetworkCheckRequest) (*rpc.NetworkDriftList, error) {
+	return &rpc.NetworkDriftList{}, nil
+}
+
+func TestAdminServer(t *testing.T) {
+	ca := newTestCA(t)
+	certDir := t.TempDir()
+	serverCert, serverKey := ca.issue(t, "hostnic-node", true)
+	for name, data := range map[string][]byte{adminCertFile: serverCert, adminKeyFile: serverKey, adminCAFile: ca.pem} {
+		if err := os.WriteFile(filepath.Join(certDir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server, err := newAdminServer(conf.AdminConf{
+		CertDir: certDir,
+		Readers: []string{"reader"},
+		Admins:  []string{"admin"},
+	}, &testBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Stop()
+
+	// dial trusts the server certificate, the client certificate of name is issued by issuer
+	dial := func(issuer *testCA, name string) rpc.CNIBackendClient {
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.cert)
+		config := &tls.Config{RootCAs: pool}
+		if name != "" {
+			cert, key := issuer.issue(t, name, false)
+			keyPair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.Certificates = []tls.Certificate{keyPair}
+		}
+		conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(config)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return rpc.NewCNIBackendClient(conn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reader := dial(ca, "reader")
+	admin := dial(ca, "admin")
+	stranger := dial(ca, "stranger")
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"reader shows nics", func() error { _, err := reader.ShowNics(ctx, &rpc.Nothing{}); return err }, codes.OK},
+		{"reader checks network", func() error {
+			_, err := reader.CheckNetwork(ctx, &rpc.NetworkCheckRequest{DryRun: true})
+			return err
+		}, codes.OK},
+		{"reader could not repair network", func() error {
+			_, err := reader.CheckNetwork(ctx, &rpc.NetworkCheckRequest{DryRun: false})
+			return err
+		}, codes.PermissionDenied},
+		{"reader could not clear nics", func() error { _, err := reader.ClearNics(ctx, &rpc.Nothing{}); return err }, codes.PermissionDenied},
+		{"admin clears nics", func() error { _, err := admin.ClearNics(ctx, &rpc.Nothing{}); return err }, codes.OK},
+		{"admin repairs network", func() error {
+			_, err := admin.CheckNetwork(ctx, &rpc.NetworkCheckRequest{DryRun: false})
+			return err
+		}, codes.OK},
+		{"admin could not add network", func() error { _, err := admin.AddNetwork(ctx, &rpc.IPAMMessage{}); return err }, codes.PermissionDenied},
+		{"unknown client is denied", func() error { _, err := stranger.ShowNics(ctx, &rpc.Nothing{}); return err }, codes.PermissionDenied},
+		{"client without certificate is rejected", func() error {
+			_, err := dial(ca, "").ShowNics(ctx, &rpc.Nothing{})
+			return err
+		}, codes.Unavailable},
+		{"client signed by other ca is rejected", func() error {
+			_, err := dial(newTestCA(t), "admin").ShowNics(ctx, &rpc.Nothing{})
+			return err
+		}, codes.Unavailable},
+	}
+	for _, test := range tests {
+		if code := status.Code(test.call()); code != test.code {
+			t.Errorf("%s: got code %v, want %v", test.name, code, test.code)
+		}
+	}
+}
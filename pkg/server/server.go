@@ -7,14 +7,17 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/containernetworking/cni/pkg/types/current"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/kubernetes"
 	log "k8s.io/klog/v2"
 
@@ -23,8 +26,11 @@ import (
 	"github.com/yunify/hostnic-cni/pkg/config"
 	"github.com/yunify/hostnic-cni/pkg/constants"
 	"github.com/yunify/hostnic-cni/pkg/metrics"
+	"github.com/yunify/hostnic-cni/pkg/metrics/instrument"
+	"github.com/yunify/hostnic-cni/pkg/networkutils"
 	"github.com/yunify/hostnic-cni/pkg/rpc"
 	"github.com/yunify/hostnic-cni/pkg/simple/client/network/ippool/ipam"
+	"github.com/yunify/hostnic-cni/pkg/tracing"
 )
 
 type IPAMServer struct {
@@ -32,26 +38,18 @@ type IPAMServer struct {
 	kubeclient    kubernetes.Interface
 	ipamclient    ipam.IPAMClient
 	clusterConfig *config.ClusterConfig
+	poolSelector  *config.PoolSelector
 	metricsPort   int
-	oddPodCount   *metrics.OddPodCount
 }
 
-func NewIPAMServer(conf conf.ServerConf, clusterConfig *config.ClusterConfig, kubeclient kubernetes.Interface, ipamclient ipam.IPAMClient, metricsPort int) *IPAMServer {
-	count := metrics.OddPodCount{
-		BlockFailedCount:        0,
-		PoolFailedCount:         0,
-		NotFoundCount:           0,
-		AllocFailedCount:        0,
-		FreeFromPoolFailedCount: 0,
-		FreeFromHostFailedCount: 0,
-	}
+func NewIPAMServer(conf conf.ServerConf, clusterConfig *config.ClusterConfig, poolSelector *config.PoolSelector, kubeclient kubernetes.Interface, ipamclient ipam.IPAMClient, metricsPort int) *IPAMServer {
 	return &IPAMServer{
 		conf:          conf,
 		kubeclient:    kubeclient,
 		ipamclient:    ipamclient,
 		clusterConfig: clusterConfig,
+		poolSelector:  poolSelector,
 		metricsPort:   metricsPort,
-		oddPodCount:   &count,
 	}
 }
 
@@ -75,9 +73,10 @@ func (s *IPAMServer) run(stopCh <-chan struct{}) {
 	}
 
 	//start up metrics server routine
-	hostnicMetricsManager := metrics.NewHostnicMetricsManager(s.kubeclient, s.ipamclient, s.oddPodCount)
+	hostnicMetricsManager := metrics.NewHostnicMetricsManager()
 	reg := prometheus.NewPedanticRegistry()
 	reg.MustRegister(hostnicMetricsManager)
+	instrument.MustRegister(prometheus.WrapRegistererWith(prometheus.Labels{"node_name": os.Getenv("MY_NODE_NAME")}, reg))
 	gatherers := prometheus.Gatherers{
 		reg,
 	}
@@ -94,15 +93,36 @@ func (s *IPAMServer) run(stopCh <-chan struct{}) {
 	}()
 
 	//start up server rpc routine
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(tracing.UnaryServerInterceptor()))
 	rpc.RegisterCNIBackendServer(grpcServer, s)
 	go func() {
 		grpcServer.Serve(listener)
 	}()
 
 	log.Info("server grpc server started")
+
+	// the admin listener never serves AddNetwork and DelNetwork, which stay on the local socket
+	var adminServer *grpc.Server
+	if s.conf.Admin.Listen != "" {
+		adminServer, err = newAdminServer(s.conf.Admin, s)
+		if err != nil {
+			log.Fatalf("Failed to setup admin server: %v", err)
+		}
+		adminListener, err := net.Listen("tcp", s.conf.Admin.Listen)
+		if err != nil {
+			log.Fatalf("Failed to listen to %s: %v", s.conf.Admin.Listen, err)
+		}
+		go func() {
+			adminServer.Serve(adminListener)
+		}()
+		log.Infof("admin grpc server started on %s", s.conf.Admin.Listen)
+	}
+
 	<-stopCh
 	grpcServer.Stop()
+	if adminServer != nil {
+		adminServer.Stop()
+	}
 	log.Info("server grpc server stopped")
 }
 
@@ -126,9 +146,8 @@ func (s *IPAMServer) getK8sPodInfo(podName, podNamespace string) (ipList []strin
 }
 
 // AddNetwork handle add pod request
-func (s *IPAMServer) AddNetwork(context context.Context, in *rpc.IPAMMessage) (*rpc.IPAMMessage, error) {
+func (s *IPAMServer) AddNetwork(ctx context.Context, in *rpc.IPAMMessage) (_ *rpc.IPAMMessage, err error) {
 	var (
-		err      error
 		info     ipam.PoolInfo
 		rst      *current.Result
 		podIP    string
@@ -136,96 +155,146 @@ func (s *IPAMServer) AddNetwork(context context.Context, in *rpc.IPAMMessage) (*
 	)
 
 	log.Infof("AddNetwork request (%v)", in.Args)
+	start := time.Now()
 	defer func() {
+		instrument.ObserveSince(instrument.AddNetworkDuration, start, instrument.Outcome(err), metricsPoolLabel(info.IPPool))
 		log.Infof("AddNetwork reply (%s): from (%v) get (%s) nic (%s) %v", handleID, info, podIP, allocator.GetNicKey(in.Nic), err)
 	}()
 
 	handleID = podHandleKey(in.Args)
-	ipList, err := s.getK8sPodInfo(in.Args.Name, in.Args.Namespace)
-	if err != nil {
-		return nil, err
+	secondary := constants.IsSecondaryIfName(in.Args.IfName)
+	var ipList []string
+	if !secondary {
+		// fixed ips of pod are for the primary interface
+		ipList, err = s.getK8sPodInfo(in.Args.Name, in.Args.Namespace)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	attrs := map[string]string{
 		ipam.IPAMBlockAttributeNamespace: in.Args.Namespace,
 		ipam.IPAMBlockAttributeNode:      in.Args.NodeName,
 		ipam.IPAMBlockAttributePod:       in.Args.Name,
-		ipam.IPAMBlockAttributeTimestamp: time.Now().UTC().String(),
+		ipam.IPAMBlockAttributeTimestamp: time.Now().UTC().Format(ipam.IPAMBlockAttributeTimestampLayout),
+	}
+	if secondary {
+		// GC of the network only collects the handles of its own
+		attrs[ipam.IPAMBlockAttributeVxNet] = in.Args.VxNet
 	}
 
-	if blocks := s.clusterConfig.GetBlocksForAPP(in.Args.Namespace); len(blocks) > 0 {
+	ipamCtx, span := tracing.Start(ctx, "ipam.Assign", attribute.String("handle", handleID))
+	rst, err = s.assignIP(ipamCtx, handleID, in.Args, ipList, attrs, &info)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	podIP = rst.IPs[0].Address.IP.String()
+
+	if s.conf.NetworkPolicy == "calico" && !secondary {
+		// patch pod's annotations for calico policy
+		if err := s.patchPodIPAnnotations(in.Args.Namespace, in.Args.Name, podIP); err != nil {
+			if err := s.ipamclient.ReleaseByHandle(handleID); err != nil {
+				log.Errorf("AddNetwork request (%v) ReleaseByHandle failed: %v", in.Args, err)
+			}
+			return nil, err
+		}
+	}
+
+	in.Args.VxNet = info.IPPool
+	in.Args.PodIP = podIP
+	in.IP = podIP
+	in.Nic, err = allocator.Alloc.AllocHostNic(ctx, in.Args)
+	if err != nil {
+		instrument.AllocFromHostFailed.Inc()
+	}
+	return in, err
+}
+
+// assignIP assigns ip for pod from the subnets of its namespace, or the default ippools of node
+func (s *IPAMServer) assignIP(ctx context.Context, handleID string, pod *rpc.PodInfo, ipList []string, attrs map[string]string, info *ipam.PoolInfo) (*current.Result, error) {
+	var (
+		rst *current.Result
+		err error
+	)
+
+	if constants.IsSecondaryIfName(pod.IfName) {
+		// secondary interfaces are from the vxnet of its netconf
+		if pod.VxNet == "" {
+			return nil, fmt.Errorf("vxnet of secondary interface %s not set", pod.IfName)
+		}
+		if rst, err = s.ipamclient.AutoAssignFromPools(ipam.AutoAssignArgs{
+			HandleID: handleID,
+			Pools:    []string{pod.VxNet},
+			Info:     info,
+			Attrs:    attrs,
+			Context:  ctx,
+		}); err != nil {
+			instrument.AllocFromPoolFailed.Inc()
+			return nil, err
+		}
+	} else if blocks := s.clusterConfig.GetBlocksForAPP(pod.Namespace); len(blocks) > 0 {
 		if len(ipList) > 0 {
-			rst, err = s.ipamclient.AssignFixIps(handleID, ipList, nil, blocks, &info, attrs)
+			rst, err = s.ipamclient.AssignFixIps(handleID, ipList, nil, blocks, info, attrs)
 			if err != nil {
 				return nil, err
 			}
 		} else if rst, err = s.ipamclient.AutoAssignFromBlocks(ipam.AutoAssignArgs{
 			HandleID: handleID,
 			Blocks:   blocks,
-			Info:     &info,
+			Info:     info,
 			Attrs:    attrs,
+			Context:  ctx,
 		}); err != nil {
-			(*s.oddPodCount).BlockFailedCount = (*s.oddPodCount).BlockFailedCount + 1
+			instrument.AllocFromBlockFailed.Inc()
 			return nil, err
 		}
-	} else if pools := s.clusterConfig.GetDefaultIPPools(); len(pools) > 0 {
+	} else if pools := s.poolSelector.FilterIPPools(pod.NodeName, s.clusterConfig.GetDefaultIPPools()); len(pools) > 0 {
 		if len(ipList) > 0 {
-			rst, err = s.ipamclient.AssignFixIps(handleID, ipList, pools, nil, &info, attrs)
+			rst, err = s.ipamclient.AssignFixIps(handleID, ipList, pools, nil, info, attrs)
 			if err != nil {
 				return nil, err
 			}
 		} else if rst, err = s.ipamclient.AutoAssignFromPools(ipam.AutoAssignArgs{
 			HandleID: handleID,
 			Pools:    pools,
-			Info:     &info,
+			Info:     info,
 			Attrs:    attrs,
+			Context:  ctx,
 		}); err != nil {
-			(*s.oddPodCount).PoolFailedCount = (*s.oddPodCount).PoolFailedCount + 1
+			instrument.AllocFromPoolFailed.Inc()
 			return nil, err
 		}
 	} else {
-		(*s.oddPodCount).NotFoundCount = (*s.oddPodCount).NotFoundCount + 1
+		instrument.AllocResourceNotFound.Inc()
 		return nil, fmt.Errorf("pool or block not found")
 	}
 
-	podIP = rst.IPs[0].Address.IP.String()
-
-	if s.conf.NetworkPolicy == "calico" {
-		// patch pod's annotations for calico policy
-		if err := s.patchPodIPAnnotations(in.Args.Namespace, in.Args.Name, podIP); err != nil {
-			if err := s.ipamclient.ReleaseByHandle(handleID); err != nil {
-				log.Errorf("AddNetwork request (%v) ReleaseByHandle failed: %v", in.Args, err)
-			}
-			return nil, err
-		}
-	}
-
-	in.Args.VxNet = info.IPPool
-	in.Args.PodIP = podIP
-	in.IP = podIP
-	in.Nic, err = allocator.Alloc.AllocHostNic(in.Args)
-	if err != nil {
-		(*s.oddPodCount).AllocFailedCount = (*s.oddPodCount).AllocFailedCount + 1
-	}
-	return in, err
+	return rst, nil
 }
 
 // DelNetwork handle del pod request
-func (s *IPAMServer) DelNetwork(context context.Context, in *rpc.IPAMMessage) (*rpc.IPAMMessage, error) {
-	var (
-		err      error
-		handleID string
-	)
+func (s *IPAMServer) DelNetwork(ctx context.Context, in *rpc.IPAMMessage) (_ *rpc.IPAMMessage, err error) {
+	var handleID string
 
 	log.Infof("DelNetwork request (%v)", in.Args)
+	start := time.Now()
 	defer func() {
+		pool := ""
+		if in.Nic != nil && in.Nic.VxNet != nil {
+			pool = in.Nic.VxNet.ID
+		}
+		instrument.ObserveSince(instrument.DelNetworkDuration, start, instrument.Outcome(err), metricsPoolLabel(pool))
 		log.Infof("DelNetwork reply (%s): ip (%v) nic (%s) %v", handleID, in.IP, allocator.GetNicKey(in.Nic), err)
 	}()
 
 	handleID = podHandleKey(in.Args)
 
 	//get nic and pod ip info here
+	_, span := tracing.Start(ctx, "allocator.FreeHostNic", attribute.Bool("peek", true))
 	in.Nic, in.IP, _ = allocator.Alloc.FreeHostNic(in.Args, true)
+	span.End()
 
 	// if no nic or pod record in db, get ip by handleID
 	// this ip only used for log
@@ -247,16 +316,21 @@ func (s *IPAMServer) DelNetwork(context context.Context, in *rpc.IPAMMessage) (*
 
 	//release ip in ipamblock
 	log.Infof("going to release ip (%s) by handleID %s", in.IP, handleID)
-	if err = s.ipamclient.ReleaseByHandle(handleID); err != nil {
-		(*s.oddPodCount).FreeFromPoolFailedCount = (*s.oddPodCount).FreeFromPoolFailedCount + 1
+	_, span = tracing.Start(ctx, "ipam.ReleaseByHandle", attribute.String("handle", handleID))
+	err = s.ipamclient.ReleaseByHandle(handleID)
+	tracing.End(span, err)
+	if err != nil {
+		instrument.FreeFromPoolFailed.Inc()
 		return in, fmt.Errorf("release ip %s by handleID %s error: %v", in.IP, handleID, err)
 	}
 
 	//clear pod db record
 	log.Infof("release ip (%s) by handleID %s success, going to clear db record for hostnic", in.IP, handleID)
+	_, span = tracing.Start(ctx, "allocator.FreeHostNic", attribute.Bool("peek", false))
 	_, _, err = allocator.Alloc.FreeHostNic(in.Args, in.Peek)
+	tracing.End(span, err)
 	if err != nil {
-		(*s.oddPodCount).FreeFromHostFailedCount = (*s.oddPodCount).FreeFromHostFailedCount + 1
+		instrument.FreeFromHostFailed.Inc()
 		return in, fmt.Errorf("clear pod db record error for %s: %v", handleID, err)
 	}
 	return in, nil
@@ -295,6 +369,158 @@ func (s *IPAMServer) ClearNics(context context.Context, in *rpc.Nothing) (*rpc.N
 	return in, err
 }
 
+func (s *IPAMServer) CheckNetwork(context context.Context, in *rpc.NetworkCheckRequest) (*rpc.NetworkDriftList, error) {
+	log.Infof("CheckNetwork request: dryRun %t", in.DryRun)
+	ret := &rpc.NetworkDriftList{}
+	var err error
+	defer func() {
+		log.Infof("CheckNetwork reply: %d drifts %v", len(ret.Items), err)
+	}()
+	ret.Items, err = allocator.Alloc.CheckNetwork(in.DryRun)
+	return ret, err
+}
+
+func (s *IPAMServer) ShowLeases(context context.Context, in *rpc.Nothing) (*rpc.DHCPLeaseList, error) {
+	log.Info("ShowLeases request")
+	return &rpc.DHCPLeaseList{Items: networkutils.Leases.List()}, nil
+}
+
+func (s *IPAMServer) ShowPods(context context.Context, in *rpc.Nothing) (*rpc.PodInfoList, error) {
+	log.Info("ShowPods request")
+	return &rpc.PodInfoList{Items: allocator.Alloc.GetPods()}, nil
+}
+
+// GCNetwork releases the pods recorded on this node and the handles allocated to them, whose sandboxes
+// are not in the valid attachments of runtime. GC is called for every network, and the valid attachments
+// only list those of the network, so only the pods and handles of in.VxNet (or the primary network if empty)
+// are collected. Allocations younger than NicAttachTimeout are left, they may belong to an ADD in progress.
+func (s *IPAMServer) GCNetwork(ctx context.Context, in *rpc.GCRequest) (_ *rpc.GCReply, err error) {
+	log.Infof("GCNetwork request: node %s, vxnet %q, %d valid attachments", in.NodeName, in.VxNet, len(in.ValidAttachments))
+	ret := &rpc.GCReply{}
+	defer func() {
+		log.Infof("GCNetwork reply: %d pods %d handles released %v", len(ret.Pods), len(ret.Handles), err)
+	}()
+
+	handles, err := s.ipamclient.GetNodeHandles(in.NodeName)
+	if err != nil {
+		return nil, fmt.Errorf("get handles of node %s error: %v", in.NodeName, err)
+	}
+	released := make(map[string]bool)
+	var errs []error
+	for _, pod := range allocator.Alloc.GetPods() {
+		if !gcInNetwork(in.VxNet, pod.IfName, pod.VxNet) {
+			continue
+		}
+		handleID := podHandleKey(pod)
+		if gcAttachmentValid(in.ValidAttachments, pod.Containter, pod.IfName) || gcAllocationRecent(handles[handleID]) {
+			continue
+		}
+		if err := s.releasePod(ctx, pod); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		released[handleID] = true
+		ret.Pods = append(ret.Pods, pod)
+	}
+
+	// handles without pod records, which are left by ADD failed after assigning ip
+	for handleID, attrs := range handles {
+		// egress ips are released by the egress manager
+		if attrs[ipam.IPAMBlockAttributeEgressPolicy] != "" {
+			continue
+		}
+		if !gcHandleInNetwork(in.VxNet, attrs) {
+			continue
+		}
+		if released[handleID] || gcHandleValid(in.ValidAttachments, handleID) || gcAllocationRecent(attrs) {
+			continue
+		}
+		log.Infof("GCNetwork release handle %s of pod %s/%s", handleID, attrs[ipam.IPAMBlockAttributeNamespace], attrs[ipam.IPAMBlockAttributePod])
+		if err := s.ipamclient.ReleaseByHandle(handleID); err != nil {
+			errs = append(errs, fmt.Errorf("release handle %s error: %v", handleID, err))
+			continue
+		}
+		ret.Handles = append(ret.Handles, handleID)
+	}
+	return ret, utilerrors.NewAggregate(errs)
+}
+
+// releasePod cleans up the rules and arpreply of pod, releases its ip and removes its db record like DelNetwork
+func (s *IPAMServer) releasePod(ctx context.Context, pod *rpc.PodInfo) error {
+	handleID := podHandleKey(pod)
+	log.Infof("GCNetwork release pod %s ip %s", handleID, pod.PodIP)
+
+	nic, podIP, _ := allocator.Alloc.FreeHostNic(pod, true)
+	if nic != nil && podIP != "" {
+		if err := networkutils.NetworkHelper.CleanupPodNetwork(nic, podIP); err != nil {
+			return fmt.Errorf("clean network rule for pod %s error: %v", handleID, err)
+		}
+	}
+	if err := s.ipamclient.ReleaseByHandle(handleID); err != nil {
+		return fmt.Errorf("release ip %s by handleID %s error: %v", podIP, handleID, err)
+	}
+	if _, _, err := allocator.Alloc.FreeHostNic(pod, false); err != nil {
+		return fmt.Errorf("clear pod db record error for %s: %v", handleID, err)
+	}
+	return nil
+}
+
+// gcInNetwork reports whether the pod record on ifName with podVxNet belongs to the network of vxnet,
+// records of the primary interface belong to the primary network whatever vxnet they are from.
+func gcInNetwork(vxnet, ifName, podVxNet string) bool {
+	if vxnet == "" {
+		return !constants.IsSecondaryIfName(ifName)
+	}
+	return constants.IsSecondaryIfName(ifName) && podVxNet == vxnet
+}
+
+// gcHandleInNetwork reports whether the handle with attrs belongs to the network of vxnet
+func gcHandleInNetwork(vxnet string, attrs map[string]string) bool {
+	return attrs[ipam.IPAMBlockAttributeVxNet] == vxnet
+}
+
+func gcAttachmentValid(valid []*rpc.Attachment, containerID, ifName string) bool {
+	for _, attachment := range valid {
+		if attachment.ContainerID == containerID && (ifName == "" || attachment.IfName == ifName) {
+			return true
+		}
+	}
+	return false
+}
+
+// gcHandleValid reports whether handleID belongs to a valid attachment, handle ids end with the container id,
+// and the interface name for secondary interfaces.
+func gcHandleValid(valid []*rpc.Attachment, handleID string) bool {
+	for _, attachment := range valid {
+		suffix := "-" + attachment.ContainerID
+		if constants.IsSecondaryIfName(attachment.IfName) {
+			suffix += "-" + attachment.IfName
+		}
+		if strings.HasSuffix(handleID, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// gcAllocationRecent reports whether the ip of attrs is allocated within NicAttachTimeout,
+// allocations without a valid timestamp are not recent.
+func gcAllocationRecent(attrs map[string]string) bool {
+	allocated, err := time.Parse(ipam.IPAMBlockAttributeTimestampLayout, attrs[ipam.IPAMBlockAttributeTimestamp])
+	if err != nil {
+		return false
+	}
+	return time.Since(allocated) < constants.NicAttachTimeout
+}
+
+func (s *IPAMServer) Status(context context.Context, in *rpc.Nothing) (*rpc.StatusReply, error) {
+	reason := allocator.Alloc.Unavailable()
+	if reason != "" {
+		log.Warningf("Status reply: not ready, %s", reason)
+	}
+	return &rpc.StatusReply{Ready: reason == "", Reason: reason}, nil
+}
+
 func (s *IPAMServer) patchPodIPAnnotations(ns, podName string, ip string) error {
 	patch, err := calculateAnnotationPatch(constants.CalicoAnnotationPodIP, ip, constants.CalicoAnnotationPodIPs, ip)
 	if err != nil {
@@ -310,7 +536,20 @@ func (s *IPAMServer) patchPodIPAnnotations(ns, podName string, ip string) error
 }
 
 func podHandleKey(pod *rpc.PodInfo) string {
-	return pod.Namespace + "-" + pod.Name + "-" + pod.Containter
+	key := pod.Namespace + "-" + pod.Name + "-" + pod.Containter
+	// a pod may have interfaces from several vxnets through multus
+	if constants.IsSecondaryIfName(pod.IfName) {
+		key += "-" + pod.IfName
+	}
+	return key
+}
+
+// metricsPoolLabel returns the pool label of latency metrics, requests failed before choosing a pool are unknown
+func metricsPoolLabel(pool string) string {
+	if pool == "" {
+		return "unknown"
+	}
+	return pool
 }
 
 func calculateAnnotationPatch(namesAndValues ...string) ([]byte, error) {
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+	"github.com/yunify/hostnic-cni/pkg/simple/client/network/ippool/ipam"
+)
+
+func TestGCValid(t *testing.T) {
+	valid := []*rpc.Attachment{{ContainerID: "c1", IfName: "eth0"}}
+
+	pod := &rpc.PodInfo{Namespace: "default", Name: "web", Containter: "c1", IfName: "eth0"}
+	if !gcAttachmentValid(valid, pod.Containter, pod.IfName) || !gcHandleValid(valid, podHandleKey(pod)) {
+		t.Errorf("pod %s should be valid", podHandleKey(pod))
+	}
+	// records before ifname was saved
+	if !gcAttachmentValid(valid, "c1", "") {
+		t.Errorf("pod without ifname should be valid")
+	}
+	if gcAttachmentValid(valid, "c1", "net1") {
+		t.Errorf("pod on other interface should not be valid")
+	}
+
+	// secondary interfaces of multus have their own handles
+	secondary := &rpc.PodInfo{Namespace: "default", Name: "web", Containter: "c1", IfName: "net1"}
+	if podHandleKey(secondary) == podHandleKey(pod) {
+		t.Errorf("secondary interface should not share handle %s", podHandleKey(pod))
+	}
+	if gcHandleValid(valid, podHandleKey(secondary)) {
+		t.Errorf("handle %s of removed secondary interface should not be valid", podHandleKey(secondary))
+	}
+	valid = append(valid, &rpc.Attachment{ContainerID: "c1", IfName: "net1"})
+	if !gcHandleValid(valid, podHandleKey(secondary)) {
+		t.Errorf("handle %s should be valid", podHandleKey(secondary))
+	}
+
+	stale := &rpc.PodInfo{Namespace: "default", Name: "web", Containter: "c0", IfName: "eth0"}
+	if gcAttachmentValid(valid, stale.Containter, stale.IfName) || gcHandleValid(valid, podHandleKey(stale)) {
+		t.Errorf("pod %s should not be valid", podHandleKey(stale))
+	}
+}
+
+func TestGCInNetwork(t *testing.T) {
+	tests := []struct {
+		vxnet    string
+		ifName   string
+		podVxNet string
+		in       bool
+	}{
+		// GC of the primary network passes only eth0 attachments, secondary interfaces are left
+		{"", "eth0", "vxnet-a", true},
+		{"", "", "vxnet-a", true},
+		{"", "net1", "vxnet-b", false},
+		{"vxnet-b", "net1", "vxnet-b", true},
+		{"vxnet-b", "net2", "vxnet-c", false},
+		{"vxnet-b", "eth0", "vxnet-b", false},
+	}
+	for _, test := range tests {
+		if in := gcInNetwork(test.vxnet, test.ifName, test.podVxNet); in != test.in {
+			t.Errorf("pod on %s of %s in network %q: got %t, want %t", test.ifName, test.podVxNet, test.vxnet, in, test.in)
+		}
+	}
+
+	primary := map[string]string{ipam.IPAMBlockAttributePod: "web"}
+	secondary := map[string]string{ipam.IPAMBlockAttributePod: "web", ipam.IPAMBlockAttributeVxNet: "vxnet-b"}
+	if !gcHandleInNetwork("", primary) || gcHandleInNetwork("", secondary) {
+		t.Errorf("only handles without vxnet belong to the primary network")
+	}
+	if !gcHandleInNetwork("vxnet-b", secondary) || gcHandleInNetwork("vxnet-c", secondary) || gcHandleInNetwork("vxnet-b", primary) {
+		t.Errorf("handles of secondary networks should belong to their vxnet only")
+	}
+}
+
+func TestGCAllocationRecent(t *testing.T) {
+	tests := []struct {
+		timestamp string
+		recent    bool
+	}{
+		{time.Now().UTC().Format(ipam.IPAMBlockAttributeTimestampLayout), true},
+		// timestamps written before the layout was named
+		{time.Now().UTC().String(), true},
+		{time.Now().Add(-time.Hour).UTC().Format(ipam.IPAMBlockAttributeTimestampLayout), false},
+		{"", false},
+	}
+	for _, test := range tests {
+		attrs := map[string]string{ipam.IPAMBlockAttributeTimestamp: test.timestamp}
+		if recent := gcAllocationRecent(attrs); recent != test.recent {
+			t.Errorf("timestamp %q: got recent %t, want %t", test.timestamp, recent, test.recent)
+		}
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"net"
 	"reflect"
 	"slices"
+	"sort"
 	"strings"
 
 	cnitypes "github.com/containernetworking/cni/pkg/types"
@@ -32,6 +33,8 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	cnet "github.com/projectcalico/libcalico-go/lib/net"
 	"github.com/projectcalico/libcalico-go/lib/set"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -51,6 +54,7 @@ import (
 	networklisters "github.com/yunify/hostnic-cni/pkg/client/listers/network/v1alpha1"
 	"github.com/yunify/hostnic-cni/pkg/constants"
 	"github.com/yunify/hostnic-cni/pkg/simple/client/network/utils"
+	"github.com/yunify/hostnic-cni/pkg/tracing"
 )
 
 const (
@@ -63,6 +67,12 @@ const (
 	IPAMBlockAttributeNode      = "node"
 	IPAMBlockAttributeIP        = "ip"
 	IPAMBlockAttributeTimestamp = "timestamp"
+	// IPAMBlockAttributeTimestampLayout is the layout of the timestamp attribute, the same as time.Time.String
+	IPAMBlockAttributeTimestampLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+	// IPAMBlockAttributeEgressPolicy is set on the egress ips claimed by egress policies instead of pods
+	IPAMBlockAttributeEgressPolicy = "egresspolicy"
+	// IPAMBlockAttributeVxNet is set on the ips of secondary interfaces, it is the vxnet of the network attached by multus
+	IPAMBlockAttributeVxNet = "vxnet"
 )
 
 var (
@@ -141,6 +151,17 @@ type IPAMClient struct {
 
 	nodeLister       corelisters.NodeLister
 	nodeListerSynced cache.InformerSynced
+
+	// node prefers the blocks claimed by itself when not empty, see WithBlockAffinity
+	node string
+}
+
+// WithBlockAffinity returns a copy of the client which assigns addresses on behalf of node:
+// it prefers the blocks claimed by node, claims new or unclaimed blocks when those are full,
+// and borrows from the blocks of other nodes at last.
+func (c IPAMClient) WithBlockAffinity(node string) IPAMClient {
+	c.node = node
+	return c
 }
 
 func (c IPAMClient) Sync(stopCh <-chan struct{}) error {
@@ -165,7 +186,15 @@ func (c IPAMClient) AutoAssign(args AutoAssignArgs) (*current.Result, error) {
 		block  *v1alpha1.IPAMBlock
 	)
 
+	ctx, span := tracing.Start(args.context(), "ipam.AutoAssign", attribute.String("pool", args.Pool))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	for i := 0; i < datastoreRetries; i++ {
+		if i > 0 {
+			span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", i), attribute.String("error", err.Error())))
+		}
 		pool, err = c.ippoolsLister.Get(args.Pool)
 		if err != nil {
 			return nil, ErrNoQualifiedPool
@@ -180,7 +209,7 @@ func (c IPAMClient) AutoAssign(args AutoAssignArgs) (*current.Result, error) {
 			return nil, ErrUnknowIPPoolType
 		}
 
-		block, ip, err = c.autoAssign(args.HandleID, args.Attrs, pool)
+		block, ip, err = c.autoAssign(ctx, args.HandleID, args.Attrs, pool)
 		if err != nil {
 			if errors.Is(err, ErrNoFreeBlocks) {
 				return nil, err
@@ -192,7 +221,8 @@ func (c IPAMClient) AutoAssign(args AutoAssignArgs) (*current.Result, error) {
 
 	if err != nil {
 		klog.Infof("AutoAssign: args=%s, err=%v", spew.Sdump(args), err)
-		return nil, ErrMaxRetry
+		err = ErrMaxRetry
+		return nil, err
 	}
 
 	args.Info.IPPool = pool.Name
@@ -239,25 +269,35 @@ func (c IPAMClient) findOrClaimBlock(pool *v1alpha1.IPPool, minFreeIps int) (*v1
 		return nil, err
 	}
 
+	if c.node != "" {
+		return c.findOrClaimAffineBlock(pool, remainingBlocks, minFreeIps)
+	}
+
 	// First, we try to find a block from one of the existing blocks.
 	for len(remainingBlocks) > 0 {
 		// Pop first cidr.
 		block := remainingBlocks[0]
 		remainingBlocks = remainingBlocks[1:]
 
-		// Pull out the block.
+		// Pull out the block, it is copied since the block from lister shares slices with the cache.
 		if block.NumFreeAddresses() >= minFreeIps {
-			return &block, nil
+			return block.DeepCopy(), nil
 		} else {
 			continue
 		}
 	}
 
 	//Second, create unused Address Blocks
+	return c.createBlock(pool, minFreeIps)
+}
+
+// createBlock creates an unused block of pool, claimed by the node of client if any
+func (c IPAMClient) createBlock(pool *v1alpha1.IPPool, minFreeIps int) (*v1alpha1.IPAMBlock, error) {
 	b, err := c.findUnclaimedBlock(pool)
 	if err != nil {
 		return nil, err
 	}
+	b.SetAffinityNode(c.node)
 	blockName := b.BlockName()
 	controllerutil.SetControllerReference(pool, b, scheme.Scheme)
 	b, err = c.client.NetworkV1alpha1().IPAMBlocks().Create(context.Background(), b, metav1.CreateOptions{})
@@ -278,7 +318,115 @@ func (c IPAMClient) findOrClaimBlock(pool *v1alpha1.IPPool, minFreeIps int) (*v1
 	}
 }
 
-func (c IPAMClient) autoAssign(handleID string, attrs map[string]string, requestedPool *v1alpha1.IPPool) (*v1alpha1.IPAMBlock, *cnet.IPNet, error) {
+// findOrClaimAffineBlock looks for a block with free space in the order of: the blocks claimed by
+// the node, a new block created for the node, a block claimed by no node, and the blocks of other
+// nodes.
+func (c IPAMClient) findOrClaimAffineBlock(pool *v1alpha1.IPPool, blocks []v1alpha1.IPAMBlock, minFreeIps int) (*v1alpha1.IPAMBlock, error) {
+	var unclaimed, borrowed *v1alpha1.IPAMBlock
+	for i := range blocks {
+		block := &blocks[i]
+		if block.NumFreeAddresses() < minFreeIps {
+			continue
+		}
+		switch block.AffinityNode() {
+		case c.node:
+			return block.DeepCopy(), nil
+		case "":
+			if unclaimed == nil {
+				unclaimed = block
+			}
+		default:
+			if borrowed == nil {
+				borrowed = block
+			}
+		}
+	}
+
+	b, err := c.createBlock(pool, minFreeIps)
+	if err == nil {
+		return b, nil
+	}
+	if !errors.Is(err, ErrNoFreeBlocks) {
+		return nil, err
+	}
+
+	if unclaimed != nil {
+		return unclaimed.DeepCopy(), nil
+	}
+	if borrowed != nil {
+		klog.V(4).Infof("Node %s borrows from block %s of node %s", c.node, borrowed.Name, borrowed.AffinityNode())
+		return borrowed.DeepCopy(), nil
+	}
+	return nil, err
+}
+
+// sortBlocksByAffinity orders the blocks for the node of client: its own blocks first, then blocks
+// claimed by no node, and the blocks of other nodes at last. The order is kept when affinity is disabled.
+func (c IPAMClient) sortBlocksByAffinity(blocks []*v1alpha1.IPAMBlock) {
+	if c.node == "" {
+		return
+	}
+	rank := func(b *v1alpha1.IPAMBlock) int {
+		switch b.AffinityNode() {
+		case c.node:
+			return 0
+		case "":
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return rank(blocks[i]) < rank(blocks[j])
+	})
+}
+
+// ReleaseAffinity gives back the blocks claimed by node, e.g. when the node is deleted,
+// so that other nodes could claim them. The addresses in them are kept.
+func (c IPAMClient) ReleaseAffinity(node string) error {
+	blocks, err := c.ipamblocksLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	for _, b := range blocks {
+		if b.AffinityNode() != node {
+			continue
+		}
+		if err := c.releaseBlockAffinity(b.Name, node); err != nil {
+			return fmt.Errorf("release affinity of block %s for node %s failed: %v", b.Name, node, err)
+		}
+		klog.Infof("Released affinity of block %s for node %s", b.Name, node)
+	}
+	return nil
+}
+
+func (c IPAMClient) releaseBlockAffinity(blockName, node string) error {
+	for i := 0; i < datastoreRetries; i++ {
+		block, err := c.queryBlock(blockName)
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if block.AffinityNode() != node {
+			return nil
+		}
+
+		block.SetAffinityNode("")
+		if _, err = c.updateBlock(block); err != nil {
+			if k8serrors.IsConflict(err) {
+				continue
+			}
+			return err
+		}
+		return nil
+	}
+	return ErrMaxRetry
+}
+
+func (c IPAMClient) autoAssign(ctx context.Context, handleID string, attrs map[string]string, requestedPool *v1alpha1.IPPool) (*v1alpha1.IPAMBlock, *cnet.IPNet, error) {
 	var (
 		block  *v1alpha1.IPAMBlock
 		result *cnet.IPNet
@@ -290,20 +438,26 @@ func (c IPAMClient) autoAssign(handleID string, attrs map[string]string, request
 		return nil, nil, err
 	}
 
-	result, err = c.autoAssignFromBlock(handleID, attrs, block)
+	result, err = c.autoAssignFromBlock(ctx, handleID, attrs, block)
 	return block, result, err
 }
 
-func (c IPAMClient) autoAssignFromBlock(handleID string, attrs map[string]string, requestedBlock *v1alpha1.IPAMBlock) (*cnet.IPNet, error) {
+func (c IPAMClient) autoAssignFromBlock(ctx context.Context, handleID string, attrs map[string]string, requestedBlock *v1alpha1.IPAMBlock) (*cnet.IPNet, error) {
 	var (
 		result *cnet.IPNet
 		err    error
 	)
 
+	_, span := tracing.Start(ctx, "ipam.AssignFromBlock", attribute.String("block", requestedBlock.Name))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	for i := 0; i < datastoreRetries; i++ {
 		result, err = c.assignFromExistingBlock(requestedBlock, handleID, attrs)
 		if err != nil {
 			if k8serrors.IsConflict(err) {
+				span.AddEvent("conflict", trace.WithAttributes(attribute.Int("attempt", i)))
 				requestedBlock, err = c.queryBlock(requestedBlock.Name)
 				if err != nil {
 					return nil, err
@@ -317,7 +471,8 @@ func (c IPAMClient) autoAssignFromBlock(handleID string, attrs map[string]string
 		return result, nil
 	}
 
-	return nil, ErrMaxRetry
+	err = ErrMaxRetry
+	return nil, err
 }
 
 func (c IPAMClient) assignFromExistingBlock(block *v1alpha1.IPAMBlock, handleID string, attrs map[string]string) (*cnet.IPNet, error) {
@@ -326,18 +481,27 @@ func (c IPAMClient) assignFromExistingBlock(block *v1alpha1.IPAMBlock, handleID
 		return nil, fmt.Errorf("block %s has no availabe IP", block.BlockName())
 	}
 
+	// a block claimed by no node is claimed along with the assignment
+	claim := c.node != "" && block.AffinityNode() == ""
+	if claim {
+		block.SetAffinityNode(c.node)
+	}
+
 	err := c.incrementHandle(handleID, block, 1)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = c.client.NetworkV1alpha1().IPAMBlocks().Update(context.Background(), block, metav1.UpdateOptions{})
+	_, err = c.updateBlock(block)
 	if err != nil {
 		if err := c.decrementHandle(handleID, block, 1); err != nil {
 			klog.Errorf("Failed to decrement handle %s", handleID)
 		}
 		return nil, err
 	}
+	if claim {
+		klog.Infof("Node %s claimed block %s", c.node, block.Name)
+	}
 
 	return &ips[0], nil
 }
@@ -396,7 +560,7 @@ func (c IPAMClient) releaseByHandle(handleID string, blockName string) error {
 					}
 				}
 			*/
-			_, err = c.client.NetworkV1alpha1().IPAMBlocks().Update(context.Background(), block, metav1.UpdateOptions{})
+			_, err = c.updateBlock(block)
 			if err != nil {
 				if k8serrors.IsConflict(err) {
 					// Comparison failed - retry.
@@ -410,7 +574,7 @@ func (c IPAMClient) releaseByHandle(handleID string, blockName string) error {
 			// Compare and swap the AllocationBlock using the original
 			// KVPair read from before.  No need to update the Value since we
 			// have been directly manipulating the value referenced by the KVPair.
-			_, err = c.client.NetworkV1alpha1().IPAMBlocks().Update(context.Background(), block, metav1.UpdateOptions{})
+			_, err = c.updateBlock(block)
 			if err != nil {
 				if k8serrors.IsConflict(err) {
 					// Comparison failed - retry.
@@ -840,6 +1004,10 @@ func (c IPAMClient) GetBrokenBlocks(args GetUtilizationArgs, missingHandles, mis
 						fmt.Printf("block %s attrIndex %d is nil\n", blockName, *attrIndex)
 						continue
 					}
+					// egress ips have no pods
+					if block.Spec.Attributes[*attrIndex].AttrSecondary[IPAMBlockAttributeEgressPolicy] != "" {
+						continue
+					}
 
 					//ip allocated, but pod with this ip not exists
 					var found bool
@@ -998,11 +1166,11 @@ func (c IPAMClient) ListBlocks(pool string) ([]v1alpha1.IPAMBlock, error) {
 	return result, nil
 }
 
-// DeleteBlock deletes the given block.
+// DeleteBlock deletes the given block, the block is marked deleted even if its bookkeeping is broken.
 func (c IPAMClient) DeleteBlock(b *v1alpha1.IPAMBlock) error {
 	if !b.IsDeleted() {
 		b.MarkDeleted()
-		_, err := c.client.NetworkV1alpha1().IPAMBlocks().Update(context.Background(), b, metav1.UpdateOptions{})
+		_, err := c.writeBlock(b)
 		if err != nil {
 			return err
 		}
@@ -1010,6 +1178,20 @@ func (c IPAMClient) DeleteBlock(b *v1alpha1.IPAMBlock) error {
 	return c.client.NetworkV1alpha1().IPAMBlocks().Delete(context.Background(), b.Name, metav1.DeleteOptions{})
 }
 
+// updateBlock writes the block back, unless its bookkeeping is broken.
+func (c IPAMClient) updateBlock(b *v1alpha1.IPAMBlock) (*v1alpha1.IPAMBlock, error) {
+	if err := b.Validate(); err != nil {
+		return nil, fmt.Errorf("refuse to update block %s: %v", b.Name, err)
+	}
+	return c.writeBlock(b)
+}
+
+// writeBlock writes the block back without validation, it is only for deleting blocks and repairing
+// blocks which may be broken already.
+func (c IPAMClient) writeBlock(b *v1alpha1.IPAMBlock) (*v1alpha1.IPAMBlock, error) {
+	return c.client.NetworkV1alpha1().IPAMBlocks().Update(context.Background(), b, metav1.UpdateOptions{})
+}
+
 func (c IPAMClient) queryBlock(blockName string) (*v1alpha1.IPAMBlock, error) {
 	block, err := c.client.NetworkV1alpha1().IPAMBlocks().Get(context.Background(), blockName, metav1.GetOptions{})
 	if err != nil {
@@ -1090,9 +1272,10 @@ func (c IPAMClient) AutoAssignFromBlocks(args AutoAssignArgs) (*current.Result,
 		}
 	}
 
+	c.sortBlocksByAffinity(blocks)
 	for _, block := range blocks {
 		if block.NumFreeAddresses() >= 1 {
-			if ip, err := c.autoAssignFromBlock(args.HandleID, args.Attrs, block); err == nil {
+			if ip, err := c.autoAssignFromBlock(args.context(), args.HandleID, args.Attrs, block); err == nil {
 				poolName := block.Labels[networkv1alpha1.IPPoolNameLabel]
 				if pool, err := c.ippoolsLister.Get(poolName); err == nil {
 					args.Info.IPPool = poolName
@@ -1418,7 +1601,7 @@ func (c IPAMClient) fixIP(block *v1alpha1.IPAMBlock, handleID string, ipList []s
 				if err != nil {
 					return nil, err
 				}
-				_, err = c.client.NetworkV1alpha1().IPAMBlocks().Update(context.Background(), block, metav1.UpdateOptions{})
+				_, err = c.updateBlock(block)
 				if err != nil {
 					if err := c.decrementHandle(handleID, block, 1); err != nil {
 						klog.Errorf("Failed to decrement handle %s", handleID)
@@ -1715,8 +1898,8 @@ func (c IPAMClient) ReleaseIP(ip, blockName string) error {
 		return fmt.Errorf("release by ip %s error: %v", ip, err)
 	}
 
-	//update block
-	_, err = c.client.NetworkV1alpha1().IPAMBlocks().Update(context.Background(), block, metav1.UpdateOptions{})
+	//update block, which may be broken since releasing by ip is for repairing
+	_, err = c.writeBlock(block)
 	if err != nil {
 		return fmt.Errorf("update block %s error: %v", blockName, err)
 	}
@@ -1825,7 +2008,8 @@ func (c IPAMClient) recordUsedIP(ip, blockName, podNamespace, podName, handldID
 		}
 	}
 
-	_, err = c.client.NetworkV1alpha1().IPAMBlocks().Update(context.Background(), block, metav1.UpdateOptions{})
+	// recording is for repairing, the block may be broken already
+	_, err = c.writeBlock(block)
 	if err != nil {
 		return fmt.Errorf("update block %s error: %v", blockName, err)
 	}
@@ -1898,6 +2082,29 @@ func (c IPAMClient) GetHandleIDForIP(ip string) (handleID string, err error) {
 	return handleID, nil
 }
 
+// GetNodeHandles returns the attributes of the handles allocated to pods on node, keyed by handle id
+func (c IPAMClient) GetNodeHandles(node string) (map[string]map[string]string, error) {
+	blocks, err := c.ipamblocksLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	handles := make(map[string]map[string]string)
+	for _, block := range blocks {
+		for _, attrIndex := range block.Spec.Allocations {
+			if attrIndex == nil || *attrIndex < 0 || *attrIndex >= len(block.Spec.Attributes) {
+				continue
+			}
+			attr := block.Spec.Attributes[*attrIndex]
+			if attr.AttrPrimary == "" || attr.AttrSecondary[IPAMBlockAttributeNode] != node {
+				continue
+			}
+			handles[attr.AttrPrimary] = attr.AttrSecondary
+		}
+	}
+	return handles, nil
+}
+
 func (c IPAMClient) GetIPByHandleID(handleID string) (ips []string, err error) {
 	handle, err := c.queryHandle(handleID)
 	if err != nil {
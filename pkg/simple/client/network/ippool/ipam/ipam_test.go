@@ -15,3 +15,141 @@ limitations under the License.
 */
 
 package ipam
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	"github.com/yunify/hostnic-cni/pkg/client/clientset/versioned/fake"
+	informers "github.com/yunify/hostnic-cni/pkg/client/informers/externalversions"
+	"github.com/yunify/hostnic-cni/pkg/constants"
+)
+
+const testPool = "testpool"
+
+func newTestIPAMClient(t *testing.T) (IPAMClient, *fake.Clientset) {
+	k8sClient := k8sfake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      constants.IPAMConfigName,
+			Namespace: constants.IPAMConfigNamespace,
+		},
+		Data: map[string]string{
+			constants.IPAMConfigDate: "{}",
+		},
+	})
+	client := fake.NewSimpleClientset(&v1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: testPool,
+			Labels: map[string]string{
+				v1alpha1.IPPoolNameLabel: testPool,
+				v1alpha1.IPPoolTypeLabel: v1alpha1.IPPoolTypeLocal,
+			},
+		},
+		Spec: v1alpha1.IPPoolSpec{
+			Type:      v1alpha1.IPPoolTypeLocal,
+			CIDR:      "10.10.0.0/24",
+			BlockSize: 28,
+		},
+	})
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	k8sInformerFactory := k8sinformers.NewSharedInformerFactory(k8sClient, 0)
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	c := NewIPAMClient(client, v1alpha1.IPPoolTypeLocal, informerFactory, k8sInformerFactory)
+	k8sInformerFactory.Start(stopCh)
+	informerFactory.Start(stopCh)
+	if err := c.Sync(stopCh); err != nil {
+		t.Fatal(err)
+	}
+	return c, client
+}
+
+// assign assigns an address for handleID on node, and waits for the block to be seen by lister
+func assign(t *testing.T, c IPAMClient, node, handleID string) string {
+	t.Helper()
+
+	info := &PoolInfo{}
+	if _, err := c.WithBlockAffinity(node).AutoAssign(AutoAssignArgs{
+		HandleID: handleID,
+		Pool:     testPool,
+		Info:     info,
+	}); err != nil {
+		t.Fatalf("assign %s on %s failed: %v", handleID, node, err)
+	}
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		block, err := c.ipamblocksLister.Get(info.Block)
+		return err == nil && len(block.GetHandleOrdinals(handleID)) > 0, nil
+	})
+	if err != nil {
+		t.Fatalf("block %s of %s not synced", info.Block, handleID)
+	}
+	return info.Block
+}
+
+func TestBlockAffinity(t *testing.T) {
+	c, client := newTestIPAMClient(t)
+
+	blockA := assign(t, c, "node-a", "pod-a1")
+	blockB := assign(t, c, "node-b", "pod-b1")
+	if blockA == blockB {
+		t.Fatalf("nodes share block %s", blockA)
+	}
+	if block := assign(t, c, "node-a", "pod-a2"); block != blockA {
+		t.Fatalf("node-a assigned from block %s, but it claimed %s", block, blockA)
+	}
+
+	for block, node := range map[string]string{blockA: "node-a", blockB: "node-b"} {
+		b, err := client.NetworkV1alpha1().IPAMBlocks().Get(context.Background(), block, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.AffinityNode() != node {
+			t.Fatalf("block %s claimed by %q, expect %s", block, b.AffinityNode(), node)
+		}
+	}
+
+	if err := c.ReleaseAffinity("node-a"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := client.NetworkV1alpha1().IPAMBlocks().Get(context.Background(), blockA, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.AffinityNode() != "" || len(b.GetHandleOrdinals("pod-a1")) != 1 {
+		t.Fatalf("block %s is not released with addresses kept: %+v", blockA, b.Spec)
+	}
+}
+
+func TestDeleteBrokenBlock(t *testing.T) {
+	c, client := newTestIPAMClient(t)
+
+	name := assign(t, c, "node-a", "pod-a1")
+	block, err := client.NetworkV1alpha1().IPAMBlocks().Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// leak an ordinal, the block could not be updated any more but should still be deleted
+	block.Spec.Unallocated = block.Spec.Unallocated[1:]
+	if block, err = client.NetworkV1alpha1().IPAMBlocks().Update(context.Background(), block, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.updateBlock(block.DeepCopy()); err == nil {
+		t.Fatalf("broken block %s should not be updated", name)
+	}
+
+	if err := c.DeleteBlock(block); err != nil {
+		t.Fatalf("delete broken block %s failed: %v", name, err)
+	}
+	if _, err := client.NetworkV1alpha1().IPAMBlocks().Get(context.Background(), name, metav1.GetOptions{}); err == nil {
+		t.Fatalf("block %s is not deleted", name)
+	}
+}
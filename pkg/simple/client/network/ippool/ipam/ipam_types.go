@@ -16,6 +16,8 @@ limitations under the License.
 
 package ipam
 
+import "context"
+
 type PoolInfo struct {
 	IPPool string
 	Block  string
@@ -35,6 +37,16 @@ type AutoAssignArgs struct {
 	Pools  []string
 	Blocks []string
 	Info   *PoolInfo
+
+	// Context carries the trace of the request, may be nil
+	Context context.Context
+}
+
+func (args AutoAssignArgs) context() context.Context {
+	if args.Context == nil {
+		return context.Background()
+	}
+	return args.Context
 }
 
 // GetUtilizationArgs defines the set of arguments for requesting IP utilization.
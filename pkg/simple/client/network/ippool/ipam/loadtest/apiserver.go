@@ -0,0 +1,182 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	"github.com/yunify/hostnic-cni/pkg/client/clientset/versioned/fake"
+	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/client/clientset/versioned/typed/network/v1alpha1"
+)
+
+// writeStats counts the writes of a resource and how many of them hit a conflict
+type writeStats struct {
+	updates   int64
+	conflicts int64
+}
+
+// apiserver makes the generated fake clientset behave like kube-apiserver for ipamblocks and
+// ipamhandles: updates with a stale resourceVersion fail with conflict, which the object tracker
+// of the fake clientset does not check.
+type apiserver struct {
+	lock    sync.Mutex
+	tracker k8stesting.ObjectTracker
+	version uint64
+	stats   map[string]*writeStats
+}
+
+func installAPIServer(client *fake.Clientset) *apiserver {
+	s := &apiserver{
+		tracker: client.Tracker(),
+		stats:   make(map[string]*writeStats),
+	}
+	for _, resource := range []string{v1alpha1.ResourcePluralIPAMBlock, v1alpha1.ResourcePluralIPAMHandle} {
+		s.stats[resource] = &writeStats{}
+		client.PrependReactor("*", resource, s.react)
+	}
+	return s
+}
+
+func (s *apiserver) nextVersion() string {
+	s.version++
+	return strconv.FormatUint(s.version, 10)
+}
+
+func (s *apiserver) react(action k8stesting.Action) (bool, runtime.Object, error) {
+	switch action := action.(type) {
+	case k8stesting.CreateActionImpl:
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		// the object of caller is left as it is, like a real request
+		obj := action.GetObject().DeepCopyObject()
+		objMeta, err := meta.Accessor(obj)
+		if err != nil {
+			return true, nil, err
+		}
+		objMeta.SetResourceVersion(s.nextVersion())
+		action.Object = obj
+		return k8stesting.ObjectReaction(s.tracker)(action)
+
+	case k8stesting.UpdateActionImpl:
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		stats := s.stats[action.GetResource().Resource]
+		atomic.AddInt64(&stats.updates, 1)
+
+		obj := action.GetObject().DeepCopyObject()
+		objMeta, err := meta.Accessor(obj)
+		if err != nil {
+			return true, nil, err
+		}
+		current, err := s.tracker.Get(action.GetResource(), action.GetNamespace(), objMeta.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		currentMeta, err := meta.Accessor(current)
+		if err != nil {
+			return true, nil, err
+		}
+		if currentMeta.GetResourceVersion() != objMeta.GetResourceVersion() {
+			atomic.AddInt64(&stats.conflicts, 1)
+			return true, nil, k8serrors.NewConflict(action.GetResource().GroupResource(), objMeta.GetName(),
+				fmt.Errorf("the object has been modified; please apply your changes to the latest version and try again"))
+		}
+		objMeta.SetResourceVersion(s.nextVersion())
+		action.Object = obj
+		return k8stesting.ObjectReaction(s.tracker)(action)
+	}
+
+	// other verbs are served by the default reactor
+	return false, nil, nil
+}
+
+func (s *apiserver) writeStats(resource string) (updates, conflicts int64) {
+	stats := s.stats[resource]
+	return atomic.LoadInt64(&stats.updates), atomic.LoadInt64(&stats.conflicts)
+}
+
+// latencyClientset adds latency to every request to ipamblocks and ipamhandles. It is done outside
+// of the fake clientset, which serves one request at a time.
+type latencyClientset struct {
+	*fake.Clientset
+	latency time.Duration
+}
+
+func (c *latencyClientset) NetworkV1alpha1() networkv1alpha1.NetworkV1alpha1Interface {
+	return &latencyNetwork{c.Clientset.NetworkV1alpha1(), c.latency}
+}
+
+type latencyNetwork struct {
+	networkv1alpha1.NetworkV1alpha1Interface
+	latency time.Duration
+}
+
+func (n *latencyNetwork) IPAMBlocks() networkv1alpha1.IPAMBlockInterface {
+	return &latencyIPAMBlocks{n.NetworkV1alpha1Interface.IPAMBlocks(), n.latency}
+}
+
+func (n *latencyNetwork) IPAMHandles() networkv1alpha1.IPAMHandleInterface {
+	return &latencyIPAMHandles{n.NetworkV1alpha1Interface.IPAMHandles(), n.latency}
+}
+
+type latencyIPAMBlocks struct {
+	networkv1alpha1.IPAMBlockInterface
+	latency time.Duration
+}
+
+func (c *latencyIPAMBlocks) Create(ctx context.Context, block *v1alpha1.IPAMBlock, opts metav1.CreateOptions) (*v1alpha1.IPAMBlock, error) {
+	time.Sleep(c.latency)
+	return c.IPAMBlockInterface.Create(ctx, block, opts)
+}
+
+func (c *latencyIPAMBlocks) Update(ctx context.Context, block *v1alpha1.IPAMBlock, opts metav1.UpdateOptions) (*v1alpha1.IPAMBlock, error) {
+	time.Sleep(c.latency)
+	return c.IPAMBlockInterface.Update(ctx, block, opts)
+}
+
+func (c *latencyIPAMBlocks) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	time.Sleep(c.latency)
+	return c.IPAMBlockInterface.Delete(ctx, name, opts)
+}
+
+func (c *latencyIPAMBlocks) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.IPAMBlock, error) {
+	time.Sleep(c.latency)
+	return c.IPAMBlockInterface.Get(ctx, name, opts)
+}
+
+type latencyIPAMHandles struct {
+	networkv1alpha1.IPAMHandleInterface
+	latency time.Duration
+}
+
+func (c *latencyIPAMHandles) Create(ctx context.Context, handle *v1alpha1.IPAMHandle, opts metav1.CreateOptions) (*v1alpha1.IPAMHandle, error) {
+	time.Sleep(c.latency)
+	return c.IPAMHandleInterface.Create(ctx, handle, opts)
+}
+
+func (c *latencyIPAMHandles) Update(ctx context.Context, handle *v1alpha1.IPAMHandle, opts metav1.UpdateOptions) (*v1alpha1.IPAMHandle, error) {
+	time.Sleep(c.latency)
+	return c.IPAMHandleInterface.Update(ctx, handle, opts)
+}
+
+func (c *latencyIPAMHandles) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	time.Sleep(c.latency)
+	return c.IPAMHandleInterface.Delete(ctx, name, opts)
+}
+
+func (c *latencyIPAMHandles) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.IPAMHandle, error) {
+	time.Sleep(c.latency)
+	return c.IPAMHandleInterface.Get(ctx, name, opts)
+}
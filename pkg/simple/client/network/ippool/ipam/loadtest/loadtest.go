@@ -0,0 +1,355 @@
+// Package loadtest simulates nodes doing parallel AutoAssign and Release against the generated
+// fake clientset, to measure how the IPAM client behaves under contention.
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	"github.com/yunify/hostnic-cni/pkg/client/clientset/versioned"
+	"github.com/yunify/hostnic-cni/pkg/client/clientset/versioned/fake"
+	informers "github.com/yunify/hostnic-cni/pkg/client/informers/externalversions"
+	"github.com/yunify/hostnic-cni/pkg/constants"
+	"github.com/yunify/hostnic-cni/pkg/simple/client/network/ippool/ipam"
+)
+
+const (
+	poolName      = "loadtest-pool"
+	poolNamespace = "loadtest"
+)
+
+// Config describes the simulated cluster and the load on it.
+type Config struct {
+	// Number of nodes, each one has its own IPAMClient and informer caches like hostnic-node
+	Nodes int
+	// Number of workers on every node doing AutoAssign and Release in parallel
+	Workers int
+	// Number of pods every worker assigns before releasing them
+	Pods int
+	// Latency of every request to ipamblocks and ipamhandles
+	Latency time.Duration
+
+	CIDR      string
+	BlockSize int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Nodes:     10,
+		Workers:   4,
+		Pods:      5,
+		Latency:   time.Millisecond,
+		CIDR:      "10.10.0.0/20",
+		BlockSize: 26,
+	}
+}
+
+// Strategy is the way addresses are picked, Prepare is run once with the client of the first node
+// before the load starts.
+type Strategy interface {
+	Name() string
+	Prepare(c ipam.IPAMClient, client versioned.Interface) error
+	Assign(c ipam.IPAMClient, node, handleID string) error
+}
+
+// Strategies returns all strategies known, keyed by name
+func Strategies() map[string]Strategy {
+	return map[string]Strategy{
+		"pools":    &poolsStrategy{},
+		"blocks":   &blocksStrategy{num: 4},
+		"affinity": &affinityStrategy{},
+	}
+}
+
+// poolsStrategy assigns from the pool like hostnic does by default, every node picks the first block
+// with free addresses.
+type poolsStrategy struct{}
+
+func (s *poolsStrategy) Name() string {
+	return "pools"
+}
+
+func (s *poolsStrategy) Prepare(c ipam.IPAMClient, client versioned.Interface) error {
+	return nil
+}
+
+func (s *poolsStrategy) Assign(c ipam.IPAMClient, node, handleID string) error {
+	_, err := c.AutoAssignFromPools(ipam.AutoAssignArgs{
+		HandleID: handleID,
+		Attrs:    podAttrs(node, handleID),
+		Pools:    []string{poolName},
+		Info:     &ipam.PoolInfo{},
+	})
+	return err
+}
+
+// affinityStrategy assigns from the pool with block affinity, every node prefers the blocks claimed by itself.
+type affinityStrategy struct {
+	poolsStrategy
+}
+
+func (s *affinityStrategy) Name() string {
+	return "affinity"
+}
+
+func (s *affinityStrategy) Assign(c ipam.IPAMClient, node, handleID string) error {
+	return s.poolsStrategy.Assign(c.WithBlockAffinity(node), node, handleID)
+}
+
+// blocksStrategy assigns from the blocks of a namespace like hostnic does with subnets assigned
+// to namespaces, pods of all nodes share the same few blocks.
+type blocksStrategy struct {
+	num    int
+	blocks []string
+}
+
+func (s *blocksStrategy) Name() string {
+	return "blocks"
+}
+
+func (s *blocksStrategy) Prepare(c ipam.IPAMClient, client versioned.Interface) error {
+	if err := c.AutoGenerateBlocksFromPool(poolName); err != nil {
+		return err
+	}
+	blocks, err := client.NetworkV1alpha1().IPAMBlocks().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	s.blocks = nil
+	for _, block := range blocks.Items {
+		s.blocks = append(s.blocks, block.Name)
+	}
+	sort.Strings(s.blocks)
+	if len(s.blocks) > s.num {
+		s.blocks = s.blocks[:s.num]
+	}
+	return nil
+}
+
+func (s *blocksStrategy) Assign(c ipam.IPAMClient, node, handleID string) error {
+	_, err := c.AutoAssignFromBlocks(ipam.AutoAssignArgs{
+		HandleID: handleID,
+		Attrs:    podAttrs(node, handleID),
+		Blocks:   s.blocks,
+		Info:     &ipam.PoolInfo{},
+	})
+	return err
+}
+
+func podAttrs(node, handleID string) map[string]string {
+	return map[string]string{
+		ipam.IPAMBlockAttributePod:       handleID,
+		ipam.IPAMBlockAttributeNamespace: poolNamespace,
+		ipam.IPAMBlockAttributeNode:      node,
+	}
+}
+
+// Latency summarizes the durations of one kind of operation
+type Latency struct {
+	Count  int
+	Failed int
+	P50    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+}
+
+func newLatency(durations []time.Duration, failed int) Latency {
+	l := Latency{Count: len(durations), Failed: failed}
+	if len(durations) == 0 {
+		return l
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	percentile := func(p int) time.Duration {
+		return durations[(len(durations)-1)*p/100]
+	}
+	l.P50 = percentile(50)
+	l.P99 = percentile(99)
+	l.Max = durations[len(durations)-1]
+	return l
+}
+
+func (l Latency) String() string {
+	return fmt.Sprintf("count %5d, failed %4d, p50 %10v, p99 %10v, max %10v", l.Count, l.Failed, l.P50, l.P99, l.Max)
+}
+
+// Report is the result of a run
+type Report struct {
+	Strategy string
+	Config   Config
+	Elapsed  time.Duration
+
+	Assign  Latency
+	Release Latency
+
+	BlockUpdates    int64
+	BlockConflicts  int64
+	HandleUpdates   int64
+	HandleConflicts int64
+}
+
+// ConflictRate is the ratio of writes to ipamblocks and ipamhandles rejected with conflict
+func (r *Report) ConflictRate() float64 {
+	updates := r.BlockUpdates + r.HandleUpdates
+	if updates == 0 {
+		return 0
+	}
+	return float64(r.BlockConflicts+r.HandleConflicts) / float64(updates)
+}
+
+// RetriesPerOp is the number of retries after conflicts for every AutoAssign or Release
+func (r *Report) RetriesPerOp() float64 {
+	ops := r.Assign.Count + r.Release.Count
+	if ops == 0 {
+		return 0
+	}
+	return float64(r.BlockConflicts+r.HandleConflicts) / float64(ops)
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "strategy %s: %d nodes x %d workers x %d pods, latency %v, elapsed %v\n",
+		r.Strategy, r.Config.Nodes, r.Config.Workers, r.Config.Pods, r.Config.Latency, r.Elapsed)
+	fmt.Fprintf(&b, "   assign:  %s\n", r.Assign)
+	fmt.Fprintf(&b, "   release: %s\n", r.Release)
+	fmt.Fprintf(&b, "   ipamblocks:  updates %6d, conflicts %6d\n", r.BlockUpdates, r.BlockConflicts)
+	fmt.Fprintf(&b, "   ipamhandles: updates %6d, conflicts %6d\n", r.HandleUpdates, r.HandleConflicts)
+	fmt.Fprintf(&b, "   conflict rate %.2f%%, retries per op %.2f\n", r.ConflictRate()*100, r.RetriesPerOp())
+	return b.String()
+}
+
+// recorder collects the durations of operations from all workers
+type recorder struct {
+	lock          sync.Mutex
+	assign        []time.Duration
+	release       []time.Duration
+	assignFailed  int
+	releaseFailed int
+}
+
+func (r *recorder) record(assign bool, d time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if assign {
+		r.assign = append(r.assign, d)
+		if err != nil {
+			r.assignFailed++
+		}
+	} else {
+		r.release = append(r.release, d)
+		if err != nil {
+			r.releaseFailed++
+		}
+	}
+}
+
+// Run simulates cfg.Nodes nodes against one fake apiserver, every worker of them assigns cfg.Pods
+// addresses with strategy and then releases them.
+func Run(cfg Config, strategy Strategy) (*Report, error) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	k8sClient := k8sfake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      constants.IPAMConfigName,
+			Namespace: constants.IPAMConfigNamespace,
+		},
+		Data: map[string]string{
+			constants.IPAMConfigDate: "{}",
+		},
+	})
+	fakeClient := fake.NewSimpleClientset(newPool(cfg))
+	server := installAPIServer(fakeClient)
+	client := &latencyClientset{fakeClient, cfg.Latency}
+
+	var clients []ipam.IPAMClient
+	for i := 0; i < cfg.Nodes; i++ {
+		k8sInformerFactory := k8sinformers.NewSharedInformerFactory(k8sClient, 0)
+		informerFactory := informers.NewSharedInformerFactory(client, 0)
+		c := ipam.NewIPAMClient(client, v1alpha1.IPPoolTypeLocal, informerFactory, k8sInformerFactory)
+		k8sInformerFactory.Start(stopCh)
+		informerFactory.Start(stopCh)
+		if err := c.Sync(stopCh); err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	if err := strategy.Prepare(clients[0], client); err != nil {
+		return nil, fmt.Errorf("prepare strategy %s failed: %v", strategy.Name(), err)
+	}
+	// writes of Prepare are not part of the load
+	blockUpdates, blockConflicts := server.writeStats(v1alpha1.ResourcePluralIPAMBlock)
+	handleUpdates, handleConflicts := server.writeStats(v1alpha1.ResourcePluralIPAMHandle)
+
+	rec := &recorder{}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		node := fmt.Sprintf("node-%d", i)
+		for w := 0; w < cfg.Workers; w++ {
+			wg.Add(1)
+			go func(c ipam.IPAMClient, node string, w int) {
+				defer wg.Done()
+
+				var handles []string
+				for p := 0; p < cfg.Pods; p++ {
+					handleID := fmt.Sprintf("%s-%d-%d", node, w, p)
+					t := time.Now()
+					err := strategy.Assign(c, node, handleID)
+					rec.record(true, time.Since(t), err)
+					if err == nil {
+						handles = append(handles, handleID)
+					}
+				}
+				for _, handleID := range handles {
+					t := time.Now()
+					err := c.ReleaseByHandle(handleID)
+					rec.record(false, time.Since(t), err)
+				}
+			}(c, node, w)
+		}
+	}
+	wg.Wait()
+
+	report := &Report{
+		Strategy: strategy.Name(),
+		Config:   cfg,
+		Elapsed:  time.Since(start),
+		Assign:   newLatency(rec.assign, rec.assignFailed),
+		Release:  newLatency(rec.release, rec.releaseFailed),
+	}
+	report.BlockUpdates, report.BlockConflicts = server.writeStats(v1alpha1.ResourcePluralIPAMBlock)
+	report.HandleUpdates, report.HandleConflicts = server.writeStats(v1alpha1.ResourcePluralIPAMHandle)
+	report.BlockUpdates -= blockUpdates
+	report.BlockConflicts -= blockConflicts
+	report.HandleUpdates -= handleUpdates
+	report.HandleConflicts -= handleConflicts
+	return report, nil
+}
+
+func newPool(cfg Config) *v1alpha1.IPPool {
+	return &v1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: poolName,
+			Labels: map[string]string{
+				v1alpha1.IPPoolNameLabel: poolName,
+				v1alpha1.IPPoolTypeLabel: v1alpha1.IPPoolTypeLocal,
+			},
+		},
+		Spec: v1alpha1.IPPoolSpec{
+			Type:      v1alpha1.IPPoolTypeLocal,
+			CIDR:      cfg.CIDR,
+			BlockSize: cfg.BlockSize,
+		},
+	}
+}
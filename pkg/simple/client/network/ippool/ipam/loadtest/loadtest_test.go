@@ -0,0 +1,71 @@
+package loadtest
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+func init() {
+	// AutoAssign logs every failed attempt, which floods the benchmark output
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	_ = fs.Set("logtostderr", "false")
+	_ = fs.Set("alsologtostderr", "false")
+	_ = fs.Set("stderrthreshold", "FATAL")
+}
+
+func TestRun(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Nodes = 3
+	cfg.Workers = 2
+	cfg.Pods = 3
+	cfg.Latency = 0
+
+	for name, strategy := range Strategies() {
+		report, err := Run(cfg, strategy)
+		if err != nil {
+			t.Fatalf("run strategy %s failed: %v", name, err)
+		}
+		t.Log(report)
+
+		pods := cfg.Nodes * cfg.Workers * cfg.Pods
+		if report.Assign.Count != pods || report.Release.Count != pods-report.Assign.Failed {
+			t.Errorf("strategy %s: expect %d assigns and releases, but got %d and %d", name, pods, report.Assign.Count, report.Release.Count)
+		}
+	}
+}
+
+// BenchmarkAutoAssign runs the load with every strategy, an op is one pod assigned and released.
+func BenchmarkAutoAssign(b *testing.B) {
+	cfg := DefaultConfig()
+	cfg.Latency = 2 * time.Millisecond
+
+	for name, strategy := range Strategies() {
+		b.Run(name, func(b *testing.B) {
+			var reports []*Report
+			for i := 0; i < b.N; i++ {
+				report, err := Run(cfg, strategy)
+				if err != nil {
+					b.Fatal(err)
+				}
+				reports = append(reports, report)
+			}
+
+			var p99, conflictRate, retries, failed float64
+			for _, r := range reports {
+				p99 += float64(r.Assign.P99.Microseconds()) / 1000
+				conflictRate += r.ConflictRate() * 100
+				retries += r.RetriesPerOp()
+				failed += float64(r.Assign.Failed + r.Release.Failed)
+			}
+			n := float64(len(reports))
+			b.ReportMetric(p99/n, "assign-p99-ms")
+			b.ReportMetric(conflictRate/n, "conflict-%")
+			b.ReportMetric(retries/n, "retries/op")
+			b.ReportMetric(failed/n, "failed")
+		})
+	}
+}
@@ -38,6 +38,8 @@ type Provider interface {
 	GetIPPoolStats(pool *networkv1alpha1.IPPool) (*networkv1alpha1.IPPool, error)
 	SyncStatus(stopCh <-chan struct{}, q workqueue.RateLimitingInterface) error
 	UpdateNamespace(ns *corev1.Namespace, pools []string) error
+	// ReleaseAffinity gives back the blocks claimed by a deleted node
+	ReleaseAffinity(node string) error
 	Type() string
 	Default(obj runtime.Object) error
 }
@@ -59,6 +61,10 @@ func (p provider) UpdateNamespace(ns *corev1.Namespace, pools []string) error {
 	return nil
 }
 
+func (p provider) ReleaseAffinity(node string) error {
+	return p.ipamclient.ReleaseAffinity(node)
+}
+
 func (p provider) DeleteIPPool(pool *networkv1alpha1.IPPool) (bool, error) {
 	blocks, err := p.ipamclient.ListBlocks(pool.Name)
 	if err != nil {
@@ -109,6 +115,7 @@ func (p provider) GetIPPoolStats(pool *networkv1alpha1.IPPool) (*networkv1alpha1
 		Reserved:    stat.Reserved,
 		Capacity:    stat.Capacity,
 		Synced:      true,
+		Conditions:  clone.Status.Conditions,
 	}
 	return clone, nil
 }
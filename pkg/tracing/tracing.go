@@ -0,0 +1,109 @@
+// Package tracing sets up optional OpenTelemetry tracing for the hostnic plugin and daemon.
+// Spans are exported to an OTLP collector and/or a local file, nothing is recorded when
+// neither is configured.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/yunify/hostnic-cni"
+
+type Config struct {
+	// Endpoint is the address of OTLP gRPC collector, such as "localhost:4317"
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	// Insecure disables TLS to the collector
+	Insecure bool `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	// File appends spans as json to the file for offline analysis
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+func (c Config) Enabled() bool {
+	return c.Endpoint != "" || c.File != ""
+}
+
+// Setup installs the global tracer provider for service, the returned function flushes
+// and stops the exporters. W3C trace context is always propagated, so a trace started
+// by the plugin is continued by the daemon even if only one of them exports spans.
+func Setup(service string, conf Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if !conf.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var (
+		opts    []sdktrace.TracerProviderOption
+		closers []func() error
+	)
+	if conf.Endpoint != "" {
+		clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(conf.Endpoint)}
+		if conf.Insecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(context.Background(), clientOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("create otlp exporter for %s failed: %v", conf.Endpoint, err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	if conf.File != "" {
+		f, err := os.OpenFile(conf.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open trace file %s failed: %v", conf.File, err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("create file exporter for %s failed: %v", conf.File, err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+		closers = append(closers, f.Close)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String(service),
+	))
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, sdktrace.WithResource(res))
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		for _, c := range closers {
+			c()
+		}
+		return err
+	}, nil
+}
+
+// Start starts a span named name as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
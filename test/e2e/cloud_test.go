@@ -0,0 +1,337 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yunify/hostnic-cni/pkg/constants"
+	"github.com/yunify/hostnic-cni/pkg/qcclient"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+)
+
+const instanceID = "i-e2enode"
+
+// fakeCloud plays QingCloud for the node: every vxnet is a bridge holding the gateway ip in the cloud netns,
+// and attaching a nic creates a veth pair whose one end is plugged into the bridge and the other one
+// appears in the node netns with the mac of nic.
+type fakeCloud struct {
+	lock    sync.Mutex
+	cloudNS ns.NetNS
+	nodeNS  ns.NetNS
+	vxnets  map[string]*rpc.VxNet
+	nics    map[string]*rpc.HostNic
+	seq     int
+}
+
+var _ qcclient.QingCloudAPI = &fakeCloud{}
+
+func newFakeCloud(nodeNS ns.NetNS) (*fakeCloud, error) {
+	cloudNS, err := newNS("cloud")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cloud netns: %v", err)
+	}
+	return &fakeCloud{
+		cloudNS: cloudNS,
+		nodeNS:  nodeNS,
+		vxnets:  make(map[string]*rpc.VxNet),
+		nics:    make(map[string]*rpc.HostNic),
+	}, nil
+}
+
+// addVxNet creates the bridge of vxnet with the gateway ip in the cloud netns
+func (c *fakeCloud) addVxNet(vxnet *rpc.VxNet) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, cidr, err := net.ParseCIDR(vxnet.Network)
+	if err != nil {
+		return err
+	}
+	err = c.cloudNS.Do(func(_ ns.NetNS) error {
+		la := netlink.NewLinkAttrs()
+		la.Name = vxnetBridgeName(vxnet.ID)
+		br := &netlink.Bridge{LinkAttrs: la}
+		if err := netlink.LinkAdd(br); err != nil {
+			return fmt.Errorf("failed to add bridge %s: %v", la.Name, err)
+		}
+		gw := &netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP(vxnet.Gateway), Mask: cidr.Mask}}
+		if err := netlink.AddrAdd(br, gw); err != nil {
+			return fmt.Errorf("failed to add gateway %s: %v", gw, err)
+		}
+		_, _ = sysctl.Sysctl("net/ipv4/conf/all/rp_filter", "0")
+		_, _ = sysctl.Sysctl(fmt.Sprintf("net/ipv4/conf/%s/rp_filter", la.Name), "0")
+		return netlink.LinkSetUp(br)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.vxnets[vxnet.ID] = vxnet
+	return nil
+}
+
+func vxnetBridgeName(vxnet string) string {
+	return "br-" + vxnet
+}
+
+func (c *fakeCloud) GetInstanceID() string {
+	return instanceID
+}
+
+func (c *fakeCloud) GetCreatedNicsByName(name string) ([]*rpc.HostNic, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var result []*rpc.HostNic
+	for _, nic := range c.nics {
+		result = append(result, copyNic(nic))
+	}
+	return result, nil
+}
+
+func (c *fakeCloud) GetVxNets(ids []string, customReservedIPCount int64) (map[string]*rpc.VxNet, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result := make(map[string]*rpc.VxNet)
+	for _, id := range ids {
+		if vxnet, ok := c.vxnets[id]; ok {
+			result[id] = proto.Clone(vxnet).(*rpc.VxNet)
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeCloud) DescribeNicJobs(ids []string) ([]string, map[string]bool, error) {
+	return nil, nil, nil
+}
+
+func (c *fakeCloud) GetJobStatus(id string) (string, error) {
+	return "successful", nil
+}
+
+func (c *fakeCloud) CreateNicsAndAttach(vxnet *rpc.VxNet, num int, ips []string, disableIP int) ([]*rpc.HostNic, string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.vxnets[vxnet.ID]; !ok {
+		return nil, "", fmt.Errorf("vxnet %s %s", vxnet.ID, constants.ResourceNotFound)
+	}
+
+	var result []*rpc.HostNic
+	for i := 0; i < num; i++ {
+		c.seq++
+		mac := fmt.Sprintf("52:54:%02x:%02x:%02x:%02x", 0xe2, 0xe0, c.seq>>8&0xff, c.seq&0xff)
+		nic := &rpc.HostNic{
+			ID:           mac,
+			VxNet:        proto.Clone(vxnet).(*rpc.VxNet),
+			HardwareAddr: mac,
+		}
+		if err := c.attach(nic); err != nil {
+			return nil, "", err
+		}
+		c.nics[nic.ID] = nic
+		result = append(result, copyNic(nic))
+	}
+	return result, "", nil
+}
+
+func (c *fakeCloud) GetNics(nics []string) (map[string]*rpc.HostNic, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result := make(map[string]*rpc.HostNic)
+	for _, id := range nics {
+		if nic, ok := c.nics[id]; ok {
+			result[id] = copyNic(nic)
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeCloud) DeleteNics(nicIDs []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, id := range nicIDs {
+		if nic, ok := c.nics[id]; ok && nic.Using {
+			return fmt.Errorf("nic %s is in-use", id)
+		}
+		delete(c.nics, id)
+	}
+	return nil
+}
+
+func (c *fakeCloud) DeattachNics(nicIDs []string, sync bool) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, id := range nicIDs {
+		nic, ok := c.nics[id]
+		if !ok {
+			return "", fmt.Errorf("nic %s %s", id, constants.ResourceNotFound)
+		}
+		if err := c.detach(nic); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func (c *fakeCloud) AttachNics(nicIDs []string, sync bool) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, id := range nicIDs {
+		nic, ok := c.nics[id]
+		if !ok {
+			return "", fmt.Errorf("nic %s %s", id, constants.ResourceNotFound)
+		}
+		if nic.Using {
+			continue
+		}
+		if err := c.attach(nic); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func (c *fakeCloud) GetAttachedNics() ([]*rpc.HostNic, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var result []*rpc.HostNic
+	for _, nic := range c.nics {
+		if nic.Using {
+			result = append(result, copyNic(nic))
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeCloud) GetCreatedNicsByVxNet(vxnet string) ([]*rpc.HostNic, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var result []*rpc.HostNic
+	for _, nic := range c.nics {
+		if nic.VxNet.ID == vxnet {
+			result = append(result, copyNic(nic))
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeCloud) CreateVIPs(vxnet *rpc.VxNet) (string, error) {
+	return "", fmt.Errorf("CreateVIPs is not supported by fake cloud")
+}
+
+func (c *fakeCloud) DescribeVIPs(vxnet *rpc.VxNet) ([]*rpc.VIP, error) {
+	return nil, nil
+}
+
+func (c *fakeCloud) DeleteVIPs(vips []string) (string, error) {
+	return "", nil
+}
+
+func (c *fakeCloud) CreateSecurityGroupRuleForVxNet(sg string, vxnet *rpc.VxNet) (string, error) {
+	return "", fmt.Errorf("CreateSecurityGroupRuleForVxNet is not supported by fake cloud")
+}
+
+func (c *fakeCloud) GetSecurityGroupRuleForVxNet(sg string, vxnet *rpc.VxNet) (*rpc.SecurityGroupRule, error) {
+	return nil, nil
+}
+
+func (c *fakeCloud) DeleteSecurityGroupRuleForVxNet(sg, sgr string) (string, error) {
+	return "", nil
+}
+
+func (c *fakeCloud) DescribeClusterSecurityGroup(clusterID string) (string, error) {
+	return "", nil
+}
+
+func (c *fakeCloud) DescribeClusterNodes(clusterID string) ([]*rpc.Node, error) {
+	return nil, nil
+}
+
+// attach creates the veth pair of nic, the end in node netns is left down like a nic just attached
+func (c *fakeCloud) attach(nic *rpc.HostNic) error {
+	mac, err := net.ParseMAC(nic.HardwareAddr)
+	if err != nil {
+		return err
+	}
+
+	c.seq++
+	name := fmt.Sprintf("eth%d", c.seq)
+	peerName := fmt.Sprintf("port%d", c.seq)
+	err = c.cloudNS.Do(func(_ ns.NetNS) error {
+		br, err := netlink.LinkByName(vxnetBridgeName(nic.VxNet.ID))
+		if err != nil {
+			return err
+		}
+
+		la := netlink.NewLinkAttrs()
+		la.Name = name
+		la.HardwareAddr = mac
+		if err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: la, PeerName: peerName}); err != nil {
+			return fmt.Errorf("failed to add veth %s: %v", name, err)
+		}
+		peer, err := netlink.LinkByName(peerName)
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetMaster(peer, br.(*netlink.Bridge)); err != nil {
+			return err
+		}
+		if err := netlink.LinkSetUp(peer); err != nil {
+			return err
+		}
+
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return err
+		}
+		return netlink.LinkSetNsFd(link, int(c.nodeNS.Fd()))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach nic %s: %v", nic.ID, err)
+	}
+
+	nic.Using = true
+	return nil
+}
+
+// detach deletes the end of nic in node netns, and the peer is gone with it
+func (c *fakeCloud) detach(nic *rpc.HostNic) error {
+	err := c.nodeNS.Do(func(_ ns.NetNS) error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			if link.Type() == "veth" && link.Attrs().HardwareAddr.String() == nic.HardwareAddr {
+				return netlink.LinkDel(link)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to detach nic %s: %v", nic.ID, err)
+	}
+
+	nic.Using = false
+	return nil
+}
+
+func copyNic(nic *rpc.HostNic) *rpc.HostNic {
+	return proto.Clone(nic).(*rpc.HostNic)
+}
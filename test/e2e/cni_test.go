@@ -0,0 +1,450 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/yunify/hostnic-cni/pkg/constants"
+)
+
+const (
+	cniVersion  = "0.4.0"
+	serviceCIDR = "10.233.0.0/18"
+	podIfName   = "eth0"
+	// podGateway is the fake gateway of pod, which is answered by the host veth
+	podGateway = "169.254.1.1"
+
+	testNamespace = "default"
+	tcpPort       = 8080
+)
+
+type testPod struct {
+	name        string
+	containerID string
+	netns       ns.NetNS
+}
+
+// newTestPod creates the pod object and netns, they are removed when the test finishes
+func newTestPod(t *testing.T, name string) *testPod {
+	t.Helper()
+
+	if err := node.createPod(testNamespace, name); err != nil {
+		t.Fatalf("failed to create pod %s: %v", name, err)
+	}
+	netns, err := newNS(name)
+	if err != nil {
+		t.Fatalf("failed to create netns of pod %s: %v", name, err)
+	}
+	pod := &testPod{
+		name:        name,
+		containerID: "container-" + name,
+		netns:       netns,
+	}
+	t.Cleanup(func() {
+		if err := delNS(pod.netns); err != nil {
+			t.Errorf("failed to delete netns of pod %s: %v", name, err)
+		}
+		if err := node.deletePod(testNamespace, name); err != nil {
+			t.Errorf("failed to delete pod %s: %v", name, err)
+		}
+	})
+	return pod
+}
+
+func (p *testPod) args(command string) *invoke.Args {
+	return &invoke.Args{
+		Command:     command,
+		ContainerID: p.containerID,
+		NetNS:       p.netns.Path(),
+		IfName:      podIfName,
+		PluginArgsStr: fmt.Sprintf("K8S_POD_NAMESPACE=%s;K8S_POD_NAME=%s;K8S_POD_INFRA_CONTAINER_ID=%s",
+			testNamespace, p.name, p.containerID),
+		Path: filepath.Dir(node.plugin),
+	}
+}
+
+// handleID is the ipam handle of pod, which is podHandleKey of hostnic-node
+func (p *testPod) handleID() string {
+	return testNamespace + "-" + p.name + "-" + p.containerID
+}
+
+func (n *fakeNode) netconf(prevResult *current.Result) []byte {
+	conf := map[string]interface{}{
+		"cniVersion":  cniVersion,
+		"name":        "hostnic",
+		"type":        "hostnic",
+		"serviceCIDR": serviceCIDR,
+		"logFile":     filepath.Join(n.dir, "hostnic.log"),
+	}
+	if prevResult != nil {
+		conf["prevResult"] = prevResult
+	}
+	data, _ := json.Marshal(conf)
+	return data
+}
+
+func (n *fakeNode) cniAdd(pod *testPod) (*current.Result, error) {
+	r, err := invoke.ExecPluginWithResult(context.Background(), n.plugin, n.netconf(nil), pod.args("ADD"), nil)
+	if err != nil {
+		return nil, err
+	}
+	return current.NewResultFromResult(r)
+}
+
+func (n *fakeNode) cniCheck(pod *testPod, result *current.Result) error {
+	return invoke.ExecPluginWithoutResult(context.Background(), n.plugin, n.netconf(result), pod.args("CHECK"), nil)
+}
+
+func (n *fakeNode) cniDel(pod *testPod) error {
+	return invoke.ExecPluginWithoutResult(context.Background(), n.plugin, n.netconf(nil), pod.args("DEL"), nil)
+}
+
+// addPod runs ADD for pod and deletes it when the test finishes
+func addPod(t *testing.T, pod *testPod) *current.Result {
+	t.Helper()
+
+	result, err := node.cniAdd(pod)
+	if err != nil {
+		t.Fatalf("ADD of pod %s failed: %v", pod.name, err)
+	}
+	if len(result.IPs) != 1 {
+		t.Fatalf("ADD of pod %s returned ips %v", pod.name, result.IPs)
+	}
+	t.Cleanup(func() {
+		if err := node.cniDel(pod); err != nil {
+			t.Errorf("DEL of pod %s failed: %v", pod.name, err)
+		}
+	})
+	return result
+}
+
+func TestAddCheckDel(t *testing.T) {
+	pod := newTestPod(t, "pod-a")
+
+	result, err := node.cniAdd(pod)
+	if err != nil {
+		t.Fatalf("ADD failed: %v", err)
+	}
+	if len(result.IPs) != 1 {
+		t.Fatalf("ADD returned ips %v", result.IPs)
+	}
+	podIP := result.IPs[0].Address.IP
+	_, vxnet, _ := net.ParseCIDR(node.vxnet.Network)
+	if !vxnet.Contains(podIP) {
+		t.Fatalf("pod ip %s is not in vxnet %s", podIP, vxnet)
+	}
+
+	hostVeth := assertHostVeth(t, podIP)
+	assertPodNetwork(t, pod, podIP, hostVeth)
+	table := assertNicNetwork(t)
+	assertRule(t, constants.ToContainerRulePriority, nil, hostIPNet(podIP), constants.MainTable)
+	assertRule(t, constants.FromContainerRulePriority, vxnet, nil, table)
+	if got := node.handleOrdinals(t, pod); got != 1 {
+		t.Fatalf("pod has %d ips in ipam blocks, expect 1", got)
+	}
+	assertConnected(t, pod, podIP, node.cloud.cloudNS, net.ParseIP(node.vxnet.Gateway))
+
+	if err := node.cniCheck(pod, result); err != nil {
+		t.Fatalf("CHECK failed: %v", err)
+	}
+
+	if err := node.cniDel(pod); err != nil {
+		t.Fatalf("DEL failed: %v", err)
+	}
+	if rules := listRules(t, constants.ToContainerRulePriority, nil, hostIPNet(podIP)); len(rules) != 0 {
+		t.Fatalf("rules to pod are not removed: %v", rules)
+	}
+	if _, err := netlink.LinkByName(hostVeth.Attrs().Name); err == nil {
+		t.Fatalf("host veth %s is not removed", hostVeth.Attrs().Name)
+	}
+	if got := node.handleOrdinals(t, pod); got != 0 {
+		t.Fatalf("pod has %d ips in ipam blocks after DEL, expect 0", got)
+	}
+	if err := node.cniCheck(pod, result); err == nil {
+		t.Fatalf("CHECK succeeded after DEL")
+	}
+
+	// DEL may be called more than once
+	if err := node.cniDel(pod); err != nil {
+		t.Fatalf("second DEL failed: %v", err)
+	}
+}
+
+func TestCheckDetectsMissingRule(t *testing.T) {
+	pod := newTestPod(t, "pod-check")
+	result := addPod(t, pod)
+	podIP := result.IPs[0].Address.IP
+
+	if err := node.cniCheck(pod, result); err != nil {
+		t.Fatalf("CHECK failed: %v", err)
+	}
+
+	rules := listRules(t, constants.ToContainerRulePriority, nil, hostIPNet(podIP))
+	for i := range rules {
+		if err := netlink.RuleDel(&rules[i]); err != nil {
+			t.Fatalf("failed to delete rule %s: %v", rules[i], err)
+		}
+	}
+	if err := node.cniCheck(pod, result); err == nil || !strings.Contains(err.Error(), "rule") {
+		t.Fatalf("CHECK should report the missing rule, got %v", err)
+	}
+}
+
+func TestPodToPod(t *testing.T) {
+	podA := newTestPod(t, "pod-b")
+	podB := newTestPod(t, "pod-c")
+	resultA := addPod(t, podA)
+	resultB := addPod(t, podB)
+
+	if resultA.IPs[0].Address.IP.Equal(resultB.IPs[0].Address.IP) {
+		t.Fatalf("pods got the same ip %s", resultA.IPs[0].Address.IP)
+	}
+	ipA, ipB := resultA.IPs[0].Address.IP, resultB.IPs[0].Address.IP
+	assertConnected(t, podA, ipA, podB.netns, ipB)
+	assertConnected(t, podB, ipB, podA.netns, ipA)
+}
+
+func TestRepairDeletedBridge(t *testing.T) {
+	pod := newTestPod(t, "pod-repair")
+	result := addPod(t, pod)
+
+	table := assertNicNetwork(t)
+	br, err := netlink.LinkByName(constants.GetHostNicBridgeName(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkDel(br); err != nil {
+		t.Fatalf("failed to delete bridge: %v", err)
+	}
+
+	// hostnic-node rebuilds the bridge, routes and rule of nic on the deletion event
+	err = wait.PollImmediate(500*time.Millisecond, 30*time.Second, func() (bool, error) {
+		return node.cniCheck(pod, result) == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("bridge is not repaired: %v", node.cniCheck(pod, result))
+	}
+	assertNicNetwork(t)
+	assertConnected(t, pod, result.IPs[0].Address.IP, node.cloud.cloudNS, net.ParseIP(node.vxnet.Gateway))
+}
+
+// assertHostVeth returns the host veth of pod, which the route to pod goes through
+func assertHostVeth(t *testing.T, podIP net.IP) netlink.Link {
+	t.Helper()
+
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Dst: hostIPNet(podIP)}, netlink.RT_FILTER_DST)
+	if err != nil || len(routes) != 1 {
+		t.Fatalf("route to pod %s not found: %v %v", podIP, routes, err)
+	}
+	veth, err := netlink.LinkByIndex(routes[0].LinkIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if veth.Type() != "veth" || !strings.HasPrefix(veth.Attrs().Name, constants.HostNicPrefix) {
+		t.Fatalf("route to pod %s goes through %s %s", podIP, veth.Type(), veth.Attrs().Name)
+	}
+	return veth
+}
+
+// assertPodNetwork checks the ip, route and neighbor in the netns of pod
+func assertPodNetwork(t *testing.T, pod *testPod, podIP net.IP, hostVeth netlink.Link) {
+	t.Helper()
+
+	err := pod.netns.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(podIfName)
+		if err != nil {
+			return err
+		}
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		if len(addrs) != 1 || !addrs[0].IP.Equal(podIP) {
+			return fmt.Errorf("%s has addresses %v, expect %s", podIfName, addrs, podIP)
+		}
+
+		routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		defaultRoute := false
+		for _, route := range routes {
+			if route.Dst == nil && route.Gw.Equal(net.ParseIP(podGateway)) {
+				defaultRoute = true
+			}
+		}
+		if !defaultRoute {
+			return fmt.Errorf("default route via %s not found in %v", podGateway, routes)
+		}
+
+		neighs, err := netlink.NeighList(link.Attrs().Index, netlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		for _, neigh := range neighs {
+			if neigh.IP.Equal(net.ParseIP(podGateway)) {
+				if neigh.State != netlink.NUD_PERMANENT || neigh.HardwareAddr.String() != hostVeth.Attrs().HardwareAddr.String() {
+					return fmt.Errorf("neighbor %s is %s state %d, expect %s permanent",
+						podGateway, neigh.HardwareAddr, neigh.State, hostVeth.Attrs().HardwareAddr)
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("neighbor %s not found in %v", podGateway, neighs)
+	})
+	if err != nil {
+		t.Fatalf("network of pod %s is wrong: %v", pod.name, err)
+	}
+}
+
+// assertNicNetwork checks the nic of vxnet, its bridge and route table, and returns the table
+func assertNicNetwork(t *testing.T) int {
+	t.Helper()
+
+	nicName := constants.GetHostNicName(node.vxnet.ID)
+	nic, err := netlink.LinkByName(nicName)
+	if err != nil {
+		t.Fatalf("nic %s not found: %v", nicName, err)
+	}
+	if nic.Attrs().Flags&net.FlagUp == 0 {
+		t.Fatalf("nic %s is down", nicName)
+	}
+	br, err := netlink.LinkByIndex(nic.Attrs().MasterIndex)
+	if err != nil || br.Type() != "bridge" {
+		t.Fatalf("nic %s is not in a bridge: %v", nicName, err)
+	}
+	table, err := strconv.Atoi(strings.TrimPrefix(br.Attrs().Name, constants.BridgePrefix))
+	if err != nil {
+		t.Fatalf("bridge %s of nic is not named by its route table", br.Attrs().Name)
+	}
+
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: table}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultRoute := false
+	for _, route := range routes {
+		if route.Dst == nil && route.Gw.Equal(net.ParseIP(node.vxnet.Gateway)) && route.LinkIndex == br.Attrs().Index {
+			defaultRoute = true
+		}
+	}
+	if !defaultRoute {
+		t.Fatalf("default route via %s dev %s not found in table %d: %v", node.vxnet.Gateway, br.Attrs().Name, table, routes)
+	}
+	return table
+}
+
+func listRules(t *testing.T, priority int, src, dst *net.IPNet) []netlink.Rule {
+	t.Helper()
+
+	rules, err := netlink.RuleList(unix.AF_INET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []netlink.Rule
+	for _, rule := range rules {
+		if rule.Priority == priority && sameIPNet(rule.Src, src) && sameIPNet(rule.Dst, dst) {
+			result = append(result, rule)
+		}
+	}
+	return result
+}
+
+func assertRule(t *testing.T, priority int, src, dst *net.IPNet, table int) {
+	t.Helper()
+
+	rules := listRules(t, priority, src, dst)
+	if len(rules) != 1 || rules[0].Table != table {
+		t.Fatalf("expect one rule from %v to %v lookup %d with priority %d, got %v", src, dst, table, priority, rules)
+	}
+}
+
+func sameIPNet(a, b *net.IPNet) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.String() == b.String()
+}
+
+func hostIPNet(ip net.IP) *net.IPNet {
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+}
+
+// assertConnected dials a tcp server on ip in netns from pod, the server should see the ip of pod
+func assertConnected(t *testing.T, pod *testPod, podIP net.IP, netns ns.NetNS, ip net.IP) {
+	t.Helper()
+
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(tcpPort))
+	var listener net.Listener
+	err := netns.Do(func(_ ns.NetNS) error {
+		var err error
+		listener, err = net.Listen("tcp4", addr)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", addr, err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(conn.RemoteAddr().String()))
+			conn.Close()
+		}
+	}()
+
+	var remote string
+	err = pod.netns.Do(func(_ ns.NetNS) error {
+		conn, err := net.DialTimeout("tcp4", addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		remote = string(buf[:n])
+		return err
+	})
+	if err != nil {
+		t.Fatalf("pod %s failed to connect %s: %v", pod.name, addr, err)
+	}
+	if host, _, _ := net.SplitHostPort(remote); host != podIP.String() {
+		t.Fatalf("server saw pod %s as %q, expect %s", pod.name, remote, podIP)
+	}
+}
+
+// handleOrdinals returns the count of ips allocated to pod in ipam blocks
+func (n *fakeNode) handleOrdinals(t *testing.T, pod *testPod) int {
+	t.Helper()
+
+	blocks, err := n.client.NetworkV1alpha1().IPAMBlocks().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for i := range blocks.Items {
+		count += len(blocks.Items[i].GetHandleOrdinals(pod.handleID()))
+	}
+	return count
+}
@@ -0,0 +1,325 @@
+//go:build e2e
+
+// Package e2e runs hostnic-node and the hostnic cni plugin against a real kernel without any cloud account.
+//
+// The test binary runs itself again in new network, mount and uts namespaces, which become the node.
+// A fake cloud turns nics attached to the node into veth pairs from the vxnet bridges in a "cloud" netns,
+// hostnic-node runs in the test process with fake clientsets of kubernetes, and the plugin is built
+// and executed like a container runtime does, with a netns for every pod.
+package e2e
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/yunify/hostnic-cni/pkg/allocator"
+	networkv1alpha1 "github.com/yunify/hostnic-cni/pkg/apis/network/v1alpha1"
+	"github.com/yunify/hostnic-cni/pkg/client/clientset/versioned/fake"
+	informers "github.com/yunify/hostnic-cni/pkg/client/informers/externalversions"
+	"github.com/yunify/hostnic-cni/pkg/conf"
+	"github.com/yunify/hostnic-cni/pkg/config"
+	"github.com/yunify/hostnic-cni/pkg/constants"
+	"github.com/yunify/hostnic-cni/pkg/db"
+	"github.com/yunify/hostnic-cni/pkg/networkutils"
+	"github.com/yunify/hostnic-cni/pkg/qcclient"
+	"github.com/yunify/hostnic-cni/pkg/rpc"
+	"github.com/yunify/hostnic-cni/pkg/server"
+	"github.com/yunify/hostnic-cni/pkg/simple/client/network/ippool/ipam"
+)
+
+const (
+	// nodeEnv is set for the test binary running in the namespaces of node
+	nodeEnv = "HOSTNIC_E2E_NODE"
+
+	nodeName = "e2e-node"
+	// nodeIP is the address of eth0, which is checked by the plugin
+	nodeIP = "192.168.0.10/24"
+
+	// dbPath is the default path of leveldb
+	dbPath      = "/var/lib/hostnic"
+	metricsPort = 9191
+)
+
+var node *fakeNode
+
+type fakeNode struct {
+	dir    string
+	plugin string
+	cloud  *fakeCloud
+	vxnet  *rpc.VxNet
+
+	k8s    *k8sfake.Clientset
+	client *fake.Clientset
+	stopCh chan struct{}
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if os.Getenv(nodeEnv) == "" {
+		os.Exit(runInNode())
+	}
+	os.Exit(runSuite(m))
+}
+
+// runInNode runs the test binary again in the namespaces of node, so that nothing of the host is touched.
+// The e2e tag is only set when the tests are asked for, so a node unable to run them fails the run
+// instead of passing it silently.
+func runInNode() int {
+	if os.Geteuid() != 0 {
+		fmt.Fprintln(os.Stderr, "e2e tests require root")
+		return 1
+	}
+	if err := lookupTools(); err != nil {
+		fmt.Fprintf(os.Stderr, "e2e tests can not run: %v\n", err)
+		return 1
+	}
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), nodeEnv+"=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWNET | syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS,
+	}
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "failed to run e2e tests in node namespaces: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// lookupTools checks the commands used by the plugin and hostnic-node
+func lookupTools() error {
+	for _, tool := range []string{"go", "iptables", "flock"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("%s not found in PATH", tool)
+		}
+	}
+	// the path of ebtables is fixed in networkutils
+	if _, err := os.Stat("/sbin/ebtables"); err != nil {
+		return fmt.Errorf("ebtables not found: %v", err)
+	}
+	return nil
+}
+
+func runSuite(m *testing.M) int {
+	var err error
+	node, err = setupNode()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to setup node: %v\n", err)
+		return 1
+	}
+	defer node.teardown()
+
+	return m.Run()
+}
+
+func setupNode() (*fakeNode, error) {
+	// mounts below are only seen by the node
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return nil, fmt.Errorf("failed to make mounts private: %v", err)
+	}
+	for _, dir := range []string{nsRunDir, filepath.Dir(constants.DefaultSocketPath), dbPath} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := unix.Mount("tmpfs", dir, "tmpfs", 0, ""); err != nil {
+			return nil, fmt.Errorf("failed to mount tmpfs to %s: %v", dir, err)
+		}
+	}
+	// the plugin takes hostname as node name
+	if err := unix.Sethostname([]byte(nodeName)); err != nil {
+		return nil, fmt.Errorf("failed to set hostname: %v", err)
+	}
+	if err := setupPrimaryNic(); err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "hostnic-e2e")
+	if err != nil {
+		return nil, err
+	}
+	n := &fakeNode{
+		dir:    dir,
+		plugin: filepath.Join(dir, "hostnic"),
+		vxnet: &rpc.VxNet{
+			ID:      "vxnet-e2e1",
+			Network: "172.16.1.0/24",
+			Gateway: "172.16.1.1",
+			IPStart: "172.16.1.2",
+			IPEnd:   "172.16.1.253",
+		},
+		stopCh: make(chan struct{}),
+	}
+
+	build := exec.Command("go", "build", "-o", n.plugin, "github.com/yunify/hostnic-cni/cmd/hostnic")
+	build.Stdout, build.Stderr = os.Stderr, os.Stderr
+	if err := build.Run(); err != nil {
+		return nil, fmt.Errorf("failed to build plugin: %v", err)
+	}
+
+	nodeNS, err := ns.GetCurrentNS()
+	if err != nil {
+		return nil, err
+	}
+	if n.cloud, err = newFakeCloud(nodeNS); err != nil {
+		return nil, err
+	}
+	if err := n.cloud.addVxNet(n.vxnet); err != nil {
+		return nil, err
+	}
+
+	if err := n.startDaemon(); err != nil {
+		return nil, fmt.Errorf("failed to start hostnic-node: %v", err)
+	}
+	return n, nil
+}
+
+// setupPrimaryNic creates eth0 of node, the plugin marks the traffic to its address
+func setupPrimaryNic() error {
+	if err := setLinkUp("lo"); err != nil {
+		return err
+	}
+
+	la := netlink.NewLinkAttrs()
+	la.Name = constants.DefaultPrimaryNic
+	eth0 := &netlink.Dummy{LinkAttrs: la}
+	if err := netlink.LinkAdd(eth0); err != nil {
+		return fmt.Errorf("failed to add %s: %v", la.Name, err)
+	}
+	addr, err := netlink.ParseAddr(nodeIP)
+	if err != nil {
+		return err
+	}
+	if err := netlink.AddrAdd(eth0, addr); err != nil {
+		return fmt.Errorf("failed to add address to %s: %v", la.Name, err)
+	}
+	return setLinkUp(la.Name)
+}
+
+func setLinkUp(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetUp(link)
+}
+
+// startDaemon runs hostnic-node like cmd/ipam does, with the fake cloud and clientsets
+func (n *fakeNode) startDaemon() error {
+	if err := db.SetupLevelDB(db.NewLevelDBOptions()); err != nil {
+		return err
+	}
+	qcclient.QClient = n.cloud
+
+	n.k8s = k8sfake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: constants.IPAMConfigNamespace, Name: constants.IPAMConfigName},
+			Data: map[string]string{
+				constants.IPAMConfigDate: fmt.Sprintf(`{"%s": ["%s"]}`, constants.IPAMDefaultPoolKey, n.vxnet.ID),
+			},
+		},
+	)
+	n.client = fake.NewSimpleClientset(&networkv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: n.vxnet.ID,
+			Labels: map[string]string{
+				networkv1alpha1.IPPoolNameLabel: n.vxnet.ID,
+				networkv1alpha1.IPPoolTypeLabel: networkv1alpha1.IPPoolTypeLocal,
+			},
+		},
+		Spec: networkv1alpha1.IPPoolSpec{
+			Type:       networkv1alpha1.IPPoolTypeLocal,
+			CIDR:       n.vxnet.Network,
+			Gateway:    n.vxnet.Gateway,
+			BlockSize:  26,
+			RangeStart: n.vxnet.IPStart,
+			RangeEnd:   n.vxnet.IPEnd,
+		},
+	})
+
+	k8sInformerFactory := k8sinformers.NewSharedInformerFactory(n.k8s, 0)
+	informerFactory := informers.NewSharedInformerFactory(n.client, 0)
+	clusterConfig := config.NewClusterConfig(k8sInformerFactory.Core().V1().ConfigMaps())
+	poolSelector := config.NewPoolSelector(k8sInformerFactory.Core().V1().Nodes(), informerFactory.Network().V1alpha1().VxNetPools())
+	ipamClient := ipam.NewIPAMClient(n.client, networkv1alpha1.IPPoolTypeLocal, informerFactory, k8sInformerFactory)
+	k8sInformerFactory.Start(n.stopCh)
+	informerFactory.Start(n.stopCh)
+	if err := clusterConfig.Sync(n.stopCh); err != nil {
+		return err
+	}
+	if err := poolSelector.Sync(n.stopCh); err != nil {
+		return err
+	}
+	if err := ipamClient.Sync(n.stopCh); err != nil {
+		return err
+	}
+
+	networkutils.SetupNetworkHelper()
+	allocator.SetupAllocator(conf.PoolConf{
+		MaxNic:         constants.NicNumLimit,
+		Sync:           constants.DefaultJobSyn,
+		RouteTableBase: constants.DefaultRouteTableBase,
+		RouteTableSize: constants.DefaultRouteTableSize,
+		NodeSync:       constants.DefaultNodeSync,
+		NodeThreshold:  constants.DefaultNodeThreshold,
+		VxnetThreshold: constants.DefaultVxnetThreshold,
+		FreePeriod:     constants.DefaultFreePeriod,
+	})
+	if err := allocator.Alloc.Start(n.stopCh); err != nil {
+		return err
+	}
+	server.NewIPAMServer(conf.ServerConf{ServerPath: constants.DefaultSocketPath}, clusterConfig, poolSelector, n.k8s, ipamClient, metricsPort).Start(n.stopCh)
+
+	return wait.PollImmediate(100*time.Millisecond, 10*time.Second, func() (bool, error) {
+		conn, err := net.Dial("unix", constants.DefaultSocketPath)
+		if err != nil {
+			return false, nil
+		}
+		conn.Close()
+		return true, nil
+	})
+}
+
+func (n *fakeNode) teardown() {
+	close(n.stopCh)
+	db.CloseDB()
+	if err := delNS(n.cloud.cloudNS); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to delete cloud netns: %v\n", err)
+	}
+	os.RemoveAll(n.dir)
+}
+
+// createPod creates the pod object read by hostnic-node
+func (n *fakeNode) createPod(namespace, name string) error {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+	}
+	_, err := n.k8s.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	return err
+}
+
+func (n *fakeNode) deletePod(namespace, name string) error {
+	return n.k8s.CoreV1().Pods(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+}
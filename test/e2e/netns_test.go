@@ -0,0 +1,71 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"golang.org/x/sys/unix"
+)
+
+// nsRunDir is where named netns are mounted, like ip netns add does
+const nsRunDir = "/var/run/netns"
+
+// newNS creates a netns and bind mounts it to nsRunDir/name
+func newNS(name string) (ns.NetNS, error) {
+	path := filepath.Join(nsRunDir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		// the thread is never unlocked since its netns is changed, so it exits with the goroutine
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			errCh <- fmt.Errorf("unshare netns failed: %v", err)
+			return
+		}
+		src := fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid())
+		if err := unix.Mount(src, path, "none", unix.MS_BIND, ""); err != nil {
+			errCh <- fmt.Errorf("mount %s to %s failed: %v", src, path, err)
+			return
+		}
+		errCh <- nil
+	}()
+	if err := <-errCh; err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	netns, err := ns.GetNS(path)
+	if err != nil {
+		return nil, err
+	}
+	// loopback is used by the tcp checks inside netns
+	if err := netns.Do(func(_ ns.NetNS) error {
+		return setLinkUp("lo")
+	}); err != nil {
+		netns.Close()
+		return nil, err
+	}
+	return netns, nil
+}
+
+// delNS removes the netns created by newNS, the links in it are gone with it
+func delNS(netns ns.NetNS) error {
+	path := netns.Path()
+	if err := netns.Close(); err != nil {
+		return err
+	}
+	if err := unix.Unmount(path, unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount %s failed: %v", path, err)
+	}
+	return os.Remove(path)
+}